@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -18,31 +23,58 @@ func main() {
 		os.Exit(1)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Printf("❌ Migration failed: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	r := gin.Default()
 
 	// CORS configuration
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:5173"}, // Frontend URLs
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Requested-With", "X-Guest-Token"},
+		ExposeHeaders:    []string{"Content-Length", "X-Guest-Token"},
 		AllowCredentials: true,
 		MaxAge:           12 * 3600, // 12 hours
 	}))
 
 	// Register routes
-	if err := routes.Register(r, cfg); err != nil {
+	shutdown, err := routes.Register(r, cfg)
+	if err != nil {
 		log.Printf("❌ Failed to register routes: %v", err)
 		os.Exit(1)
 	}
+	defer shutdown()
 
 	addr := ":" + cfg.Port
 	log.Printf("🚀 Server starting on %s", addr)
 	log.Printf("📦 Store backend: %s", cfg.StoreBackend)
 	log.Printf("👤 Admin email: %s", cfg.AdminEmail)
 
-	if err := r.Run(addr); err != nil {
+	srv := &http.Server{Addr: addr, Handler: r}
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-errCh:
 		log.Printf("❌ Server failed: %v", err)
+		shutdown()
 		os.Exit(1)
+	case <-ctx.Done():
+	}
+
+	log.Println("🛑 Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️  Graceful shutdown failed: %v", err)
 	}
 }
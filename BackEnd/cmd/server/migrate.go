@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/example/ecommerce-api/internal/config"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+const migrateUsage = "usage: server migrate up | down [steps] | status"
+
+// runMigrate implements `server migrate up|down|status`
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	dsn := cfg.MySQLDSN
+	if cfg.StoreBackend == "postgres" {
+		dsn = cfg.PostgresDSN
+	}
+
+	m, db, err := store.NewMigrator(cfg.StoreBackend, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		ran, err := m.Up(ctx)
+		for _, mg := range ran {
+			log.Printf("✅ Applied %04d_%s", mg.Version, mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(ran) == 0 {
+			log.Println("Schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+		}
+		ran, err := m.Down(ctx, steps)
+		for _, mg := range ran {
+			log.Printf("↩️  Reverted %04d_%s", mg.Version, mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		st, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range st {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
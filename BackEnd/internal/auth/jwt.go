@@ -47,8 +47,46 @@ func (j *JWTManager) Verify(tokenStr string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 	claims, ok := token.Claims.(*Claims)
-	if !ok {
+	if !ok || claims.UserID == "" {
 		return nil, errors.New("invalid claims")
 	}
 	return claims, nil
 }
+
+// GuestClaims identify an anonymous visitor's cart
+type GuestClaims struct {
+	GuestID string `json:"gid"`
+	jwt.RegisteredClaims
+}
+
+// GenerateGuest signs a guest token for guestID; it is no user token, so
+// Verify rejects it
+func (j *JWTManager) GenerateGuest(guestID string, ttl time.Duration) (string, error) {
+	claims := &GuestClaims{
+		GuestID: guestID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(j.secret)
+}
+
+// VerifyGuest returns the guest ID of a guest token
+func (j *JWTManager) VerifyGuest(tokenStr string) (string, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &GuestClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return j.secret, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid {
+		return "", errors.New("invalid token")
+	}
+	claims, ok := token.Claims.(*GuestClaims)
+	if !ok || claims.GuestID == "" {
+		return "", errors.New("invalid claims")
+	}
+	return claims.GuestID, nil
+}
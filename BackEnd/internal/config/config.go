@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -21,10 +23,28 @@ type Config struct {
 	MySQLDSN     string
 	PostgresDSN  string
 
+	// Memory store snapshots; empty path disables them
+	MemorySnapshotPath     string
+	MemorySnapshotInterval time.Duration
+
 	// Midtrans
 	MidtransServerKey    string
 	MidtransIsProduction bool
 
+	// How long a pending order holds its stock before it expires
+	PaymentTimeout time.Duration
+
+	// How long a guest cart lasts without changes before it is deleted
+	GuestCartTTL time.Duration
+
+	// Which locations fulfil an order first: "priority" or "nearest" to the
+	// coordinates given at checkout
+	AllocationRule string
+
+	// Stock below which admins are alerted, for products without their own
+	// threshold; 0 turns the default off
+	LowStockThreshold int
+
 	// Email (Gmail SMTP)
 	SMTPFrom     string
 	SMTPPassword string // App Password dari Gmail
@@ -65,6 +85,10 @@ func Load() (*Config, error) {
 		MySQLDSN:     strings.TrimSpace(os.Getenv("MYSQL_DSN")),
 		PostgresDSN:  strings.TrimSpace(os.Getenv("POSTGRES_DSN")),
 
+		MemorySnapshotPath: strings.TrimSpace(os.Getenv("MEMORY_SNAPSHOT_PATH")),
+
+		AllocationRule: getenv("ALLOCATION_RULE", "priority"),
+
 		// Midtrans
 		MidtransServerKey:    strings.TrimSpace(os.Getenv("MIDTRANS_SERVER_KEY")),
 		MidtransIsProduction: getenv("MIDTRANS_IS_PRODUCTION", "false") == "true",
@@ -117,6 +141,30 @@ func Load() (*Config, error) {
 		}
 	}
 
+	cfg.MemorySnapshotInterval, err = time.ParseDuration(getenv("MEMORY_SNAPSHOT_INTERVAL", "1m"))
+	if err != nil || cfg.MemorySnapshotInterval <= 0 {
+		return nil, fmt.Errorf("MEMORY_SNAPSHOT_INTERVAL must be a positive duration such as 30s or 5m")
+	}
+
+	cfg.PaymentTimeout, err = time.ParseDuration(getenv("PAYMENT_TIMEOUT", "24h"))
+	if err != nil || cfg.PaymentTimeout <= 0 {
+		return nil, fmt.Errorf("PAYMENT_TIMEOUT must be a positive duration such as 30m or 24h")
+	}
+
+	cfg.GuestCartTTL, err = time.ParseDuration(getenv("GUEST_CART_TTL", "168h"))
+	if err != nil || cfg.GuestCartTTL <= 0 {
+		return nil, fmt.Errorf("GUEST_CART_TTL must be a positive duration such as 72h or 168h")
+	}
+
+	if cfg.AllocationRule != "priority" && cfg.AllocationRule != "nearest" {
+		return nil, fmt.Errorf("ALLOCATION_RULE must be priority or nearest")
+	}
+
+	cfg.LowStockThreshold, err = strconv.Atoi(getenv("LOW_STOCK_THRESHOLD", "5"))
+	if err != nil || cfg.LowStockThreshold < 0 {
+		return nil, fmt.Errorf("LOW_STOCK_THRESHOLD must be a whole number of 0 or more")
+	}
+
 	if (cfg.SMTPFrom == "") != (cfg.SMTPPassword == "") {
 		return nil, fmt.Errorf("SMTP_FROM and SMTP_PASSWORD must be set together")
 	}
@@ -339,6 +339,160 @@ func (s *Service) SendPasswordReset(to, token, baseURL string) error {
 	return s.send(to, "Reset Password Akun Anda", body.String())
 }
 
+// SendBackInStock tells a subscriber that a product is available again
+func (s *Service) SendBackInStock(to, productName, productURL string) error {
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #6d3b2a; color: white; padding: 20px; text-align: center; }
+        .content { background: #f9f9f9; padding: 30px; }
+        .button { 
+            display: inline-block; 
+            padding: 12px 30px; 
+            background: #6d3b2a; 
+            color: white; 
+            text-decoration: none; 
+            border-radius: 5px;
+            margin: 20px 0;
+        }
+        .footer { text-align: center; padding: 20px; color: #666; font-size: 12px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>📦 Stok Tersedia Lagi!</h1>
+        </div>
+        <div class="content">
+            <h2>{{.ProductName}}</h2>
+            <p>Produk yang Anda tunggu sudah tersedia kembali. Stok terbatas, segera pesan sebelum kehabisan.</p>
+            <center>
+                <a href="{{.ProductURL}}" class="button">Lihat Produk</a>
+            </center>
+            <p>Atau copy link berikut ke browser Anda:</p>
+            <p style="word-break: break-all; color: #666;">{{.ProductURL}}</p>
+            <p><small>Anda menerima email ini karena meminta notifikasi stok untuk produk ini. Notifikasi hanya dikirim sekali.</small></p>
+        </div>
+        <div class="footer">
+            <p>Email ini dikirim secara otomatis, mohon tidak membalas.</p>
+            <p>&copy; {{.Year}} E-Commerce API. All rights reserved.</p>
+        </div>
+    </div>
+</body>
+</html>
+`
+
+	data := struct {
+		ProductName string
+		ProductURL  string
+		Year        int
+	}{
+		ProductName: productName,
+		ProductURL:  productURL,
+		Year:        time.Now().Year(),
+	}
+
+	t, err := template.New("back-in-stock").Parse(tmpl)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return err
+	}
+
+	return s.send(to, "Stok Tersedia Lagi - "+productName, body.String())
+}
+
+// LowStockItem is one product in a low-stock alert
+type LowStockItem struct {
+	Name      string
+	SKU       string
+	Stock     int
+	Threshold int
+	URL       string
+}
+
+// SendLowStockAlert tells an admin which products just dropped below their
+// stock threshold. An empty list sends nothing
+func (s *Service) SendLowStockAlert(to string, items []LowStockItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	tmpl := `
+<!DOCTYPE html>
+<html>
+<head>
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+        .container { max-width: 600px; margin: 0 auto; padding: 20px; }
+        .header { background: #F44336; color: white; padding: 20px; text-align: center; }
+        .content { background: #f9f9f9; padding: 30px; }
+        table { width: 100%; border-collapse: collapse; background: white; margin: 20px 0; }
+        th, td { padding: 10px; border-bottom: 1px solid #eee; text-align: left; }
+        .stock { font-weight: bold; color: #F44336; }
+        .footer { text-align: center; padding: 20px; color: #666; font-size: 12px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>⚠️ Stok Menipis</h1>
+        </div>
+        <div class="content">
+            <p>Stok produk berikut baru saja turun di bawah batas minimum:</p>
+            <table>
+                <tr><th>Produk</th><th>SKU</th><th>Stok</th><th>Batas</th></tr>
+                {{range .Items}}
+                <tr>
+                    <td><a href="{{.URL}}">{{.Name}}</a></td>
+                    <td>{{.SKU}}</td>
+                    <td class="stock">{{.Stock}}</td>
+                    <td>{{.Threshold}}</td>
+                </tr>
+                {{end}}
+            </table>
+            <p>Segera lakukan restock agar pelanggan tidak kehabisan.</p>
+        </div>
+        <div class="footer">
+            <p>Email ini dikirim secara otomatis, mohon tidak membalas.</p>
+            <p>&copy; {{.Year}} E-Commerce API. All rights reserved.</p>
+        </div>
+    </div>
+</body>
+</html>
+`
+
+	data := struct {
+		Items []LowStockItem
+		Year  int
+	}{
+		Items: items,
+		Year:  time.Now().Year(),
+	}
+
+	t, err := template.New("low-stock").Parse(tmpl)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		return err
+	}
+
+	subject := "Stok Menipis - " + items[0].Name
+	if len(items) > 1 {
+		subject = fmt.Sprintf("Stok Menipis - %d produk", len(items))
+	}
+	return s.send(to, subject, body.String())
+}
+
 // send is the core email sending function
 func (s *Service) send(to, subject, htmlBody string) error {
 	msg := []byte(fmt.Sprintf(
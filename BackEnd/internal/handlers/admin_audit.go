@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 500
+)
+
+type AdminAuditHandler struct {
+	store store.Store
+}
+
+func NewAdminAuditHandler(st store.Store) *AdminAuditHandler {
+	return &AdminAuditHandler{store: st}
+}
+
+// List returns audit entries, newest first. Filters: actor, entity_type,
+// entity_id, from, to (RFC3339 or YYYY-MM-DD; a bare `to` date includes
+// that whole day) and limit.
+func (h *AdminAuditHandler) List(c *gin.Context) {
+	filter := store.AuditFilter{
+		ActorID:    c.Query("actor"),
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+		Limit:      defaultAuditLimit,
+	}
+
+	if v := c.Query("from"); v != "" {
+		t, _, err := parseAuditTime(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from harus RFC3339 atau YYYY-MM-DD"})
+			return
+		}
+		filter.From = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, dateOnly, err := parseAuditTime(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to harus RFC3339 atau YYYY-MM-DD"})
+			return
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		filter.To = t
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit harus angka positif"})
+			return
+		}
+		filter.Limit = min(n, maxAuditLimit)
+	}
+
+	entries, err := h.store.ListAuditEntries(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func parseAuditTime(v string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse(time.RFC3339, v); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse(time.DateOnly, v)
+	return t, true, err
+}
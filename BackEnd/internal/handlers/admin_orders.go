@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/example/ecommerce-api/internal/audit"
 	"github.com/example/ecommerce-api/internal/models"
 	"github.com/example/ecommerce-api/internal/store"
 )
@@ -20,13 +22,14 @@ func NewAdminOrdersHandler(st store.Store) *AdminOrdersHandler {
 }
 
 type adminOrderResp struct {
-	OrderID    string            `json:"order_id"`
-	UserID     string            `json:"user_id"`
-	Status     string            `json:"status"`
-	Amount     int64             `json:"amount_cents"`
-	PaymentRef string            `json:"payment_ref,omitempty"`
-	Items      []models.CartItem `json:"items,omitempty"`
-	CreatedAt  time.Time         `json:"created_at"`
+	OrderID     string          `json:"order_id"`
+	UserID      string          `json:"user_id"`
+	Status      string          `json:"status"`
+	Reservation string          `json:"reservation"`
+	Amount      int64           `json:"amount_cents"`
+	PaymentRef  string          `json:"payment_ref,omitempty"`
+	Items       []orderItemResp `json:"items,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
 }
 
 type orderStatusReq struct {
@@ -34,26 +37,32 @@ type orderStatusReq struct {
 }
 
 func (h *AdminOrdersHandler) List(c *gin.Context) {
-	orders, err := h.store.ListOrders()
+	page, ok := readPage(c)
+	if !ok {
+		return
+	}
+	orders, next, err := h.store.ListOrders(c.Request.Context(), page)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		listError(c, err)
 		return
 	}
 
+	products := map[string]*models.Product{}
 	resp := make([]adminOrderResp, 0, len(orders))
 	for _, o := range orders {
 		resp = append(resp, adminOrderResp{
-			OrderID:    o.ID,
-			UserID:     o.UserID,
-			Status:     o.Status,
-			Amount:     o.Amount,
-			PaymentRef: o.PaymentRef,
-			Items:      o.Items,
-			CreatedAt:  o.CreatedAt,
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Status:      o.Status,
+			Reservation: o.Reservation,
+			Amount:      o.Amount,
+			PaymentRef:  o.PaymentRef,
+			Items:       describeOrderItems(c.Request.Context(), h.store, o.Items, products),
+			CreatedAt:   o.CreatedAt,
 		})
 	}
 
-	c.JSON(http.StatusOK, resp)
+	c.JSON(http.StatusOK, pageResp{Items: resp, NextCursor: next})
 }
 
 func (h *AdminOrdersHandler) UpdateStatus(c *gin.Context) {
@@ -76,7 +85,7 @@ func (h *AdminOrdersHandler) UpdateStatus(c *gin.Context) {
 	}
 
 	switch status {
-	case "pending", "paid", "failed", "done", "completed":
+	case "pending", "paid", "failed", "canceled", "done", "completed":
 		if status == "completed" {
 			status = "done"
 		}
@@ -85,10 +94,26 @@ func (h *AdminOrdersHandler) UpdateStatus(c *gin.Context) {
 		return
 	}
 
-	if err := h.store.UpdateOrderStatus(orderID, status); err != nil {
+	before, err := h.store.GetOrder(c.Request.Context(), orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	err = h.store.UpdateOrderStatus(c.Request.Context(), orderID, status)
+	if errors.Is(err, store.ErrInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Stock tidak cukup untuk membuka kembali order ini: " + err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	after, err := h.store.GetOrder(c.Request.Context(), orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "order.status", "order", orderID, before, after)
 
 	c.Status(http.StatusNoContent)
 }
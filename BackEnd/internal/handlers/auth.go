@@ -20,6 +20,7 @@ import (
 	"github.com/example/ecommerce-api/internal/auth"
 	"github.com/example/ecommerce-api/internal/config"
 	"github.com/example/ecommerce-api/internal/email"
+	"github.com/example/ecommerce-api/internal/middleware"
 	"github.com/example/ecommerce-api/internal/store"
 )
 
@@ -65,15 +66,16 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 		return
 	}
 
-	u, err := h.store.CreateUser(req.FullName, req.Phone, req.Email, hash, "user", "email", "", false)
+	u, err := h.store.CreateUser(c.Request.Context(), req.FullName, req.Phone, req.Email, hash, "user", "email", "", false)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	h.mergeGuestCart(c, c.GetHeader(middleware.GuestTokenHeader), u.ID)
 
 	otp := generateOTP()
 	expiresAt := time.Now().Add(10 * time.Minute)
-	if err := h.store.CreateEmailVerification(u.ID, otp, expiresAt); err != nil {
+	if err := h.store.CreateEmailVerification(c.Request.Context(), u.ID, otp, expiresAt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Gagal membuat OTP verifikasi"})
 		return
 	}
@@ -103,7 +105,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	u, err := h.store.GetUserByEmail(req.Email)
+	u, err := h.store.GetUserByEmail(c.Request.Context(), req.Email)
 	if err != nil || !auth.VerifyPassword(u.Password, req.Password) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Email atau password salah"})
 		return
@@ -120,6 +122,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
 		return
 	}
+	h.mergeGuestCart(c, c.GetHeader(middleware.GuestTokenHeader), u.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"token":          t,
@@ -143,13 +146,13 @@ func (h *AuthHandler) VerifyOTP(c *gin.Context) {
 		return
 	}
 
-	verification, err := h.store.GetEmailVerification(strings.TrimSpace(req.OTP))
+	verification, err := h.store.GetEmailVerification(c.Request.Context(), strings.TrimSpace(req.OTP))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "OTP tidak valid"})
 		return
 	}
 
-	user, err := h.store.GetUserByEmail(strings.TrimSpace(req.Email))
+	user, err := h.store.GetUserByEmail(c.Request.Context(), strings.TrimSpace(req.Email))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User tidak ditemukan"})
 		return
@@ -165,12 +168,12 @@ func (h *AuthHandler) VerifyOTP(c *gin.Context) {
 		return
 	}
 
-	if err := h.store.MarkEmailVerified(user.ID); err != nil {
+	if err := h.store.MarkEmailVerified(c.Request.Context(), user.ID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Gagal verifikasi email"})
 		return
 	}
 
-	_ = h.store.DeleteEmailVerification(verification.Token)
+	_ = h.store.DeleteEmailVerification(c.Request.Context(), verification.Token)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Email berhasil diverifikasi"})
 }
@@ -186,7 +189,7 @@ func (h *AuthHandler) ResendOTP(c *gin.Context) {
 		return
 	}
 
-	user, err := h.store.GetUserByEmail(strings.TrimSpace(req.Email))
+	user, err := h.store.GetUserByEmail(c.Request.Context(), strings.TrimSpace(req.Email))
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"message": "Jika email terdaftar, OTP baru akan dikirim"})
 		return
@@ -199,7 +202,7 @@ func (h *AuthHandler) ResendOTP(c *gin.Context) {
 
 	otp := generateOTP()
 	expiresAt := time.Now().Add(10 * time.Minute)
-	if err := h.store.CreateEmailVerification(user.ID, otp, expiresAt); err != nil {
+	if err := h.store.CreateEmailVerification(c.Request.Context(), user.ID, otp, expiresAt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Gagal membuat OTP baru"})
 		return
 	}
@@ -226,7 +229,7 @@ func (h *AuthHandler) GoogleLogin(c *gin.Context) {
 		return
 	}
 
-	user, err := h.store.GetUserByEmail(strings.TrimSpace(req.Email))
+	user, err := h.store.GetUserByEmail(c.Request.Context(), strings.TrimSpace(req.Email))
 	if err != nil {
 		tempPasswordHash, hashErr := auth.HashPassword(uuid.NewString())
 		if hashErr != nil {
@@ -239,7 +242,7 @@ func (h *AuthHandler) GoogleLogin(c *gin.Context) {
 			fullName = "Google User"
 		}
 
-		user, err = h.store.CreateUser(fullName, "", strings.TrimSpace(req.Email), tempPasswordHash, "user", "google", strings.TrimSpace(req.GoogleID), true)
+		user, err = h.store.CreateUser(c.Request.Context(), fullName, "", strings.TrimSpace(req.Email), tempPasswordHash, "user", "google", strings.TrimSpace(req.GoogleID), true)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
@@ -252,6 +255,7 @@ func (h *AuthHandler) GoogleLogin(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
 		return
 	}
+	h.mergeGuestCart(c, c.GetHeader(middleware.GuestTokenHeader), user.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"token":          token,
@@ -269,8 +273,17 @@ func (h *AuthHandler) GoogleStart(c *gin.Context) {
 		return
 	}
 
+	// A redirect cannot carry the guest header, so the token may come as
+	// ?guest_token= too; it rides the state back to GoogleCallback
+	guestToken := c.GetHeader(middleware.GuestTokenHeader)
+	if guestToken == "" {
+		guestToken = c.Query("guest_token")
+	}
+	if _, err := h.jwtManager.VerifyGuest(guestToken); err != nil {
+		guestToken = ""
+	}
 	next := sanitizeNext(c.Query("next"))
-	state := encodeState(next)
+	state := encodeState(next, guestToken)
 	url := conf.AuthCodeURL(state, oauth2.AccessTypeOnline)
 	c.Redirect(http.StatusFound, url)
 }
@@ -284,7 +297,7 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 
 	code := c.Query("code")
 	state := c.Query("state")
-	next := decodeState(state)
+	next, guestToken := decodeState(state)
 	if code == "" {
 		h.redirectToFrontend(c, next, "Google login gagal: code kosong", "")
 		return
@@ -330,7 +343,7 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 		return
 	}
 
-	user, err := h.store.GetUserByEmail(strings.TrimSpace(payload.Email))
+	user, err := h.store.GetUserByEmail(c.Request.Context(), strings.TrimSpace(payload.Email))
 	if err != nil {
 		tempPasswordHash, hashErr := auth.HashPassword(uuid.NewString())
 		if hashErr != nil {
@@ -341,7 +354,7 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 		if fullName == "" {
 			fullName = "Google User"
 		}
-		user, err = h.store.CreateUser(fullName, "", payload.Email, tempPasswordHash, "user", "google", payload.ID, true)
+		user, err = h.store.CreateUser(c.Request.Context(), fullName, "", payload.Email, tempPasswordHash, "user", "google", payload.ID, true)
 		if err != nil {
 			h.redirectToFrontend(c, next, err.Error(), "")
 			return
@@ -354,6 +367,7 @@ func (h *AuthHandler) GoogleCallback(c *gin.Context) {
 		h.redirectToFrontend(c, next, "Token error", "")
 		return
 	}
+	h.mergeGuestCart(c, guestToken, user.ID)
 
 	params := url.Values{}
 	params.Set("token", jwtToken)
@@ -414,24 +428,32 @@ func sanitizeNext(next string) string {
 	return next
 }
 
-func encodeState(next string) string {
-	state := fmt.Sprintf("%s|%s", uuid.NewString(), sanitizeNext(next))
+// encodeState packs the page to return to and the guest token, if any,
+// into the OAuth state
+func encodeState(next, guestToken string) string {
+	state := fmt.Sprintf("%s|%s|%s", uuid.NewString(), guestToken, sanitizeNext(next))
 	return base64.RawURLEncoding.EncodeToString([]byte(state))
 }
 
-func decodeState(state string) string {
+// decodeState unpacks encodeState. States from before guest tokens were
+// added have only the nonce and the page.
+func decodeState(state string) (next, guestToken string) {
 	if state == "" {
-		return "/products"
+		return "/products", ""
 	}
 	decoded, err := base64.RawURLEncoding.DecodeString(state)
 	if err != nil {
-		return "/products"
+		return "/products", ""
 	}
-	parts := strings.SplitN(string(decoded), "|", 2)
-	if len(parts) != 2 {
-		return "/products"
+	parts := strings.SplitN(string(decoded), "|", 3)
+	switch len(parts) {
+	case 2:
+		return sanitizeNext(parts[1]), ""
+	case 3:
+		return sanitizeNext(parts[2]), parts[1]
+	default:
+		return "/products", ""
 	}
-	return sanitizeNext(parts[1])
 }
 
 func (h *AuthHandler) VerifyEmail(c *gin.Context) {
@@ -441,7 +463,7 @@ func (h *AuthHandler) VerifyEmail(c *gin.Context) {
 		return
 	}
 
-	verification, err := h.store.GetEmailVerification(token)
+	verification, err := h.store.GetEmailVerification(c.Request.Context(), token)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Token tidak valid atau sudah kadaluarsa"})
 		return
@@ -453,13 +475,13 @@ func (h *AuthHandler) VerifyEmail(c *gin.Context) {
 	}
 
 	// Mark email as verified
-	if err := h.store.MarkEmailVerified(verification.UserID); err != nil {
+	if err := h.store.MarkEmailVerified(c.Request.Context(), verification.UserID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
 		return
 	}
 
 	// Delete verification token
-	_ = h.store.DeleteEmailVerification(token)
+	_ = h.store.DeleteEmailVerification(c.Request.Context(), token)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "✅ Email berhasil diverifikasi! Silakan login.",
@@ -477,7 +499,7 @@ func (h *AuthHandler) RequestPasswordReset(c *gin.Context) {
 		return
 	}
 
-	u, err := h.store.GetUserByEmail(req.Email)
+	u, err := h.store.GetUserByEmail(c.Request.Context(), req.Email)
 	if err != nil {
 		// Don't reveal if email exists
 		c.JSON(http.StatusOK, gin.H{"message": "Jika email terdaftar, link reset password akan dikirim"})
@@ -487,7 +509,7 @@ func (h *AuthHandler) RequestPasswordReset(c *gin.Context) {
 	// Create reset token
 	token := uuid.NewString()
 	expiresAt := time.Now().Add(1 * time.Hour)
-	if err := h.store.CreatePasswordReset(u.ID, token, expiresAt); err != nil {
+	if err := h.store.CreatePasswordReset(c.Request.Context(), u.ID, token, expiresAt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reset token"})
 		return
 	}
@@ -514,7 +536,7 @@ func (h *AuthHandler) ResetPassword(c *gin.Context) {
 		return
 	}
 
-	reset, err := h.store.GetPasswordReset(req.Token)
+	reset, err := h.store.GetPasswordReset(c.Request.Context(), req.Token)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Token tidak valid"})
 		return
@@ -533,13 +555,13 @@ func (h *AuthHandler) ResetPassword(c *gin.Context) {
 	}
 
 	// Update password
-	if err := h.store.UpdateUserPassword(reset.UserID, hash); err != nil {
+	if err := h.store.UpdateUserPassword(c.Request.Context(), reset.UserID, hash); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 		return
 	}
 
 	// Delete reset token
-	_ = h.store.DeletePasswordReset(req.Token)
+	_ = h.store.DeletePasswordReset(c.Request.Context(), req.Token)
 
 	c.JSON(http.StatusOK, gin.H{"message": "✅ Password berhasil direset! Silakan login dengan password baru."})
 }
@@ -548,3 +570,16 @@ func generateOTP() string {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	return fmt.Sprintf("%06d", r.Intn(1000000))
 }
+
+// mergeGuestCart moves the cart of the guest token sent with a login or
+// signup into the user's cart. Without a valid token there is nothing to
+// merge; a failed merge leaves the guest cart to try again.
+func (h *AuthHandler) mergeGuestCart(c *gin.Context, guestToken, userID string) {
+	guestID, err := h.jwtManager.VerifyGuest(guestToken)
+	if err != nil {
+		return
+	}
+	if _, err := h.store.MergeGuestCart(c.Request.Context(), guestID, userID); err != nil {
+		log.Printf("⚠️  Failed to merge guest cart %s into user %s: %v", guestID, userID, err)
+	}
+}
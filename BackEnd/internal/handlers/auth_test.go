@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/auth"
+	"github.com/example/ecommerce-api/internal/config"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// TestGoogleStartCarriesGuestToken starts the Google redirect flow as a
+// guest and expects the state sent to Google to bring the token back
+func TestGoogleStartCarriesGuestToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jm := auth.NewJWTManager("test-secret-test-secret-test-secret")
+	guestToken, err := jm.GenerateGuest("guest-1", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateGuest: %v", err)
+	}
+	cfg := &config.Config{GoogleClientID: "id", GoogleClientSecret: "secret", GoogleRedirectURL: "http://localhost:8080/api/v1/auth/google/callback"}
+	h := NewAuthHandler(cfg, store.NewInMemoryStore(), jm, nil)
+	r := gin.New()
+	r.GET("/google/start", h.GoogleStart)
+
+	for _, tc := range []struct {
+		name, token, want string
+	}{
+		{"guest", guestToken, guestToken},
+		{"forged token", "not-a-token", ""},
+		{"no token", "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/google/start?next=/cart&guest_token="+url.QueryEscape(tc.token), nil))
+			if w.Code != http.StatusFound {
+				t.Fatalf("GoogleStart = %d, want a redirect", w.Code)
+			}
+			loc, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("Location: %v", err)
+			}
+			next, got := decodeState(loc.Query().Get("state"))
+			if next != "/cart" || got != tc.want {
+				t.Fatalf("state = %q, %q; want /cart, %q", next, got, tc.want)
+			}
+		})
+	}
+
+	// States issued before guest tokens still lead back
+	if next, got := decodeState("dXVpZHwvb3JkZXJz"); next != "/orders" || got != "" {
+		t.Fatalf("old state = %q, %q; want /orders and no token", next, got)
+	}
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/example/ecommerce-api/internal/middleware"
+	"github.com/example/ecommerce-api/internal/models"
 	"github.com/example/ecommerce-api/internal/store"
 )
 
@@ -19,17 +22,27 @@ func NewCartHandler(st store.Store) *CartHandler {
 
 type cartUpdateReq struct {
 	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id"`
 	Quantity  int    `json:"quantity"`
 }
 
+// Cart handlers serve both the user routes and the guest ones, where
+// middleware.GuestAuth has set a guest ID
+
 func (h *CartHandler) Add(c *gin.Context) {
 	var req cartUpdateReq
 	if err := c.ShouldBindJSON(&req); err != nil || req.ProductID == "" || req.Quantity <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
 		return
 	}
-	userID := c.GetString(string(middleware.UserIDKey))
-	if err := h.store.AddToCart(userID, req.ProductID, req.Quantity); err != nil {
+	var err error
+	if guestID := c.GetString(string(middleware.GuestIDKey)); guestID != "" {
+		err = h.store.AddToGuestCart(c.Request.Context(), guestID, req.ProductID, req.VariantID, req.Quantity)
+	} else {
+		userID := c.GetString(string(middleware.UserIDKey))
+		err = h.store.AddToCart(c.Request.Context(), userID, req.ProductID, req.VariantID, req.Quantity)
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -42,20 +55,136 @@ func (h *CartHandler) Remove(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
 		return
 	}
-	userID := c.GetString(string(middleware.UserIDKey))
-	if err := h.store.RemoveFromCart(userID, req.ProductID, req.Quantity); err != nil {
+	var err error
+	if guestID := c.GetString(string(middleware.GuestIDKey)); guestID != "" {
+		err = h.store.RemoveFromGuestCart(c.Request.Context(), guestID, req.ProductID, req.VariantID, req.Quantity)
+	} else {
+		userID := c.GetString(string(middleware.UserIDKey))
+		err = h.store.RemoveFromCart(c.Request.Context(), userID, req.ProductID, req.VariantID, req.Quantity)
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	c.Status(http.StatusNoContent)
 }
 
-func (h *CartHandler) View(c *gin.Context) {
-	userID := c.GetString(string(middleware.UserIDKey))
-	cart, err := h.store.GetCart(userID)
+type cartSetQuantityReq struct {
+	VariantID string `json:"variant_id"` // wajib untuk produk bervarian
+	Quantity  *int   `json:"quantity"`   // 0 = hapus baris
+}
+
+// SetQuantity sets a line to an exact quantity and answers with the cart
+func (h *CartHandler) SetQuantity(c *gin.Context) {
+	var req cartSetQuantityReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Quantity == nil || *req.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		return
+	}
+	productID := c.Param("product_id")
+	var err error
+	if guestID := c.GetString(string(middleware.GuestIDKey)); guestID != "" {
+		err = h.store.SetGuestCartQuantity(c.Request.Context(), guestID, productID, req.VariantID, *req.Quantity)
+	} else {
+		userID := c.GetString(string(middleware.UserIDKey))
+		err = h.store.SetCartQuantity(c.Request.Context(), userID, productID, req.VariantID, *req.Quantity)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, cart)
+	h.View(c)
+}
+
+// cartLineResp is a cart line with the product details and price the cart
+// page shows
+type cartLineResp struct {
+	models.CartItem
+	Name           string `json:"name,omitempty"`
+	Variant        string `json:"variant,omitempty"` // e.g. "M / Merah"
+	SKU            string `json:"sku,omitempty"`     // milik varian bila ada
+	Thumbnail      string `json:"thumbnail,omitempty"`
+	UnitPriceCents int64  `json:"unit_price_cents"`
+	LineTotalCents int64  `json:"line_total_cents"`
+	Stock          int    `json:"stock"`     // stok yang tersedia untuk baris ini
+	Available      bool   `json:"available"` // false bila produk atau varian tidak dijual lagi
+}
+
+type cartResp struct {
+	UserID        string         `json:"user_id,omitempty"`
+	ID            string         `json:"id,omitempty"` // ID keranjang tamu
+	Items         []cartLineResp `json:"items"`
+	SubtotalCents int64          `json:"subtotal_cents"` // baris yang tersedia saja
+	ItemCount     int            `json:"item_count"`     // jumlah unit di baris yang tersedia
+}
+
+// describeCart prices the lines of a cart at today's prices. Lines whose
+// product is gone, archived or off sale, or whose variant was removed, are
+// kept but left out of the totals.
+func describeCart(ctx context.Context, st store.Store, items []models.CartItem) ([]cartLineResp, int64, int) {
+	now := time.Now()
+	products := map[string]*models.Product{}
+	res := make([]cartLineResp, 0, len(items))
+	var subtotal int64
+	count := 0
+	for _, it := range items {
+		p, ok := products[it.ProductID]
+		if !ok {
+			p, _ = st.GetProduct(ctx, it.ProductID)
+			products[it.ProductID] = p
+		}
+		line := cartLineResp{CartItem: it}
+		if p == nil {
+			res = append(res, line)
+			continue
+		}
+		line.Name = p.Name
+		line.SKU = p.SKU
+		line.Thumbnail = p.Thumbnail
+		line.Stock = p.Stock
+		v := p.Variant(it.VariantID)
+		if v != nil {
+			line.Variant = variantValues(p, v)
+			line.SKU = v.SKU
+			line.Stock = v.Stock
+			if v.Image != "" {
+				line.Thumbnail = v.Image
+			}
+		}
+		line.UnitPriceCents = p.PriceFor(v)
+		line.LineTotalCents = line.UnitPriceCents * int64(it.Quantity)
+		line.Available = p.ArchivedAt == nil && p.Live(now) &&
+			(v != nil || (it.VariantID == "" && len(p.Variants) == 0))
+		if line.Available {
+			subtotal += line.LineTotalCents
+			count += it.Quantity
+		}
+		res = append(res, line)
+	}
+	return res, subtotal, count
+}
+
+// View returns the cart with every line priced and described
+func (h *CartHandler) View(c *gin.Context) {
+	ctx := c.Request.Context()
+	var resp cartResp
+	if guestID := c.GetString(string(middleware.GuestIDKey)); guestID != "" {
+		cart, err := h.store.GetGuestCart(ctx, guestID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp.ID = cart.ID
+		resp.Items, resp.SubtotalCents, resp.ItemCount = describeCart(ctx, h.store, cart.Items)
+	} else {
+		userID := c.GetString(string(middleware.UserIDKey))
+		cart, err := h.store.GetCart(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		resp.UserID = cart.UserID
+		resp.Items, resp.SubtotalCents, resp.ItemCount = describeCart(ctx, h.store, cart.Items)
+	}
+	c.JSON(http.StatusOK, resp)
 }
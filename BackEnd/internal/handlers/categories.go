@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/audit"
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+type CategoriesHandler struct {
+	store store.Store
+}
+
+func NewCategoriesHandler(st store.Store) *CategoriesHandler {
+	return &CategoriesHandler{store: st}
+}
+
+// Tree returns the categories as nested trees, siblings by sort order then
+// name
+func (h *CategoriesHandler) Tree(c *gin.Context) {
+	cats, err := h.store.ListCategories(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, buildCategoryTree(cats))
+}
+
+// AdminList returns every category flat, for pickers and tables
+func (h *CategoriesHandler) AdminList(c *gin.Context) {
+	cats, err := h.store.ListCategories(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cats)
+}
+
+// Admin create category
+
+type createCategoryReq struct {
+	Name      string `json:"name"`
+	Slug      string `json:"slug"` // kosong = dibuat dari name
+	SKUPrefix string `json:"sku_prefix"`
+	ParentID  string `json:"parent_id"`
+	SortOrder int    `json:"sort_order"`
+	Image     string `json:"image"`
+}
+
+func (h *CategoriesHandler) Create(c *gin.Context) {
+	var req createCategoryReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+
+	cat := &models.Category{
+		Name:      strings.TrimSpace(req.Name),
+		Slug:      strings.TrimSpace(req.Slug),
+		SKUPrefix: strings.ToUpper(strings.TrimSpace(req.SKUPrefix)),
+		ParentID:  strings.TrimSpace(req.ParentID),
+		SortOrder: req.SortOrder,
+		Image:     strings.TrimSpace(req.Image),
+	}
+	if err := validateCategory(cat); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := h.store.CreateCategory(c.Request.Context(), cat)
+	if err != nil {
+		categoryError(c, err)
+		return
+	}
+	audit.Record(c, "category.create", "category", res.ID, nil, res)
+	c.JSON(http.StatusOK, res)
+}
+
+// Admin update category
+
+type updateCategoryReq struct {
+	Name      *string `json:"name"`
+	Slug      *string `json:"slug"`
+	SKUPrefix *string `json:"sku_prefix"` // "" = pakai inisial nama produk
+	ParentID  *string `json:"parent_id"`  // "" = jadikan kategori utama
+	SortOrder *int    `json:"sort_order"`
+	Image     *string `json:"image"`
+}
+
+func (h *CategoriesHandler) Update(c *gin.Context) {
+	id := c.Param("id")
+	var req updateCategoryReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload: " + err.Error()})
+		return
+	}
+	cats, err := h.store.ListCategories(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if findCategory(cats, id) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+		return
+	}
+
+	var before models.Category
+	res, err := h.store.UpdateCategory(c.Request.Context(), id, func(cat *models.Category) error {
+		before = *cat
+		if req.Name != nil {
+			cat.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.Slug != nil {
+			cat.Slug = strings.TrimSpace(*req.Slug)
+		}
+		if req.SKUPrefix != nil {
+			cat.SKUPrefix = strings.ToUpper(strings.TrimSpace(*req.SKUPrefix))
+		}
+		if req.ParentID != nil {
+			cat.ParentID = strings.TrimSpace(*req.ParentID)
+		}
+		if req.SortOrder != nil {
+			cat.SortOrder = *req.SortOrder
+		}
+		if req.Image != nil {
+			cat.Image = strings.TrimSpace(*req.Image)
+		}
+		return validateCategory(cat)
+	})
+	if err != nil {
+		categoryError(c, err)
+		return
+	}
+	audit.Record(c, "category.update", "category", id, before, res)
+	c.JSON(http.StatusOK, res)
+}
+
+// Delete removes an empty category; one that still has subcategories or
+// products is refused
+func (h *CategoriesHandler) Delete(c *gin.Context) {
+	id := c.Param("id")
+	cats, err := h.store.ListCategories(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	before := findCategory(cats, id)
+	if before == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+		return
+	}
+
+	if err := h.store.DeleteCategory(c.Request.Context(), id); err != nil {
+		categoryError(c, err)
+		return
+	}
+	audit.Record(c, "category.delete", "category", id, before, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// maxSKUPrefix is the longest SKU prefix a category may set
+const maxSKUPrefix = 10
+
+// validateCategory requires a name and derives the slug from it when none
+// is given; a given slug must already be in slug form. SKU prefixes are
+// upper-case letters and digits.
+func validateCategory(cat *models.Category) error {
+	if cat.Name == "" {
+		return errors.New("name wajib diisi")
+	}
+	if len(cat.SKUPrefix) > maxSKUPrefix || strings.TrimFunc(cat.SKUPrefix, func(r rune) bool {
+		return r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+	}) != "" {
+		return fmt.Errorf("sku_prefix maksimal %d huruf atau angka", maxSKUPrefix)
+	}
+	if cat.Slug == "" {
+		cat.Slug = store.Slugify(cat.Name)
+		if cat.Slug == "" {
+			return errors.New("name harus mengandung huruf atau angka")
+		}
+		return nil
+	}
+	if store.Slugify(cat.Slug) != cat.Slug {
+		return errors.New("slug hanya boleh huruf kecil, angka dan tanda hubung")
+	}
+	return nil
+}
+
+// categoryError answers a failed category write: 409 for conflicts, 400
+// for everything the admin can fix in the request
+func categoryError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrSlugTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Slug sudah dipakai kategori lain"})
+	case errors.Is(err, store.ErrCategoryInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "Kategori masih punya subkategori atau produk"})
+	case errors.Is(err, store.ErrCategoryCycle):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Kategori tidak bisa dipindah ke bawah dirinya sendiri"})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+func findCategory(cats []*models.Category, id string) *models.Category {
+	for _, cat := range cats {
+		if cat.ID == id {
+			return cat
+		}
+	}
+	return nil
+}
+
+// buildCategoryTree nests a flat, sorted list under each category's parent.
+// Categories whose parent is missing become roots.
+func buildCategoryTree(flat []*models.Category) []*models.Category {
+	byID := make(map[string]*models.Category, len(flat))
+	for _, cat := range flat {
+		cp := *cat
+		cp.Children = []*models.Category{}
+		byID[cp.ID] = &cp
+	}
+	roots := []*models.Category{}
+	for _, cat := range flat {
+		node := byID[cat.ID]
+		if parent, ok := byID[cat.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+			continue
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// categoryAndDescendants returns the ID of the category with the given slug
+// followed by those of all its subcategories, or nil if the slug is unknown
+func categoryAndDescendants(flat []*models.Category, slug string) []string {
+	var root *models.Category
+	for _, cat := range flat {
+		if cat.Slug == slug {
+			root = cat
+			break
+		}
+	}
+	if root == nil {
+		return nil
+	}
+
+	ids := []string{root.ID}
+	for i := 0; i < len(ids); i++ {
+		for _, cat := range flat {
+			if cat.ParentID == ids[i] {
+				ids = append(ids, cat.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// productCategoryFilter resolves ?category=<slug> into the category IDs to
+// list. ok is false when the slug names no category, so nothing can match.
+func (h *ProductsHandler) productCategoryFilter(ctx context.Context, slug string) (ids []string, ok bool, err error) {
+	slug = strings.TrimSpace(slug)
+	if slug == "" {
+		return nil, true, nil
+	}
+	cats, err := h.store.ListCategories(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	ids = categoryAndDescendants(cats, slug)
+	return ids, ids != nil, nil
+}
+
+// setProductCategory files p under a category. An explicit category_id wins
+// and also sets the category name; free text is linked to the category with
+// the same slug when there is one and kept as is otherwise.
+func setProductCategory(cats []*models.Category, p *models.Product, categoryID, category *string) error {
+	if categoryID != nil {
+		id := strings.TrimSpace(*categoryID)
+		if id != "" {
+			cat := findCategory(cats, id)
+			if cat == nil {
+				return errors.New("kategori tidak ditemukan")
+			}
+			p.CategoryID, p.Category = cat.ID, cat.Name
+			return nil
+		}
+		p.CategoryID, p.Category = "", ""
+	}
+	if category != nil {
+		p.CategoryID, p.Category = "", strings.TrimSpace(*category)
+		if slug := store.Slugify(p.Category); slug != "" {
+			for _, cat := range cats {
+				if cat.Slug == slug {
+					p.CategoryID, p.Category = cat.ID, cat.Name
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
@@ -21,30 +23,81 @@ type CheckoutHandler struct {
 	store        store.Store
 	pay          payment.Gateway
 	emailService *email.Service
+	lowStock     *LowStockAlerter
 }
 
-func NewCheckoutHandler(cfg *config.Config, st store.Store, gw payment.Gateway, es *email.Service) *CheckoutHandler {
+func NewCheckoutHandler(cfg *config.Config, st store.Store, gw payment.Gateway, es *email.Service, lsa *LowStockAlerter) *CheckoutHandler {
 	return &CheckoutHandler{
 		cfg:          cfg,
 		store:        st,
 		pay:          gw,
 		emailService: es,
+		lowStock:     lsa,
 	}
 }
 
 type checkoutReq struct {
 	PaymentMethod string `json:"payment_method"` // gopay, shopeepay, qris, bank_transfer
+	// Lokasi pengiriman, opsional; dipakai bila ALLOCATION_RULE=nearest
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
 }
 
 type orderResp struct {
-	OrderID     string            `json:"order_id"`
-	Status      string            `json:"status"`
-	Amount      int64             `json:"amount_cents"`
-	PaymentRef  string            `json:"payment_ref,omitempty"`
-	PaymentURL  string            `json:"payment_url,omitempty"`
-	RedirectURL string            `json:"redirect_url,omitempty"`
-	Items       []models.CartItem `json:"items,omitempty"`
-	CreatedAt   time.Time         `json:"created_at,omitempty"`
+	OrderID     string          `json:"order_id"`
+	Status      string          `json:"status"`
+	Amount      int64           `json:"amount_cents"`
+	PaymentRef  string          `json:"payment_ref,omitempty"`
+	PaymentURL  string          `json:"payment_url,omitempty"`
+	RedirectURL string          `json:"redirect_url,omitempty"`
+	Items       []orderItemResp `json:"items,omitempty"`
+	PayBefore   *time.Time      `json:"pay_before,omitempty"` // stock is released after this
+	CreatedAt   time.Time       `json:"created_at,omitempty"`
+}
+
+// payBefore is when an unpaid order expires, or nil once it no longer holds
+// stock
+func (h *CheckoutHandler) payBefore(o *models.Order) *time.Time {
+	if o.Reservation != models.ReservationHeld {
+		return nil
+	}
+	t := o.ReservedAt.Add(h.cfg.PaymentTimeout)
+	return &t
+}
+
+// orderItemResp is an order line with the product details order history
+// needs, resolved even when the product has since been archived
+type orderItemResp struct {
+	models.CartItem
+	Name      string `json:"name,omitempty"`
+	Variant   string `json:"variant,omitempty"` // e.g. "M / Merah"
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// describeOrderItems attaches product names to order lines. products caches
+// lookups across calls; lines whose product was purged keep only their IDs.
+func describeOrderItems(ctx context.Context, st store.Store, items []models.CartItem, products map[string]*models.Product) []orderItemResp {
+	res := make([]orderItemResp, 0, len(items))
+	for _, it := range items {
+		p, ok := products[it.ProductID]
+		if !ok {
+			p, _ = st.GetProduct(ctx, it.ProductID)
+			products[it.ProductID] = p
+		}
+		line := orderItemResp{CartItem: it}
+		if p != nil {
+			line.Name = p.Name
+			line.Thumbnail = p.Thumbnail
+			if v := p.Variant(it.VariantID); v != nil {
+				line.Variant = variantValues(p, v)
+				if v.Image != "" {
+					line.Thumbnail = v.Image
+				}
+			}
+		}
+		res = append(res, line)
+	}
+	return res
 }
 
 func (h *CheckoutHandler) Checkout(c *gin.Context) {
@@ -53,99 +106,90 @@ func (h *CheckoutHandler) Checkout(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment method required"})
 		return
 	}
+	if err := validateCoordinates(req.Latitude, req.Longitude); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	userID := c.GetString(string(middleware.UserIDKey))
 	email := c.GetString(string(middleware.EmailKey))
 
-	// Get user cart
-	cart, err := h.store.GetCart(userID)
-	if err != nil || len(cart.Items) == 0 {
+	// Pricing, stock, the order and clearing the cart happen in one store
+	// transaction. The gateway is only called once it is committed, so a
+	// slow payment provider never holds the cart or product locks.
+	ctx := store.WithAllocation(c.Request.Context(), store.Allocation{Rule: h.cfg.AllocationRule, Latitude: req.Latitude, Longitude: req.Longitude})
+	o, priced, err := h.store.CheckoutCart(ctx, userID)
+	switch {
+	case errors.Is(err, store.ErrCartEmpty):
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Keranjang kosong"})
 		return
+	case errors.Is(err, store.ErrInsufficientStock):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Stock tidak cukup: " + err.Error()})
+		return
+	case errors.Is(err, store.ErrProductArchived), errors.Is(err, store.ErrProductNotPublished):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Produk sudah tidak tersedia: " + err.Error()})
+		return
+	case errors.Is(err, store.ErrVariantRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pilih varian untuk setiap produk di keranjang"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Gagal membuat order: " + err.Error()})
+		return
 	}
 
-	// Calculate total amount and get product details
-	var amount int64
 	var itemsStr string
 	midtransItems := []payment.MidtransItem{}
-
-	for _, it := range cart.Items {
-		p, err := h.store.GetProduct(it.ProductID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product in cart: " + it.ProductID})
-			return
-		}
-
-		// Check stock
-		if p.Stock < it.Quantity {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Stock tidak cukup untuk %s (tersedia: %d)", p.Name, p.Stock),
-			})
-			return
-		}
-
-		itemAmount := int64(it.Quantity) * p.PriceCents
-		amount += itemAmount
+	for _, it := range o.Items {
+		p := priced[it.ProductID]
+		name := variantLabel(p, it.VariantID)
+		itemAmount := int64(it.Quantity) * it.PriceCents
 
 		// Build items string for email
-		itemsStr += fmt.Sprintf("- %s x%d = Rp %d\n", p.Name, it.Quantity, itemAmount/100)
+		itemsStr += fmt.Sprintf("- %s x%d = Rp %d\n", name, it.Quantity, itemAmount/100)
 
-		// Build Midtrans items
+		// Build Midtrans items; each variant is its own line
+		itemID := p.ID
+		if it.VariantID != "" {
+			itemID = it.VariantID
+		}
 		midtransItems = append(midtransItems, payment.MidtransItem{
-			ID:       p.ID,
-			Price:    p.PriceCents,
+			ID:       itemID,
+			Price:    it.PriceCents,
 			Quantity: it.Quantity,
-			Name:     p.Name,
+			Name:     name,
 		})
 	}
 
-	// Create order (status: pending)
-	o, err := h.store.CreateOrder(userID, cart.Items, amount, "pending", "")
+	paymentRef, paymentURL, err := h.charge(c.Request.Context(), o, email, req.PaymentMethod, midtransItems)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Gagal membuat order: " + err.Error()})
+		h.abandon(c.Request.Context(), o)
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Payment gagal: " + err.Error()})
 		return
 	}
-
-	// Process payment with Midtrans
-	ctx := context.Background()
-	metadata := map[string]string{
-		"order_id":      o.ID,
-		"email":         email,
-		"customer_name": email, // Could get from user profile
+	// An order without its reference cannot be matched to the payment, so
+	// it is given up, and its items go back to the cart, if saving fails
+	if err := h.savePaymentRef(c.Request.Context(), o.ID, paymentRef); err != nil {
+		log.Printf("⚠️  Failed to save payment reference %s of order %s: %v", paymentRef, o.ID, err)
+		h.abandon(c.Request.Context(), o)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Gagal menyimpan pembayaran, silakan checkout ulang"})
+		return
 	}
+	o.PaymentRef = paymentRef
 
-	// If using Midtrans, try to create Snap transaction
-	var paymentURL string
-	var paymentRef string
+	amount := o.Amount
 
-	if midtransGw, ok := h.pay.(*payment.MidtransGateway); ok {
-		// Use Snap for better UX
-		snapResp, err := midtransGw.CreateSnapTransaction(ctx, o.ID, amount, email, midtransItems)
-		if err != nil {
-			// Fallback to direct charge
-			paymentRef, err = h.pay.Charge(ctx, amount, req.PaymentMethod, metadata)
-			if err != nil {
-				c.JSON(http.StatusPaymentRequired, gin.H{"error": "Payment gagal: " + err.Error()})
-				return
-			}
-		} else {
-			paymentURL = snapResp.PaymentURL
-			paymentRef = snapResp.OrderID
-		}
-	} else {
-		// Mock gateway
-		paymentRef, err = h.pay.Charge(ctx, amount, req.PaymentMethod, metadata)
-		if err != nil {
-			c.JSON(http.StatusPaymentRequired, gin.H{"error": "Payment gagal"})
-			return
-		}
+	// priced carries the stock the order left; adding its units back gives
+	// the stock before it
+	before := map[string]int{}
+	after := make([]*models.Product, 0, len(priced))
+	for id, p := range priced {
+		before[id] = p.Stock
+		after = append(after, p)
 	}
-
-	// Update order with payment reference
-	_ = h.store.UpdateOrderPaymentRef(o.ID, paymentRef)
-
-	// Clear cart
-	h.store.ClearCart(userID)
+	for _, it := range o.Items {
+		before[it.ProductID] += it.Quantity
+	}
+	h.lowStock.Check(c.Request.Context(), before, after)
 
 	// Send order confirmation email (async)
 	if h.emailService != nil {
@@ -159,7 +203,8 @@ func (h *CheckoutHandler) Checkout(c *gin.Context) {
 		Status:     "pending",
 		Amount:     amount,
 		PaymentRef: paymentRef,
-		Items:      o.Items,
+		Items:      describeOrderItems(c.Request.Context(), h.store, o.Items, priced),
+		PayBefore:  h.payBefore(o),
 		CreatedAt:  o.CreatedAt,
 	}
 
@@ -171,14 +216,80 @@ func (h *CheckoutHandler) Checkout(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// abandon marks an order whose payment could not be requested or recorded
+// as failed, which puts its units back in stock, and returns its lines to
+// the cart
+func (h *CheckoutHandler) abandon(ctx context.Context, o *models.Order) {
+	ctx = context.WithoutCancel(ctx)
+	if err := h.store.UpdateOrderStatus(ctx, o.ID, "failed"); err != nil {
+		// The reservation expiry releases the units later
+		log.Printf("⚠️  Failed to release order %s after a failed payment: %v", o.ID, err)
+		return
+	}
+	for _, it := range o.Items {
+		if err := h.store.AddToCart(ctx, o.UserID, it.ProductID, it.VariantID, it.Quantity); err != nil {
+			log.Printf("⚠️  Failed to return %s to the cart of %s: %v", it.ProductID, o.UserID, err)
+		}
+	}
+}
+
+// paymentRefAttempts is how often savePaymentRef writes the reference
+// before giving up, waiting paymentRefRetryDelay longer after each failure
+const paymentRefAttempts = 3
+
+var paymentRefRetryDelay = 200 * time.Millisecond
+
+// savePaymentRef stores the reference of a charged order, retrying failed
+// writes. The request being canceled does not stop it.
+func (h *CheckoutHandler) savePaymentRef(ctx context.Context, orderID, ref string) error {
+	ctx = context.WithoutCancel(ctx)
+	var err error
+	for attempt := 1; attempt <= paymentRefAttempts; attempt++ {
+		if err = h.store.UpdateOrderPaymentRef(ctx, orderID, ref); err == nil {
+			return nil
+		}
+		if attempt < paymentRefAttempts {
+			time.Sleep(time.Duration(attempt) * paymentRefRetryDelay)
+		}
+	}
+	return err
+}
+
+// charge requests payment for a priced order and returns the payment
+// reference plus, for Midtrans Snap, the URL the customer should visit
+func (h *CheckoutHandler) charge(ctx context.Context, o *models.Order, email, method string, items []payment.MidtransItem) (string, string, error) {
+	metadata := map[string]string{
+		"order_id":      o.ID,
+		"email":         email,
+		"customer_name": email, // Could get from user profile
+	}
+
+	if midtransGw, ok := h.pay.(*payment.MidtransGateway); ok {
+		// Use Snap for better UX
+		snapResp, err := midtransGw.CreateSnapTransaction(ctx, o.ID, o.Amount, email, items)
+		if err == nil {
+			return snapResp.OrderID, snapResp.PaymentURL, nil
+		}
+		// Fallback to direct charge
+	}
+
+	ref, err := h.pay.Charge(ctx, o.Amount, method, metadata)
+	return ref, "", err
+}
+
 func (h *CheckoutHandler) MyOrders(c *gin.Context) {
+	page, ok := readPage(c)
+	if !ok {
+		return
+	}
 	userID := c.GetString(string(middleware.UserIDKey))
-	orders, err := h.store.ListOrdersByUser(userID)
+	orders, next, err := h.store.ListOrdersByUser(c.Request.Context(), userID, page)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		listError(c, err)
 		return
 	}
 
+	products := map[string]*models.Product{}
 	resp := make([]orderResp, 0, len(orders))
 	for _, o := range orders {
 		resp = append(resp, orderResp{
@@ -186,11 +297,12 @@ func (h *CheckoutHandler) MyOrders(c *gin.Context) {
 			Status:     o.Status,
 			Amount:     o.Amount,
 			PaymentRef: o.PaymentRef,
-			Items:      o.Items,
+			Items:      describeOrderItems(c.Request.Context(), h.store, o.Items, products),
+			PayBefore:  h.payBefore(o),
 			CreatedAt:  o.CreatedAt,
 		})
 	}
-	c.JSON(http.StatusOK, resp)
+	c.JSON(http.StatusOK, pageResp{Items: resp, NextCursor: next})
 }
 
 // MidtransCallback handles payment notifications from Midtrans
@@ -222,8 +334,28 @@ func (h *CheckoutHandler) MidtransCallback(c *gin.Context) {
 		status = "pending"
 	}
 
+	// A pending notification must not reopen an order that has meanwhile
+	// expired or failed and released its stock
+	o, err := h.store.GetOrder(c.Request.Context(), orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if status == "pending" && o.Status != "pending" {
+		c.JSON(http.StatusOK, gin.H{"message": "OK"})
+		return
+	}
+
 	// Update order status
-	if err := h.store.UpdateOrderStatus(orderID, status); err != nil {
+	err = h.store.UpdateOrderStatus(c.Request.Context(), orderID, status)
+	if errors.Is(err, store.ErrInsufficientStock) {
+		// Paid after expiring, with the stock sold since; Midtrans retries
+		// the notification, which goes through once stock is back
+		log.Printf("⚠️  Order %s paid after its stock was released: %v", orderID, err)
+		c.JSON(http.StatusConflict, gin.H{"error": "Stock no longer available"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
 		return
 	}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/config"
+	"github.com/example/ecommerce-api/internal/middleware"
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/payment"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// flakyRefStore fails as many payment reference writes as failures says
+type flakyRefStore struct {
+	*store.InMemoryStore
+	failures int
+}
+
+func (s *flakyRefStore) UpdateOrderPaymentRef(ctx context.Context, orderID, ref string) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("connection reset")
+	}
+	return s.InMemoryStore.UpdateOrderPaymentRef(ctx, orderID, ref)
+}
+
+// TestCheckoutSavesPaymentRef charges a cart while the store drops writes
+// of the payment reference. A write that succeeds on retry keeps the
+// order; one that never does fails it and gives the cart back.
+func TestCheckoutSavesPaymentRef(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	paymentRefRetryDelay = 0
+
+	for _, tc := range []struct {
+		name     string
+		failures int
+		code     int
+		status   string
+	}{
+		{"retried", paymentRefAttempts - 1, http.StatusOK, "pending"},
+		{"given up", paymentRefAttempts, http.StatusInternalServerError, "failed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := &flakyRefStore{InMemoryStore: store.NewInMemoryStore(), failures: tc.failures}
+			u, err := st.CreateUser(ctx, "Buyer", "0812", "buyer@example.com", "hash", "user", "email", "", true)
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			p, err := st.CreateProduct(ctx, &models.Product{Name: "Kopi", PriceCents: 25000, SKU: "KOPI-1", Stock: 5})
+			if err != nil {
+				t.Fatalf("CreateProduct: %v", err)
+			}
+			if err := st.AddToCart(ctx, u.ID, p.ID, "", 2); err != nil {
+				t.Fatalf("AddToCart: %v", err)
+			}
+
+			h := NewCheckoutHandler(&config.Config{}, st, &payment.MockGateway{}, nil, nil)
+			r := gin.New()
+			r.POST("/checkout", func(c *gin.Context) {
+				c.Set(string(middleware.UserIDKey), u.ID)
+				c.Set(string(middleware.EmailKey), u.Email)
+			}, h.Checkout)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/checkout", strings.NewReader(`{"payment_method": "qris"}`))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+			if w.Code != tc.code {
+				t.Fatalf("Checkout = %d %s, want %d", w.Code, w.Body, tc.code)
+			}
+
+			orders, _, err := st.ListOrdersByUser(ctx, u.ID, store.Page{})
+			if err != nil || len(orders) != 1 {
+				t.Fatalf("ListOrdersByUser = %d orders, %v; want 1", len(orders), err)
+			}
+			o := orders[0]
+			if o.Status != tc.status {
+				t.Fatalf("order status = %q, want %q", o.Status, tc.status)
+			}
+			c, err := st.GetCart(ctx, u.ID)
+			if err != nil {
+				t.Fatalf("GetCart: %v", err)
+			}
+			if tc.status == "pending" {
+				if o.PaymentRef != "pay_"+o.ID || len(c.Items) != 0 {
+					t.Fatalf("order ref %q with %d cart lines left, want pay_%s and none", o.PaymentRef, len(c.Items), o.ID)
+				}
+				return
+			}
+			if len(c.Items) != 1 || c.Items[0].Quantity != 2 {
+				t.Fatalf("cart after giving up = %+v, want the 2 units back", c.Items)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/email"
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// LowStockAlerter emails every admin when products drop below their stock
+// threshold
+type LowStockAlerter struct {
+	store       store.Store
+	email       *email.Service
+	threshold   int // for products without their own
+	frontendURL string
+}
+
+func NewLowStockAlerter(st store.Store, es *email.Service, threshold int, frontendURL string) *LowStockAlerter {
+	return &LowStockAlerter{store: st, email: es, threshold: threshold, frontendURL: strings.TrimRight(frontendURL, "/")}
+}
+
+// Check alerts admins about the products, as a write returned them, whose
+// stock went below their threshold with it. before holds each one's stock
+// ahead of the write by ID. One email lists all of them.
+func (a *LowStockAlerter) Check(ctx context.Context, before map[string]int, after []*models.Product) {
+	if a == nil || a.email == nil {
+		return
+	}
+	after = slices.Clone(after)
+	slices.SortFunc(after, func(x, y *models.Product) int { return strings.Compare(x.ID, y.ID) })
+
+	items := []email.LowStockItem{}
+	for _, p := range after {
+		was, ok := before[p.ID]
+		if !ok || p.ArchivedAt != nil {
+			continue
+		}
+		threshold := p.StockThreshold(a.threshold)
+		if p.Stock < threshold && was >= threshold {
+			items = append(items, email.LowStockItem{
+				Name:      p.Name,
+				SKU:       p.SKU,
+				Stock:     p.Stock,
+				Threshold: threshold,
+				URL:       a.frontendURL + "/admin/products/" + p.ID,
+			})
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	admins, err := a.store.ListUsersByRole(ctx, "admin")
+	if err != nil {
+		log.Printf("⚠️  Failed to list admins for a low-stock alert: %v", err)
+		return
+	}
+	go func() {
+		for _, u := range admins {
+			if err := a.email.SendLowStockAlert(u.Email, items); err != nil {
+				log.Printf("⚠️  Failed to send low-stock alert to %s: %v", u.Email, err)
+			}
+		}
+	}()
+}
+
+type InventoryHandler struct {
+	store     store.Store
+	threshold int
+}
+
+func NewInventoryHandler(st store.Store, threshold int) *InventoryHandler {
+	return &InventoryHandler{store: st, threshold: threshold}
+}
+
+type lowStockResp struct {
+	*models.Product
+	Threshold int `json:"threshold"` // batas yang berlaku, milik produk atau default
+}
+
+// LowStock lists the products below their stock threshold, lowest stock
+// first
+func (h *InventoryHandler) LowStock(c *gin.Context) {
+	ps, err := h.store.ListLowStockProducts(c.Request.Context(), h.threshold)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	res := make([]lowStockResp, len(ps))
+	for i, p := range ps {
+		res[i] = lowStockResp{Product: p, Threshold: p.StockThreshold(h.threshold)}
+	}
+	c.JSON(http.StatusOK, gin.H{"default_threshold": h.threshold, "items": res})
+}
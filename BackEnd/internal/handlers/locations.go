@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/audit"
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+type LocationsHandler struct {
+	store store.Store
+}
+
+func NewLocationsHandler(st store.Store) *LocationsHandler {
+	return &LocationsHandler{store: st}
+}
+
+// List returns every location by priority; the first is the default that
+// new stock goes to
+func (h *LocationsHandler) List(c *gin.Context) {
+	locs, err := h.store.ListLocations(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, locs)
+}
+
+// Admin create location
+
+type createLocationReq struct {
+	Name      string   `json:"name"`
+	Code      string   `json:"code"`
+	Priority  int      `json:"priority"`  // kecil = dipakai lebih dulu
+	Latitude  *float64 `json:"latitude"`  // isi bersama longitude untuk alokasi terdekat
+	Longitude *float64 `json:"longitude"` // isi bersama latitude untuk alokasi terdekat
+}
+
+func (h *LocationsHandler) Create(c *gin.Context) {
+	var req createLocationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+
+	l := &models.Location{
+		Name:      strings.TrimSpace(req.Name),
+		Code:      strings.ToUpper(strings.TrimSpace(req.Code)),
+		Priority:  req.Priority,
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
+	}
+	if err := validateLocation(l); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := h.store.CreateLocation(c.Request.Context(), l)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "location.create", "location", res.ID, nil, res)
+	c.JSON(http.StatusOK, res)
+}
+
+// Admin update location
+
+type updateLocationReq struct {
+	Name     *string `json:"name"`
+	Code     *string `json:"code"`
+	Priority *int    `json:"priority"`
+	// Koordinat diganti bersamaan
+	Latitude         *float64 `json:"latitude"`
+	Longitude        *float64 `json:"longitude"`
+	ClearCoordinates bool     `json:"clear_coordinates"` // true = hapus koordinat
+}
+
+func (h *LocationsHandler) Update(c *gin.Context) {
+	id := c.Param("id")
+	var req updateLocationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload: " + err.Error()})
+		return
+	}
+	locs, err := h.store.ListLocations(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if findLocation(locs, id) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "location not found"})
+		return
+	}
+
+	var before models.Location
+	res, err := h.store.UpdateLocation(c.Request.Context(), id, func(l *models.Location) error {
+		before = *l
+		if req.Name != nil {
+			l.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.Code != nil {
+			l.Code = strings.ToUpper(strings.TrimSpace(*req.Code))
+		}
+		if req.Priority != nil {
+			l.Priority = *req.Priority
+		}
+		switch {
+		case req.ClearCoordinates:
+			l.Latitude, l.Longitude = nil, nil
+		case req.Latitude != nil || req.Longitude != nil:
+			l.Latitude, l.Longitude = req.Latitude, req.Longitude
+		}
+		return validateLocation(l)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "location.update", "location", id, before, res)
+	c.JSON(http.StatusOK, res)
+}
+
+// Delete removes a location that holds no stock; the last one left is kept
+func (h *LocationsHandler) Delete(c *gin.Context) {
+	id := c.Param("id")
+	locs, err := h.store.ListLocations(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	before := findLocation(locs, id)
+	if before == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "location not found"})
+		return
+	}
+
+	err = h.store.DeleteLocation(c.Request.Context(), id)
+	if errors.Is(err, store.ErrLocationInUse) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Lokasi masih menyimpan stok atau satu-satunya lokasi"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "location.delete", "location", id, before, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// validateLocation requires a name and either both coordinates, in range,
+// or neither
+func validateLocation(l *models.Location) error {
+	if l.Name == "" {
+		return errors.New("name wajib diisi")
+	}
+	if len(l.Name) > 255 || len(l.Code) > 50 {
+		return errors.New("name maksimal 255 karakter dan code maksimal 50 karakter")
+	}
+	return validateCoordinates(l.Latitude, l.Longitude)
+}
+
+// validateCoordinates accepts a latitude and longitude given together and
+// in range, or neither
+func validateCoordinates(lat, lng *float64) error {
+	if (lat == nil) != (lng == nil) {
+		return errors.New("latitude dan longitude harus diisi bersamaan")
+	}
+	if lat != nil && (*lat < -90 || *lat > 90 || *lng < -180 || *lng > 180) {
+		return errors.New("latitude harus -90 sampai 90 dan longitude -180 sampai 180")
+	}
+	return nil
+}
+
+func findLocation(locs []*models.Location, id string) *models.Location {
+	for _, l := range locs {
+		if l.ID == id {
+			return l
+		}
+	}
+	return nil
+}
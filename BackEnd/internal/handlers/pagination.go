@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageResp is the envelope of every paginated list. NextCursor is passed
+// back as ?cursor= to get the following page; it is empty on the last one.
+type pageResp struct {
+	Items      any    `json:"items"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// readPage parses ?cursor= and ?limit=. On a bad limit it writes the 400
+// response itself and returns false.
+func readPage(c *gin.Context) (store.Page, bool) {
+	page := store.Page{Cursor: c.Query("cursor"), Limit: defaultPageLimit}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit harus angka positif"})
+			return page, false
+		}
+		page.Limit = min(n, maxPageLimit)
+	}
+	return page, true
+}
+
+// listError answers a failed list call: 400 for a cursor the store rejected,
+// 500 for anything else
+func listError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor tidak valid"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
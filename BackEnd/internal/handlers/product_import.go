@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/audit"
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// productCSVColumns are the columns of the catalog CSV, in export order.
+// Imports may order them freely and leave out all but the required ones.
+var productCSVColumns = []string{"sku", "name", "description", "category", "price_cents", "stock", "thumbnail", "images", "archived"}
+
+var requiredCSVColumns = []string{"sku", "name", "price_cents"}
+
+const (
+	maxImportBytes = 10 << 20
+	maxImportRows  = 5000
+	exportPageSize = 200
+)
+
+// importRowError lists what is wrong with one CSV row; Row counts the header
+// as row 1, as spreadsheets do
+type importRowError struct {
+	Row    int      `json:"row"`
+	SKU    string   `json:"sku,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+type importResp struct {
+	DryRun  bool             `json:"dry_run"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Errors  []importRowError `json:"errors"`
+}
+
+// csvRow is one data row by column name
+type csvRow struct {
+	line  int
+	cells map[string]string
+}
+
+// Import upserts products by SKU from a CSV upload, either a multipart
+// "file" or the raw request body. Every row is first checked against the
+// catalog without writing anything; only when all rows pass are they
+// applied, in one transaction. ?dry_run=true stops after the check.
+func (h *ProductsHandler) Import(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	rows, err := readProductCSV(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cats, err := h.store.ListCategories(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Rows without a usable SKU cannot be matched and are only reported
+	resp := importResp{DryRun: dryRun, Errors: []importRowError{}}
+	rowErrs := make([][]string, len(rows))
+	skus := []string{}
+	keyed := []int{}
+	firstLine := map[string]int{}
+	for i, row := range rows {
+		sku := row.cells["sku"]
+		switch line, dup := firstLine[sku]; {
+		case sku == "":
+			rowErrs[i] = append(rowErrs[i], "sku wajib diisi")
+		case dup:
+			rowErrs[i] = append(rowErrs[i], fmt.Sprintf("sku sudah dipakai di baris %d", line))
+		default:
+			firstLine[sku] = row.line
+			skus = append(skus, sku)
+			keyed = append(keyed, i)
+		}
+	}
+
+	check, err := h.store.ImportProducts(c.Request.Context(), skus, true, func(i int, p *models.Product) error {
+		row := keyed[i]
+		rowErrs[row] = append(rowErrs[row], applyProductCSVRow(cats, p, rows[row].cells)...)
+		return nil
+	})
+	if err != nil {
+		importError(c, err)
+		return
+	}
+	for i, errs := range rowErrs {
+		if len(errs) > 0 {
+			resp.Errors = append(resp.Errors, importRowError{Row: rows[i].line, SKU: rows[i].cells["sku"], Errors: errs})
+		}
+	}
+	// Counts are of the rows that would be written
+	for i, row := range check {
+		if len(rowErrs[keyed[i]]) > 0 {
+			continue
+		}
+		if row.Created {
+			resp.Created++
+		} else {
+			resp.Updated++
+		}
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if len(resp.Errors) > 0 {
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	// The catalog may have changed since the check; a row that no longer
+	// passes aborts the import
+	done, err := h.store.ImportProducts(c.Request.Context(), skus, false, func(i int, p *models.Product) error {
+		row := rows[keyed[i]]
+		if errs := applyProductCSVRow(cats, p, row.cells); len(errs) > 0 {
+			return fmt.Errorf("baris %d: %s", row.line, strings.Join(errs, "; "))
+		}
+		return nil
+	})
+	if err != nil {
+		importError(c, err)
+		return
+	}
+	created, updated := []string{}, []string{}
+	for _, row := range done {
+		if row.Created {
+			created = append(created, row.Product.SKU)
+		} else {
+			updated = append(updated, row.Product.SKU)
+		}
+	}
+	resp.Created, resp.Updated = len(created), len(updated)
+	audit.Record(c, "product.import", "product", "", nil, gin.H{"created": created, "updated": updated})
+	c.JSON(http.StatusOK, resp)
+}
+
+func importError(c *gin.Context, err error) {
+	if errors.Is(err, store.ErrSKUTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU sudah dipakai produk lain"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// readProductCSV reads the upload into rows keyed by column name. Excel's
+// byte order mark is skipped and a header with more semicolons than commas
+// switches the separator to semicolons, as Excel writes it in locales with
+// a decimal comma.
+func readProductCSV(c *gin.Context) ([]csvRow, error) {
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return nil, errors.New("file diperlukan")
+		}
+		if fh.Size > maxImportBytes {
+			return nil, errors.New("ukuran file maksimal 10MB")
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		body = f
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxImportBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportBytes {
+		return nil, errors.New("ukuran file maksimal 10MB")
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	r := csv.NewReader(bytes.NewReader(data))
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(header, []byte(";")) > bytes.Count(header, []byte(",")) {
+		r.Comma = ';'
+	}
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("CSV tidak valid: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("CSV kosong")
+	}
+	if len(records)-1 > maxImportRows {
+		return nil, fmt.Errorf("maksimal %d baris per impor", maxImportRows)
+	}
+
+	columns := make([]string, len(records[0]))
+	seen := map[string]bool{}
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(productCSVColumns, name) {
+			return nil, fmt.Errorf("kolom %q tidak dikenal; kolom yang bisa dipakai: %s", name, strings.Join(productCSVColumns, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("kolom %q muncul dua kali", name)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+	for _, name := range requiredCSVColumns {
+		if !seen[name] {
+			return nil, fmt.Errorf("kolom %q wajib ada", name)
+		}
+	}
+
+	rows := make([]csvRow, 0, len(records)-1)
+	for i, rec := range records[1:] {
+		row := csvRow{line: i + 2, cells: make(map[string]string, len(columns))}
+		for j, name := range columns {
+			row.cells[name] = strings.TrimSpace(rec[j])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// applyProductCSVRow writes the columns present in cells onto p and returns
+// what is wrong with them. Columns left out of the file keep the stored
+// values; on new products they start empty.
+func applyProductCSVRow(cats []*models.Category, p *models.Product, cells map[string]string) []string {
+	errs := []string{}
+	if p.Name = cells["name"]; p.Name == "" {
+		errs = append(errs, "name wajib diisi")
+	}
+	if n, err := strconv.ParseInt(cells["price_cents"], 10, 64); err != nil || n <= 0 {
+		errs = append(errs, "price_cents harus angka lebih dari 0")
+	} else {
+		p.SetBasePrice(n)
+	}
+	if v, ok := cells["description"]; ok {
+		p.Description = v
+	}
+	if v, ok := cells["category"]; ok {
+		if err := setProductCategory(cats, p, nil, &v); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if v, ok := cells["stock"]; ok {
+		n, err := strconv.Atoi(v)
+		switch {
+		case err != nil || n < 0:
+			errs = append(errs, "stock harus angka 0 atau lebih")
+		case len(p.Variants) > 0 && n != p.Stock:
+			errs = append(errs, "stock produk bervarian diatur per varian")
+		default:
+			p.Stock = n
+		}
+	}
+	if v, ok := cells["thumbnail"]; ok {
+		p.Thumbnail = v
+	}
+	if v, ok := cells["images"]; ok {
+		images, err := normalizeImages(parseImagesCell(v))
+		if err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			p.Images = images
+		}
+	}
+	if v, ok := cells["archived"]; ok {
+		archived := false
+		if v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, "archived harus true atau false")
+			}
+			archived = b
+		}
+		switch {
+		case archived && p.ArchivedAt == nil:
+			now := time.Now()
+			p.ArchivedAt, p.Status = &now, models.ProductArchived
+		case !archived && p.ArchivedAt != nil:
+			p.ArchivedAt, p.Status = nil, models.ProductPublished
+		}
+	}
+	return errs
+}
+
+// parseImagesCell reads a gallery written by formatImagesCell: images
+// separated by "|", each a URL optionally followed by a space and alt text
+func parseImagesCell(cell string) []models.ProductImage {
+	images := []models.ProductImage{}
+	if cell == "" {
+		return images
+	}
+	for _, part := range strings.Split(cell, "|") {
+		url, alt, _ := strings.Cut(strings.TrimSpace(part), " ")
+		images = append(images, models.ProductImage{URL: url, Alt: alt})
+	}
+	return images
+}
+
+func formatImagesCell(images []models.ProductImage) string {
+	parts := make([]string, len(images))
+	for i, img := range images {
+		parts[i] = strings.TrimSpace(img.URL + " " + img.Alt)
+	}
+	return strings.Join(parts, "|")
+}
+
+// Export streams the whole catalog, archived products included, as CSV in
+// the columns Import reads
+func (h *ProductsHandler) Export(c *gin.Context) {
+	ctx := c.Request.Context()
+	filter := store.ProductFilter{IncludeArchived: true}
+	page := store.Page{Limit: exportPageSize}
+	ps, next, err := h.store.ListProducts(ctx, filter, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="products-%s.csv"`, time.Now().Format("20060102")))
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write(productCSVColumns)
+	for {
+		for _, p := range ps {
+			_ = w.Write([]string{
+				p.SKU,
+				p.Name,
+				p.Description,
+				p.Category,
+				strconv.FormatInt(p.BasePrice(), 10),
+				strconv.Itoa(p.Stock),
+				p.Thumbnail,
+				formatImagesCell(p.Images),
+				strconv.FormatBool(p.ArchivedAt != nil),
+			})
+		}
+		w.Flush()
+		c.Writer.Flush()
+		if next == "" {
+			break
+		}
+		// Headers are out, so a failure can only cut the file short
+		page.Cursor = next
+		if ps, next, err = h.store.ListProducts(ctx, filter, page); err != nil {
+			log.Printf("⚠️  Product export stopped: %v", err)
+			return
+		}
+	}
+	if err := w.Error(); err != nil {
+		log.Printf("⚠️  Product export failed: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+// productStatuses are the values of ?status= on the admin product list
+var productStatuses = []string{models.ProductDraft, models.ProductPublished, models.ProductArchived}
+
+// setProductLifecycle applies the status and publish window of a create or
+// update request; nil or empty leaves the status as it is. Times are
+// RFC3339 and "" clears them. Archiving goes through Delete and Restore, so
+// only draft and published can be set, and not on an archived product.
+func setProductLifecycle(p *models.Product, status, publishAt, unpublishAt *string) error {
+	if status != nil && strings.TrimSpace(*status) != "" {
+		s := strings.ToLower(strings.TrimSpace(*status))
+		if s != models.ProductDraft && s != models.ProductPublished {
+			return errors.New("status harus draft atau published")
+		}
+		if p.Status == models.ProductArchived {
+			return errors.New("produk diarsipkan, pulihkan dulu sebelum mengubah status")
+		}
+		p.Status = s
+	}
+	for _, f := range []struct {
+		name string
+		in   *string
+		dst  **time.Time
+	}{{"publish_at", publishAt, &p.PublishAt}, {"unpublish_at", unpublishAt, &p.UnpublishAt}} {
+		if f.in == nil {
+			continue
+		}
+		v := strings.TrimSpace(*f.in)
+		if v == "" {
+			*f.dst = nil
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return fmt.Errorf("%s harus berformat RFC3339, contoh 2026-03-01T09:00:00+07:00", f.name)
+		}
+		*f.dst = &t
+	}
+	if p.PublishAt != nil && p.UnpublishAt != nil && !p.UnpublishAt.After(*p.PublishAt) {
+		return errors.New("unpublish_at harus setelah publish_at")
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/email"
+	"github.com/example/ecommerce-api/internal/middleware"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// restockEmailGap is the least time between two back-in-stock emails, so a
+// big restock does not flood the SMTP server
+const restockEmailGap = time.Second
+
+// RestockNotifier emails the subscribers of a product once it is back in
+// stock. Emails are queued and sent one at a time by a background worker;
+// a subscription is only removed once its email has gone out, so one that
+// could not be sent is tried again on the next restock.
+type RestockNotifier struct {
+	store       store.Store
+	send        func(to, productName, productURL string) error // nil without an email service
+	frontendURL string
+
+	mu      sync.Mutex
+	queue   []restockEmail
+	pending map[string]bool // subscriptions queued or being sent, by subscriptionKey
+	wake    chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+// restockEmail is one email to a subscriber, covering every line of the
+// product they are waiting for that is back
+type restockEmail struct {
+	userID     string
+	productID  string
+	variantIDs []string
+}
+
+// NewRestockNotifier starts the worker sending the queued emails; without
+// an email service nothing is sent and subscriptions are kept until there is
+// one. Stop ends the worker.
+func NewRestockNotifier(st store.Store, es *email.Service, frontendURL string) *RestockNotifier {
+	var send func(to, productName, productURL string) error
+	if es != nil {
+		send = es.SendBackInStock
+	}
+	return newRestockNotifier(st, send, frontendURL)
+}
+
+func newRestockNotifier(st store.Store, send func(to, productName, productURL string) error, frontendURL string) *RestockNotifier {
+	n := &RestockNotifier{
+		store:       st,
+		send:        send,
+		frontendURL: strings.TrimRight(frontendURL, "/"),
+		pending:     map[string]bool{},
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	if send != nil {
+		n.wg.Add(1)
+		go n.run()
+	}
+	return n
+}
+
+// subscriptionKey identifies a subscription in RestockNotifier.pending
+func subscriptionKey(userID, productID, variantID string) string {
+	return userID + "/" + productID + "/" + variantID
+}
+
+// Notify queues one email per subscriber waiting for a line of productID
+// that is in stock now. Subscriptions already queued are left alone.
+func (n *RestockNotifier) Notify(ctx context.Context, productID string) {
+	if n == nil || n.send == nil {
+		return
+	}
+	subs, err := n.store.ListRestockedSubscriptions(ctx, productID)
+	if err != nil {
+		log.Printf("⚠️  Failed to list stock subscriptions of %s: %v", productID, err)
+		return
+	}
+
+	n.mu.Lock()
+	byUser := map[string]int{} // index in n.queue of the email queued now
+	for _, sub := range subs {
+		key := subscriptionKey(sub.UserID, sub.ProductID, sub.VariantID)
+		if n.pending[key] {
+			continue
+		}
+		n.pending[key] = true
+		if i, ok := byUser[sub.UserID]; ok {
+			n.queue[i].variantIDs = append(n.queue[i].variantIDs, sub.VariantID)
+			continue
+		}
+		byUser[sub.UserID] = len(n.queue)
+		n.queue = append(n.queue, restockEmail{userID: sub.UserID, productID: productID, variantIDs: []string{sub.VariantID}})
+	}
+	queued := len(byUser) > 0
+	n.mu.Unlock()
+
+	if !queued {
+		return
+	}
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop ends the worker. Emails still queued are not sent; their
+// subscriptions are kept for the next restock.
+func (n *RestockNotifier) Stop() {
+	n.once.Do(func() {
+		close(n.done)
+		n.wg.Wait()
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if len(n.queue) > 0 {
+			log.Printf("⚠️  %d queued back-in-stock emails not sent; their subscriptions are kept", len(n.queue))
+		}
+	})
+}
+
+func (n *RestockNotifier) run() {
+	defer n.wg.Done()
+	for {
+		n.mu.Lock()
+		var next *restockEmail
+		if len(n.queue) > 0 {
+			next = &n.queue[0]
+			n.queue = n.queue[1:]
+		}
+		n.mu.Unlock()
+
+		if next == nil {
+			select {
+			case <-n.wake:
+				continue
+			case <-n.done:
+				return
+			}
+		}
+
+		n.deliver(*next)
+		n.mu.Lock()
+		for _, variantID := range next.variantIDs {
+			delete(n.pending, subscriptionKey(next.userID, next.productID, variantID))
+		}
+		n.mu.Unlock()
+
+		select {
+		case <-time.After(restockEmailGap):
+		case <-n.done:
+			return
+		}
+	}
+}
+
+// deliver sends one email and then removes the subscriptions it covers.
+// On any failure they are kept for the next restock.
+func (n *RestockNotifier) deliver(e restockEmail) {
+	ctx := context.Background()
+	u, err := n.store.GetUserByID(ctx, e.userID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load subscriber %s for a back-in-stock email: %v", e.userID, err)
+		return
+	}
+	p, err := n.store.GetProduct(ctx, e.productID)
+	if err != nil {
+		log.Printf("⚠️  Failed to load product %s for a back-in-stock email: %v", e.productID, err)
+		return
+	}
+	if err := n.send(u.Email, p.Name, n.frontendURL+"/products/"+p.ID); err != nil {
+		log.Printf("⚠️  Failed to send back-in-stock email to %s: %v", u.Email, err)
+		return
+	}
+	for _, variantID := range e.variantIDs {
+		if err := n.store.UnsubscribeStock(ctx, e.userID, e.productID, variantID); err != nil {
+			log.Printf("⚠️  Failed to remove stock subscription of %s to %s after emailing: %v", e.userID, e.productID, err)
+		}
+	}
+}
+
+// Back-in-stock subscriptions
+
+type stockSubscriptionReq struct {
+	VariantID string `json:"variant_id"` // wajib untuk produk bervarian
+}
+
+// Subscribe asks for an email when an out of stock product, or one of its
+// variants, is back in stock
+func (h *ProductsHandler) Subscribe(c *gin.Context) {
+	var req stockSubscriptionReq
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	p, err := h.store.GetProduct(ctx, c.Param("id"))
+	if err != nil || !p.Live(time.Now()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	if req.VariantID == "" && len(p.Variants) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Produk ini punya varian; pilih variant_id"})
+		return
+	}
+	if req.VariantID != "" && p.Variant(req.VariantID) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "variant not found"})
+		return
+	}
+
+	userID := c.GetString(string(middleware.UserIDKey))
+	sub, err := h.store.SubscribeStock(ctx, userID, p.ID, req.VariantID)
+	switch {
+	case errors.Is(err, store.ErrInStock):
+		c.JSON(http.StatusConflict, gin.H{"error": "Produk masih tersedia"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+// Unsubscribe cancels a back-in-stock email; it is fine if there was none
+func (h *ProductsHandler) Unsubscribe(c *gin.Context) {
+	var req stockSubscriptionReq
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+			return
+		}
+	}
+	userID := c.GetString(string(middleware.UserIDKey))
+	if err := h.store.UnsubscribeStock(c.Request.Context(), userID, c.Param("id"), req.VariantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// TestRestockNotifierKeepsUnsent fails the first back-in-stock email and
+// expects the subscription to survive it, then to go once an email is sent
+func TestRestockNotifierKeepsUnsent(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewInMemoryStore()
+
+	u, err := st.CreateUser(ctx, "Subscriber", "0812", "sub@example.com", "hash", "user", "email", "", true)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	p, err := st.CreateProduct(ctx, &models.Product{Name: "Kopi", PriceCents: 25000, SKU: "KOPI-1"})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	if _, err := st.SubscribeStock(ctx, u.ID, p.ID, ""); err != nil {
+		t.Fatalf("SubscribeStock: %v", err)
+	}
+	if _, _, err := st.AdjustStock(ctx, p.ID, "", "", 3, models.StockRestock, ""); err != nil {
+		t.Fatalf("AdjustStock: %v", err)
+	}
+
+	results := make(chan error, 2)
+	results <- errors.New("smtp down")
+	results <- nil
+	sent := make(chan struct{}, 2)
+	rn := newRestockNotifier(st, func(to, productName, productURL string) error {
+		defer func() { sent <- struct{}{} }()
+		return <-results
+	}, "https://shop.example.com")
+	defer rn.Stop()
+
+	subscribed := func() int {
+		t.Helper()
+		subs, err := st.ListRestockedSubscriptions(ctx, p.ID)
+		if err != nil {
+			t.Fatalf("ListRestockedSubscriptions: %v", err)
+		}
+		return len(subs)
+	}
+	wait := func() {
+		t.Helper()
+		select {
+		case <-sent:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no back-in-stock email attempted")
+		}
+	}
+
+	rn.Notify(ctx, p.ID)
+	wait()
+	if n := subscribed(); n != 1 {
+		t.Fatalf("%d subscriptions after a failed email, want 1", n)
+	}
+
+	// The failed email must have left the queue before the next restock
+	idle := func() bool {
+		rn.mu.Lock()
+		defer rn.mu.Unlock()
+		return len(rn.pending) == 0
+	}
+	for deadline := time.Now().Add(5 * time.Second); !idle(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("failed email still pending")
+		}
+	}
+	rn.Notify(ctx, p.ID)
+	wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for subscribed() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription kept after its email was sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/audit"
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// Admin product prices
+
+// priceTimelineResp is where a product's price has been and where it is
+// going: the price now and outside sales, the schedules by start time and
+// the history, newest first
+type priceTimelineResp struct {
+	ProductID         string                  `json:"product_id"`
+	PriceCents        int64                   `json:"price_cents"`
+	RegularPriceCents int64                   `json:"regular_price_cents"`
+	OnSale            bool                    `json:"on_sale"`
+	Schedules         []*models.PriceSchedule `json:"schedules"`
+	History           []*models.PriceChange   `json:"history"`
+}
+
+// Prices answers the price timeline of a product
+func (h *ProductsHandler) Prices(c *gin.Context) {
+	ctx := c.Request.Context()
+	p, err := h.store.GetProduct(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	schedules, err := h.store.ListPriceSchedules(ctx, p.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	history, err := h.store.ListPriceHistory(ctx, p.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, priceTimelineResp{
+		ProductID:         p.ID,
+		PriceCents:        p.PriceCents,
+		RegularPriceCents: p.BasePrice(),
+		OnSale:            p.RegularPriceCents != nil,
+		Schedules:         schedules,
+		History:           history,
+	})
+}
+
+type createPriceScheduleReq struct {
+	PriceCents json.Number `json:"price_cents"`
+	StartsAt   string      `json:"starts_at"` // kosong = sekarang
+	EndsAt     string      `json:"ends_at"`   // kosong = harga baru permanen
+}
+
+// CreatePriceSchedule schedules a price change, or with ends_at a sale after
+// which the regular price comes back. A start in the past means now.
+func (h *ProductsHandler) CreatePriceSchedule(c *gin.Context) {
+	var req createPriceScheduleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	p, err := h.store.GetProduct(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+
+	price, err := parsePriceNumber(req.PriceCents)
+	if err != nil || price <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "price_cents harus angka lebih dari 0"})
+		return
+	}
+	now := time.Now()
+	sc := &models.PriceSchedule{ProductID: p.ID, PriceCents: price, StartsAt: now}
+	if v := strings.TrimSpace(req.StartsAt); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "starts_at harus berformat RFC3339, contoh 2026-03-01T09:00:00+07:00"})
+			return
+		}
+		if t.After(now) {
+			sc.StartsAt = t
+		}
+	}
+	if v := strings.TrimSpace(req.EndsAt); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at harus berformat RFC3339, contoh 2026-03-08T23:59:59+07:00"})
+			return
+		}
+		if !t.After(sc.StartsAt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at harus setelah starts_at dan belum lewat"})
+			return
+		}
+		sc.EndsAt = &t
+	}
+
+	res, err := h.store.CreatePriceSchedule(c.Request.Context(), sc)
+	if errors.Is(err, store.ErrPriceScheduleOverlap) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Jadwal diskon bertabrakan dengan diskon lain untuk produk ini"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "price_schedule.create", "price_schedule", res.ID, nil, res)
+	c.JSON(http.StatusOK, res)
+}
+
+// CancelPriceSchedule cancels a schedule that has not started yet, or ends
+// a running sale now
+func (h *ProductsHandler) CancelPriceSchedule(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, scheduleID := c.Param("id"), c.Param("scheduleId")
+	schedules, err := h.store.ListPriceSchedules(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	var before *models.PriceSchedule
+	for _, sc := range schedules {
+		if sc.ID == scheduleID {
+			before = sc
+		}
+	}
+	if before == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "price schedule not found"})
+		return
+	}
+
+	res, err := h.store.CancelPriceSchedule(ctx, id, scheduleID)
+	if errors.Is(err, store.ErrPriceScheduleDone) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Jadwal harga sudah selesai atau dibatalkan"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "price_schedule.cancel", "price_schedule", res.ID, before, res)
+	c.JSON(http.StatusOK, res)
+}
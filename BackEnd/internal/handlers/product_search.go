@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// priceFacetBounds are the lower ends of the price buckets in the facets:
+// Rp0, Rp50rb, Rp100rb, Rp250rb, Rp500rb and Rp1jt
+var priceFacetBounds = []int64{0, 5_000_000, 10_000_000, 25_000_000, 50_000_000, 100_000_000}
+
+var productSorts = map[string]store.ProductSort{
+	"":           store.SortNewest,
+	"newest":     store.SortNewest,
+	"price_asc":  store.SortPriceAsc,
+	"price_desc": store.SortPriceDesc,
+	"rating":     store.SortRating,
+	"bestseller": store.SortBestSelling,
+	"relevance":  store.SortRelevance,
+}
+
+// productListResp is a page of products with, on the first page of the
+// public list, the facets of the whole result
+type productListResp struct {
+	pageResp
+	Facets *productFacetsResp `json:"facets,omitempty"`
+}
+
+type productFacetsResp struct {
+	Categories []categoryFacet `json:"categories"`
+	Prices     []priceFacet    `json:"prices"`
+}
+
+// categoryFacet counts a category's products including its subcategories'
+type categoryFacet struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parent_id,omitempty"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Count    int    `json:"count"`
+}
+
+// priceFacet is a price range; MaxPriceCents is exclusive and left out on
+// the last, open-ended range
+type priceFacet struct {
+	MinPriceCents int64  `json:"min_price_cents"`
+	MaxPriceCents *int64 `json:"max_price_cents,omitempty"`
+	Count         int    `json:"count"`
+}
+
+// readProductFilter parses the search parameters shared by the public and
+// admin product lists: q, category (a slug, subcategories included),
+// min_price_cents, max_price_cents, in_stock, min_rating and sort, which
+// defaults to relevance when there is a query and to newest otherwise. On bad
+// input it writes the 400 response itself and returns false. A category
+// slug that matches nothing yields a filter with no category IDs and
+// empty=true; callers answer with an empty page.
+func (h *ProductsHandler) readProductFilter(c *gin.Context) (filter store.ProductFilter, empty, ok bool) {
+	filter.Query = c.Query("q")
+
+	bad := func(msg string) (store.ProductFilter, bool, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return filter, false, false
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{"min_price_cents", &filter.MinPriceCents}, {"max_price_cents", &filter.MaxPriceCents}} {
+		if v := c.Query(p.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return bad(p.name + " harus angka positif")
+			}
+			*p.dst = n
+		}
+	}
+	if filter.MaxPriceCents > 0 && filter.MinPriceCents > filter.MaxPriceCents {
+		return bad("min_price_cents tidak boleh lebih dari max_price_cents")
+	}
+	if v := c.Query("in_stock"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return bad("in_stock harus true atau false")
+		}
+		filter.InStock = b
+	}
+	if v := c.Query("min_rating"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r < 0 || r > 5 {
+			return bad("min_rating harus angka 0 sampai 5")
+		}
+		filter.MinRating = r
+	}
+	sort, known := productSorts[strings.ToLower(strings.TrimSpace(c.Query("sort")))]
+	if !known {
+		return bad("sort harus relevance, newest, price_asc, price_desc, rating atau bestseller")
+	}
+	if sort == store.SortNewest && strings.TrimSpace(c.Query("sort")) == "" && strings.TrimSpace(filter.Query) != "" {
+		sort = store.SortRelevance
+	}
+	filter.Sort = sort
+
+	ids, found, err := h.productCategoryFilter(c.Request.Context(), c.Query("category"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return filter, false, false
+	}
+	filter.CategoryIDs = ids
+	return filter, !found, true
+}
+
+// productFacets counts filter's results per category, subcategories rolled
+// up into their parents, and per price range
+func (h *ProductsHandler) productFacets(ctx context.Context, filter store.ProductFilter) (*productFacetsResp, error) {
+	counts, err := h.store.ProductFacets(ctx, filter, priceFacetBounds)
+	if err != nil {
+		return nil, err
+	}
+	cats, err := h.store.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &productFacetsResp{
+		Categories: make([]categoryFacet, 0, len(cats)),
+		Prices:     make([]priceFacet, 0, len(priceFacetBounds)),
+	}
+	byID := make(map[string]*models.Category, len(cats))
+	for _, cat := range cats {
+		byID[cat.ID] = cat
+	}
+	totals := map[string]int{}
+	for _, cat := range cats {
+		n := counts.Categories[cat.ID]
+		for cur := cat; cur != nil && n > 0; cur = byID[cur.ParentID] {
+			totals[cur.ID] += n
+		}
+	}
+	for _, cat := range cats {
+		resp.Categories = append(resp.Categories, categoryFacet{ID: cat.ID, ParentID: cat.ParentID, Name: cat.Name, Slug: cat.Slug, Count: totals[cat.ID]})
+	}
+	for i, lower := range priceFacetBounds {
+		f := priceFacet{MinPriceCents: lower, Count: counts.PriceBuckets[i]}
+		if i+1 < len(priceFacetBounds) {
+			f.MaxPriceCents = &priceFacetBounds[i+1]
+		}
+		resp.Prices = append(resp.Prices, f)
+	}
+	return resp, nil
+}
+
+// emptyProductPage answers a search that cannot match anything
+func emptyProductPage(c *gin.Context) {
+	c.JSON(http.StatusOK, pageResp{Items: []*models.Product{}})
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/audit"
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// Admin inventory ledger
+
+type adjustStockReq struct {
+	Delta      int    `json:"delta"`
+	Reason     string `json:"reason"`      // adjustment (default), restock atau return
+	VariantID  string `json:"variant_id"`  // wajib untuk produk bervarian
+	LocationID string `json:"location_id"` // kosong = lokasi utama
+	Note       string `json:"note"`
+}
+
+// AdjustStock posts a manual stock movement, such as a stock count
+// correction or a delivery from a supplier
+func (h *ProductsHandler) AdjustStock(c *gin.Context) {
+	var req adjustStockReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if req.Reason = strings.TrimSpace(req.Reason); req.Reason == "" {
+		req.Reason = models.StockAdjustment
+	}
+	switch req.Reason {
+	case models.StockAdjustment, models.StockRestock, models.StockReturn:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason harus adjustment, restock atau return"})
+		return
+	}
+	if req.Delta == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "delta tidak boleh 0"})
+		return
+	}
+	if req.Note = strings.TrimSpace(req.Note); len(req.Note) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "note maksimal 255 karakter"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	p, err := h.store.GetProduct(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	if req.VariantID != "" && p.Variant(req.VariantID) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "variant not found"})
+		return
+	}
+
+	if req.LocationID != "" && !h.locationExists(c, req.LocationID) {
+		return
+	}
+
+	m, after, err := h.store.AdjustStock(ctx, p.ID, req.VariantID, req.LocationID, req.Delta, req.Reason, req.Note)
+	switch {
+	case errors.Is(err, store.ErrVariantRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Produk ini punya varian; pilih variant_id"})
+		return
+	case errors.Is(err, store.ErrInsufficientStock):
+		c.JSON(http.StatusConflict, gin.H{"error": "Stok tidak boleh kurang dari 0"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "stock.adjust", "product", p.ID, nil, m)
+	if m.Delta > 0 {
+		h.restock.Notify(ctx, p.ID)
+	}
+	h.lowStock.Check(ctx, map[string]int{p.ID: after.Stock - m.Delta}, []*models.Product{after})
+	c.JSON(http.StatusOK, m)
+}
+
+// StockLevels lists a product's stock per location and variant. Locations
+// holding none of it are left out.
+func (h *ProductsHandler) StockLevels(c *gin.Context) {
+	levels, err := h.store.ListStockLevels(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	c.JSON(http.StatusOK, levels)
+}
+
+type transferStockReq struct {
+	FromLocationID string `json:"from_location_id"`
+	ToLocationID   string `json:"to_location_id"`
+	Quantity       int    `json:"quantity"`
+	VariantID      string `json:"variant_id"` // wajib untuk produk bervarian
+	Note           string `json:"note"`
+}
+
+// TransferStock moves units of a product between two locations; its total
+// stock stays the same
+func (h *ProductsHandler) TransferStock(c *gin.Context) {
+	var req transferStockReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if req.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quantity harus lebih dari 0"})
+		return
+	}
+	if req.FromLocationID == "" || req.ToLocationID == "" || req.FromLocationID == req.ToLocationID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from_location_id dan to_location_id wajib diisi dan harus berbeda"})
+		return
+	}
+	if req.Note = strings.TrimSpace(req.Note); len(req.Note) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "note maksimal 255 karakter"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	p, err := h.store.GetProduct(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	if req.VariantID != "" && p.Variant(req.VariantID) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "variant not found"})
+		return
+	}
+	if !h.locationExists(c, req.FromLocationID) || !h.locationExists(c, req.ToLocationID) {
+		return
+	}
+
+	ms, err := h.store.TransferStock(ctx, p.ID, req.VariantID, req.FromLocationID, req.ToLocationID, req.Quantity, req.Note)
+	switch {
+	case errors.Is(err, store.ErrVariantRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Produk ini punya varian; pilih variant_id"})
+		return
+	case errors.Is(err, store.ErrInsufficientStock):
+		c.JSON(http.StatusConflict, gin.H{"error": "Stok di lokasi asal tidak cukup"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "stock.transfer", "product", p.ID, nil, ms)
+	c.JSON(http.StatusOK, ms)
+}
+
+// locationExists answers 404 and reports false when there is no location id
+func (h *ProductsHandler) locationExists(c *gin.Context, id string) bool {
+	locs, err := h.store.ListLocations(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if findLocation(locs, id) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "location not found"})
+		return false
+	}
+	return true
+}
+
+// StockMovements lists a product's stock movements, newest first
+func (h *ProductsHandler) StockMovements(c *gin.Context) {
+	page, ok := readPage(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	p, err := h.store.GetProduct(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	ms, next, err := h.store.ListStockMovements(ctx, p.ID, page)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, pageResp{Items: ms, NextCursor: next})
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/audit"
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// Admin product variants
+
+type createVariantReq struct {
+	SKU        string            `json:"sku"`
+	Options    map[string]string `json:"options"`
+	PriceCents *json.Number      `json:"price_cents"` // kosong = ikut harga produk
+	Stock      int               `json:"stock"`
+	Image      string            `json:"image"`
+}
+
+func (h *ProductsHandler) CreateVariant(c *gin.Context) {
+	id := c.Param("id")
+	var req createVariantReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	p, err := h.store.GetProduct(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	v := &models.ProductVariant{
+		SKU:     strings.TrimSpace(req.SKU),
+		Options: trimOptionValues(req.Options),
+		Stock:   req.Stock,
+		Image:   strings.TrimSpace(req.Image),
+	}
+	if req.PriceCents != nil {
+		price, err := parseVariantPrice(*req.PriceCents)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		v.PriceCents = price
+	}
+	if err := validateVariant(p, v); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if v.SKU == "" {
+		v.SKU = variantSKU(p, v)
+	}
+
+	res, err := h.store.CreateVariant(c.Request.Context(), id, v)
+	if errors.Is(err, store.ErrSKUTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU sudah dipakai varian lain"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "variant.create", "product_variant", res.ID, nil, res)
+	c.JSON(http.StatusOK, res)
+}
+
+type updateVariantReq struct {
+	SKU        *string            `json:"sku"`
+	Options    *map[string]string `json:"options"`
+	PriceCents *json.Number       `json:"price_cents"` // 0 = hapus harga khusus varian
+	Stock      *int               `json:"stock"`
+	Image      *string            `json:"image"`
+}
+
+func (h *ProductsHandler) UpdateVariant(c *gin.Context) {
+	id, variantID := c.Param("id"), c.Param("variantId")
+	var req updateVariantReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload: " + err.Error()})
+		return
+	}
+	if req.Stock != nil && *req.Stock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stock tidak boleh negatif"})
+		return
+	}
+	p, err := h.store.GetProduct(c.Request.Context(), id)
+	if err != nil || p.Variant(variantID) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "variant not found"})
+		return
+	}
+
+	var before models.ProductVariant
+	res, after, err := h.store.UpdateVariant(c.Request.Context(), id, variantID, func(v *models.ProductVariant) error {
+		before = *v
+		if req.SKU != nil {
+			v.SKU = strings.TrimSpace(*req.SKU)
+		}
+		if req.Options != nil {
+			v.Options = trimOptionValues(*req.Options)
+		}
+		if req.PriceCents != nil {
+			price, err := parseVariantPrice(*req.PriceCents)
+			if err != nil {
+				return err
+			}
+			v.PriceCents = price
+		}
+		if req.Stock != nil {
+			v.Stock = *req.Stock
+		}
+		if req.Image != nil {
+			v.Image = strings.TrimSpace(*req.Image)
+		}
+		if v.SKU == "" {
+			v.SKU = variantSKU(p, v)
+		}
+		return validateVariant(p, v)
+	})
+	if errors.Is(err, store.ErrSKUTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU sudah dipakai varian lain"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "variant.update", "product_variant", variantID, before, res)
+	// Subscriptions are per variant; Notify only reaches those whose line
+	// is back in stock
+	if res.Stock > before.Stock {
+		h.restock.Notify(c.Request.Context(), id)
+	}
+	// The product's stock moved by as much as the variant's
+	h.lowStock.Check(c.Request.Context(), map[string]int{id: after.Stock - res.Stock + before.Stock}, []*models.Product{after})
+	c.JSON(http.StatusOK, res)
+}
+
+// DeleteVariant removes a variant and drops it from carts; refused once it
+// has been ordered
+func (h *ProductsHandler) DeleteVariant(c *gin.Context) {
+	id, variantID := c.Param("id"), c.Param("variantId")
+	p, err := h.store.GetProduct(c.Request.Context(), id)
+	if err != nil || p.Variant(variantID) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "variant not found"})
+		return
+	}
+	before := *p.Variant(variantID)
+
+	if err := h.store.DeleteVariant(c.Request.Context(), id, variantID); err != nil {
+		if errors.Is(err, store.ErrVariantInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Varian sudah pernah dipesan, tidak bisa dihapus"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "variant.delete", "product_variant", variantID, before, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// normalizeOptions trims option names and values and rejects empty or
+// repeated ones
+func normalizeOptions(in []models.ProductOption) ([]models.ProductOption, error) {
+	out := make([]models.ProductOption, 0, len(in))
+	names := map[string]bool{}
+	for i, o := range in {
+		o.Name = strings.TrimSpace(o.Name)
+		if o.Name == "" {
+			return nil, fmt.Errorf("options[%d].name wajib diisi", i)
+		}
+		if names[strings.ToLower(o.Name)] {
+			return nil, fmt.Errorf("opsi %q dobel", o.Name)
+		}
+		names[strings.ToLower(o.Name)] = true
+
+		values := make([]string, 0, len(o.Values))
+		seen := map[string]bool{}
+		for _, v := range o.Values {
+			v = strings.TrimSpace(v)
+			if v == "" || seen[v] {
+				return nil, fmt.Errorf("nilai opsi %q kosong atau dobel", o.Name)
+			}
+			seen[v] = true
+			values = append(values, v)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("opsi %q belum punya nilai", o.Name)
+		}
+		out = append(out, models.ProductOption{Name: o.Name, Values: values})
+	}
+	return out, nil
+}
+
+// validateVariant checks v against the product it belongs to: one allowed
+// value per option, no other variant with the same combination, sane price
+// and stock
+func validateVariant(p *models.Product, v *models.ProductVariant) error {
+	if len(p.Options) == 0 {
+		return errors.New("produk belum punya opsi varian")
+	}
+	if v.Stock < 0 {
+		return errors.New("stock tidak boleh negatif")
+	}
+	if err := checkVariantOptions(p.Options, v.Options); err != nil {
+		return err
+	}
+	for _, other := range p.Variants {
+		if other.ID != v.ID && maps.Equal(other.Options, v.Options) {
+			return errors.New("kombinasi opsi sudah dipakai varian lain")
+		}
+	}
+	return nil
+}
+
+// checkVariantOptions requires exactly one listed value for every option
+func checkVariantOptions(options []models.ProductOption, values map[string]string) error {
+	if len(values) != len(options) {
+		return errors.New("varian harus memilih satu nilai untuk setiap opsi")
+	}
+	for _, o := range options {
+		val, ok := values[o.Name]
+		if !ok {
+			return fmt.Errorf("nilai opsi %q wajib diisi", o.Name)
+		}
+		if !slices.Contains(o.Values, val) {
+			return fmt.Errorf("%q bukan nilai opsi %q", val, o.Name)
+		}
+	}
+	return nil
+}
+
+func trimOptionValues(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return out
+}
+
+// parseVariantPrice reads a price override; 0 means "use the product price"
+func parseVariantPrice(value json.Number) (*int64, error) {
+	price, err := parsePriceNumber(value)
+	if err != nil || price < 0 {
+		return nil, errors.New("price_cents invalid")
+	}
+	if price == 0 {
+		return nil, nil
+	}
+	return &price, nil
+}
+
+// variantSKU derives a SKU from the product's and the option values, e.g.
+// TSHIRT-1-M-RED
+func variantSKU(p *models.Product, v *models.ProductVariant) string {
+	parts := []string{p.SKU}
+	for _, o := range p.Options {
+		parts = append(parts, strings.ToUpper(strings.ReplaceAll(v.Options[o.Name], " ", "")))
+	}
+	return strings.Join(parts, "-")
+}
+
+// variantValues lists v's option values in the product's option order,
+// e.g. "M / Merah"
+func variantValues(p *models.Product, v *models.ProductVariant) string {
+	values := make([]string, 0, len(p.Options))
+	for _, o := range p.Options {
+		if val := v.Options[o.Name]; val != "" {
+			values = append(values, val)
+		}
+	}
+	return strings.Join(values, " / ")
+}
+
+// variantLabel names a product line for receipts and payment items, e.g.
+// "Kaos (M / Merah)"
+func variantLabel(p *models.Product, variantID string) string {
+	v := p.Variant(variantID)
+	if v == nil {
+		return p.Name
+	}
+	if values := variantValues(p, v); values != "" {
+		return p.Name + " (" + values + ")"
+	}
+	return p.Name
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// TestUpdateVariantNotifiesRestock raises a variant's stock from zero
+// through the admin endpoint and expects its subscriber to be emailed
+func TestUpdateVariantNotifiesRestock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	st := store.NewInMemoryStore()
+
+	u, err := st.CreateUser(ctx, "Subscriber", "0812", "sub@example.com", "hash", "user", "email", "", true)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	p, err := st.CreateProduct(ctx, &models.Product{
+		Name:       "Kaos",
+		PriceCents: 10000,
+		SKU:        "KAOS-1",
+		Options:    []models.ProductOption{{Name: "size", Values: []string{"S", "M"}}},
+	})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	v, err := st.CreateVariant(ctx, p.ID, &models.ProductVariant{SKU: "KAOS-1-S", Options: map[string]string{"size": "S"}})
+	if err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+	if _, err := st.SubscribeStock(ctx, u.ID, p.ID, v.ID); err != nil {
+		t.Fatalf("SubscribeStock: %v", err)
+	}
+
+	sent := make(chan string, 1)
+	rn := newRestockNotifier(st, func(to, productName, productURL string) error {
+		sent <- to
+		return nil
+	}, "https://shop.example.com")
+	defer rn.Stop()
+	h := NewProductsHandler(st, rn, nil)
+
+	r := gin.New()
+	r.PUT("/products/:id/variants/:variantId", h.UpdateVariant)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/products/"+p.ID+"/variants/"+v.ID, strings.NewReader(`{"stock": 3}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("UpdateVariant = %d %s, want 200", w.Code, w.Body)
+	}
+
+	select {
+	case to := <-sent:
+		if to != u.Email {
+			t.Fatalf("back-in-stock email sent to %q, want %q", to, u.Email)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no back-in-stock email after raising the variant's stock")
+	}
+}
@@ -1,40 +1,54 @@
 package handlers
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/example/ecommerce-api/internal/audit"
 	"github.com/example/ecommerce-api/internal/middleware"
 	"github.com/example/ecommerce-api/internal/models"
 	"github.com/example/ecommerce-api/internal/store"
 )
 
 type ProductsHandler struct {
-	store store.Store
+	store    store.Store
+	restock  *RestockNotifier
+	lowStock *LowStockAlerter
 }
 
-func NewProductsHandler(st store.Store) *ProductsHandler {
-	return &ProductsHandler{store: st}
+func NewProductsHandler(st store.Store, rn *RestockNotifier, lsa *LowStockAlerter) *ProductsHandler {
+	return &ProductsHandler{store: st, restock: rn, lowStock: lsa}
 }
 
 // Admin create product
 
 type createProductReq struct {
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	Category    string      `json:"category"`
-	Price       json.Number `json:"price"` // accept both price and price_cents
-	PriceCents  json.Number `json:"price_cents"`
-	SKU         string      `json:"sku"`
-	Stock       int         `json:"stock"`
-	Thumbnail   string      `json:"thumbnail"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Category    string                 `json:"category"`
+	CategoryID  string                 `json:"category_id"`
+	Price       json.Number            `json:"price"` // accept both price and price_cents
+	PriceCents  json.Number            `json:"price_cents"`
+	SKU         string                 `json:"sku"`
+	Stock       int                    `json:"stock"`
+	Thumbnail   string                 `json:"thumbnail"`
+	Images      []models.ProductImage  `json:"images"`
+	Options     []models.ProductOption `json:"options"`
+	Status      string                 `json:"status"` // draft atau published (default)
+	PublishAt   string                 `json:"publish_at"`
+	UnpublishAt string                 `json:"unpublish_at"`
+	// Batas stok menipis; kosong = LOW_STOCK_THRESHOLD
+	LowStockThreshold *int `json:"low_stock_threshold"`
 }
 
 func (h *ProductsHandler) Create(c *gin.Context) {
@@ -49,6 +63,10 @@ func (h *ProductsHandler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "name dan stock harus valid"})
 		return
 	}
+	if req.LowStockThreshold != nil && *req.LowStockThreshold < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "low_stock_threshold tidak boleh negatif"})
+		return
+	}
 
 	// Gunakan price jika price_cents tidak ada
 	priceCents := int64(0)
@@ -73,40 +91,89 @@ func (h *ProductsHandler) Create(c *gin.Context) {
 		return
 	}
 
-	// Generate SKU otomatis jika tidak ada
-	sku := req.SKU
-	if sku == "" {
-		sku = generateSKU(req.Name)
+	images, err := normalizeImages(req.Images)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	options, err := normalizeOptions(req.Options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+
 	p := &models.Product{
 		Name:        req.Name,
 		Description: req.Description,
-		Category:    req.Category,
 		PriceCents:  priceCents,
-		SKU:         sku,
+		SKU:         strings.TrimSpace(req.SKU),
 		Stock:       req.Stock,
 		Thumbnail:   req.Thumbnail,
+		Images:      images,
+		Options:     options,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+
+		LowStockThreshold: req.LowStockThreshold,
+	}
+	cats, err := h.store.ListCategories(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := setProductCategory(cats, p, &req.CategoryID, &req.Category); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := setProductLifecycle(p, &req.Status, &req.PublishAt, &req.UnpublishAt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Generate SKU otomatis jika tidak ada; a generated one that happens to
+	// be taken is drawn again
+	generated := p.SKU == ""
+	var res *models.Product
+	for attempt := 1; ; attempt++ {
+		if generated {
+			p.SKU = newSKU(skuPrefix(cats, p))
+		}
+		res, err = h.store.CreateProduct(c.Request.Context(), p)
+		if !generated || !errors.Is(err, store.ErrSKUTaken) || attempt == maxSKUAttempts {
+			break
+		}
+	}
+	if errors.Is(err, store.ErrSKUTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU sudah dipakai produk lain"})
+		return
 	}
-	res, err := h.store.CreateProduct(p)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	audit.Record(c, "product.create", "product", res.ID, nil, res)
 	c.JSON(http.StatusOK, res)
 }
 
 // Admin update product
 
 type updateProductReq struct {
-	Name        *string      `json:"name"`
-	Description *string      `json:"description"`
-	Category    *string      `json:"category"`
-	PriceCents  *json.Number `json:"price_cents"`
-	SKU         *string      `json:"sku"`
-	Stock       *int         `json:"stock"`
-	Thumbnail   *string      `json:"thumbnail"`
+	Name        *string                 `json:"name"`
+	Description *string                 `json:"description"`
+	Category    *string                 `json:"category"`
+	CategoryID  *string                 `json:"category_id"` // "" = lepas dari kategori
+	PriceCents  *json.Number            `json:"price_cents"`
+	SKU         *string                 `json:"sku"`
+	Stock       *int                    `json:"stock"`
+	Thumbnail   *string                 `json:"thumbnail"`
+	Images      *[]models.ProductImage  `json:"images"`
+	Options     *[]models.ProductOption `json:"options"`
+	Status      *string                 `json:"status"`
+	PublishAt   *string                 `json:"publish_at"`   // "" = tanpa jadwal
+	UnpublishAt *string                 `json:"unpublish_at"` // "" = tanpa jadwal
+	// Batas stok menipis milik produk ini
+	LowStockThreshold      *int `json:"low_stock_threshold"`
+	ClearLowStockThreshold bool `json:"clear_low_stock_threshold"` // true = kembali ke default
 }
 
 func (h *ProductsHandler) Update(c *gin.Context) {
@@ -116,136 +183,310 @@ func (h *ProductsHandler) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload: " + err.Error()})
 		return
 	}
-	res, err := h.store.UpdateProduct(id, func(p *models.Product) error {
+	if req.Stock != nil && *req.Stock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stock tidak boleh negatif"})
+		return
+	}
+	var cats []*models.Category
+	if req.Category != nil || req.CategoryID != nil {
+		var err error
+		if cats, err = h.store.ListCategories(c.Request.Context()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var before models.Product
+	res, err := h.store.UpdateProduct(c.Request.Context(), id, func(p *models.Product) error {
+		before = *p
 		if req.Name != nil {
 			p.Name = *req.Name
 		}
 		if req.Description != nil {
 			p.Description = *req.Description
 		}
-		if req.Category != nil {
-			p.Category = *req.Category
+		if err := setProductCategory(cats, p, req.CategoryID, req.Category); err != nil {
+			return err
 		}
 		if req.PriceCents != nil {
 			parsed, err := parsePriceNumber(*req.PriceCents)
 			if err != nil {
 				return err
 			}
-			p.PriceCents = parsed
+			// During a sale this is the price it ends on
+			p.SetBasePrice(parsed)
 		}
 		if req.SKU != nil {
-			p.SKU = *req.SKU
+			if p.SKU = strings.TrimSpace(*req.SKU); p.SKU == "" {
+				return errors.New("sku tidak boleh kosong")
+			}
 		}
 		if req.Stock != nil {
+			// Stock of a product with variants is the sum of theirs;
+			// forms that echo it back unchanged are fine
+			if len(p.Variants) > 0 && *req.Stock != p.Stock {
+				return errors.New("stock produk bervarian diatur per varian")
+			}
 			p.Stock = *req.Stock
 		}
 		if req.Thumbnail != nil {
 			p.Thumbnail = *req.Thumbnail
 		}
+		if req.Images != nil {
+			images, err := normalizeImages(*req.Images)
+			if err != nil {
+				return err
+			}
+			p.Images = images
+		}
+		if req.Options != nil {
+			options, err := normalizeOptions(*req.Options)
+			if err != nil {
+				return err
+			}
+			for _, v := range p.Variants {
+				if err := checkVariantOptions(options, v.Options); err != nil {
+					return fmt.Errorf("opsi tidak cocok dengan varian %s: %w", v.SKU, err)
+				}
+			}
+			p.Options = options
+		}
+		if err := setProductLifecycle(p, req.Status, req.PublishAt, req.UnpublishAt); err != nil {
+			return err
+		}
+		switch {
+		case req.ClearLowStockThreshold:
+			p.LowStockThreshold = nil
+		case req.LowStockThreshold != nil:
+			if *req.LowStockThreshold < 0 {
+				return errors.New("low_stock_threshold tidak boleh negatif")
+			}
+			p.LowStockThreshold = req.LowStockThreshold
+		}
 		p.UpdatedAt = time.Now()
 		return nil
 	})
+	if errors.Is(err, store.ErrSKUTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU sudah dipakai produk lain"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	audit.Record(c, "product.update", "product", id, before, res)
+	if res.Stock > before.Stock {
+		h.restock.Notify(c.Request.Context(), id)
+	}
+	h.lowStock.Check(c.Request.Context(), map[string]int{id: before.Stock}, []*models.Product{res})
 	c.JSON(http.StatusOK, res)
 }
 
+// Delete archives the product: it disappears from the storefront but stays
+// available to order history and can be restored
 func (h *ProductsHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.store.DeleteProduct(id); err != nil {
+	before, err := h.store.GetProduct(c.Request.Context(), id)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
+	if err := h.store.ArchiveProduct(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	after, err := h.store.GetProduct(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "product.archive", "product", id, before, after)
 	c.Status(http.StatusNoContent)
 }
 
-func (h *ProductsHandler) Get(c *gin.Context) {
+func (h *ProductsHandler) Restore(c *gin.Context) {
 	id := c.Param("id")
-	p, err := h.store.GetProduct(id)
+	before, err := h.store.GetProduct(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
+	if err := h.store.RestoreProduct(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	p, err := h.store.GetProduct(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "product.restore", "product", id, before, p)
 	c.JSON(http.StatusOK, p)
 }
 
-func (h *ProductsHandler) List(c *gin.Context) {
-	q := c.Query("q")
-	ps, err := h.store.ListProducts(q)
+// Purge removes the product for good; refused once it has been ordered
+func (h *ProductsHandler) Purge(c *gin.Context) {
+	id := c.Param("id")
+	before, err := h.store.GetProduct(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, ps)
+	if err := h.store.PurgeProduct(c.Request.Context(), id); err != nil {
+		if errors.Is(err, store.ErrProductInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Produk sudah pernah dipesan, arsipkan saja"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c, "product.purge", "product", id, before, nil)
+	c.Status(http.StatusNoContent)
 }
 
-func (h *ProductsHandler) AdminList(c *gin.Context) {
-	q := c.Query("q")
-	sortParam := strings.ToLower(strings.TrimSpace(c.Query("sort")))
+func (h *ProductsHandler) Get(c *gin.Context) {
+	id := c.Param("id")
+	p, err := h.store.GetProduct(c.Request.Context(), id)
+	if err != nil || !p.Live(time.Now()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// AdminGet returns a product whether or not it is archived
+func (h *ProductsHandler) AdminGet(c *gin.Context) {
+	id := c.Param("id")
+	p, err := h.store.GetProduct(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
 
-	ps, err := h.store.ListProducts(q)
+// BySKU looks a product up by its own SKU or one of its variants', for
+// warehouse scanners. variant_id is set when a variant's SKU matched.
+func (h *ProductsHandler) BySKU(c *gin.Context) {
+	p, variantID, err := h.store.GetProductBySKU(c.Request.Context(), strings.TrimSpace(c.Param("sku")))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"product": p, "variant_id": variantID})
+}
 
-	switch sortParam {
-	case "bestseller":
-		orders, err := h.store.ListOrders()
-		if err != nil {
+// List is the storefront search; see readProductFilter for the parameters.
+// The first page also carries the facets of the whole result.
+func (h *ProductsHandler) List(c *gin.Context) {
+	page, ok := readPage(c)
+	if !ok {
+		return
+	}
+	filter, empty, ok := h.readProductFilter(c)
+	if !ok {
+		return
+	}
+	if empty {
+		emptyProductPage(c)
+		return
+	}
+	filter.LiveAt = time.Now()
+	ps, next, err := h.store.ListProducts(c.Request.Context(), filter, page)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	resp := productListResp{pageResp: pageResp{Items: ps, NextCursor: next}}
+	if page.Cursor == "" {
+		if resp.Facets, err = h.productFacets(c.Request.Context(), filter); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-
-		sales := make(map[string]int)
-		for _, o := range orders {
-			status := strings.ToLower(o.Status)
-			if status != "paid" && status != "done" && status != "completed" {
-				continue
-			}
-			for _, item := range o.Items {
-				sales[item.ProductID] += item.Quantity
-			}
-		}
-
-		sort.SliceStable(ps, func(i, j int) bool {
-			left := sales[ps[i].ID]
-			right := sales[ps[j].ID]
-			if left == right {
-				return ps[i].CreatedAt.After(ps[j].CreatedAt)
-			}
-			return left > right
-		})
-	default:
-		sort.SliceStable(ps, func(i, j int) bool {
-			return ps[i].CreatedAt.After(ps[j].CreatedAt)
-		})
 	}
+	c.JSON(http.StatusOK, resp)
+}
 
-	c.JSON(http.StatusOK, ps)
+// AdminList pages through every product, drafts, scheduled and archived
+// ones included, with the same filters and sorts as List but without
+// facets. ?status= narrows it to one status.
+func (h *ProductsHandler) AdminList(c *gin.Context) {
+	page, ok := readPage(c)
+	if !ok {
+		return
+	}
+	filter, empty, ok := h.readProductFilter(c)
+	if !ok {
+		return
+	}
+	if filter.Status = strings.ToLower(strings.TrimSpace(c.Query("status"))); filter.Status != "" && !slices.Contains(productStatuses, filter.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status harus draft, published atau archived"})
+		return
+	}
+	if empty {
+		emptyProductPage(c)
+		return
+	}
+	filter.IncludeArchived = true
+	ps, next, err := h.store.ListProducts(c.Request.Context(), filter, page)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, pageResp{Items: ps, NextCursor: next})
 }
 
 // Admin only middleware helper (not a handler)
 
 func AdminOnly() gin.HandlerFunc { return middleware.RequireAdmin() }
 
-// Helper function to generate SKU from product name
-func generateSKU(name string) string {
-	// Simple SKU generation: uppercase first letters + timestamp
-	// Example: "Test Product" -> "TP-1234567890"
-	var initials string
-	words := strings.Fields(name)
-	for _, word := range words {
-		if len(word) > 0 {
-			initials += strings.ToUpper(string(word[0]))
+// maxSKUAttempts is how often Create draws a new SKU before giving up
+const maxSKUAttempts = 5
+
+// skuAlphabet leaves out characters that read alike: 0/O and 1/I
+const skuAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newSKU returns prefix, a dash and six random characters, e.g. KAOS-7QX2MD
+func newSKU(prefix string) string {
+	b := make([]byte, 6)
+	// crypto/rand does not fail on supported platforms
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = skuAlphabet[int(b[i])%len(skuAlphabet)]
+	}
+	return prefix + "-" + string(b)
+}
+
+// skuPrefix is the SKU prefix of p's category or, without one, the initials
+// of p's name: "Kaos Polos" -> KP
+func skuPrefix(cats []*models.Category, p *models.Product) string {
+	if cat := findCategory(cats, p.CategoryID); cat != nil && cat.SKUPrefix != "" {
+		return cat.SKUPrefix
+	}
+	initials := ""
+	for _, word := range strings.Fields(p.Name) {
+		if r := []rune(word)[0]; r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			initials += strings.ToUpper(string(r))
 		}
 	}
-	if initials == "" {
-		initials = "PROD"
+	if initials == "" || len(initials) > maxSKUPrefix {
+		return "PROD"
+	}
+	return initials
+}
+
+// normalizeImages trims gallery entries and rejects ones without a URL
+func normalizeImages(in []models.ProductImage) ([]models.ProductImage, error) {
+	out := make([]models.ProductImage, 0, len(in))
+	for i, img := range in {
+		img.URL = strings.TrimSpace(img.URL)
+		img.Alt = strings.TrimSpace(img.Alt)
+		if img.URL == "" {
+			return nil, fmt.Errorf("images[%d].url wajib diisi", i)
+		}
+		out = append(out, img)
 	}
-	return initials + "-" + fmt.Sprintf("%d", time.Now().UnixNano()%1000000)
+	return out, nil
 }
 
 func parsePriceNumber(value json.Number) (int64, error) {
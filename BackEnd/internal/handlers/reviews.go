@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 
@@ -37,21 +36,21 @@ func (h *ReviewsHandler) Create(c *gin.Context) {
 	}
 
 	// Check if user has placed at least one order (optional validation)
-	orders, err := h.store.ListOrdersByUser(userID.(string))
+	orders, _, err := h.store.ListOrdersByUser(c.Request.Context(), userID.(string), store.Page{Limit: 1})
 	if err != nil || len(orders) == 0 {
 		c.JSON(http.StatusForbidden, gin.H{"error": "you must complete at least one order to leave a review"})
 		return
 	}
 
 	// Get user info for display name
-	user, err := h.store.GetUserByID(userID.(string))
+	user, err := h.store.GetUserByID(c.Request.Context(), userID.(string))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user"})
 		return
 	}
 
 	// Limit reviews per user (optional: 1 review per user)
-	reviewCount, _ := h.store.GetUserReviewCount(userID.(string))
+	reviewCount, _ := h.store.GetUserReviewCount(c.Request.Context(), userID.(string))
 	if reviewCount > 0 {
 		c.JSON(http.StatusForbidden, gin.H{"error": "you have already submitted a review"})
 		return
@@ -60,7 +59,7 @@ func (h *ReviewsHandler) Create(c *gin.Context) {
 	// Default user photo placeholder
 	userPhoto := "https://images.unsplash.com/photo-1535713875002-d1d0cf377fde?auto=format&fit=crop&w=240&q=80"
 
-	review, err := h.store.CreateReview(userID.(string), user.Email, userPhoto, req.Rating, req.Comment)
+	review, err := h.store.CreateReview(c.Request.Context(), userID.(string), user.Email, userPhoto, req.Rating, req.Comment)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -71,14 +70,16 @@ func (h *ReviewsHandler) Create(c *gin.Context) {
 
 // List handles GET /api/v1/reviews
 func (h *ReviewsHandler) List(c *gin.Context) {
-	limitStr := c.DefaultQuery("limit", "20")
-	limit, _ := strconv.Atoi(limitStr)
+	page, ok := readPage(c)
+	if !ok {
+		return
+	}
 
-	reviews, err := h.store.ListReviews(limit)
+	reviews, next, err := h.store.ListReviews(c.Request.Context(), page)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		listError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, reviews)
+	c.JSON(http.StatusOK, pageResp{Items: reviews, NextCursor: next})
 }
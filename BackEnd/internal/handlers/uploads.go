@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"github.com/example/ecommerce-api/internal/audit"
 	"github.com/example/ecommerce-api/internal/config"
 )
 
@@ -53,7 +54,9 @@ func (h *UploadsHandler) UploadProductThumbnail(c *gin.Context) {
 	}
 
 	baseURL := strings.TrimRight(h.cfg.BaseURL, "/")
+	url := baseURL + "/uploads/" + filename
+	audit.Record(c, "upload.thumbnail", "upload", filename, nil, gin.H{"url": url, "size": file.Size})
 	c.JSON(http.StatusOK, gin.H{
-		"url": baseURL + "/uploads/" + filename,
+		"url": url,
 	})
 }
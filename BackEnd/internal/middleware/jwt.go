@@ -3,8 +3,10 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 
 	"github.com/example/ecommerce-api/internal/auth"
 )
@@ -12,11 +14,15 @@ import (
 type ContextUserKey string
 
 const (
-	UserIDKey ContextUserKey = "user_id"
-	EmailKey  ContextUserKey = "email"
-	AdminKey  ContextUserKey = "admin"
+	UserIDKey  ContextUserKey = "user_id"
+	EmailKey   ContextUserKey = "email"
+	AdminKey   ContextUserKey = "admin"
+	GuestIDKey ContextUserKey = "guest_id"
 )
 
+// GuestTokenHeader carries a guest's signed token, both ways
+const GuestTokenHeader = "X-Guest-Token"
+
 func JWTAuth(j *auth.JWTManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		h := c.GetHeader("Authorization")
@@ -47,3 +53,24 @@ func RequireAdmin() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// GuestAuth identifies an anonymous visitor by the guest token in
+// X-Guest-Token. A missing, expired or invalid token starts a new guest.
+// Every response carries a fresh token valid for ttl, so a cart in use does
+// not expire.
+func GuestAuth(j *auth.JWTManager, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		guestID, err := j.VerifyGuest(c.GetHeader(GuestTokenHeader))
+		if err != nil {
+			guestID = uuid.NewString()
+		}
+		tok, err := j.GenerateGuest(guestID, ttl)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		c.Header(GuestTokenHeader, tok)
+		c.Set(string(GuestIDKey), guestID)
+		c.Next()
+	}
+}
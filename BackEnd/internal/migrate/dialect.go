@@ -0,0 +1,64 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// lockName identifies the migration lock shared by every server instance
+const lockName = "schema_migrations"
+
+// Dialect holds the SQL that differs between database engines
+type Dialect struct {
+	CreateTable string
+	Insert      string
+	Delete      string
+	Lock        func(ctx context.Context, conn *sql.Conn) error
+	Unlock      func(ctx context.Context, conn *sql.Conn) error
+}
+
+// MySQL uses a named GET_LOCK held by the migrating connection. MySQL commits
+// DDL implicitly, so a failed migration may leave partial changes behind.
+var MySQL = Dialect{
+	CreateTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at DATETIME NOT NULL
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+	Insert: `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?,?,?)`,
+	Delete: `DELETE FROM schema_migrations WHERE version=?`,
+	Lock: func(ctx context.Context, conn *sql.Conn) error {
+		var got sql.NullInt64
+		if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 60)`, lockName).Scan(&got); err != nil {
+			return err
+		}
+		if !got.Valid || got.Int64 != 1 {
+			return errors.New("another instance is running migrations")
+		}
+		return nil
+	},
+	Unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, lockName)
+		return err
+	},
+}
+
+// Postgres uses a session-level advisory lock keyed on the lock name
+var Postgres = Dialect{
+	CreateTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`,
+	Insert: `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1,$2,$3)`,
+	Delete: `DELETE FROM schema_migrations WHERE version=$1`,
+	Lock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, lockName)
+		return err
+	},
+	Unlock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1))`, lockName)
+		return err
+	},
+}
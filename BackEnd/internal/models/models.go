@@ -1,29 +1,216 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Product describes a sellable item
 type Product struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
-	Category    string `json:"category"` // Tambahan: Agar bisa difilter
+	Category    string `json:"category"`              // Nama kategori, ikut berubah bila kategori diganti nama
+	CategoryID  string `json:"category_id,omitempty"` // Kategori di pohon kategori
 	PriceCents  int64  `json:"price_cents"`
-	SKU         string `json:"sku"`
-	Stock       int    `json:"stock"`
+	// RegularPriceCents is the price PriceCents returns to when the sale
+	// running now ends; nil outside sales
+	RegularPriceCents *int64 `json:"regular_price_cents,omitempty"`
+	SKU               string `json:"sku"`
+	Stock             int    `json:"stock"`
 	// Tambahan: Untuk tampilan UI yang cantik
-	Thumbnail   string    `json:"thumbnail"`    // Gambar utama
-	Images      string    `json:"images"`       // Disimpan sebagai string (JSON/Comma separated)
-	Rating      float64   `json:"rating"`       // Dummy rating: 4.5
-	ReviewCount int       `json:"review_count"` // Dummy count: 120 ulasan
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	Thumbnail   string         `json:"thumbnail"`    // Gambar utama
+	Images      []ProductImage `json:"images"`       // Galeri berurutan, disimpan sebagai JSON
+	Rating      float64        `json:"rating"`       // Dummy rating: 4.5
+	ReviewCount int            `json:"review_count"` // Dummy count: 120 ulasan
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	ArchivedAt  *time.Time     `json:"archived_at,omitempty"` // Diisi saat produk diarsipkan
+	// Status is ProductDraft, ProductPublished or ProductArchived. A
+	// published product is only on the storefront between PublishAt and
+	// UnpublishAt, when they are set.
+	Status      string     `json:"status"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	UnpublishAt *time.Time `json:"unpublish_at,omitempty"`
+	// LowStockThreshold alerts admins once Stock drops below it; nil uses
+	// the store-wide default
+	LowStockThreshold *int `json:"low_stock_threshold,omitempty"`
+	// Options are the axes variants differ on; Variants are the sellable
+	// combinations. With variants, Stock is the sum of their stock.
+	Options  []ProductOption  `json:"options"`
+	Variants []ProductVariant `json:"variants"`
+}
+
+// Product statuses
+const (
+	ProductDraft     = "draft"
+	ProductPublished = "published"
+	ProductArchived  = "archived"
+)
+
+// Live reports whether the storefront shows p at the given time: published
+// and inside its publish window
+func (p *Product) Live(at time.Time) bool {
+	return p.Status == ProductPublished &&
+		(p.PublishAt == nil || !p.PublishAt.After(at)) &&
+		(p.UnpublishAt == nil || p.UnpublishAt.After(at))
+}
+
+// BasePrice is the price p sells at outside sales
+func (p *Product) BasePrice() int64 {
+	if p.RegularPriceCents != nil {
+		return *p.RegularPriceCents
+	}
+	return p.PriceCents
+}
+
+// SetBasePrice changes the price p sells at outside sales; a running sale
+// keeps its price
+func (p *Product) SetBasePrice(cents int64) {
+	if p.RegularPriceCents != nil {
+		p.RegularPriceCents = &cents
+		return
+	}
+	p.PriceCents = cents
+}
+
+// StockThreshold is the stock p alerts admins below: its own threshold, or
+// fallback when it has none
+func (p *Product) StockThreshold(fallback int) int {
+	if p.LowStockThreshold != nil {
+		return *p.LowStockThreshold
+	}
+	return fallback
+}
+
+// LowOnStock reports whether p is below its stock threshold
+func (p *Product) LowOnStock(fallback int) bool {
+	return p.Stock < p.StockThreshold(fallback)
+}
+
+// Variant returns the product's variant with the given ID, or nil
+func (p *Product) Variant(id string) *ProductVariant {
+	for i := range p.Variants {
+		if p.Variants[i].ID == id {
+			return &p.Variants[i]
+		}
+	}
+	return nil
+}
+
+// PriceFor returns the unit price of v, or of the product itself when v is nil
+func (p *Product) PriceFor(v *ProductVariant) int64 {
+	if v != nil && v.PriceCents != nil {
+		return *v.PriceCents
+	}
+	return p.PriceCents
+}
+
+// Price change reasons
+const (
+	PriceCreated   = "create"
+	PriceManual    = "manual"
+	PriceImport    = "import"
+	PriceScheduled = "schedule"
+	PriceSaleStart = "sale_start"
+	PriceSaleEnd   = "sale_end"
+)
+
+// PriceChange is one entry of a product's price history. Changes during a
+// sale other than its start and end are to the regular price.
+type PriceChange struct {
+	ID            string    `json:"id"`
+	ProductID     string    `json:"product_id"`
+	OldPriceCents int64     `json:"old_price_cents"`
+	NewPriceCents int64     `json:"new_price_cents"`
+	Reason        string    `json:"reason"`
+	ActorID       string    `json:"actor_id,omitempty"`    // kosong untuk perubahan terjadwal
+	ScheduleID    string    `json:"schedule_id,omitempty"` // jadwal yang menjalankannya
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Price schedule statuses
+const (
+	SchedulePending  = "pending"
+	ScheduleActive   = "active" // sale running
+	ScheduleDone     = "done"
+	ScheduleCanceled = "canceled"
+)
+
+// PriceSchedule changes a product's price at StartsAt. With EndsAt it is a
+// sale and the price goes back to the regular one at EndsAt.
+type PriceSchedule struct {
+	ID         string     `json:"id"`
+	ProductID  string     `json:"product_id"`
+	PriceCents int64      `json:"price_cents"`
+	StartsAt   time.Time  `json:"starts_at"`
+	EndsAt     *time.Time `json:"ends_at,omitempty"`
+	Status     string     `json:"status"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ProductImage is one entry of a product's ordered gallery
+type ProductImage struct {
+	URL string `json:"url"`
+	Alt string `json:"alt,omitempty"`
+}
+
+// ProductOption is one axis of variation, e.g. size with values S, M, L
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductVariant is one sellable combination of option values with its own
+// SKU and stock
+type ProductVariant struct {
+	ID         string            `json:"id"`
+	ProductID  string            `json:"product_id"`
+	SKU        string            `json:"sku"`
+	Options    map[string]string `json:"options"`               // option name -> value
+	PriceCents *int64            `json:"price_cents,omitempty"` // overrides the product price when set
+	Stock      int               `json:"stock"`
+	Image      string            `json:"image,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Location is a place stock is kept and shipped from, such as a warehouse
+// or a shop counter
+type Location struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Code      string    `json:"code,omitempty"`
+	Priority  int       `json:"priority"`            // lower ships first; the first location is the default
+	Latitude  *float64  `json:"latitude,omitempty"`  // for nearest-first allocation
+	Longitude *float64  `json:"longitude,omitempty"` // for nearest-first allocation
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StockLevel is the stock of a product, or one of its variants, at one
+// location
+type StockLevel struct {
+	LocationID string `json:"location_id"`
+	ProductID  string `json:"product_id"`
+	VariantID  string `json:"variant_id,omitempty"`
+	Stock      int    `json:"stock"`
+}
+
+// StockAllocation is the part of an order line shipped from one location
+type StockAllocation struct {
+	LocationID string `json:"location_id"`
+	Quantity   int    `json:"quantity"`
 }
 
 // Cart and nested items
 type CartItem struct {
-	ProductID string `json:"product_id"`
-	Quantity  int    `json:"quantity"`
+	ProductID   string            `json:"product_id"`
+	VariantID   string            `json:"variant_id,omitempty"` // Wajib bila produk punya varian
+	Quantity    int               `json:"quantity"`
+	PriceCents  int64             `json:"price_cents,omitempty"` // Harga satuan saat checkout, hanya terisi di item order
+	Allocations []StockAllocation `json:"allocations,omitempty"` // lokasi pengirim, hanya terisi di item order yang memegang stok
 }
 
 type Cart struct {
@@ -31,6 +218,14 @@ type Cart struct {
 	Items  []CartItem `json:"items"`
 }
 
+// GuestCart is the cart of a visitor who has not logged in, identified by
+// the guest ID in their signed guest token
+type GuestCart struct {
+	ID        string     `json:"id"`
+	Items     []CartItem `json:"items"`
+	UpdatedAt time.Time  `json:"updated_at"` // perubahan terakhir; keranjang kedaluwarsa setelah lama tidak dipakai
+}
+
 // Checkout
 type CheckoutRequest struct {
 	PaymentMethod string `json:"payment_method"` // e.g., "card"
@@ -41,11 +236,57 @@ type Order struct {
 	UserID     string     `json:"user_id"`
 	Items      []CartItem `json:"items"`
 	Amount     int64      `json:"amount_cents"`
-	Status     string     `json:"status"` // pending, paid, failed
+	Status     string     `json:"status"` // pending, paid, done, failed, canceled, expired
 	PaymentRef string     `json:"payment_ref"`
-	CreatedAt  time.Time  `json:"created_at"`
+	// Reservation is what has become of the ordered units: held while the
+	// order awaits payment, committed once paid, released back into stock
+	// when it fails, is canceled or expires
+	Reservation string    `json:"reservation"`
+	ReservedAt  time.Time `json:"reserved_at"` // when the units were last taken from stock
+	CreatedAt   time.Time `json:"created_at"`
 }
 
+// Stock movement reasons
+const (
+	StockSale       = "sale"
+	StockRestock    = "restock"
+	StockAdjustment = "adjustment"
+	StockReturn     = "return"
+	StockRelease    = "reservation_release"
+	StockTransfer   = "transfer"
+)
+
+// StockSubscription asks for an email once a product, or one of its
+// variants, is back in stock
+type StockSubscription struct {
+	UserID    string    `json:"user_id"`
+	ProductID string    `json:"product_id"`
+	VariantID string    `json:"variant_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StockMovement is one entry of the inventory ledger. The deltas of a
+// product's movements add up to its stock.
+type StockMovement struct {
+	ID          string    `json:"id"`
+	ProductID   string    `json:"product_id"`
+	VariantID   string    `json:"variant_id,omitempty"`
+	LocationID  string    `json:"location_id"`
+	Delta       int       `json:"delta"`
+	Reason      string    `json:"reason"`
+	ReferenceID string    `json:"reference_id,omitempty"` // order ID for sales, releases and returns
+	ActorID     string    `json:"actor_id,omitempty"`     // kosong untuk perubahan otomatis
+	Note        string    `json:"note,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Order reservation states
+const (
+	ReservationHeld      = "held"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+)
+
 // Review represents a user review for the coffeehouse
 type Review struct {
 	ID        string    `json:"id"`
@@ -56,3 +297,32 @@ type Review struct {
 	Comment   string    `json:"comment"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// Category is a node in the category tree. Children is only filled in when
+// the tree is built for display.
+type Category struct {
+	ID        string      `json:"id"`
+	ParentID  string      `json:"parent_id,omitempty"` // kosong untuk kategori teratas
+	Name      string      `json:"name"`
+	Slug      string      `json:"slug"`
+	SKUPrefix string      `json:"sku_prefix,omitempty"` // awalan SKU otomatis produk di kategori ini
+	SortOrder int         `json:"sort_order"`
+	Image     string      `json:"image,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	Children  []*Category `json:"children,omitempty"`
+}
+
+// AuditEntry records one privileged change. Before and After hold only the
+// fields that changed; a create has no Before and a delete no After.
+type AuditEntry struct {
+	ID         string          `json:"id"`
+	ActorID    string          `json:"actor_id"`
+	Action     string          `json:"action"`      // e.g. product.update, order.status
+	EntityType string          `json:"entity_type"` // product, order, upload
+	EntityID   string          `json:"entity_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	IP         string          `json:"ip"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
@@ -14,14 +14,14 @@ import (
 
 // MidtransGateway implements Midtrans payment integration
 type MidtransGateway struct {
-	serverKey   string
+	serverKey    string
 	isProduction bool
-	client      *http.Client
+	client       *http.Client
 }
 
 func NewMidtransGateway(serverKey string, isProduction bool) *MidtransGateway {
 	return &MidtransGateway{
-		serverKey:   serverKey,
+		serverKey:    serverKey,
 		isProduction: isProduction,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
@@ -31,11 +31,11 @@ func NewMidtransGateway(serverKey string, isProduction bool) *MidtransGateway {
 
 // Midtrans request/response structures
 type MidtransChargeRequest struct {
-	PaymentType string                 `json:"payment_type"`
-	Transaction MidtransTransaction    `json:"transaction_details"`
-	Customer    MidtransCustomer       `json:"customer_details,omitempty"`
-	Items       []MidtransItem         `json:"item_details,omitempty"`
-	Callbacks   *MidtransCallbacks     `json:"callbacks,omitempty"`
+	PaymentType string              `json:"payment_type"`
+	Transaction MidtransTransaction `json:"transaction_details"`
+	Customer    MidtransCustomer    `json:"customer_details,omitempty"`
+	Items       []MidtransItem      `json:"item_details,omitempty"`
+	Callbacks   *MidtransCallbacks  `json:"callbacks,omitempty"`
 }
 
 type MidtransTransaction struct {
@@ -87,7 +87,7 @@ func (m *MidtransGateway) Charge(ctx context.Context, amountCents int64, method
 
 	// Determine payment type from method
 	paymentType := m.getPaymentType(method)
-	
+
 	req := MidtransChargeRequest{
 		PaymentType: paymentType,
 		Transaction: MidtransTransaction{
@@ -250,4 +250,4 @@ func (m *MidtransGateway) getPaymentType(method string) string {
 	default:
 		return "bank_transfer" // default to bank transfer
 	}
-}
\ No newline at end of file
+}
@@ -1,11 +1,13 @@
 package routes
 
 import (
+	"context"
 	"fmt"
 	"log"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/example/ecommerce-api/internal/audit"
 	"github.com/example/ecommerce-api/internal/auth"
 	"github.com/example/ecommerce-api/internal/config"
 	"github.com/example/ecommerce-api/internal/email"
@@ -15,8 +17,12 @@ import (
 	"github.com/example/ecommerce-api/internal/store"
 )
 
-func Register(r *gin.Engine, cfg *config.Config) error {
+// Register builds the store and services and mounts every route on r. The
+// returned shutdown function flushes and closes the store; call it once the
+// server has stopped serving requests.
+func Register(r *gin.Engine, cfg *config.Config) (shutdown func(), err error) {
 	var st store.Store
+	shutdown = func() {}
 
 	// Initialize store based on backend type
 	switch cfg.StoreBackend {
@@ -24,22 +30,53 @@ func Register(r *gin.Engine, cfg *config.Config) error {
 		log.Printf("Connecting to MySQL...")
 		ms, err := store.NewMySQLStore(cfg.MySQLDSN)
 		if err != nil {
-			return fmt.Errorf("mysql connection failed: %w", err)
+			return nil, fmt.Errorf("mysql connection failed: %w", err)
 		} else {
 			log.Println("✅ MySQL connected successfully")
 			st = ms
+			shutdown = func() { _ = ms.Close() }
 		}
 	case "postgres":
-		log.Printf("⚠️  PostgreSQL not yet implemented, using in-memory store")
-		st = store.NewInMemoryStore()
+		log.Printf("Connecting to PostgreSQL...")
+		ps, err := store.NewPostgresStore(cfg.PostgresDSN)
+		if err != nil {
+			return nil, fmt.Errorf("postgres connection failed: %w", err)
+		}
+		log.Println("✅ PostgreSQL connected successfully")
+		st = ps
+		shutdown = func() { _ = ps.Close() }
 	default:
 		log.Println("📝 Using in-memory store")
-		st = store.NewInMemoryStore()
+		ms := store.NewInMemoryStore()
+		if path := cfg.MemorySnapshotPath; path != "" {
+			if err := ms.LoadSnapshot(path); err != nil {
+				return nil, fmt.Errorf("memory snapshot load failed: %w", err)
+			}
+			stop := ms.StartSnapshots(path, cfg.MemorySnapshotInterval)
+			shutdown = func() {
+				if err := stop(); err != nil {
+					log.Printf("⚠️  Failed to save memory snapshot: %v", err)
+					return
+				}
+				log.Printf("💾 Memory snapshot saved to %s", path)
+			}
+			log.Printf("💾 Memory snapshots: %s every %s", path, cfg.MemorySnapshotInterval)
+		}
+		st = ms
+	}
+
+	stopExpiry := store.StartReservationExpiry(st, cfg.PaymentTimeout)
+	stopGuestExpiry := store.StartGuestCartExpiry(st, cfg.GuestCartTTL)
+	closeStore := shutdown
+	shutdown = func() {
+		stopExpiry()
+		stopGuestExpiry()
+		closeStore()
 	}
 
 	// Seed default admin user
 	adminHash, _ := auth.HashPassword(cfg.AdminPassword)
-	if err := st.SeedAdminUser(cfg.AdminEmail, adminHash); err != nil {
+	if err := st.SeedAdminUser(context.Background(), cfg.AdminEmail, adminHash); err != nil {
 		log.Printf("⚠️  Failed to seed admin: %v", err)
 	} else {
 		log.Printf("✅ Admin user seeded: %s", cfg.AdminEmail)
@@ -69,12 +106,23 @@ func Register(r *gin.Engine, cfg *config.Config) error {
 
 	// Initialize handlers
 	authH := handlers.NewAuthHandler(cfg, st, jwtm, emailSvc)
-	prodH := handlers.NewProductsHandler(st)
+	restockN := handlers.NewRestockNotifier(st, emailSvc, cfg.FrontendURL)
+	closeRoutes := shutdown
+	shutdown = func() {
+		restockN.Stop()
+		closeRoutes()
+	}
+	lowStockA := handlers.NewLowStockAlerter(st, emailSvc, cfg.LowStockThreshold, cfg.FrontendURL)
+	prodH := handlers.NewProductsHandler(st, restockN, lowStockA)
+	catH := handlers.NewCategoriesHandler(st)
+	locH := handlers.NewLocationsHandler(st)
 	cartH := handlers.NewCartHandler(st)
-	checkH := handlers.NewCheckoutHandler(cfg, st, pay, emailSvc)
+	checkH := handlers.NewCheckoutHandler(cfg, st, pay, emailSvc, lowStockA)
+	invH := handlers.NewInventoryHandler(st, cfg.LowStockThreshold)
 	reviewH := handlers.NewReviewsHandler(st)
 	adminOrdersH := handlers.NewAdminOrdersHandler(st)
 	uploadsH := handlers.NewUploadsHandler(cfg)
+	auditH := handlers.NewAdminAuditHandler(st)
 
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
@@ -117,20 +165,59 @@ func Register(r *gin.Engine, cfg *config.Config) error {
 		prod.GET("/:id", prodH.Get)
 	}
 
+	// Public category tree
+	api.GET("/categories", catH.Tree)
+
 	// Public review routes
 	api.GET("/reviews", reviewH.List)
 
 	// Admin routes
 	admin := api.Group("/admin")
-	admin.Use(middleware.JWTAuth(jwtm), middleware.RequireAdmin())
+	admin.Use(middleware.JWTAuth(jwtm), middleware.RequireAdmin(), audit.Middleware(st))
 	{
 		admin.GET("/products", prodH.AdminList)
+		admin.GET("/products/export", prodH.Export)
+		admin.POST("/products/import", prodH.Import)
+		admin.GET("/products/by-sku/:sku", prodH.BySKU)
+		admin.GET("/products/:id", prodH.AdminGet)
 		admin.POST("/products", prodH.Create)
 		admin.PUT("/products/:id", prodH.Update)
 		admin.DELETE("/products/:id", prodH.Delete)
+		admin.POST("/products/:id/restore", prodH.Restore)
+		admin.DELETE("/products/:id/purge", prodH.Purge)
+		admin.POST("/products/:id/variants", prodH.CreateVariant)
+		admin.PUT("/products/:id/variants/:variantId", prodH.UpdateVariant)
+		admin.DELETE("/products/:id/variants/:variantId", prodH.DeleteVariant)
+		admin.GET("/products/:id/prices", prodH.Prices)
+		admin.POST("/products/:id/prices/schedules", prodH.CreatePriceSchedule)
+		admin.DELETE("/products/:id/prices/schedules/:scheduleId", prodH.CancelPriceSchedule)
+		admin.POST("/products/:id/stock", prodH.AdjustStock)
+		admin.GET("/products/:id/stock/movements", prodH.StockMovements)
+		admin.GET("/products/:id/stock/locations", prodH.StockLevels)
+		admin.POST("/products/:id/stock/transfer", prodH.TransferStock)
+		admin.GET("/categories", catH.AdminList)
+		admin.POST("/categories", catH.Create)
+		admin.PUT("/categories/:id", catH.Update)
+		admin.DELETE("/categories/:id", catH.Delete)
+		admin.GET("/inventory/low-stock", invH.LowStock)
+		admin.GET("/locations", locH.List)
+		admin.POST("/locations", locH.Create)
+		admin.PUT("/locations/:id", locH.Update)
+		admin.DELETE("/locations/:id", locH.Delete)
 		admin.GET("/orders", adminOrdersH.List)
 		admin.PUT("/orders/:id/status", adminOrdersH.UpdateStatus)
 		admin.POST("/uploads/thumbnail", uploadsH.UploadProductThumbnail)
+		admin.GET("/audit", auditH.List)
+	}
+
+	// Guest cart routes; login and signup merge the cart sent in X-Guest-Token
+	guest := api.Group("/cart")
+	guest.Use(middleware.GuestAuth(jwtm, cfg.GuestCartTTL))
+	{
+		guest.GET("", cartH.View)
+		guest.POST("/add", cartH.Add)
+		guest.POST("/remove", cartH.Remove)
+		guest.PUT("/items/:product_id", cartH.SetQuantity)
 	}
 
 	// User routes (authenticated)
@@ -140,14 +227,17 @@ func Register(r *gin.Engine, cfg *config.Config) error {
 		user.GET("/cart", cartH.View)
 		user.POST("/cart/add", cartH.Add)
 		user.POST("/cart/remove", cartH.Remove)
+		user.PUT("/cart/items/:product_id", cartH.SetQuantity)
 		user.POST("/checkout", checkH.Checkout)
 		user.GET("/orders", checkH.MyOrders)
 		user.POST("/reviews", reviewH.Create)
+		user.POST("/products/:id/notify", prodH.Subscribe)
+		user.DELETE("/products/:id/notify", prodH.Unsubscribe)
 	}
 
 	// Midtrans webhook
 	api.POST("/webhooks/midtrans", checkH.MidtransCallback)
 
 	log.Println("✅ Routes registered successfully")
-	return nil
+	return shutdown, nil
 }
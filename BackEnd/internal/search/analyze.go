@@ -0,0 +1,205 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// stopwords are dropped from documents and queries alike
+var stopwords = map[string]bool{
+	"dan": true, "atau": true, "yang": true, "di": true, "ke": true, "dari": true,
+	"untuk": true, "dengan": true, "ini": true, "itu": true,
+	"the": true, "and": true, "or": true, "of": true, "for": true, "with": true,
+	"a": true, "an": true, "to": true, "in": true,
+}
+
+// Terms splits text into lower-case words of letters and digits, stopwords
+// left out
+func Terms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := words[:0]
+	for _, w := range words {
+		if !stopwords[w] {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// Stem reduces a word to a rough root so that inflected forms match: English
+// plurals and -ing/-ed, Indonesian -nya/-kan/-an and ber-/ter-/me- prefixes.
+// Affixes are only stripped while enough of the word is left, and words
+// with digits are left alone.
+func Stem(word string) string {
+	if strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+		return word
+	}
+	// cut strips affix from s if at least keep letters remain
+	cut := func(s, affix string, prefix bool, keep int) (string, bool) {
+		if len(s)-len(affix) < keep {
+			return s, false
+		}
+		if prefix && strings.HasPrefix(s, affix) {
+			return s[len(affix):], true
+		}
+		if !prefix && strings.HasSuffix(s, affix) {
+			return s[:len(s)-len(affix)], true
+		}
+		return s, false
+	}
+
+	// English
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"), strings.HasSuffix(word, "shes"), strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "xes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"):
+		return word
+	}
+	for _, suffix := range []string{"ing", "ed"} {
+		if s, ok := cut(word, suffix, false, 4); ok {
+			return s
+		}
+	}
+	if s, ok := cut(word, "s", false, 3); ok {
+		return s
+	}
+
+	// Indonesian: particle or possessive, then derivational suffix, then
+	// prefix
+	w := word
+	for _, suffix := range []string{"nya", "lah", "kah"} {
+		if s, ok := cut(w, suffix, false, 4); ok {
+			w = s
+			break
+		}
+	}
+	for _, suffix := range []string{"kan", "an"} {
+		if s, ok := cut(w, suffix, false, 4); ok {
+			w = s
+			break
+		}
+	}
+	for _, prefix := range []string{"ber", "ter", "mem", "men", "me"} {
+		if s, ok := cut(w, prefix, true, 4); ok && prefixFits(prefix, s) {
+			w = s
+			break
+		}
+	}
+	return w
+}
+
+// prefixFits reports whether Indonesian puts prefix before rest, so that
+// English words such as "medium" keep their first letters. me- and its
+// nasal forms only go before certain sounds; ber- and ter- before any.
+func prefixFits(prefix, rest string) bool {
+	switch prefix {
+	case "me":
+		return strings.IndexByte("lmnrwy", rest[0]) >= 0
+	case "mem":
+		return strings.IndexByte("bfpv", rest[0]) >= 0
+	case "men":
+		return strings.IndexByte("cdjtz", rest[0]) >= 0
+	}
+	return true
+}
+
+// synonymGroups are words shoppers use for the same thing, in Indonesian
+// and English
+var synonymGroups = [][]string{
+	{"kaos", "kaus", "tshirt", "tee"},
+	{"kemeja", "shirt"},
+	{"celana", "pants", "trousers"},
+	{"jaket", "jacket"},
+	{"sepatu", "shoe", "shoes", "sneakers"},
+	{"sandal", "sandals"},
+	{"tas", "bag", "bags"},
+	{"topi", "hat", "cap"},
+	{"jam", "watch"},
+	{"buku", "book", "books"},
+	{"kopi", "coffee"},
+	{"teh", "tea"},
+	{"ponsel", "hp", "handphone", "smartphone"},
+	{"laptop", "notebook"},
+	{"keyboard", "papanketik"},
+	{"mouse", "tetikus"},
+}
+
+// synonyms maps the stem of every word above to the stems of its group
+var synonyms = func() map[string][]string {
+	m := map[string][]string{}
+	for _, group := range synonymGroups {
+		stems := []string{}
+		for _, w := range group {
+			if s := Stem(w); !slices.Contains(stems, s) {
+				stems = append(stems, s)
+			}
+		}
+		for _, s := range stems {
+			for _, other := range stems {
+				if other != s {
+					m[s] = append(m[s], other)
+				}
+			}
+		}
+	}
+	return m
+}()
+
+// Synonyms returns the stems that mean the same as stem
+func Synonyms(stem string) []string {
+	return synonyms[stem]
+}
+
+// maxTypos is how many edits a query word may be off by: none for short
+// words, where one edit already changes the word, one from four letters and
+// two from eight
+func maxTypos(word string) int {
+	switch n := len([]rune(word)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// neighbouring letters between a and b. It gives up and returns max+1 once
+// the distance exceeds max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			best = min(best, cur[j])
+		}
+		if best > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,192 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+// Field weights: a word in the name counts four times one in the
+// description
+const (
+	weightName        = 4
+	weightSKU         = 3
+	weightCategory    = 2
+	weightDescription = 1
+)
+
+// How much each kind of match is worth next to the word as typed
+const (
+	matchWord    = 1.0
+	matchStem    = 0.9
+	matchSynonym = 0.8
+	matchTypo    = 0.6
+)
+
+// postings maps a document ID to the weight of the best field a word
+// appears in
+type postings map[string]float64
+
+// MemoryIndex is an in-process inverted index over words and their stems
+type MemoryIndex struct {
+	mu    sync.RWMutex
+	words map[string]postings
+	stems map[string]postings
+	docs  map[string]memoryDoc
+	vocab *Vocabulary
+}
+
+// memoryDoc remembers what a document was filed under, for Remove
+type memoryDoc struct {
+	words []string
+	stems []string
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		words: map[string]postings{},
+		stems: map[string]postings{},
+		docs:  map[string]memoryDoc{},
+		vocab: NewVocabulary(),
+	}
+}
+
+func (ix *MemoryIndex) Put(p *models.Product) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(p.ID)
+	doc := memoryDoc{}
+	file := func(text string, weight float64) {
+		for _, w := range Terms(text) {
+			doc.words = append(doc.words, w)
+			doc.stems = append(doc.stems, Stem(w))
+			post(ix.words, w, p.ID, weight)
+			post(ix.stems, Stem(w), p.ID, weight)
+		}
+	}
+	file(p.Name, weightName)
+	file(p.SKU, weightSKU)
+	file(p.Category, weightCategory)
+	file(p.Description, weightDescription)
+	ix.docs[p.ID] = doc
+	ix.vocab.Add(doc.words...)
+}
+
+func (ix *MemoryIndex) Remove(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+// remove drops a document; callers hold the write lock
+func (ix *MemoryIndex) remove(id string) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for _, w := range doc.words {
+		unpost(ix.words, w, id)
+	}
+	for _, s := range doc.stems {
+		unpost(ix.stems, s, id)
+	}
+	ix.vocab.Remove(doc.words...)
+	delete(ix.docs, id)
+}
+
+func post(index map[string]postings, key, id string, weight float64) {
+	p, ok := index[key]
+	if !ok {
+		p = postings{}
+		index[key] = p
+	}
+	p[id] = max(p[id], weight)
+}
+
+func unpost(index map[string]postings, key, id string) {
+	if p, ok := index[key]; ok {
+		delete(p, id)
+		if len(p) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+// Search scores each document by its best match for every query word,
+// weighted by field, kind of match and how rare the matched word is.
+// Documents missing any query word are left out.
+func (ix *MemoryIndex) Search(ctx context.Context, query string, limit int) ([]Hit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	expansions := Expand(query, ix.vocab)
+	if len(expansions) == 0 {
+		return []Hit{}, nil
+	}
+	var scores map[string]float64
+	for _, e := range expansions {
+		best := map[string]float64{}
+		add := func(p postings, match float64) {
+			idf := 1 + math.Log(float64(len(ix.docs))/float64(len(p)))
+			for id, weight := range p {
+				best[id] = max(best[id], weight*match*idf)
+			}
+		}
+		if p, ok := ix.words[e.Word]; ok {
+			add(p, matchWord)
+		}
+		if p, ok := ix.stems[e.Stem]; ok {
+			add(p, matchStem)
+		}
+		for _, s := range e.Synonyms {
+			if p, ok := ix.stems[s]; ok {
+				add(p, matchSynonym)
+			}
+		}
+		for _, w := range e.Typos {
+			if p, ok := ix.words[w]; ok {
+				add(p, matchTypo)
+			}
+		}
+
+		if scores == nil {
+			scores = best
+			continue
+		}
+		for id := range scores {
+			if s, ok := best[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, s := range scores {
+		hits = append(hits, Hit{ID: id, Score: s})
+	}
+	sortHits(hits)
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// sortHits orders hits best first, ties by ID so results are stable
+func sortHits(hits []Hit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+}
@@ -0,0 +1,131 @@
+// Package search finds and ranks products for free-text queries.
+//
+// An Index is kept current by the store as products change and is asked for
+// the products matching a query, best first. Every implementation analyzes
+// text the same way: words are lower-cased and stemmed, synonyms count as
+// matches, and query words with a typo or two are corrected against the
+// words the catalog uses. Name matches rank above description matches.
+package search
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+// Index ranks products for free-text queries
+type Index interface {
+	// Put adds a product or replaces its earlier version
+	Put(p *models.Product)
+	Remove(id string)
+	// Search returns up to limit products matching every word of query,
+	// best first
+	Search(ctx context.Context, query string, limit int) ([]Hit, error)
+}
+
+// Hit is a product matching a query. Scores only compare within one search.
+type Hit struct {
+	ID    string
+	Score float64
+}
+
+// Expansion lists the ways one query word may match
+type Expansion struct {
+	Word     string   // as typed, lower-cased
+	Stem     string   // Stem(Word)
+	Synonyms []string // stems of words meaning the same
+	Typos    []string // catalog words a typo or two away, when Word is not one
+}
+
+// Expand analyzes a query into one expansion per word. vocab supplies the
+// corrections for misspelt words and may be nil.
+func Expand(query string, vocab *Vocabulary) []Expansion {
+	words := Terms(query)
+	out := make([]Expansion, 0, len(words))
+	for _, w := range words {
+		e := Expansion{Word: w, Stem: Stem(w)}
+		e.Synonyms = Synonyms(e.Stem)
+		if vocab != nil && !vocab.Has(w) {
+			e.Typos = vocab.Near(w)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// Vocabulary is the set of words typo correction can suggest. Words are
+// counted so that removing one document keeps words others still use.
+type Vocabulary struct {
+	mu    sync.RWMutex
+	words map[string]int
+}
+
+func NewVocabulary() *Vocabulary {
+	return &Vocabulary{words: map[string]int{}}
+}
+
+func (v *Vocabulary) Add(words ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, w := range words {
+		v.words[w]++
+	}
+}
+
+func (v *Vocabulary) Remove(words ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, w := range words {
+		if v.words[w]--; v.words[w] <= 0 {
+			delete(v.words, w)
+		}
+	}
+}
+
+func (v *Vocabulary) Has(word string) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.words[word] > 0
+}
+
+// Near returns the words within maxTypos edits of word, closest first
+func (v *Vocabulary) Near(word string) []string {
+	budget := maxTypos(word)
+	if budget == 0 {
+		return nil
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	type near struct {
+		word string
+		dist int
+	}
+	found := []near{}
+	for w := range v.words {
+		if d := editDistance(word, w, budget); d <= budget {
+			found = append(found, near{w, d})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].dist != found[j].dist {
+			return found[i].dist < found[j].dist
+		}
+		return found[i].word < found[j].word
+	})
+	out := make([]string, len(found))
+	for i, n := range found {
+		out[i] = n.word
+	}
+	return out
+}
+
+// Words returns the searchable words of a product, the same for every Index
+func Words(p *models.Product) []string {
+	words := Terms(p.Name)
+	words = append(words, Terms(p.SKU)...)
+	words = append(words, Terms(p.Category)...)
+	return append(words, Terms(p.Description)...)
+}
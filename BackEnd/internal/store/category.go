@@ -0,0 +1,96 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+var (
+	errCategoryNotFound = errors.New("category not found")
+	errParentNotFound   = errors.New("parent category not found")
+)
+
+// Slugify turns a name into a URL slug: lower-case letters and digits joined
+// by single hyphens, e.g. "Kopi & Teh" -> "kopi-teh"
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+			continue
+		}
+		hyphen = true
+	}
+	return b.String()
+}
+
+// checkCategoryParent verifies that parentID exists and that putting id
+// under it would not make id its own ancestor. byID holds every category.
+func checkCategoryParent(byID map[string]*models.Category, id, parentID string) error {
+	for cur := parentID; cur != ""; {
+		if cur == id {
+			return ErrCategoryCycle
+		}
+		c, ok := byID[cur]
+		if !ok {
+			if cur == parentID {
+				return errParentNotFound
+			}
+			return nil
+		}
+		cur = c.ParentID
+	}
+	return nil
+}
+
+// sortCategories orders categories by sort order, then name
+func sortCategories(cs []*models.Category) {
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].SortOrder != cs[j].SortOrder {
+			return cs[i].SortOrder < cs[j].SortOrder
+		}
+		if cs[i].Name != cs[j].Name {
+			return cs[i].Name < cs[j].Name
+		}
+		return cs[i].ID < cs[j].ID
+	})
+}
+
+// categoriesFromNames builds top-level categories out of free-text product
+// categories. Names that differ only in case or punctuation share a slug
+// and so one category, named after the first spelling seen. The returned
+// map takes every input name to its category.
+func categoriesFromNames(names []string, now time.Time) ([]*models.Category, map[string]*models.Category) {
+	bySlug := map[string]*models.Category{}
+	byName := map[string]*models.Category{}
+	res := []*models.Category{}
+	for _, raw := range names {
+		name := strings.TrimSpace(raw)
+		if name == "" {
+			continue
+		}
+		slug := Slugify(name)
+		if slug == "" {
+			slug = "category"
+		}
+		c, ok := bySlug[slug]
+		if !ok {
+			c = &models.Category{ID: uuid.NewString(), Name: name, Slug: slug, CreatedAt: now, UpdatedAt: now}
+			bySlug[slug] = c
+			res = append(res, c)
+		}
+		byName[raw] = c
+	}
+	return res, byName
+}
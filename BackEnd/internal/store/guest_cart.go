@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+// guestCartSweep is how often StartGuestCartExpiry looks for idle guest carts
+const guestCartSweep = time.Hour
+
+var errNotInCart = errors.New("item not in cart")
+
+// cartLineStock checks that a line of p with variantID can go in a cart and
+// returns the stock it sells from
+func cartLineStock(p *models.Product, variantID string, at time.Time) (int, error) {
+	if p.ArchivedAt != nil {
+		return 0, ErrProductArchived
+	}
+	if !p.Live(at) {
+		return 0, ErrProductNotPublished
+	}
+	v, err := resolveVariant(p, variantID)
+	if err != nil {
+		return 0, err
+	}
+	return stockFor(p, v), nil
+}
+
+// checkCartLine checks that qty units of a line of p with variantID can be
+// in a cart
+func checkCartLine(p *models.Product, variantID string, qty int, at time.Time) error {
+	stock, err := cartLineStock(p, variantID, at)
+	if err != nil {
+		return err
+	}
+	if stock < qty {
+		return errors.New("insufficient stock")
+	}
+	return nil
+}
+
+// cartQuantity is the quantity of a line in items, 0 when it is not there
+func cartQuantity(items []models.CartItem, productID, variantID string) int {
+	for _, it := range items {
+		if it.ProductID == productID && it.VariantID == variantID {
+			return it.Quantity
+		}
+	}
+	return 0
+}
+
+// setCartQuantity sets the quantity of a line in items, adding it when it is
+// missing and dropping it at 0
+func setCartQuantity(items []models.CartItem, productID, variantID string, qty int) []models.CartItem {
+	for i := range items {
+		if items[i].ProductID == productID && items[i].VariantID == variantID {
+			if qty <= 0 {
+				return append(items[:i], items[i+1:]...)
+			}
+			items[i].Quantity = qty
+			return items
+		}
+	}
+	if qty <= 0 {
+		return items
+	}
+	return append(items, models.CartItem{ProductID: productID, VariantID: variantID, Quantity: qty})
+}
+
+// mergeCartItems returns the user's cart lines with the guest's added, each
+// line capped at the stock it sells from. Guest lines that can no longer be
+// bought are dropped; user lines already over stock are left as they are.
+func mergeCartItems(items, guest []models.CartItem, product func(id string) *models.Product, at time.Time) []models.CartItem {
+	merged := append([]models.CartItem{}, items...)
+	for _, it := range guest {
+		p := product(it.ProductID)
+		if p == nil {
+			continue
+		}
+		stock, err := cartLineStock(p, it.VariantID, at)
+		if err != nil {
+			continue
+		}
+		cur := cartQuantity(merged, it.ProductID, it.VariantID)
+		if qty := min(cur+it.Quantity, stock); qty > cur {
+			merged = setCartQuantity(merged, it.ProductID, it.VariantID, qty)
+		}
+	}
+	return merged
+}
+
+// StartGuestCartExpiry deletes the guest carts of st left unchanged for
+// longer than idle, checking every hour or every idle if that is shorter,
+// until the returned stop function is called
+func StartGuestCartExpiry(st Store, idle time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(min(guestCartSweep, idle))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := st.ExpireGuestCarts(context.Background(), time.Now().Add(-idle))
+				if err != nil {
+					log.Printf("⚠️  Failed to expire guest carts: %v", err)
+				} else if n > 0 {
+					log.Printf("🛒 Expired %d idle guest carts", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+// manualStockReasons are the reasons an admin may give AdjustStock; sales
+// and reservation releases only come from orders
+var manualStockReasons = []string{models.StockAdjustment, models.StockRestock, models.StockReturn}
+
+// stockLevels maps each stock line of p to its units: every variant by ID,
+// or the product itself under "" when it has no variants
+func stockLevels(p *models.Product) map[string]int {
+	if len(p.Variants) == 0 {
+		return map[string]int{"": p.Stock}
+	}
+	levels := make(map[string]int, len(p.Variants))
+	for _, v := range p.Variants {
+		levels[v.ID] = v.Stock
+	}
+	return levels
+}
+
+// stockChanges returns the movements that take productID from the levels
+// before to the levels after, ordered by stock line. A nil before is a new
+// product.
+func stockChanges(productID string, before, after map[string]int, reason, actorID, note string, at time.Time) []*models.StockMovement {
+	lines := make([]string, 0, len(before)+len(after))
+	for id := range before {
+		lines = append(lines, id)
+	}
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			lines = append(lines, id)
+		}
+	}
+	slices.Sort(lines)
+
+	out := []*models.StockMovement{}
+	for _, id := range lines {
+		if delta := after[id] - before[id]; delta != 0 {
+			out = append(out, newStockMovement(productID, id, "", delta, reason, "", actorID, note, at))
+		}
+	}
+	return out
+}
+
+// openingBalance records the stock p had before the ledger existed, so that
+// its movements still add up to its stock
+func openingBalance(p *models.Product, at time.Time) []*models.StockMovement {
+	return stockChanges(p.ID, nil, stockLevels(p), models.StockAdjustment, "", "opening balance", at)
+}
+
+func newStockMovement(productID, variantID, locationID string, delta int, reason, refID, actorID, note string, at time.Time) *models.StockMovement {
+	return &models.StockMovement{
+		ID:          uuid.NewString(),
+		ProductID:   productID,
+		VariantID:   variantID,
+		LocationID:  locationID,
+		Delta:       delta,
+		Reason:      reason,
+		ReferenceID: refID,
+		ActorID:     actorID,
+		Note:        note,
+		CreatedAt:   at,
+	}
+}
+
+// orderStockMovements records units of o taken (sign -1) or put back (1) at
+// the locations its lines are allocated to; putting back is a reservation
+// release until the order was sold and a return after. Lines without
+// allocations, from before locations, leave the location to the caller.
+func orderStockMovements(o *models.Order, sign int, from, actorID string, at time.Time) []*models.StockMovement {
+	reason := models.StockSale
+	if sign > 0 {
+		reason = models.StockRelease
+		if from == models.ReservationCommitted {
+			reason = models.StockReturn
+		}
+	}
+	out := make([]*models.StockMovement, 0, len(o.Items))
+	for _, it := range o.Items {
+		if len(it.Allocations) == 0 {
+			out = append(out, newStockMovement(it.ProductID, it.VariantID, "", sign*it.Quantity, reason, o.ID, actorID, "", at))
+			continue
+		}
+		for _, a := range it.Allocations {
+			out = append(out, newStockMovement(it.ProductID, it.VariantID, a.LocationID, sign*a.Quantity, reason, o.ID, actorID, "", at))
+		}
+	}
+	return out
+}
+
+// checkStockAdjustment rejects manual adjustments AdjustStock does not take
+func checkStockAdjustment(delta int, reason string) error {
+	if delta == 0 || !slices.Contains(manualStockReasons, reason) {
+		return ErrInvalidStockAdjustment
+	}
+	return nil
+}
+
+// stockActor is the user in ctx making a change, or fallback when there is
+// none, as for a customer's own order
+func stockActor(ctx context.Context, fallback string) string {
+	if id := actorFrom(ctx); id != "" {
+		return id
+	}
+	return fallback
+}
+
+// compareLowStock orders ListLowStockProducts: lowest stock first, then by
+// name
+func compareLowStock(a, b *models.Product) int {
+	if c := cmp.Compare(a.Stock, b.Stock); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+var errLocationNotFound = errors.New("location not found")
+
+// defaultLocationName names the location existing stock is moved to when
+// locations are introduced
+const defaultLocationName = "Gudang Utama"
+
+// Allocation rules for picking the locations an order ships from
+const (
+	AllocatePriority = "priority" // locations in priority order
+	AllocateNearest  = "nearest"  // closest location to the destination first
+)
+
+// Allocation says how an order's lines are spread over locations. Nearest
+// without a destination, and locations without coordinates, fall back to
+// priority order.
+type Allocation struct {
+	Rule      string
+	Latitude  *float64
+	Longitude *float64
+}
+
+type allocationKey struct{}
+
+// WithAllocation returns ctx carrying the allocation used by orders placed
+// or reopened with it; without one, orders ship in priority order
+func WithAllocation(ctx context.Context, a Allocation) context.Context {
+	return context.WithValue(ctx, allocationKey{}, a)
+}
+
+func allocationFrom(ctx context.Context) Allocation {
+	a, _ := ctx.Value(allocationKey{}).(Allocation)
+	return a
+}
+
+func newDefaultLocation(at time.Time) *models.Location {
+	return &models.Location{ID: uuid.NewString(), Name: defaultLocationName, CreatedAt: at, UpdatedAt: at}
+}
+
+// compareLocations orders ListLocations: by priority, then name. The first
+// location is the default.
+func compareLocations(a, b *models.Location) int {
+	if c := cmp.Compare(a.Priority, b.Priority); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// allocationOrder returns the IDs of locs in the order a tries them
+func allocationOrder(locs []*models.Location, a Allocation) []string {
+	locs = slices.Clone(locs)
+	slices.SortFunc(locs, compareLocations)
+	if a.Rule == AllocateNearest && a.Latitude != nil && a.Longitude != nil {
+		dist := func(l *models.Location) float64 {
+			if l.Latitude == nil || l.Longitude == nil {
+				return math.Inf(1)
+			}
+			return distanceKm(*a.Latitude, *a.Longitude, *l.Latitude, *l.Longitude)
+		}
+		slices.SortStableFunc(locs, func(x, y *models.Location) int { return cmp.Compare(dist(x), dist(y)) })
+	}
+	ids := make([]string, len(locs))
+	for i, l := range locs {
+		ids[i] = l.ID
+	}
+	return ids
+}
+
+// distanceKm is the great-circle distance between two points
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLng := rad(lat2-lat1), rad(lng2-lng1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// allocateLine picks where qty units of a line ship from, given its stock
+// per location: the first location in order holding all of them, or else
+// as many as each holds in turn. Reports false when there are not enough.
+func allocateLine(levels map[string]int, order []string, qty int) ([]models.StockAllocation, bool) {
+	for _, id := range order {
+		if levels[id] >= qty {
+			return []models.StockAllocation{{LocationID: id, Quantity: qty}}, true
+		}
+	}
+	allocs := []models.StockAllocation{}
+	for _, id := range order {
+		if qty == 0 {
+			break
+		}
+		if n := min(levels[id], qty); n > 0 {
+			allocs = append(allocs, models.StockAllocation{LocationID: id, Quantity: n})
+			qty -= n
+		}
+	}
+	return allocs, qty == 0
+}
+
+// allocateItems spreads items over locations tried in order, lineStock
+// giving the stock of a line per location. Lines of the same product share
+// its stock.
+func allocateItems(items []models.CartItem, order []string, lineStock func(productID, variantID string) (map[string]int, error)) ([][]models.StockAllocation, error) {
+	type line struct{ location, product, variant string }
+	taken := map[line]int{}
+	allocs := make([][]models.StockAllocation, len(items))
+	for i, it := range items {
+		levels, err := lineStock(it.ProductID, it.VariantID)
+		if err != nil {
+			return nil, err
+		}
+		for loc := range levels {
+			levels[loc] -= taken[line{loc, it.ProductID, it.VariantID}]
+		}
+		a, ok := allocateLine(levels, order, it.Quantity)
+		if !ok {
+			return nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, it.ProductID)
+		}
+		for _, al := range a {
+			taken[line{al.LocationID, it.ProductID, it.VariantID}] += al.Quantity
+		}
+		allocs[i] = a
+	}
+	return allocs, nil
+}
+
+// spreadStock places m, a change to the total stock of a line, on
+// locations given the line's stock per location: units added go to the
+// default location, the first in order, and units removed come from it
+// first, then from the others in order
+func spreadStock(m *models.StockMovement, levels map[string]int, order []string) []*models.StockMovement {
+	if m.LocationID != "" || len(order) == 0 {
+		return []*models.StockMovement{m}
+	}
+	if m.Delta > 0 {
+		placed := *m
+		placed.LocationID = order[0]
+		return []*models.StockMovement{&placed}
+	}
+	out := []*models.StockMovement{}
+	left := -m.Delta
+	for _, id := range order {
+		if n := min(levels[id], left); n > 0 {
+			out = append(out, newStockMovement(m.ProductID, m.VariantID, id, -n, m.Reason, m.ReferenceID, m.ActorID, m.Note, m.CreatedAt))
+			left -= n
+		}
+	}
+	if left > 0 {
+		// Levels already short of the total; the default location absorbs it
+		out = append(out, newStockMovement(m.ProductID, m.VariantID, order[0], -left, m.Reason, m.ReferenceID, m.ActorID, m.Note, m.CreatedAt))
+	}
+	return out
+}
+
+// checkStockTransfer rejects transfers TransferStock does not take
+func checkStockTransfer(fromID, toID string, qty int) error {
+	if qty <= 0 || fromID == "" || toID == "" || fromID == toID {
+		return ErrInvalidStockAdjustment
+	}
+	return nil
+}
+
+// transferMovements records qty units of a line leaving one location for
+// another
+func transferMovements(productID, variantID, fromID, toID string, qty int, actorID, note string, at time.Time) []*models.StockMovement {
+	return []*models.StockMovement{
+		newStockMovement(productID, variantID, fromID, -qty, models.StockTransfer, "", actorID, note, at),
+		newStockMovement(productID, variantID, toID, qty, models.StockTransfer, "", actorID, note, at),
+	}
+}
@@ -1,13 +1,18 @@
 package store
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/search"
 )
 
 // InMemoryStore implements users, products, carts, and orders in memory
@@ -18,27 +23,63 @@ type InMemoryStore struct {
 	emailVerifications map[string]*models.EmailVerification
 	passwordResets     map[string]*models.PasswordReset
 	products           map[string]*models.Product
+	categories         map[string]*models.Category
 	carts              map[string]*models.Cart
+	guestCarts         map[string]*models.GuestCart
 	orders             map[string]*models.Order
 	reviews            map[string]*models.Review
+	auditLog           []*models.AuditEntry
+	priceHistory       []*models.PriceChange
+	priceSchedules     map[string]*models.PriceSchedule
+	stockLedger        []*models.StockMovement
+	locations          map[string]*models.Location
+	locationStock      map[stockKey]int // nonzero levels only
+	stockSubscriptions map[stockSubscriptionKey]*models.StockSubscription
+	archivedStatus     map[string]string // status to restore archived products to, by ID
+	nextPriceAt        time.Time         // earliest step of priceSchedules; zero when none is left
+	search             *search.MemoryIndex
 }
 
 func NewInMemoryStore() *InMemoryStore {
-	return &InMemoryStore{
+	s := &InMemoryStore{
 		users:              make(map[string]*models.User),
 		byEmail:            make(map[string]*models.User),
 		emailVerifications: make(map[string]*models.EmailVerification),
 		passwordResets:     make(map[string]*models.PasswordReset),
 		products:           make(map[string]*models.Product),
+		categories:         make(map[string]*models.Category),
 		carts:              make(map[string]*models.Cart),
+		guestCarts:         make(map[string]*models.GuestCart),
 		orders:             make(map[string]*models.Order),
 		reviews:            make(map[string]*models.Review),
+		priceSchedules:     make(map[string]*models.PriceSchedule),
+		locations:          make(map[string]*models.Location),
+		locationStock:      make(map[stockKey]int),
+		stockSubscriptions: make(map[stockSubscriptionKey]*models.StockSubscription),
+		archivedStatus:     make(map[string]string),
+		search:             search.NewMemoryIndex(),
 	}
+	l := newDefaultLocation(time.Now())
+	s.locations[l.ID] = l
+	return s
+}
+
+// stockKey identifies the stock of a line at one location
+type stockKey struct {
+	location, product, variant string
+}
+
+type stockSubscriptionKey struct {
+	user, product, variant string
 }
 
 // Users
 
-func (s *InMemoryStore) CreateUser(fullName, phone, email, passwordHash, role, provider, googleID string, emailVerified bool) (*models.User, error) {
+func (s *InMemoryStore) CreateUser(ctx context.Context, fullName, phone, email, passwordHash, role, provider, googleID string, emailVerified bool) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -72,7 +113,11 @@ func (s *InMemoryStore) CreateUser(fullName, phone, email, passwordHash, role, p
 	return u, nil
 }
 
-func (s *InMemoryStore) GetUserByEmail(email string) (*models.User, error) {
+func (s *InMemoryStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -83,7 +128,11 @@ func (s *InMemoryStore) GetUserByEmail(email string) (*models.User, error) {
 	return u, nil
 }
 
-func (s *InMemoryStore) GetUserByID(id string) (*models.User, error) {
+func (s *InMemoryStore) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -94,7 +143,35 @@ func (s *InMemoryStore) GetUserByID(id string) (*models.User, error) {
 	return u, nil
 }
 
-func (s *InMemoryStore) SeedAdminUser(email, passwordHash string) error {
+func (s *InMemoryStore) ListUsersByRole(ctx context.Context, role string) ([]*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := []*models.User{}
+	for _, u := range s.users {
+		if u.Role == role {
+			cp := *u
+			res = append(res, &cp)
+		}
+	}
+	slices.SortFunc(res, func(a, b *models.User) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return res, nil
+}
+
+func (s *InMemoryStore) SeedAdminUser(ctx context.Context, email, passwordHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -118,7 +195,11 @@ func (s *InMemoryStore) SeedAdminUser(email, passwordHash string) error {
 	return nil
 }
 
-func (s *InMemoryStore) UpdateUserPassword(userID, newPasswordHash string) error {
+func (s *InMemoryStore) UpdateUserPassword(ctx context.Context, userID, newPasswordHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -131,7 +212,11 @@ func (s *InMemoryStore) UpdateUserPassword(userID, newPasswordHash string) error
 	return nil
 }
 
-func (s *InMemoryStore) MarkEmailVerified(userID string) error {
+func (s *InMemoryStore) MarkEmailVerified(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -146,7 +231,11 @@ func (s *InMemoryStore) MarkEmailVerified(userID string) error {
 
 // Email Verification
 
-func (s *InMemoryStore) CreateEmailVerification(userID, token string, expiresAt time.Time) error {
+func (s *InMemoryStore) CreateEmailVerification(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -158,7 +247,11 @@ func (s *InMemoryStore) CreateEmailVerification(userID, token string, expiresAt
 	return nil
 }
 
-func (s *InMemoryStore) GetEmailVerification(token string) (*models.EmailVerification, error) {
+func (s *InMemoryStore) GetEmailVerification(ctx context.Context, token string) (*models.EmailVerification, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -169,7 +262,11 @@ func (s *InMemoryStore) GetEmailVerification(token string) (*models.EmailVerific
 	return v, nil
 }
 
-func (s *InMemoryStore) GetLatestEmailVerificationByUser(userID string) (*models.EmailVerification, error) {
+func (s *InMemoryStore) GetLatestEmailVerificationByUser(ctx context.Context, userID string) (*models.EmailVerification, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -191,7 +288,11 @@ func (s *InMemoryStore) GetLatestEmailVerificationByUser(userID string) (*models
 	return latest, nil
 }
 
-func (s *InMemoryStore) DeleteEmailVerification(token string) error {
+func (s *InMemoryStore) DeleteEmailVerification(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -201,7 +302,11 @@ func (s *InMemoryStore) DeleteEmailVerification(token string) error {
 
 // Password Reset
 
-func (s *InMemoryStore) CreatePasswordReset(userID, token string, expiresAt time.Time) error {
+func (s *InMemoryStore) CreatePasswordReset(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -213,7 +318,11 @@ func (s *InMemoryStore) CreatePasswordReset(userID, token string, expiresAt time
 	return nil
 }
 
-func (s *InMemoryStore) GetPasswordReset(token string) (*models.PasswordReset, error) {
+func (s *InMemoryStore) GetPasswordReset(ctx context.Context, token string) (*models.PasswordReset, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -224,7 +333,11 @@ func (s *InMemoryStore) GetPasswordReset(token string) (*models.PasswordReset, e
 	return r, nil
 }
 
-func (s *InMemoryStore) DeletePasswordReset(token string) error {
+func (s *InMemoryStore) DeletePasswordReset(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -234,272 +347,1733 @@ func (s *InMemoryStore) DeletePasswordReset(token string) error {
 
 // Products
 
-func (s *InMemoryStore) CreateProduct(p *models.Product) (*models.Product, error) {
+func (s *InMemoryStore) CreateProduct(ctx context.Context, p *models.Product) (*models.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.productSKUTaken(p.SKU, "") {
+		return nil, ErrSKUTaken
+	}
 	p.ID = uuid.NewString()
+	if p.Images == nil {
+		p.Images = []models.ProductImage{}
+	}
+	if p.Options == nil {
+		p.Options = []models.ProductOption{}
+	}
+	if p.Status == "" {
+		p.Status = models.ProductPublished
+	}
+	// Variants are added one by one through CreateVariant
+	p.Variants = []models.ProductVariant{}
 	p.CreatedAt = time.Now()
 	p.UpdatedAt = p.CreatedAt
 	s.products[p.ID] = p
-	return p, nil
+	s.search.Put(p)
+	s.recordPrice(newPriceChange(p.ID, 0, p.BasePrice(), models.PriceCreated, actorFrom(ctx), "", p.CreatedAt))
+	s.recordStock(stockChanges(p.ID, nil, stockLevels(p), models.StockRestock, actorFrom(ctx), "", p.CreatedAt)...)
+	return cloneProduct(p), nil
 }
 
-func (s *InMemoryStore) UpdateProduct(id string, update func(p *models.Product) error) (*models.Product, error) {
+func (s *InMemoryStore) UpdateProduct(ctx context.Context, id string, update func(p *models.Product) error) (*models.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	p, ok := s.products[id]
+	s.applyPriceSchedules(time.Now())
+	stored, ok := s.products[id]
 	if !ok {
 		return nil, errors.New("product not found")
 	}
 
+	// Edit a copy so a failing update leaves the product untouched
+	p := cloneProduct(stored)
 	if err := update(p); err != nil {
 		return nil, err
 	}
+	if p.Stock < 0 {
+		return nil, ErrInsufficientStock
+	}
 
+	if s.productSKUTaken(p.SKU, id) {
+		return nil, ErrSKUTaken
+	}
+	p.Variants = stored.Variants
+	rollupStock(p)
 	p.UpdatedAt = time.Now()
-	return p, nil
+	s.products[id] = p
+	s.search.Put(p)
+	s.recordPrice(basePriceChange(stored, p, models.PriceManual, actorFrom(ctx), p.UpdatedAt))
+	s.recordStock(stockChanges(id, stockLevels(stored), stockLevels(p), models.StockAdjustment, actorFrom(ctx), "", p.UpdatedAt)...)
+	return cloneProduct(p), nil
 }
 
-func (s *InMemoryStore) DeleteProduct(id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, ok := s.products[id]; !ok {
-		return errors.New("product not found")
+func (s *InMemoryStore) ArchiveProduct(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	delete(s.products, id)
-	return nil
-}
-
-func (s *InMemoryStore) GetProduct(id string) (*models.Product, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	p, ok := s.products[id]
 	if !ok {
-		return nil, errors.New("product not found")
+		return errors.New("product not found")
 	}
-	return p, nil
-}
-
-func (s *InMemoryStore) ListProducts(query string) ([]*models.Product, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	res := []*models.Product{}
-	for _, p := range s.products {
-		if query == "" || containsFold(p.Name, query) || containsFold(p.Description, query) || containsFold(p.SKU, query) || containsFold(p.Category, query) {
-			cp := *p
-			res = append(res, &cp)
-		}
+	if p.ArchivedAt == nil {
+		now := time.Now()
+		s.archivedStatus[id] = p.Status
+		p.ArchivedAt = &now
+		p.Status = models.ProductArchived
+		p.UpdatedAt = now
 	}
-	return res, nil
+	return nil
 }
 
-// Carts
+func (s *InMemoryStore) RestoreProduct(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-func (s *InMemoryStore) GetOrCreateCart(userID string) *models.Cart {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	c, ok := s.carts[userID]
+	p, ok := s.products[id]
 	if !ok {
-		c = &models.Cart{UserID: userID, Items: []models.CartItem{}}
-		s.carts[userID] = c
+		return errors.New("product not found")
 	}
-	return c
+	if p.ArchivedAt != nil {
+		status, ok := s.archivedStatus[id]
+		if !ok {
+			status = models.ProductDraft
+		}
+		p.ArchivedAt = nil
+		p.Status = status
+		p.UpdatedAt = time.Now()
+		delete(s.archivedStatus, id)
+	}
+	return nil
 }
 
-func (s *InMemoryStore) AddToCart(userID, productID string, qty int) error {
-	if qty <= 0 {
-		return errors.New("quantity must be positive")
+func (s *InMemoryStore) PurgeProduct(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	c, ok := s.carts[userID]
-	if !ok {
-		c = &models.Cart{UserID: userID, Items: []models.CartItem{}}
-		s.carts[userID] = c
-	}
-
-	// Validate product and stock
-	p, ok := s.products[productID]
-	if !ok {
+	if _, ok := s.products[id]; !ok {
 		return errors.New("product not found")
 	}
-
-	// Check current cart quantity
-	currentQty := 0
-	for i := range c.Items {
-		if c.Items[i].ProductID == productID {
-			currentQty = c.Items[i].Quantity
-			break
+	for _, o := range s.orders {
+		for _, it := range o.Items {
+			if it.ProductID == id {
+				return ErrProductInUse
+			}
 		}
 	}
 
-	if p.Stock < (currentQty + qty) {
-		return errors.New("insufficient stock")
+	// Drop it from carts too, as the SQL foreign keys cascade
+	for _, c := range s.carts {
+		c.Items = slices.DeleteFunc(c.Items, func(it models.CartItem) bool { return it.ProductID == id })
 	}
-
-	// Add or update
-	found := false
-	for i := range c.Items {
-		if c.Items[i].ProductID == productID {
-			c.Items[i].Quantity += qty
-			found = true
-			break
+	for _, c := range s.guestCarts {
+		c.Items = slices.DeleteFunc(c.Items, func(it models.CartItem) bool { return it.ProductID == id })
+	}
+	delete(s.products, id)
+	delete(s.archivedStatus, id)
+	s.search.Remove(id)
+	s.priceHistory = slices.DeleteFunc(s.priceHistory, func(c *models.PriceChange) bool { return c.ProductID == id })
+	s.stockLedger = slices.DeleteFunc(s.stockLedger, func(m *models.StockMovement) bool { return m.ProductID == id })
+	for k := range s.locationStock {
+		if k.product == id {
+			delete(s.locationStock, k)
 		}
 	}
-
-	if !found {
-		c.Items = append(c.Items, models.CartItem{ProductID: productID, Quantity: qty})
+	for k := range s.stockSubscriptions {
+		if k.product == id {
+			delete(s.stockSubscriptions, k)
+		}
 	}
-
+	for scID, sc := range s.priceSchedules {
+		if sc.ProductID == id {
+			delete(s.priceSchedules, scID)
+		}
+	}
+	s.resetNextPriceAt()
 	return nil
 }
 
-func (s *InMemoryStore) RemoveFromCart(userID, productID string, qty int) error {
-	if qty <= 0 {
-		return errors.New("quantity must be positive")
+// productSKUTaken reports whether a product other than id has sku. Callers
+// hold the lock.
+func (s *InMemoryStore) productSKUTaken(sku, id string) bool {
+	for _, p := range s.products {
+		if p.SKU == sku && p.ID != id {
+			return true
+		}
 	}
+	return false
+}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// variantSKUTaken reports whether a variant other than id has sku. Callers
+// hold the lock.
+func (s *InMemoryStore) variantSKUTaken(sku, id string) bool {
+	for _, p := range s.products {
+		for _, v := range p.Variants {
+			if v.SKU == sku && v.ID != id {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	c, ok := s.carts[userID]
-	if !ok {
-		return errors.New("cart not found")
+func (s *InMemoryStore) GetProductBySKU(ctx context.Context, sku string) (*models.Product, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
 	}
+	s.settlePrices()
 
-	for i := range c.Items {
-		if c.Items[i].ProductID == productID {
-			if c.Items[i].Quantity <= qty {
-				// Remove item
-				c.Items = append(c.Items[:i], c.Items[i+1:]...)
-				return nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, p := range s.products {
+		if p.SKU == sku {
+			return cloneProduct(p), "", nil
+		}
+	}
+	for _, p := range s.products {
+		for _, v := range p.Variants {
+			if v.SKU == sku {
+				return cloneProduct(p), v.ID, nil
 			}
-			c.Items[i].Quantity -= qty
-			return nil
 		}
 	}
-
-	return errors.New("item not in cart")
+	return nil, "", errors.New("product not found")
 }
 
-func (s *InMemoryStore) ClearCart(userID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	delete(s.carts, userID)
-}
+func (s *InMemoryStore) GetProduct(ctx context.Context, id string) (*models.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.settlePrices()
 
-func (s *InMemoryStore) GetCart(userID string) (*models.Cart, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	c, ok := s.carts[userID]
+	p, ok := s.products[id]
 	if !ok {
-		return &models.Cart{UserID: userID, Items: []models.CartItem{}}, nil
+		return nil, errors.New("product not found")
 	}
-
-	copyItems := make([]models.CartItem, len(c.Items))
-	copy(copyItems, c.Items)
-	return &models.Cart{UserID: userID, Items: copyItems}, nil
+	return cloneProduct(p), nil
 }
 
-// Orders
-
-func (s *InMemoryStore) CreateOrder(userID string, items []models.CartItem, amount int64, status, paymentRef string) (*models.Order, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	id := uuid.NewString()
-	o := &models.Order{
-		ID:         id,
-		UserID:     userID,
-		Items:      append([]models.CartItem(nil), items...),
-		Amount:     amount,
-		Status:     status,
-		PaymentRef: paymentRef,
-		CreatedAt:  time.Now(),
+func (s *InMemoryStore) ListProducts(ctx context.Context, filter ProductFilter, page Page) ([]*models.Product, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
 	}
+	s.settlePrices()
 
-	s.orders[id] = o
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	// Decrement stock
-	for _, it := range items {
-		if p, ok := s.products[it.ProductID]; ok {
-			if p.Stock >= it.Quantity {
-				p.Stock -= it.Quantity
-			}
+	hits, err := searchHits(ctx, s.search, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	res := []*models.Product{}
+	for _, p := range s.products {
+		if filter.matches(p, hits) {
+			res = append(res, cloneProduct(p))
 		}
 	}
+	if filter.Sort == SortNewest || filter.Sort == SortRelevance && hits == nil {
+		return pageSlice(res, page, productKey)
+	}
 
-	return o, nil
+	var sold map[string]int
+	if filter.Sort == SortBestSelling {
+		sold = s.unitsSold()
+	}
+	value := func(p *models.Product) float64 { return filter.Sort.value(p, sold, hits) }
+	return sortedPageSlice(res, page, filter.Sort.ascending(), value, productKey)
 }
 
-func (s *InMemoryStore) ListOrdersByUser(userID string) ([]*models.Order, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	res := []*models.Order{}
+// unitsSold totals order quantities per product over paid and completed
+// orders. Callers hold the lock.
+func (s *InMemoryStore) unitsSold() map[string]int {
+	sold := map[string]int{}
 	for _, o := range s.orders {
-		if o.UserID == userID {
-			copyO := *o
-			res = append(res, &copyO)
+		if !isSold(o.Status) {
+			continue
+		}
+		for _, it := range o.Items {
+			sold[it.ProductID] += it.Quantity
 		}
 	}
-	return res, nil
+	return sold
 }
 
-func (s *InMemoryStore) ListOrders() ([]*models.Order, error) {
+func (s *InMemoryStore) ProductFacets(ctx context.Context, filter ProductFilter, priceBounds []int64) (*ProductFacets, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.settlePrices()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	res := make([]*models.Order, 0, len(s.orders))
-	for _, o := range s.orders {
-		copyO := *o
-		res = append(res, &copyO)
+	hits, err := searchHits(ctx, s.search, filter)
+	if err != nil {
+		return nil, err
 	}
-	return res, nil
-}
-
-func (s *InMemoryStore) UpdateOrderStatus(orderID, status string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	byCategory, byPrice := filter, filter
+	byCategory.CategoryIDs = nil
+	byPrice.MinPriceCents, byPrice.MaxPriceCents = 0, 0
 
-	o, ok := s.orders[orderID]
-	if !ok {
-		return errors.New("order not found")
+	facets := &ProductFacets{Categories: map[string]int{}, PriceBuckets: make([]int, len(priceBounds))}
+	for _, p := range s.products {
+		if byCategory.matches(p, hits) {
+			facets.Categories[p.CategoryID]++
+		}
+		if byPrice.matches(p, hits) {
+			if i := priceBucket(priceBounds, p.PriceCents); i >= 0 {
+				facets.PriceBuckets[i]++
+			}
+		}
 	}
-
-	o.Status = status
-	return nil
+	return facets, nil
 }
 
-func (s *InMemoryStore) UpdateOrderPaymentRef(orderID, paymentRef string) error {
+func (s *InMemoryStore) ImportProducts(ctx context.Context, skus []string, dryRun bool, update func(i int, p *models.Product) error) ([]ImportedProduct, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkImportSKUs(skus); err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	o, ok := s.orders[orderID]
-	if !ok {
-		return errors.New("order not found")
+	now := time.Now()
+	s.applyPriceSchedules(now)
+	bySKU := make(map[string]*models.Product, len(s.products))
+	for _, p := range s.products {
+		bySKU[p.SKU] = p
+	}
+	// Rows are built on copies and only stored once all of them succeed
+	rows := make([]ImportedProduct, len(skus))
+	for i, sku := range skus {
+		row, err := importRow(i, sku, bySKU[sku], now, update)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = row
+	}
+	if dryRun {
+		return rows, nil
 	}
 
-	o.PaymentRef = paymentRef
-	return nil
+	actorID := actorFrom(ctx)
+	for i, row := range rows {
+		var before int64
+		var levels map[string]int
+		reason := models.StockRestock
+		if row.Created {
+			row.Product.ID = uuid.NewString()
+		} else {
+			stored := s.products[row.Product.ID]
+			before, levels, reason = stored.BasePrice(), stockLevels(stored), models.StockAdjustment
+		}
+		s.recordPrice(importPriceChange(row, before, actorID, now))
+		s.recordStock(stockChanges(row.Product.ID, levels, stockLevels(row.Product), reason, actorID, "", now)...)
+		s.products[row.Product.ID] = row.Product
+		s.search.Put(row.Product)
+		rows[i].Product = cloneProduct(row.Product)
+	}
+	return rows, nil
 }
 
-// Reviews
+// Prices
 
-func (s *InMemoryStore) CreateReview(userID, userName, userPhoto string, rating int, comment string) (*models.Review, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// settlePrices applies the price schedules that have come due. Read methods
+// call it before taking the read lock.
+func (s *InMemoryStore) settlePrices() {
+	now := time.Now()
+	s.mu.RLock()
+	due := !s.nextPriceAt.IsZero() && !s.nextPriceAt.After(now)
+	s.mu.RUnlock()
+	if due {
+		s.mu.Lock()
+		s.applyPriceSchedules(now)
+		s.mu.Unlock()
+	}
+}
+
+// applyPriceSchedules takes every schedule step due at now, earliest first.
+// Callers hold the write lock.
+func (s *InMemoryStore) applyPriceSchedules(now time.Time) {
+	if s.nextPriceAt.IsZero() || s.nextPriceAt.After(now) {
+		return
+	}
+	for {
+		var next *models.PriceSchedule
+		for _, sc := range s.priceSchedules {
+			if scheduleDue(sc, now) && (next == nil || scheduleBefore(sc, next)) {
+				next = sc
+			}
+		}
+		if next == nil {
+			break
+		}
+		p := s.products[next.ProductID]
+		s.recordPrice(stepPriceSchedule(p, next, now))
+		p.UpdatedAt = now
+	}
+	s.resetNextPriceAt()
+}
+
+// resetNextPriceAt recomputes nextPriceAt. Callers hold the write lock.
+func (s *InMemoryStore) resetNextPriceAt() {
+	s.nextPriceAt = time.Time{}
+	for _, sc := range s.priceSchedules {
+		if at := scheduleNextAt(sc); at != nil && (s.nextPriceAt.IsZero() || at.Before(s.nextPriceAt)) {
+			s.nextPriceAt = *at
+		}
+	}
+}
+
+// recordPrice appends c, if any, to the price history. Callers hold the
+// write lock.
+func (s *InMemoryStore) recordPrice(c *models.PriceChange) {
+	if c != nil {
+		s.priceHistory = append(s.priceHistory, c)
+	}
+}
+
+func (s *InMemoryStore) ListPriceHistory(ctx context.Context, productID string) ([]*models.PriceChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.settlePrices()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.products[productID]; !ok {
+		return nil, errors.New("product not found")
+	}
+	res := []*models.PriceChange{}
+	for _, c := range s.priceHistory {
+		if c.ProductID == productID {
+			cp := *c
+			res = append(res, &cp)
+		}
+	}
+	// Scheduled steps are dated when they fell due, which can be before
+	// changes recorded ahead of them
+	slices.SortStableFunc(res, func(a, b *models.PriceChange) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return res, nil
+}
+
+func (s *InMemoryStore) CreatePriceSchedule(ctx context.Context, sc *models.PriceSchedule) (*models.PriceSchedule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.applyPriceSchedules(now)
+	if _, ok := s.products[sc.ProductID]; !ok {
+		return nil, errors.New("product not found")
+	}
+	others := make([]*models.PriceSchedule, 0, len(s.priceSchedules))
+	for _, o := range s.priceSchedules {
+		others = append(others, o)
+	}
+	if salesOverlap(sc, others) {
+		return nil, ErrPriceScheduleOverlap
+	}
+
+	stored := clonePriceSchedule(sc)
+	stored.ID = uuid.NewString()
+	stored.Status = models.SchedulePending
+	stored.CreatedBy = actorFrom(ctx)
+	stored.CreatedAt = now
+	s.priceSchedules[stored.ID] = stored
+	s.resetNextPriceAt()
+	// One that starts now takes effect straight away
+	s.applyPriceSchedules(now)
+	return clonePriceSchedule(stored), nil
+}
+
+func (s *InMemoryStore) ListPriceSchedules(ctx context.Context, productID string) ([]*models.PriceSchedule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.settlePrices()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.products[productID]; !ok {
+		return nil, errors.New("product not found")
+	}
+	res := []*models.PriceSchedule{}
+	for _, sc := range s.priceSchedules {
+		if sc.ProductID == productID {
+			res = append(res, clonePriceSchedule(sc))
+		}
+	}
+	slices.SortFunc(res, comparePriceSchedules)
+	return res, nil
+}
+
+func (s *InMemoryStore) CancelPriceSchedule(ctx context.Context, productID, scheduleID string) (*models.PriceSchedule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.applyPriceSchedules(now)
+	sc, ok := s.priceSchedules[scheduleID]
+	if !ok || sc.ProductID != productID {
+		return nil, errors.New("price schedule not found")
+	}
+	switch sc.Status {
+	case models.SchedulePending:
+	case models.ScheduleActive:
+		p := s.products[productID]
+		s.recordPrice(endSale(p, sc, now, actorFrom(ctx)))
+		p.UpdatedAt = now
+		sc.EndsAt = &now
+	default:
+		return nil, ErrPriceScheduleDone
+	}
+	sc.Status = models.ScheduleCanceled
+	s.resetNextPriceAt()
+	return clonePriceSchedule(sc), nil
+}
+
+func clonePriceSchedule(sc *models.PriceSchedule) *models.PriceSchedule {
+	cp := *sc
+	if sc.EndsAt != nil {
+		end := *sc.EndsAt
+		cp.EndsAt = &end
+	}
+	return &cp
+}
+
+// Categories
+
+func (s *InMemoryStore) CreateCategory(ctx context.Context, c *models.Category) (*models.Category, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.ID = uuid.NewString()
+	if err := s.checkCategory(c); err != nil {
+		return nil, err
+	}
+	c.Children = nil
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
+	cp := *c
+	s.categories[c.ID] = &cp
+	return c, nil
+}
+
+func (s *InMemoryStore) UpdateCategory(ctx context.Context, id string, update func(c *models.Category) error) (*models.Category, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.categories[id]
+	if !ok {
+		return nil, errCategoryNotFound
+	}
+	c := *stored
+	if err := update(&c); err != nil {
+		return nil, err
+	}
+	c.ID = id
+	if err := s.checkCategory(&c); err != nil {
+		return nil, err
+	}
+	c.Children = nil
+	c.UpdatedAt = time.Now()
+
+	if c.Name != stored.Name {
+		for _, p := range s.products {
+			if p.CategoryID == id {
+				p.Category = c.Name
+				s.search.Put(p)
+			}
+		}
+	}
+	s.categories[id] = &c
+	res := c
+	return &res, nil
+}
+
+// checkCategory enforces unique slugs and a parent that exists and is not
+// c itself or one of its descendants. Callers hold the write lock.
+func (s *InMemoryStore) checkCategory(c *models.Category) error {
+	for _, other := range s.categories {
+		if other.ID != c.ID && other.Slug == c.Slug {
+			return ErrSlugTaken
+		}
+	}
+	return checkCategoryParent(s.categories, c.ID, c.ParentID)
+}
+
+func (s *InMemoryStore) DeleteCategory(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.categories[id]; !ok {
+		return errCategoryNotFound
+	}
+	for _, c := range s.categories {
+		if c.ParentID == id {
+			return ErrCategoryInUse
+		}
+	}
+	for _, p := range s.products {
+		if p.CategoryID == id {
+			return ErrCategoryInUse
+		}
+	}
+	delete(s.categories, id)
+	return nil
+}
+
+func (s *InMemoryStore) ListCategories(ctx context.Context) ([]*models.Category, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*models.Category, 0, len(s.categories))
+	for _, c := range s.categories {
+		cp := *c
+		res = append(res, &cp)
+	}
+	sortCategories(res)
+	return res, nil
+}
+
+// Variants
+
+func (s *InMemoryStore) CreateVariant(ctx context.Context, productID string, v *models.ProductVariant) (*models.ProductVariant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.products[productID]
+	if !ok {
+		return nil, errors.New("product not found")
+	}
+	if s.variantSKUTaken(v.SKU, "") {
+		return nil, ErrSKUTaken
+	}
+
+	v.ID = uuid.NewString()
+	v.ProductID = productID
+	if v.Options == nil {
+		v.Options = map[string]string{}
+	}
+	v.CreatedAt = time.Now()
+	v.UpdatedAt = v.CreatedAt
+
+	// Replace rather than append in place: copies handed out earlier may
+	// share the old backing array
+	p := cloneProduct(stored)
+	p.Variants = append(p.Variants, cloneVariant(*v))
+	rollupStock(p)
+	p.UpdatedAt = v.CreatedAt
+	s.products[productID] = p
+	s.recordStock(stockChanges(productID, stockLevels(stored), stockLevels(p), models.StockAdjustment, actorFrom(ctx), "", p.UpdatedAt)...)
+
+	res := cloneVariant(*v)
+	return &res, nil
+}
+
+func (s *InMemoryStore) UpdateVariant(ctx context.Context, productID, variantID string, update func(v *models.ProductVariant) error) (*models.ProductVariant, *models.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.products[productID]
+	if !ok {
+		return nil, nil, errors.New("product not found")
+	}
+	p := cloneProduct(stored)
+	v := p.Variant(variantID)
+	if v == nil {
+		return nil, nil, errVariantNotFound
+	}
+
+	if err := update(v); err != nil {
+		return nil, nil, err
+	}
+	if v.Stock < 0 {
+		return nil, nil, ErrInsufficientStock
+	}
+	if s.variantSKUTaken(v.SKU, variantID) {
+		return nil, nil, ErrSKUTaken
+	}
+	v.ID, v.ProductID = variantID, productID
+	v.UpdatedAt = time.Now()
+	rollupStock(p)
+	p.UpdatedAt = v.UpdatedAt
+	s.products[productID] = p
+	s.recordStock(stockChanges(productID, stockLevels(stored), stockLevels(p), models.StockAdjustment, actorFrom(ctx), "", p.UpdatedAt)...)
+
+	res := cloneVariant(*v)
+	return &res, cloneProduct(p), nil
+}
+
+func (s *InMemoryStore) DeleteVariant(ctx context.Context, productID, variantID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.products[productID]
+	if !ok {
+		return errors.New("product not found")
+	}
+	if stored.Variant(variantID) == nil {
+		return errVariantNotFound
+	}
+	for _, o := range s.orders {
+		for _, it := range o.Items {
+			if it.VariantID == variantID {
+				return ErrVariantInUse
+			}
+		}
+	}
+
+	for _, c := range s.carts {
+		c.Items = slices.DeleteFunc(c.Items, func(it models.CartItem) bool { return it.VariantID == variantID })
+	}
+	for _, c := range s.guestCarts {
+		c.Items = slices.DeleteFunc(c.Items, func(it models.CartItem) bool { return it.VariantID == variantID })
+	}
+	p := cloneProduct(stored)
+	p.Variants = slices.DeleteFunc(p.Variants, func(v models.ProductVariant) bool { return v.ID == variantID })
+	// With the last variant gone there is nothing left to sell
+	p.Stock = 0
+	rollupStock(p)
+	p.UpdatedAt = time.Now()
+	s.products[productID] = p
+	s.recordStock(stockChanges(productID, stockLevels(stored), stockLevels(p), models.StockAdjustment, actorFrom(ctx), "", p.UpdatedAt)...)
+	return nil
+}
+
+// Inventory
+
+// recordStock places ms on locations, moves the stock there and appends
+// them to the ledger. A location since deleted gives way to the default.
+// Callers hold the write lock.
+func (s *InMemoryStore) recordStock(ms ...*models.StockMovement) {
+	order := s.locationOrder(Allocation{})
+	for _, m := range ms {
+		for _, placed := range spreadStock(m, s.lineStock(m.ProductID, m.VariantID), order) {
+			if _, ok := s.locations[placed.LocationID]; !ok {
+				placed.LocationID = order[0]
+			}
+			k := stockKey{placed.LocationID, placed.ProductID, placed.VariantID}
+			if s.locationStock[k] += placed.Delta; s.locationStock[k] == 0 {
+				delete(s.locationStock, k)
+			}
+			s.stockLedger = append(s.stockLedger, placed)
+		}
+	}
+}
+
+// locationOrder returns the location IDs in the order a tries them.
+// Callers hold the lock.
+func (s *InMemoryStore) locationOrder(a Allocation) []string {
+	locs := make([]*models.Location, 0, len(s.locations))
+	for _, l := range s.locations {
+		locs = append(locs, l)
+	}
+	return allocationOrder(locs, a)
+}
+
+// lineStock returns the stock of a line per location. Callers hold the lock.
+func (s *InMemoryStore) lineStock(productID, variantID string) map[string]int {
+	levels := map[string]int{}
+	for k, n := range s.locationStock {
+		if k.product == productID && k.variant == variantID {
+			levels[k.location] = n
+		}
+	}
+	return levels
+}
+
+// allocateStock spreads the lines of o over locations following a, without
+// moving stock yet; o is left alone on failure. Callers hold the lock and
+// have checked the totals.
+func (s *InMemoryStore) allocateStock(o *models.Order, a Allocation) error {
+	allocs, err := allocateItems(o.Items, s.locationOrder(a), func(productID, variantID string) (map[string]int, error) {
+		return s.lineStock(productID, variantID), nil
+	})
+	if err != nil {
+		return err
+	}
+	// Copies of o handed out earlier share its items
+	items := slices.Clone(o.Items)
+	for i := range items {
+		items[i].Allocations = allocs[i]
+	}
+	o.Items = items
+	return nil
+}
+
+// stockLocation resolves a location ID given to AdjustStock or
+// TransferStock, "" meaning the default. Callers hold the lock.
+func (s *InMemoryStore) stockLocation(id string) (string, error) {
+	if id == "" {
+		return s.locationOrder(Allocation{})[0], nil
+	}
+	if _, ok := s.locations[id]; !ok {
+		return "", errLocationNotFound
+	}
+	return id, nil
+}
+
+func (s *InMemoryStore) AdjustStock(ctx context.Context, productID, variantID, locationID string, delta int, reason, note string) (*models.StockMovement, *models.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := checkStockAdjustment(delta, reason); err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.products[productID]
+	if !ok {
+		return nil, nil, errors.New("product not found")
+	}
+	p := cloneProduct(stored)
+	v, err := resolveVariant(p, variantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	locationID, err = s.stockLocation(locationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.locationStock[stockKey{locationID, productID, variantID}]+delta < 0 {
+		return nil, nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, productID)
+	}
+
+	now := time.Now()
+	if v != nil {
+		v.Stock += delta
+		v.UpdatedAt = now
+		rollupStock(p)
+	} else {
+		p.Stock += delta
+	}
+	p.UpdatedAt = now
+	s.products[productID] = p
+
+	m := newStockMovement(productID, variantID, locationID, delta, reason, "", actorFrom(ctx), note, now)
+	s.recordStock(m)
+	res := *m
+	return &res, cloneProduct(p), nil
+}
+
+func (s *InMemoryStore) ListStockMovements(ctx context.Context, productID string, page Page) ([]*models.StockMovement, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := []*models.StockMovement{}
+	for _, m := range s.stockLedger {
+		if m.ProductID == productID {
+			cp := *m
+			res = append(res, &cp)
+		}
+	}
+	return pageSlice(res, page, stockMovementKey)
+}
+
+func (s *InMemoryStore) ListLowStockProducts(ctx context.Context, defaultThreshold int) ([]*models.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := []*models.Product{}
+	for _, p := range s.products {
+		if p.ArchivedAt == nil && p.LowOnStock(defaultThreshold) {
+			res = append(res, cloneProduct(p))
+		}
+	}
+	slices.SortFunc(res, compareLowStock)
+	return res, nil
+}
+
+// Locations
+
+func (s *InMemoryStore) CreateLocation(ctx context.Context, l *models.Location) (*models.Location, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l.ID = uuid.NewString()
+	l.CreatedAt = time.Now()
+	l.UpdatedAt = l.CreatedAt
+	cp := *l
+	s.locations[l.ID] = &cp
+	return l, nil
+}
+
+func (s *InMemoryStore) UpdateLocation(ctx context.Context, id string, update func(l *models.Location) error) (*models.Location, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.locations[id]
+	if !ok {
+		return nil, errLocationNotFound
+	}
+	l := *stored
+	if err := update(&l); err != nil {
+		return nil, err
+	}
+	l.ID, l.CreatedAt = stored.ID, stored.CreatedAt
+	l.UpdatedAt = time.Now()
+	s.locations[id] = &l
+	res := l
+	return &res, nil
+}
+
+func (s *InMemoryStore) DeleteLocation(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.locations[id]; !ok {
+		return errLocationNotFound
+	}
+	if len(s.locations) == 1 {
+		return ErrLocationInUse
+	}
+	for k := range s.locationStock {
+		if k.location == id {
+			return ErrLocationInUse
+		}
+	}
+	delete(s.locations, id)
+	return nil
+}
+
+func (s *InMemoryStore) ListLocations(ctx context.Context) ([]*models.Location, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*models.Location, 0, len(s.locations))
+	for _, l := range s.locations {
+		cp := *l
+		res = append(res, &cp)
+	}
+	slices.SortFunc(res, compareLocations)
+	return res, nil
+}
+
+func (s *InMemoryStore) ListStockLevels(ctx context.Context, productID string) ([]*models.StockLevel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.products[productID]; !ok {
+		return nil, errors.New("product not found")
+	}
+	rank := map[string]int{}
+	for i, id := range s.locationOrder(Allocation{}) {
+		rank[id] = i
+	}
+	res := []*models.StockLevel{}
+	for k, n := range s.locationStock {
+		if k.product == productID {
+			res = append(res, &models.StockLevel{LocationID: k.location, ProductID: k.product, VariantID: k.variant, Stock: n})
+		}
+	}
+	slices.SortFunc(res, func(a, b *models.StockLevel) int {
+		if c := rank[a.LocationID] - rank[b.LocationID]; c != 0 {
+			return c
+		}
+		return strings.Compare(a.VariantID, b.VariantID)
+	})
+	return res, nil
+}
+
+func (s *InMemoryStore) TransferStock(ctx context.Context, productID, variantID, fromID, toID string, qty int, note string) ([]*models.StockMovement, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkStockTransfer(fromID, toID, qty); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.products[productID]
+	if !ok {
+		return nil, errors.New("product not found")
+	}
+	if _, err := resolveVariant(p, variantID); err != nil {
+		return nil, err
+	}
+	for _, id := range []string{fromID, toID} {
+		if _, ok := s.locations[id]; !ok {
+			return nil, errLocationNotFound
+		}
+	}
+	if s.locationStock[stockKey{fromID, productID, variantID}] < qty {
+		return nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, productID)
+	}
+
+	ms := transferMovements(productID, variantID, fromID, toID, qty, actorFrom(ctx), note, time.Now())
+	s.recordStock(ms...)
+	res := make([]*models.StockMovement, len(ms))
+	for i, m := range ms {
+		cp := *m
+		res[i] = &cp
+	}
+	return res, nil
+}
+
+// Back-in-stock subscriptions
+
+func (s *InMemoryStore) SubscribeStock(ctx context.Context, userID, productID, variantID string) (*models.StockSubscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.products[productID]
+	if !ok {
+		return nil, errors.New("product not found")
+	}
+	if err := checkStockSubscription(p, variantID); err != nil {
+		return nil, err
+	}
+	k := stockSubscriptionKey{userID, productID, variantID}
+	sub, ok := s.stockSubscriptions[k]
+	if !ok {
+		sub = &models.StockSubscription{UserID: userID, ProductID: productID, VariantID: variantID, CreatedAt: time.Now()}
+		s.stockSubscriptions[k] = sub
+	}
+	res := *sub
+	return &res, nil
+}
+
+func (s *InMemoryStore) UnsubscribeStock(ctx context.Context, userID, productID, variantID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.stockSubscriptions, stockSubscriptionKey{userID, productID, variantID})
+	return nil
+}
+
+func (s *InMemoryStore) ListRestockedSubscriptions(ctx context.Context, productID string) ([]*models.StockSubscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.products[productID]
+	if !ok {
+		return nil, errors.New("product not found")
+	}
+	res := []*models.StockSubscription{}
+	for k, sub := range s.stockSubscriptions {
+		if k.product == productID && lineInStock(p, k.variant) {
+			copySub := *sub
+			res = append(res, &copySub)
+		}
+	}
+	slices.SortFunc(res, func(a, b *models.StockSubscription) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return res, nil
+}
+
+// Carts
+
+func (s *InMemoryStore) GetOrCreateCart(ctx context.Context, userID string) *models.Cart {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[userID]
+	if !ok {
+		c = &models.Cart{UserID: userID, Items: []models.CartItem{}}
+		s.carts[userID] = c
+	}
+	return c
+}
+
+func (s *InMemoryStore) AddToCart(ctx context.Context, userID, productID, variantID string, qty int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[userID]
+	if !ok {
+		c = &models.Cart{UserID: userID, Items: []models.CartItem{}}
+		s.carts[userID] = c
+	}
+
+	// Validate product and stock
+	p, ok := s.products[productID]
+	if !ok {
+		return errors.New("product not found")
+	}
+	if p.ArchivedAt != nil {
+		return ErrProductArchived
+	}
+	if !p.Live(time.Now()) {
+		return ErrProductNotPublished
+	}
+	v, err := resolveVariant(p, variantID)
+	if err != nil {
+		return err
+	}
+
+	// Check current cart quantity
+	currentQty := 0
+	for i := range c.Items {
+		if c.Items[i].ProductID == productID && c.Items[i].VariantID == variantID {
+			currentQty = c.Items[i].Quantity
+			break
+		}
+	}
+
+	if stockFor(p, v) < (currentQty + qty) {
+		return errors.New("insufficient stock")
+	}
+
+	// Add or update
+	found := false
+	for i := range c.Items {
+		if c.Items[i].ProductID == productID && c.Items[i].VariantID == variantID {
+			c.Items[i].Quantity += qty
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		c.Items = append(c.Items, models.CartItem{ProductID: productID, VariantID: variantID, Quantity: qty})
+	}
+
+	return nil
+}
+
+func (s *InMemoryStore) RemoveFromCart(ctx context.Context, userID, productID, variantID string, qty int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[userID]
+	if !ok {
+		return errors.New("cart not found")
+	}
+
+	for i := range c.Items {
+		if c.Items[i].ProductID == productID && c.Items[i].VariantID == variantID {
+			if c.Items[i].Quantity <= qty {
+				// Remove item
+				c.Items = append(c.Items[:i], c.Items[i+1:]...)
+				return nil
+			}
+			c.Items[i].Quantity -= qty
+			return nil
+		}
+	}
+
+	return errors.New("item not in cart")
+}
+
+func (s *InMemoryStore) ClearCart(ctx context.Context, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.carts, userID)
+}
+
+func (s *InMemoryStore) GetCart(ctx context.Context, userID string) (*models.Cart, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.carts[userID]
+	if !ok {
+		return &models.Cart{UserID: userID, Items: []models.CartItem{}}, nil
+	}
+
+	copyItems := make([]models.CartItem, len(c.Items))
+	copy(copyItems, c.Items)
+	return &models.Cart{UserID: userID, Items: copyItems}, nil
+}
+
+func (s *InMemoryStore) SetCartQuantity(ctx context.Context, userID, productID, variantID string, qty int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if qty < 0 {
+		return errors.New("quantity must not be negative")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if qty > 0 {
+		p, ok := s.products[productID]
+		if !ok {
+			return errors.New("product not found")
+		}
+		if err := checkCartLine(p, variantID, qty, time.Now()); err != nil {
+			return err
+		}
+	}
+	c, ok := s.carts[userID]
+	if !ok {
+		if qty == 0 {
+			return nil
+		}
+		c = &models.Cart{UserID: userID, Items: []models.CartItem{}}
+		s.carts[userID] = c
+	}
+	c.Items = setCartQuantity(c.Items, productID, variantID, qty)
+	return nil
+}
+
+// Guest carts
+
+func (s *InMemoryStore) AddToGuestCart(ctx context.Context, guestID, productID, variantID string, qty int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.products[productID]
+	if !ok {
+		return errors.New("product not found")
+	}
+	now := time.Now()
+	stock, err := cartLineStock(p, variantID, now)
+	if err != nil {
+		return err
+	}
+	c, ok := s.guestCarts[guestID]
+	if !ok {
+		c = &models.GuestCart{ID: guestID, Items: []models.CartItem{}}
+	}
+	cur := cartQuantity(c.Items, productID, variantID)
+	if stock < cur+qty {
+		return errors.New("insufficient stock")
+	}
+	c.Items = setCartQuantity(c.Items, productID, variantID, cur+qty)
+	c.UpdatedAt = now
+	s.guestCarts[guestID] = c
+	return nil
+}
+
+func (s *InMemoryStore) RemoveFromGuestCart(ctx context.Context, guestID, productID, variantID string, qty int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.guestCarts[guestID]
+	if !ok {
+		return errNotInCart
+	}
+	cur := cartQuantity(c.Items, productID, variantID)
+	if cur == 0 {
+		return errNotInCart
+	}
+	c.Items = setCartQuantity(c.Items, productID, variantID, cur-qty)
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *InMemoryStore) SetGuestCartQuantity(ctx context.Context, guestID, productID, variantID string, qty int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if qty < 0 {
+		return errors.New("quantity must not be negative")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if qty > 0 {
+		p, ok := s.products[productID]
+		if !ok {
+			return errors.New("product not found")
+		}
+		if err := checkCartLine(p, variantID, qty, now); err != nil {
+			return err
+		}
+	}
+	c, ok := s.guestCarts[guestID]
+	if !ok {
+		if qty == 0 {
+			return nil
+		}
+		c = &models.GuestCart{ID: guestID, Items: []models.CartItem{}}
+		s.guestCarts[guestID] = c
+	}
+	c.Items = setCartQuantity(c.Items, productID, variantID, qty)
+	c.UpdatedAt = now
+	return nil
+}
+
+func (s *InMemoryStore) GetGuestCart(ctx context.Context, guestID string) (*models.GuestCart, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.guestCarts[guestID]
+	if !ok {
+		return &models.GuestCart{ID: guestID, Items: []models.CartItem{}}, nil
+	}
+	return &models.GuestCart{ID: c.ID, Items: slices.Clone(c.Items), UpdatedAt: c.UpdatedAt}, nil
+}
+
+func (s *InMemoryStore) MergeGuestCart(ctx context.Context, guestID, userID string) (*models.Cart, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[userID]
+	if !ok {
+		c = &models.Cart{UserID: userID, Items: []models.CartItem{}}
+	}
+	if guest, ok := s.guestCarts[guestID]; ok {
+		c.Items = mergeCartItems(c.Items, guest.Items, func(id string) *models.Product { return s.products[id] }, time.Now())
+		delete(s.guestCarts, guestID)
+		if len(c.Items) > 0 {
+			s.carts[userID] = c
+		}
+	}
+	return &models.Cart{UserID: userID, Items: slices.Clone(c.Items)}, nil
+}
+
+func (s *InMemoryStore) ExpireGuestCarts(ctx context.Context, idleSince time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for id, c := range s.guestCarts {
+		if c.UpdatedAt.Before(idleSince) {
+			delete(s.guestCarts, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Orders
+
+func (s *InMemoryStore) CreateOrder(ctx context.Context, userID string, items []models.CartItem, amount int64, status, paymentRef string) (*models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate every line before touching stock so a failure leaves nothing
+	// half-applied, matching the SQL backends' transaction.
+	if err := s.checkStock(items); err != nil {
+		return nil, err
+	}
+
+	id := uuid.NewString()
+	now := time.Now()
+	o := &models.Order{
+		ID:          id,
+		UserID:      userID,
+		Items:       make([]models.CartItem, len(items)),
+		Amount:      amount,
+		Status:      status,
+		PaymentRef:  paymentRef,
+		Reservation: orderReservation(status),
+		ReservedAt:  now,
+		CreatedAt:   now,
+	}
+	for i, it := range items {
+		p := s.products[it.ProductID]
+		it.PriceCents = p.PriceFor(p.Variant(it.VariantID))
+		o.Items[i] = it
+	}
+
+	if o.Reservation != models.ReservationReleased {
+		if err := s.allocateStock(o, allocationFrom(ctx)); err != nil {
+			return nil, err
+		}
+		s.moveStock(o, -1, stockActor(ctx, userID), now)
+	}
+	s.orders[id] = o
+
+	return o, nil
+}
+
+// checkStock reports whether every line of items, counted together with
+// the other lines for the same product and variant, is in stock. Callers
+// hold the lock.
+func (s *InMemoryStore) checkStock(items []models.CartItem) error {
+	need := map[string]int{}
+	for _, it := range items {
+		p, ok := s.products[it.ProductID]
+		if !ok {
+			return errors.New("product not found")
+		}
+		v, err := resolveVariant(p, it.VariantID)
+		if err != nil {
+			return err
+		}
+		key := lineKey(it.ProductID, it.VariantID)
+		need[key] += it.Quantity
+		if stockFor(p, v) < need[key] {
+			return fmt.Errorf("%w for product: %s", ErrInsufficientStock, it.ProductID)
+		}
+	}
+	return nil
+}
+
+// moveStock takes the units of o out of stock with sign -1 and puts them
+// back with 1, recording the movements against o's current reservation.
+// Lines taken must already be checked; products are replaced rather than
+// edited so copies handed out earlier stay unchanged. Callers hold the
+// write lock.
+func (s *InMemoryStore) moveStock(o *models.Order, sign int, actorID string, at time.Time) {
+	for _, it := range o.Items {
+		p, ok := s.products[it.ProductID]
+		if !ok {
+			continue
+		}
+		p = cloneProduct(p)
+		if v := p.Variant(it.VariantID); v != nil {
+			v.Stock += sign * it.Quantity
+			rollupStock(p)
+		} else {
+			p.Stock += sign * it.Quantity
+		}
+		s.products[p.ID] = p
+	}
+	s.recordStock(orderStockMovements(o, sign, o.Reservation, actorID, at)...)
+}
+
+func (s *InMemoryStore) CheckoutCart(ctx context.Context, userID string) (*models.Order, map[string]*models.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// No cart edit or other checkout can interleave under the write lock,
+	// the same guarantee the SQL backends get from row locks
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.applyPriceSchedules(time.Now())
+	c, ok := s.carts[userID]
+	if !ok || len(c.Items) == 0 {
+		return nil, nil, ErrCartEmpty
+	}
+
+	now := time.Now()
+	o := &models.Order{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Items:       make([]models.CartItem, 0, len(c.Items)),
+		Status:      "pending",
+		Reservation: models.ReservationHeld,
+		ReservedAt:  now,
+		CreatedAt:   now,
+	}
+	for _, it := range c.Items {
+		p, ok := s.products[it.ProductID]
+		if !ok {
+			return nil, nil, errors.New("product not found")
+		}
+		if p.ArchivedAt != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrProductArchived, it.ProductID)
+		}
+		if !p.Live(o.CreatedAt) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrProductNotPublished, it.ProductID)
+		}
+		v, err := resolveVariant(p, it.VariantID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stockFor(p, v) < it.Quantity {
+			return nil, nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, it.ProductID)
+		}
+		it.PriceCents = p.PriceFor(v)
+		o.Items = append(o.Items, it)
+		o.Amount += int64(it.Quantity) * it.PriceCents
+	}
+
+	if err := s.allocateStock(o, allocationFrom(ctx)); err != nil {
+		return nil, nil, err
+	}
+
+	s.moveStock(o, -1, stockActor(ctx, userID), now)
+	s.orders[o.ID] = o
+	delete(s.carts, userID)
+
+	// Copied after moveStock, with the stock the order left
+	products := map[string]*models.Product{}
+	for _, it := range o.Items {
+		products[it.ProductID] = cloneProduct(s.products[it.ProductID])
+	}
+
+	copyO := *o
+	return &copyO, products, nil
+}
+
+func (s *InMemoryStore) ListOrdersByUser(ctx context.Context, userID string, page Page) ([]*models.Order, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := []*models.Order{}
+	for _, o := range s.orders {
+		if o.UserID == userID {
+			copyO := *o
+			res = append(res, &copyO)
+		}
+	}
+	return pageSlice(res, page, orderKey)
+}
+
+func (s *InMemoryStore) ListOrders(ctx context.Context, page Page) ([]*models.Order, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*models.Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		copyO := *o
+		res = append(res, &copyO)
+	}
+	return pageSlice(res, page, orderKey)
+}
+
+func (s *InMemoryStore) UpdateOrderStatus(ctx context.Context, orderID, status string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderID]
+	if !ok {
+		return errors.New("order not found")
+	}
+
+	now := time.Now()
+	reservation := orderReservation(status)
+	switch sign := reservationMove(o.Reservation, reservation); sign {
+	case -1:
+		if err := s.checkStock(o.Items); err != nil {
+			return err
+		}
+		if err := s.allocateStock(o, allocationFrom(ctx)); err != nil {
+			return err
+		}
+		s.moveStock(o, sign, actorFrom(ctx), now)
+		o.ReservedAt = now
+	case 1:
+		s.moveStock(o, sign, actorFrom(ctx), now)
+	}
+	o.Status, o.Reservation = status, reservation
+	return nil
+}
+
+func (s *InMemoryStore) ExpireReservations(ctx context.Context, heldBefore time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	now := time.Now()
+	for _, o := range s.orders {
+		if o.Reservation != models.ReservationHeld || !o.ReservedAt.Before(heldBefore) {
+			continue
+		}
+		s.moveStock(o, 1, "", now)
+		o.Status, o.Reservation = "expired", models.ReservationReleased
+		n++
+	}
+	return n, nil
+}
+
+func (s *InMemoryStore) UpdateOrderPaymentRef(ctx context.Context, orderID, paymentRef string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderID]
+	if !ok {
+		return errors.New("order not found")
+	}
+
+	o.PaymentRef = paymentRef
+	return nil
+}
+
+func (s *InMemoryStore) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.orders[orderID]
+	if !ok {
+		return nil, errors.New("order not found")
+	}
+	copyO := *o
+	return &copyO, nil
+}
+
+// Reviews
+
+func (s *InMemoryStore) CreateReview(ctx context.Context, userID, userName, userPhoto string, rating int, comment string) (*models.Review, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	if rating < 1 || rating > 5 {
 		return nil, errors.New("rating must be between 1 and 5")
@@ -520,7 +2094,11 @@ func (s *InMemoryStore) CreateReview(userID, userName, userPhoto string, rating
 	return r, nil
 }
 
-func (s *InMemoryStore) ListReviews(limit int) ([]*models.Review, error) {
+func (s *InMemoryStore) ListReviews(ctx context.Context, page Page) ([]*models.Review, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -529,24 +2107,14 @@ func (s *InMemoryStore) ListReviews(limit int) ([]*models.Review, error) {
 		cp := *r
 		res = append(res, &cp)
 	}
+	return pageSlice(res, page, reviewKey)
+}
 
-	// Sort by created date descending
-	for i := 0; i < len(res)-1; i++ {
-		for j := i + 1; j < len(res); j++ {
-			if res[i].CreatedAt.Before(res[j].CreatedAt) {
-				res[i], res[j] = res[j], res[i]
-			}
-		}
-	}
-
-	if limit > 0 && len(res) > limit {
-		res = res[:limit]
+func (s *InMemoryStore) GetUserReviewCount(ctx context.Context, userID string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
 	}
 
-	return res, nil
-}
-
-func (s *InMemoryStore) GetUserReviewCount(userID string) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -559,6 +2127,53 @@ func (s *InMemoryStore) GetUserReviewCount(userID string) (int, error) {
 	return count, nil
 }
 
+// Audit log
+
+func (s *InMemoryStore) CreateAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.ID = uuid.NewString()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	copyE := *e
+	s.auditLog = append(s.auditLog, &copyE)
+	return nil
+}
+
+func (s *InMemoryStore) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*models.AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// auditLog is append-only, so walking it backwards yields newest first
+	res := []*models.AuditEntry{}
+	for i := len(s.auditLog) - 1; i >= 0; i-- {
+		e := s.auditLog[i]
+		if filter.ActorID != "" && e.ActorID != filter.ActorID ||
+			filter.EntityType != "" && e.EntityType != filter.EntityType ||
+			filter.EntityID != "" && e.EntityID != filter.EntityID ||
+			!filter.From.IsZero() && e.CreatedAt.Before(filter.From) ||
+			!filter.To.IsZero() && !e.CreatedAt.Before(filter.To) {
+			continue
+		}
+		copyE := *e
+		res = append(res, &copyE)
+		if filter.Limit > 0 && len(res) == filter.Limit {
+			break
+		}
+	}
+	return res, nil
+}
+
 // Utils
 
 func containsFold(s, substr string) bool {
@@ -0,0 +1,422 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/search"
+)
+
+// snapshotVersion is written to every snapshot. Fields may be added freely
+// since older files simply leave them empty; bump the version only when an
+// older file could no longer be read into the current layout.
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version            int                         `json:"version"`
+	SavedAt            time.Time                   `json:"saved_at"`
+	Users              []snapshotUser              `json:"users"`
+	EmailVerifications []*models.EmailVerification `json:"email_verifications"`
+	PasswordResets     []*models.PasswordReset     `json:"password_resets"`
+	Products           []*models.Product           `json:"products"`
+	Categories         []*models.Category          `json:"categories"` // nil in snapshots older than categories
+	Carts              []*models.Cart              `json:"carts"`
+	GuestCarts         []*models.GuestCart         `json:"guest_carts"`
+	Orders             []*models.Order             `json:"orders"`
+	Reviews            []*models.Review            `json:"reviews"`
+	AuditLog           []*models.AuditEntry        `json:"audit_log"`
+	PriceHistory       []*models.PriceChange       `json:"price_history"`
+	PriceSchedules     []*models.PriceSchedule     `json:"price_schedules"`
+	StockLedger        []*models.StockMovement     `json:"stock_ledger"` // nil in snapshots older than the ledger
+	Locations          []*models.Location          `json:"locations"`    // nil in snapshots older than locations
+	StockLevels        []*models.StockLevel        `json:"stock_levels"`
+	StockSubscriptions []*models.StockSubscription `json:"stock_subscriptions"`
+	ArchivedStatus     map[string]string           `json:"archived_status"` // status to restore archived products to, by ID
+}
+
+// snapshotUser keeps the password hash, which models.User hides from JSON
+type snapshotUser struct {
+	models.User
+	PasswordHash string `json:"password_hash"`
+}
+
+// SaveSnapshot writes the whole store to path as JSON. The file is written
+// to a temporary file in the same directory and renamed into place, so a
+// crash mid-write never leaves a truncated snapshot behind.
+func (s *InMemoryStore) SaveSnapshot(path string) error {
+	s.mu.RLock()
+	snap := snapshot{Version: snapshotVersion, SavedAt: time.Now()}
+	for _, u := range s.users {
+		snap.Users = append(snap.Users, snapshotUser{User: *u, PasswordHash: u.Password})
+	}
+	for _, v := range s.emailVerifications {
+		snap.EmailVerifications = append(snap.EmailVerifications, v)
+	}
+	for _, r := range s.passwordResets {
+		snap.PasswordResets = append(snap.PasswordResets, r)
+	}
+	for _, p := range s.products {
+		snap.Products = append(snap.Products, p)
+	}
+	snap.Categories = []*models.Category{}
+	for _, c := range s.categories {
+		snap.Categories = append(snap.Categories, c)
+	}
+	for _, c := range s.carts {
+		snap.Carts = append(snap.Carts, c)
+	}
+	for _, c := range s.guestCarts {
+		snap.GuestCarts = append(snap.GuestCarts, c)
+	}
+	for _, o := range s.orders {
+		snap.Orders = append(snap.Orders, o)
+	}
+	for _, r := range s.reviews {
+		snap.Reviews = append(snap.Reviews, r)
+	}
+	snap.AuditLog = s.auditLog
+	snap.PriceHistory = s.priceHistory
+	snap.StockLedger = s.stockLedger
+	if snap.StockLedger == nil {
+		snap.StockLedger = []*models.StockMovement{}
+	}
+	for _, sc := range s.priceSchedules {
+		snap.PriceSchedules = append(snap.PriceSchedules, sc)
+	}
+	for _, l := range s.locations {
+		snap.Locations = append(snap.Locations, l)
+	}
+	for _, sub := range s.stockSubscriptions {
+		snap.StockSubscriptions = append(snap.StockSubscriptions, sub)
+	}
+	snap.ArchivedStatus = s.archivedStatus
+	snap.StockLevels = []*models.StockLevel{}
+	for k, n := range s.locationStock {
+		snap.StockLevels = append(snap.StockLevels, &models.StockLevel{LocationID: k.location, ProductID: k.product, VariantID: k.variant, Stock: n})
+	}
+	sortSnapshot(&snap)
+	data, err := json.MarshalIndent(snap, "", "  ")
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot replaces the store's contents with the snapshot at path. A
+// missing file is not an error; the store is left as it is.
+func (s *InMemoryStore) LoadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("read snapshot %s: %w", path, err)
+	}
+	if snap.Version < 1 || snap.Version > snapshotVersion {
+		return fmt.Errorf("snapshot %s has version %d, this build reads up to %d", path, snap.Version, snapshotVersion)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = make(map[string]*models.User, len(snap.Users))
+	s.byEmail = make(map[string]*models.User, len(snap.Users))
+	for _, su := range snap.Users {
+		u := su.User
+		u.Password = su.PasswordHash
+		s.users[u.ID] = &u
+		s.byEmail[u.Email] = &u
+	}
+	s.emailVerifications = make(map[string]*models.EmailVerification, len(snap.EmailVerifications))
+	for _, v := range snap.EmailVerifications {
+		s.emailVerifications[v.Token] = v
+	}
+	s.passwordResets = make(map[string]*models.PasswordReset, len(snap.PasswordResets))
+	for _, r := range snap.PasswordResets {
+		s.passwordResets[r.Token] = r
+	}
+	s.products = make(map[string]*models.Product, len(snap.Products))
+	for _, p := range snap.Products {
+		if p.Images == nil {
+			p.Images = []models.ProductImage{}
+		}
+		// Snapshots written before variants existed have neither field
+		if p.Options == nil {
+			p.Options = []models.ProductOption{}
+		}
+		if p.Variants == nil {
+			p.Variants = []models.ProductVariant{}
+		}
+		// nor, before product statuses, a status
+		if p.Status == "" {
+			p.Status = models.ProductPublished
+			if p.ArchivedAt != nil {
+				p.Status = models.ProductArchived
+			}
+		}
+		s.products[p.ID] = p
+	}
+	s.categories = make(map[string]*models.Category, len(snap.Categories))
+	for _, c := range snap.Categories {
+		s.categories[c.ID] = c
+	}
+	if snap.Categories == nil {
+		s.backfillCategories()
+	}
+	s.dedupeSKUs()
+	s.search = search.NewMemoryIndex()
+	for _, p := range s.products {
+		s.search.Put(p)
+	}
+	s.carts = make(map[string]*models.Cart, len(snap.Carts))
+	for _, c := range snap.Carts {
+		s.carts[c.UserID] = c
+	}
+	s.guestCarts = make(map[string]*models.GuestCart, len(snap.GuestCarts))
+	for _, c := range snap.GuestCarts {
+		s.guestCarts[c.ID] = c
+	}
+	s.orders = make(map[string]*models.Order, len(snap.Orders))
+	for _, o := range snap.Orders {
+		// Orders from before reservations count as held since they were
+		// placed; failed ones as released, though their stock never came back
+		if o.Reservation == "" {
+			o.Reservation, o.ReservedAt = orderReservation(o.Status), o.CreatedAt
+		}
+		s.orders[o.ID] = o
+	}
+	s.reviews = make(map[string]*models.Review, len(snap.Reviews))
+	for _, r := range snap.Reviews {
+		s.reviews[r.ID] = r
+	}
+	s.auditLog = snap.AuditLog
+	s.priceHistory = snap.PriceHistory
+	s.priceSchedules = make(map[string]*models.PriceSchedule, len(snap.PriceSchedules))
+	for _, sc := range snap.PriceSchedules {
+		s.priceSchedules[sc.ID] = sc
+	}
+	s.resetNextPriceAt()
+	s.stockLedger = snap.StockLedger
+	if snap.StockLedger == nil {
+		// Stock from before the ledger is opened at what it is now
+		now := time.Now()
+		for _, p := range s.products {
+			s.stockLedger = append(s.stockLedger, openingBalance(p, now)...)
+		}
+	}
+	s.locations = make(map[string]*models.Location, len(snap.Locations))
+	for _, l := range snap.Locations {
+		s.locations[l.ID] = l
+	}
+	s.locationStock = make(map[stockKey]int, len(snap.StockLevels))
+	for _, l := range snap.StockLevels {
+		s.locationStock[stockKey{l.LocationID, l.ProductID, l.VariantID}] = l.Stock
+	}
+	if len(s.locations) == 0 {
+		s.backfillLocations()
+	}
+	s.archivedStatus = snap.ArchivedStatus
+	if s.archivedStatus == nil {
+		s.archivedStatus = make(map[string]string)
+	}
+	s.stockSubscriptions = make(map[stockSubscriptionKey]*models.StockSubscription, len(snap.StockSubscriptions))
+	for _, sub := range snap.StockSubscriptions {
+		s.stockSubscriptions[stockSubscriptionKey{sub.UserID, sub.ProductID, sub.VariantID}] = sub
+	}
+	return nil
+}
+
+// StartSnapshots saves the store to path every interval until the returned
+// stop function is called. stop waits for any save in progress, writes one
+// final snapshot and returns its error.
+func (s *InMemoryStore) StartSnapshots(path string, interval time.Duration) (stop func() error) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.SaveSnapshot(path); err != nil {
+					log.Printf("⚠️  Failed to save memory snapshot: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() error {
+		err := errors.New("snapshots already stopped")
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			err = s.SaveSnapshot(path)
+		})
+		return err
+	}
+}
+
+// sortSnapshot orders every list by ID so repeated saves of the same data
+// produce identical files
+func sortSnapshot(snap *snapshot) {
+	sort.Slice(snap.Users, func(i, j int) bool { return snap.Users[i].ID < snap.Users[j].ID })
+	sort.Slice(snap.EmailVerifications, func(i, j int) bool {
+		return snap.EmailVerifications[i].Token < snap.EmailVerifications[j].Token
+	})
+	sort.Slice(snap.PasswordResets, func(i, j int) bool {
+		return snap.PasswordResets[i].Token < snap.PasswordResets[j].Token
+	})
+	sort.Slice(snap.Products, func(i, j int) bool { return snap.Products[i].ID < snap.Products[j].ID })
+	sort.Slice(snap.Categories, func(i, j int) bool { return snap.Categories[i].ID < snap.Categories[j].ID })
+	sort.Slice(snap.Carts, func(i, j int) bool { return snap.Carts[i].UserID < snap.Carts[j].UserID })
+	sort.Slice(snap.GuestCarts, func(i, j int) bool { return snap.GuestCarts[i].ID < snap.GuestCarts[j].ID })
+	sort.Slice(snap.Orders, func(i, j int) bool { return snap.Orders[i].ID < snap.Orders[j].ID })
+	sort.Slice(snap.Reviews, func(i, j int) bool { return snap.Reviews[i].ID < snap.Reviews[j].ID })
+	sort.Slice(snap.PriceSchedules, func(i, j int) bool { return snap.PriceSchedules[i].ID < snap.PriceSchedules[j].ID })
+	sort.Slice(snap.StockSubscriptions, func(i, j int) bool {
+		a, b := snap.StockSubscriptions[i], snap.StockSubscriptions[j]
+		if a.ProductID != b.ProductID {
+			return a.ProductID < b.ProductID
+		}
+		if a.VariantID != b.VariantID {
+			return a.VariantID < b.VariantID
+		}
+		return a.UserID < b.UserID
+	})
+	sort.Slice(snap.Locations, func(i, j int) bool { return snap.Locations[i].ID < snap.Locations[j].ID })
+	sort.Slice(snap.StockLevels, func(i, j int) bool {
+		a, b := snap.StockLevels[i], snap.StockLevels[j]
+		if a.LocationID != b.LocationID {
+			return a.LocationID < b.LocationID
+		}
+		if a.ProductID != b.ProductID {
+			return a.ProductID < b.ProductID
+		}
+		return a.VariantID < b.VariantID
+	})
+}
+
+// backfillCategories turns the free-text categories of a snapshot written
+// before categories existed into category rows, as the SQL migration does.
+// Callers hold the write lock.
+func (s *InMemoryStore) backfillCategories() {
+	names := []string{}
+	for _, p := range s.products {
+		if p.CategoryID == "" && !slices.Contains(names, p.Category) {
+			names = append(names, p.Category)
+		}
+	}
+	sort.Strings(names)
+
+	cats, byName := categoriesFromNames(names, time.Now())
+	for _, c := range cats {
+		s.categories[c.ID] = c
+	}
+	for _, p := range s.products {
+		if c, ok := byName[p.Category]; ok && p.CategoryID == "" {
+			p.CategoryID, p.Category = c.ID, c.Name
+		}
+	}
+}
+
+// backfillLocations keeps all stock of a snapshot written before locations
+// at a default location, where the ledger says it has always been
+func (s *InMemoryStore) backfillLocations() {
+	l := newDefaultLocation(time.Now())
+	s.locations[l.ID] = l
+	for _, p := range s.products {
+		for variantID, n := range stockLevels(p) {
+			if n != 0 {
+				s.locationStock[stockKey{l.ID, p.ID, variantID}] = n
+			}
+		}
+	}
+	for _, m := range s.stockLedger {
+		if m.LocationID == "" {
+			m.LocationID = l.ID
+		}
+	}
+}
+
+// dedupeSKUs renames products and variants sharing a SKU with an older one,
+// as the SQL migration to unique SKUs does. Callers hold the write lock.
+func (s *InMemoryStore) dedupeSKUs() {
+	products := make([]*models.Product, 0, len(s.products))
+	for _, p := range s.products {
+		products = append(products, p)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		ti, idi := productKey(products[i])
+		tj, idj := productKey(products[j])
+		return ti.Before(tj) || ti.Equal(tj) && idi < idj
+	})
+
+	productSKUs, variantSKUs := map[string]bool{}, map[string]bool{}
+	for _, p := range products {
+		for _, v := range p.Variants {
+			variantSKUs[v.SKU] = true
+		}
+		productSKUs[p.SKU] = true
+	}
+	seenProducts, seenVariants := map[string]bool{}, map[string]bool{}
+	taken := func(used map[string]bool) func(string) (bool, error) {
+		return func(sku string) (bool, error) { return used[sku], nil }
+	}
+	for _, p := range products {
+		if seenProducts[p.SKU] {
+			p.SKU, _ = freeSKU(p.SKU, taken(productSKUs))
+			productSKUs[p.SKU] = true
+		}
+		seenProducts[p.SKU] = true
+		for i := range p.Variants {
+			v := &p.Variants[i]
+			if seenVariants[v.SKU] {
+				v.SKU, _ = freeSKU(v.SKU, taken(variantSKUs))
+				variantSKUs[v.SKU] = true
+			}
+			seenVariants[v.SKU] = true
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package store_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+func TestInMemorySnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	src := store.NewInMemoryStore()
+	u, err := src.CreateUser(ctx, "Demo", "0812", "demo@example.com", "secret-hash", "", "", "", true)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	p, err := src.CreateProduct(ctx, &models.Product{
+		Name:       "Demo Coffee",
+		PriceCents: 25000,
+		SKU:        "DEMO-1",
+		Stock:      10,
+		Images:     []models.ProductImage{{URL: "https://example.com/a.png", Alt: "A"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	if err := src.AddToCart(ctx, u.ID, p.ID, "", 2); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+	o, err := src.CreateOrder(ctx, u.ID, []models.CartItem{{ProductID: p.ID, Quantity: 3}}, 75000, "paid", "PAY-1")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if err := src.CreatePasswordReset(ctx, u.ID, "reset-token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("CreatePasswordReset: %v", err)
+	}
+	if err := src.CreateAuditEntry(ctx, &models.AuditEntry{ActorID: u.ID, Action: "product.create", EntityType: "product", EntityID: p.ID}); err != nil {
+		t.Fatalf("CreateAuditEntry: %v", err)
+	}
+	start := time.Now().Add(time.Hour)
+	sc, err := src.CreatePriceSchedule(ctx, &models.PriceSchedule{ProductID: p.ID, PriceCents: 20000, StartsAt: start})
+	if err != nil {
+		t.Fatalf("CreatePriceSchedule: %v", err)
+	}
+
+	if err := src.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	if leftovers, _ := filepath.Glob(path + ".*.tmp"); len(leftovers) != 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+
+	dst := store.NewInMemoryStore()
+	if err := dst.LoadSnapshot(path); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+
+	gotU, err := dst.GetUserByEmail(ctx, "demo@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if gotU.ID != u.ID || gotU.Password != "secret-hash" || !gotU.EmailVerified {
+		t.Fatalf("restored user = %+v", gotU)
+	}
+	gotP, err := dst.GetProduct(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if gotP.Stock != 7 || len(gotP.Images) != 1 || gotP.Images[0].Alt != "A" {
+		t.Fatalf("restored product = %+v", gotP)
+	}
+	if c, _ := dst.GetCart(ctx, u.ID); len(c.Items) != 1 || c.Items[0].Quantity != 2 {
+		t.Fatalf("restored cart = %+v", c)
+	}
+	orders, _, _ := dst.ListOrdersByUser(ctx, u.ID, store.Page{})
+	if len(orders) != 1 || orders[0].ID != o.ID || orders[0].PaymentRef != "PAY-1" {
+		t.Fatalf("restored orders = %+v", orders)
+	}
+	if _, err := dst.GetPasswordReset(ctx, "reset-token"); err != nil {
+		t.Fatalf("GetPasswordReset: %v", err)
+	}
+	if log, _ := dst.ListAuditEntries(ctx, store.AuditFilter{}); len(log) != 1 || log[0].EntityID != p.ID {
+		t.Fatalf("restored audit log = %+v", log)
+	}
+	if got, _ := dst.ListPriceSchedules(ctx, p.ID); len(got) != 1 || got[0].ID != sc.ID || got[0].Status != models.SchedulePending {
+		t.Fatalf("restored price schedules = %+v", got)
+	}
+	if got, _ := dst.ListPriceHistory(ctx, p.ID); len(got) != 1 || got[0].Reason != models.PriceCreated {
+		t.Fatalf("restored price history = %+v", got)
+	}
+	locs, _ := src.ListLocations(ctx)
+	if got, _ := dst.ListStockLevels(ctx, p.ID); len(got) != 1 || got[0].LocationID != locs[0].ID || got[0].Stock != 7 {
+		t.Fatalf("restored stock levels = %+v", got)
+	}
+}
+
+func TestInMemorySnapshotMissingFile(t *testing.T) {
+	st := store.NewInMemoryStore()
+	if err := st.LoadSnapshot(filepath.Join(t.TempDir(), "absent.json")); err != nil {
+		t.Fatalf("LoadSnapshot of a missing file: %v", err)
+	}
+}
+
+func TestInMemorySnapshotVersions(t *testing.T) {
+	dir := t.TempDir()
+
+	// A file from an older build lacks fields added since; it still loads.
+	old := filepath.Join(dir, "old.json")
+	if err := os.WriteFile(old, []byte(`{"version":1,"products":[{"id":"p1","name":"Old","price_cents":100,"sku":"OLD","stock":1}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewInMemoryStore()
+	if err := st.LoadSnapshot(old); err != nil {
+		t.Fatalf("LoadSnapshot(old): %v", err)
+	}
+	if p, err := st.GetProduct(context.Background(), "p1"); err != nil || p.Images == nil || p.Status != models.ProductPublished {
+		t.Fatalf("GetProduct(p1) = %+v, %v", p, err)
+	}
+	// and its stock opens the ledger
+	if ms, _, err := st.ListStockMovements(context.Background(), "p1", store.Page{}); err != nil || len(ms) != 1 || ms[0].Delta != 1 {
+		t.Fatalf("ListStockMovements(p1) = %+v, %v; want the opening balance", ms, err)
+	}
+	// at a default location holding all of it
+	locs, err := st.ListLocations(context.Background())
+	if err != nil || len(locs) != 1 {
+		t.Fatalf("ListLocations = %v, %v; want the default location", locs, err)
+	}
+	levels, err := st.ListStockLevels(context.Background(), "p1")
+	if err != nil || len(levels) != 1 || levels[0].LocationID != locs[0].ID || levels[0].Stock != 1 {
+		t.Fatalf("ListStockLevels(p1) = %+v, %v", levels, err)
+	}
+
+	newer := filepath.Join(dir, "newer.json")
+	if err := os.WriteFile(newer, []byte(`{"version":999}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.NewInMemoryStore().LoadSnapshot(newer); err == nil {
+		t.Fatal("LoadSnapshot accepted a snapshot from a newer build")
+	}
+}
+
+func TestInMemorySnapshotCategoryBackfill(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	// Written before categories existed: free-text categories only
+	legacy := `{"version":1,"products":[
+		{"id":"p1","name":"Kopi","price_cents":100,"sku":"K1","stock":1,"category":"Kopi & Teh"},
+		{"id":"p2","name":"Teh","price_cents":100,"sku":"T1","stock":1,"category":"kopi teh"},
+		{"id":"p3","name":"Buku","price_cents":100,"sku":"B1","stock":1,"category":"Buku"},
+		{"id":"p4","name":"Lain","price_cents":100,"sku":"L1","stock":1}
+	]}`
+	if err := os.WriteFile(path, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewInMemoryStore()
+	if err := st.LoadSnapshot(path); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+
+	cats, err := st.ListCategories(ctx)
+	if err != nil {
+		t.Fatalf("ListCategories: %v", err)
+	}
+	if len(cats) != 2 || cats[0].Slug != "buku" || cats[1].Slug != "kopi-teh" || cats[1].Name != "Kopi & Teh" {
+		t.Fatalf("ListCategories = %+v, want buku and kopi-teh", cats)
+	}
+	for id, want := range map[string]*models.Category{"p1": cats[1], "p2": cats[1], "p3": cats[0]} {
+		p, err := st.GetProduct(ctx, id)
+		if err != nil || p.CategoryID != want.ID || p.Category != want.Name {
+			t.Fatalf("GetProduct(%s) = %+v, %v; want it in %s", id, p, err, want.Slug)
+		}
+	}
+	if p, _ := st.GetProduct(ctx, "p4"); p.CategoryID != "" {
+		t.Fatalf("uncategorized product got category %q", p.CategoryID)
+	}
+
+	// Once saved with categories, loading again does not backfill twice
+	if err := st.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	again := store.NewInMemoryStore()
+	if err := again.LoadSnapshot(path); err != nil {
+		t.Fatalf("LoadSnapshot(again): %v", err)
+	}
+	if got, _ := again.ListCategories(ctx); len(got) != 2 || got[0].ID != cats[0].ID {
+		t.Fatalf("reloaded categories = %+v, want the same two", got)
+	}
+}
+
+func TestInMemorySnapshotSKUDedupe(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	// Written before SKUs were unique: the oldest product keeps the SKU
+	legacy := `{"version":1,"products":[
+		{"id":"p2","name":"Baru","price_cents":100,"sku":"K1","stock":1,"created_at":"2025-02-01T00:00:00Z"},
+		{"id":"p1","name":"Lama","price_cents":100,"sku":"K1","stock":1,"created_at":"2025-01-01T00:00:00Z"},
+		{"id":"p3","name":"Lain","price_cents":100,"sku":"K1-2","stock":1,"created_at":"2025-03-01T00:00:00Z"}
+	]}`
+	if err := os.WriteFile(path, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewInMemoryStore()
+	if err := st.LoadSnapshot(path); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+
+	for id, want := range map[string]string{"p1": "K1", "p2": "K1-3", "p3": "K1-2"} {
+		if p, err := st.GetProduct(ctx, id); err != nil || p.SKU != want {
+			t.Fatalf("GetProduct(%s) = %+v, %v; want SKU %s", id, p, err, want)
+		}
+	}
+	if p, _, err := st.GetProductBySKU(ctx, "K1"); err != nil || p.ID != "p1" {
+		t.Fatalf("GetProductBySKU(K1) = %+v, %v; want p1", p, err)
+	}
+}
+
+func TestInMemorySnapshotsSaveOnStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	st := store.NewInMemoryStore()
+	stop := st.StartSnapshots(path, time.Hour)
+
+	if _, err := st.CreateProduct(context.Background(), &models.Product{Name: "Late", SKU: "LATE", Stock: 1}); err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	restored := store.NewInMemoryStore()
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if ps, _, _ := restored.ListProducts(context.Background(), store.ProductFilter{}, store.Page{}); len(ps) != 1 {
+		t.Fatalf("snapshot written on stop has %d products, want 1", len(ps))
+	}
+}
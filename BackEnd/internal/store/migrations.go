@@ -0,0 +1,29 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/example/ecommerce-api/internal/migrate"
+)
+
+// NewMigrator opens the database for the given SQL backend and returns a
+// migrator for its schema. Callers must close the returned DB.
+func NewMigrator(backend, dsn string) (*migrate.Migrator, *sql.DB, error) {
+	switch backend {
+	case "mysql":
+		db, err := openMySQL(dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return migrate.New(db, migrate.MySQL, mysqlMigrations), db, nil
+	case "postgres":
+		db, err := openPostgres(dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return migrate.New(db, migrate.Postgres, postgresMigrations), db, nil
+	default:
+		return nil, nil, fmt.Errorf("store backend %q has no schema to migrate", backend)
+	}
+}
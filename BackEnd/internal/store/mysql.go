@@ -11,255 +11,64 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 
+	"github.com/example/ecommerce-api/internal/migrate"
 	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/search"
 )
 
 type MySQLStore struct {
-	db *sql.DB
+	db     *sql.DB
+	search search.Index
+	prices *priceClock
 }
 
 func NewMySQLStore(dsn string) (*MySQLStore, error) {
-	db, err := sql.Open("mysql", dsn)
+	db, err := openMySQL(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open MySQL connection: %w", err)
+		return nil, err
 	}
 
-	db.SetConnMaxLifetime(5 * time.Minute)
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
-
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := db.PingContext(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ping MySQL: %w", err)
-	}
-
-	ms := &MySQLStore{db: db}
-	if err := ms.autoMigrate(); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
-	}
-
-	return ms, nil
-}
-
-func (s *MySQLStore) autoMigrate() error {
-	// Create tables
-	stmts := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id CHAR(36) PRIMARY KEY,
-			full_name VARCHAR(255) NOT NULL DEFAULT '',
-			phone VARCHAR(50) NOT NULL DEFAULT '',
-			email VARCHAR(255) UNIQUE NOT NULL,
-			password_hash VARCHAR(255) NOT NULL,
-			role VARCHAR(20) NOT NULL DEFAULT 'user',
-			auth_provider VARCHAR(30) NOT NULL DEFAULT 'email',
-			google_id VARCHAR(255) NOT NULL DEFAULT '',
-			email_verified BOOLEAN NOT NULL DEFAULT FALSE,
-			created_at DATETIME NOT NULL,
-			INDEX idx_email (email)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
-
-		`CREATE TABLE IF NOT EXISTS email_verifications (
-			token CHAR(36) PRIMARY KEY,
-			user_id CHAR(36) NOT NULL,
-			expires_at DATETIME NOT NULL,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-			INDEX idx_user_id (user_id),
-			INDEX idx_expires_at (expires_at)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
-
-		`CREATE TABLE IF NOT EXISTS password_resets (
-			token CHAR(36) PRIMARY KEY,
-			user_id CHAR(36) NOT NULL,
-			expires_at DATETIME NOT NULL,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-			INDEX idx_user_id (user_id),
-			INDEX idx_expires_at (expires_at)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
-
-		`CREATE TABLE IF NOT EXISTS products (
-			id CHAR(36) PRIMARY KEY,
-			name VARCHAR(255) NOT NULL,
-			description TEXT,
-			category VARCHAR(100),
-			price_cents BIGINT NOT NULL,
-			sku VARCHAR(100) NOT NULL,
-			stock INT NOT NULL,
-			thumbnail TEXT,
-			created_at DATETIME NOT NULL,
-			updated_at DATETIME NOT NULL,
-			INDEX idx_sku (sku),
-			INDEX idx_name (name),
-			INDEX idx_category (category)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
-
-		`CREATE TABLE IF NOT EXISTS carts (
-			user_id CHAR(36) PRIMARY KEY,
-			updated_at DATETIME NOT NULL,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
-
-		`CREATE TABLE IF NOT EXISTS cart_items (
-			user_id CHAR(36) NOT NULL,
-			product_id CHAR(36) NOT NULL,
-			quantity INT NOT NULL,
-			PRIMARY KEY (user_id, product_id),
-			FOREIGN KEY (user_id) REFERENCES carts(user_id) ON DELETE CASCADE,
-			FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
-
-		`CREATE TABLE IF NOT EXISTS orders (
-			id CHAR(36) PRIMARY KEY,
-			user_id CHAR(36) NOT NULL,
-			amount_cents BIGINT NOT NULL,
-			status VARCHAR(20) NOT NULL,
-			payment_ref VARCHAR(255) NOT NULL,
-			created_at DATETIME NOT NULL,
-			INDEX idx_user_id (user_id),
-			INDEX idx_created_at (created_at),
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
-
-		`CREATE TABLE IF NOT EXISTS order_items (
-			order_id CHAR(36) NOT NULL,
-			product_id CHAR(36) NOT NULL,
-			quantity INT NOT NULL,
-			price_cents BIGINT NOT NULL,
-			PRIMARY KEY (order_id, product_id),
-			FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
-			FOREIGN KEY (product_id) REFERENCES products(id)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
-
-		`CREATE TABLE IF NOT EXISTS reviews (
-			id CHAR(36) PRIMARY KEY,
-			user_id CHAR(36) NOT NULL,
-			user_name VARCHAR(255) NOT NULL,
-			user_photo TEXT,
-			rating INT NOT NULL,
-			comment TEXT NOT NULL,
-			created_at DATETIME NOT NULL,
-			INDEX idx_reviews_created_at (created_at),
-			INDEX idx_reviews_user_id (user_id),
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
-	}
-
-	for _, st := range stmts {
-		if _, err := s.db.Exec(st); err != nil {
-			// Ignore "table already exists" errors
-			if !strings.Contains(err.Error(), "already exists") {
-				return err
-			}
-		}
-	}
-
-	// Check and add email_verified column if it doesn't exist
-	var emailVerifiedCount int
-	row := s.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'users' AND COLUMN_NAME = 'email_verified'")
-	if err := row.Scan(&emailVerifiedCount); err != nil {
-		return err
-	}
-
-	if emailVerifiedCount == 0 {
-		_, err := s.db.Exec(`ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE AFTER role`)
-		if err != nil && !strings.Contains(err.Error(), "Duplicate column") {
-			return err
-		}
-	}
-
-	// Check and add role column if it doesn't exist
-	var roleCount int
-	row = s.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'users' AND COLUMN_NAME = 'role'")
-	if err := row.Scan(&roleCount); err != nil {
-		return err
+	if err := migrate.New(db, migrate.MySQL, mysqlMigrations).Check(ctx); err != nil {
+		return nil, err
 	}
-
-	if roleCount == 0 {
-		_, err := s.db.Exec(`ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user' AFTER password_hash`)
-		if err != nil && !strings.Contains(err.Error(), "Duplicate column") {
-			return err
-		}
+	if err := ensureLocation(ctx, db, func(int) string { return "?" }); err != nil {
+		return nil, err
 	}
 
-	var fullNameCount int
-	row = s.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'users' AND COLUMN_NAME = 'full_name'")
-	if err := row.Scan(&fullNameCount); err != nil {
-		return err
-	}
-	if fullNameCount == 0 {
-		_, err := s.db.Exec(`ALTER TABLE users ADD COLUMN full_name VARCHAR(255) NOT NULL DEFAULT '' AFTER id`)
-		if err != nil && !strings.Contains(err.Error(), "Duplicate column") {
-			return err
-		}
-	}
+	return &MySQLStore{db: db, search: mysqlIndex{&sqlVocabulary{db: db}}, prices: &priceClock{}}, nil
+}
 
-	var phoneCount int
-	row = s.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'users' AND COLUMN_NAME = 'phone'")
-	if err := row.Scan(&phoneCount); err != nil {
-		return err
-	}
-	if phoneCount == 0 {
-		_, err := s.db.Exec(`ALTER TABLE users ADD COLUMN phone VARCHAR(50) NOT NULL DEFAULT '' AFTER full_name`)
-		if err != nil && !strings.Contains(err.Error(), "Duplicate column") {
-			return err
-		}
-	}
+// Close releases the underlying connection pool
+func (s *MySQLStore) Close() error {
+	return s.db.Close()
+}
 
-	var authProviderCount int
-	row = s.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'users' AND COLUMN_NAME = 'auth_provider'")
-	if err := row.Scan(&authProviderCount); err != nil {
-		return err
-	}
-	if authProviderCount == 0 {
-		_, err := s.db.Exec(`ALTER TABLE users ADD COLUMN auth_provider VARCHAR(30) NOT NULL DEFAULT 'email' AFTER role`)
-		if err != nil && !strings.Contains(err.Error(), "Duplicate column") {
-			return err
-		}
+func openMySQL(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MySQL connection: %w", err)
 	}
 
-	var googleIDCount int
-	row = s.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'users' AND COLUMN_NAME = 'google_id'")
-	if err := row.Scan(&googleIDCount); err != nil {
-		return err
-	}
-	if googleIDCount == 0 {
-		_, err := s.db.Exec(`ALTER TABLE users ADD COLUMN google_id VARCHAR(255) NOT NULL DEFAULT '' AFTER auth_provider`)
-		if err != nil && !strings.Contains(err.Error(), "Duplicate column") {
-			return err
-		}
-	}
+	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(5)
 
-	var categoryCount int
-	row = s.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'products' AND COLUMN_NAME = 'category'")
-	if err := row.Scan(&categoryCount); err != nil {
-		return err
-	}
-	if categoryCount == 0 {
-		_, err := s.db.Exec(`ALTER TABLE products ADD COLUMN category VARCHAR(100) AFTER description`)
-		if err != nil && !strings.Contains(err.Error(), "Duplicate column") {
-			return err
-		}
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	var thumbnailCount int
-	row = s.db.QueryRow("SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'products' AND COLUMN_NAME = 'thumbnail'")
-	if err := row.Scan(&thumbnailCount); err != nil {
-		return err
-	}
-	if thumbnailCount == 0 {
-		_, err := s.db.Exec(`ALTER TABLE products ADD COLUMN thumbnail TEXT AFTER stock`)
-		if err != nil && !strings.Contains(err.Error(), "Duplicate column") {
-			return err
-		}
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to ping MySQL: %w", err)
 	}
-
-	return nil
+	return db, nil
 }
 
 // Users
 
-func (s *MySQLStore) CreateUser(fullName, phone, email, passwordHash, role, provider, googleID string, emailVerified bool) (*models.User, error) {
+func (s *MySQLStore) CreateUser(ctx context.Context, fullName, phone, email, passwordHash, role, provider, googleID string, emailVerified bool) (*models.User, error) {
 	id := uuid.NewString()
 	now := time.Now()
 	if role == "" {
@@ -269,7 +78,7 @@ func (s *MySQLStore) CreateUser(fullName, phone, email, passwordHash, role, prov
 		provider = "email"
 	}
 
-	_, err := s.db.Exec(
+	_, err := s.db.ExecContext(ctx,
 		`INSERT INTO users (id, full_name, phone, email, password_hash, role, auth_provider, google_id, email_verified, created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`,
 		id, fullName, phone, email, passwordHash, role, provider, googleID, emailVerified, now,
 	)
@@ -294,8 +103,8 @@ func (s *MySQLStore) CreateUser(fullName, phone, email, passwordHash, role, prov
 	}, nil
 }
 
-func (s *MySQLStore) GetUserByEmail(email string) (*models.User, error) {
-	row := s.db.QueryRow(
+func (s *MySQLStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	row := s.db.QueryRowContext(ctx,
 		`SELECT id, full_name, phone, email, password_hash, role, auth_provider, google_id, email_verified, created_at FROM users WHERE email=?`,
 		email,
 	)
@@ -310,8 +119,8 @@ func (s *MySQLStore) GetUserByEmail(email string) (*models.User, error) {
 	return &u, nil
 }
 
-func (s *MySQLStore) GetUserByID(id string) (*models.User, error) {
-	row := s.db.QueryRow(
+func (s *MySQLStore) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	row := s.db.QueryRowContext(ctx,
 		`SELECT id, full_name, phone, email, password_hash, role, auth_provider, google_id, email_verified, created_at FROM users WHERE id=?`,
 		id,
 	)
@@ -326,8 +135,12 @@ func (s *MySQLStore) GetUserByID(id string) (*models.User, error) {
 	return &u, nil
 }
 
-func (s *MySQLStore) SeedAdminUser(email, passwordHash string) error {
-	row := s.db.QueryRow(`SELECT id FROM users WHERE email=?`, email)
+func (s *MySQLStore) ListUsersByRole(ctx context.Context, role string) ([]*models.User, error) {
+	return queryUsersByRole(ctx, s.db, role, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) SeedAdminUser(ctx context.Context, email, passwordHash string) error {
+	row := s.db.QueryRowContext(ctx, `SELECT id FROM users WHERE email=?`, email)
 	var id string
 	err := row.Scan(&id)
 	if err == nil {
@@ -339,7 +152,7 @@ func (s *MySQLStore) SeedAdminUser(email, passwordHash string) error {
 
 	adminID := uuid.NewString()
 	now := time.Now()
-	_, err = s.db.Exec(
+	_, err = s.db.ExecContext(ctx,
 		`INSERT INTO users (id, full_name, phone, email, password_hash, role, auth_provider, google_id, email_verified, created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`,
 		adminID, "Administrator", "", email, passwordHash, "admin", "email", "", true, now,
 	)
@@ -352,8 +165,8 @@ func (s *MySQLStore) SeedAdminUser(email, passwordHash string) error {
 	return nil
 }
 
-func (s *MySQLStore) UpdateUserPassword(userID, newPasswordHash string) error {
-	res, err := s.db.Exec(`UPDATE users SET password_hash=? WHERE id=?`, newPasswordHash, userID)
+func (s *MySQLStore) UpdateUserPassword(ctx context.Context, userID, newPasswordHash string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET password_hash=? WHERE id=?`, newPasswordHash, userID)
 	if err != nil {
 		return err
 	}
@@ -364,8 +177,8 @@ func (s *MySQLStore) UpdateUserPassword(userID, newPasswordHash string) error {
 	return nil
 }
 
-func (s *MySQLStore) MarkEmailVerified(userID string) error {
-	res, err := s.db.Exec(`UPDATE users SET email_verified=TRUE WHERE id=?`, userID)
+func (s *MySQLStore) MarkEmailVerified(ctx context.Context, userID string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET email_verified=TRUE WHERE id=?`, userID)
 	if err != nil {
 		return err
 	}
@@ -378,16 +191,16 @@ func (s *MySQLStore) MarkEmailVerified(userID string) error {
 
 // Email Verification
 
-func (s *MySQLStore) CreateEmailVerification(userID, token string, expiresAt time.Time) error {
-	_, err := s.db.Exec(
+func (s *MySQLStore) CreateEmailVerification(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
 		`INSERT INTO email_verifications (token, user_id, expires_at) VALUES (?,?,?)`,
 		token, userID, expiresAt,
 	)
 	return err
 }
 
-func (s *MySQLStore) GetEmailVerification(token string) (*models.EmailVerification, error) {
-	row := s.db.QueryRow(
+func (s *MySQLStore) GetEmailVerification(ctx context.Context, token string) (*models.EmailVerification, error) {
+	row := s.db.QueryRowContext(ctx,
 		`SELECT token, user_id, expires_at FROM email_verifications WHERE token=?`,
 		token,
 	)
@@ -402,8 +215,8 @@ func (s *MySQLStore) GetEmailVerification(token string) (*models.EmailVerificati
 	return &v, nil
 }
 
-func (s *MySQLStore) GetLatestEmailVerificationByUser(userID string) (*models.EmailVerification, error) {
-	row := s.db.QueryRow(
+func (s *MySQLStore) GetLatestEmailVerificationByUser(ctx context.Context, userID string) (*models.EmailVerification, error) {
+	row := s.db.QueryRowContext(ctx,
 		`SELECT token, user_id, expires_at FROM email_verifications WHERE user_id=? ORDER BY expires_at DESC LIMIT 1`,
 		userID,
 	)
@@ -418,23 +231,23 @@ func (s *MySQLStore) GetLatestEmailVerificationByUser(userID string) (*models.Em
 	return &v, nil
 }
 
-func (s *MySQLStore) DeleteEmailVerification(token string) error {
-	_, err := s.db.Exec(`DELETE FROM email_verifications WHERE token=?`, token)
+func (s *MySQLStore) DeleteEmailVerification(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM email_verifications WHERE token=?`, token)
 	return err
 }
 
 // Password Reset
 
-func (s *MySQLStore) CreatePasswordReset(userID, token string, expiresAt time.Time) error {
-	_, err := s.db.Exec(
+func (s *MySQLStore) CreatePasswordReset(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
 		`INSERT INTO password_resets (token, user_id, expires_at) VALUES (?,?,?)`,
 		token, userID, expiresAt,
 	)
 	return err
 }
 
-func (s *MySQLStore) GetPasswordReset(token string) (*models.PasswordReset, error) {
-	row := s.db.QueryRow(
+func (s *MySQLStore) GetPasswordReset(ctx context.Context, token string) (*models.PasswordReset, error) {
+	row := s.db.QueryRowContext(ctx,
 		`SELECT token, user_id, expires_at FROM password_resets WHERE token=?`,
 		token,
 	)
@@ -449,62 +262,169 @@ func (s *MySQLStore) GetPasswordReset(token string) (*models.PasswordReset, erro
 	return &r, nil
 }
 
-func (s *MySQLStore) DeletePasswordReset(token string) error {
-	_, err := s.db.Exec(`DELETE FROM password_resets WHERE token=?`, token)
+func (s *MySQLStore) DeletePasswordReset(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM password_resets WHERE token=?`, token)
 	return err
 }
 
 // Products
 
-func (s *MySQLStore) CreateProduct(p *models.Product) (*models.Product, error) {
+func (s *MySQLStore) CreateProduct(ctx context.Context, p *models.Product) (*models.Product, error) {
+	images, err := encodeImages(p.Images)
+	if err != nil {
+		return nil, err
+	}
+	options, err := encodeOptions(p.Options)
+	if err != nil {
+		return nil, err
+	}
+
 	p.ID = uuid.NewString()
 	now := time.Now()
 	p.CreatedAt = now
 	p.UpdatedAt = now
+	if p.Options == nil {
+		p.Options = []models.ProductOption{}
+	}
+	if p.Status == "" {
+		p.Status = models.ProductPublished
+	}
+	// Variants are added one by one through CreateVariant
+	p.Variants = []models.ProductVariant{}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
 
-	_, err := s.db.Exec(
-		`INSERT INTO products (id, name, description, category, price_cents, sku, stock, thumbnail, created_at, updated_at) 
-		VALUES (?,?,?,?,?,?,?,?,?,?)`,
-		p.ID, p.Name, p.Description, p.Category, p.PriceCents, p.SKU, p.Stock, p.Thumbnail, p.CreatedAt, p.UpdatedAt,
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO products (id, name, description, category, category_id, price_cents, sku, stock, thumbnail, images, options, rating, review_count, created_at, updated_at, status, publish_at, unpublish_at, low_stock_threshold)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		p.ID, p.Name, p.Description, p.Category, nullID(p.CategoryID), p.PriceCents, p.SKU, p.Stock, p.Thumbnail, images, options, p.Rating, p.ReviewCount, p.CreatedAt, p.UpdatedAt, p.Status, p.PublishAt, p.UnpublishAt, nullInt(p.LowStockThreshold),
 	)
 	if err != nil {
+		if isDuplicate(err) {
+			return nil, ErrSKUTaken
+		}
+		return nil, err
+	}
+	if err := insertPriceChange(ctx, tx, newPriceChange(p.ID, 0, p.BasePrice(), models.PriceCreated, actorFrom(ctx), "", now), func(int) string { return "?" }); err != nil {
+		return nil, err
+	}
+	if err := insertStockMovements(ctx, tx, stockChanges(p.ID, nil, stockLevels(p), models.StockRestock, actorFrom(ctx), "", now), func(int) string { return "?" }); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
+	s.search.Put(p)
 	return p, nil
 }
 
-func (s *MySQLStore) UpdateProduct(id string, updateFn func(p *models.Product) error) (*models.Product, error) {
-	row := s.db.QueryRow(
-		`SELECT id, name, description, category, price_cents, sku, stock, thumbnail, created_at, updated_at 
-		FROM products WHERE id=?`,
-		id,
-	)
+func (s *MySQLStore) UpdateProduct(ctx context.Context, id string, updateFn func(p *models.Product) error) (*models.Product, error) {
+	if err := s.prices.settle(ctx, s.db, func(int) string { return "?" }); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
 
-	p := models.Product{}
-	if err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Category, &p.PriceCents, &p.SKU, &p.Stock, &p.Thumbnail, &p.CreatedAt, &p.UpdatedAt); err != nil {
+	p, err := scanProduct(tx.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE id=? FOR UPDATE`, id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.New("product not found")
 		}
 		return nil, err
 	}
+	if err := loadVariants(ctx, tx, []*models.Product{p}, func(int) string { return "?" }, false); err != nil {
+		return nil, err
+	}
 
-	if err := updateFn(&p); err != nil {
+	before, levels := p.BasePrice(), stockLevels(p)
+	variants := p.Variants
+	if err := updateFn(p); err != nil {
+		return nil, err
+	}
+	if p.Stock < 0 {
+		return nil, ErrInsufficientStock
+	}
+	p.Variants = variants
+	rollupStock(p)
+
+	images, err := encodeImages(p.Images)
+	if err != nil {
+		return nil, err
+	}
+	options, err := encodeOptions(p.Options)
+	if err != nil {
 		return nil, err
 	}
 
 	p.UpdatedAt = time.Now()
-	_, err := s.db.Exec(
-		`UPDATE products SET name=?, description=?, category=?, price_cents=?, sku=?, stock=?, thumbnail=?, updated_at=? WHERE id=?`,
-		p.Name, p.Description, p.Category, p.PriceCents, p.SKU, p.Stock, p.Thumbnail, p.UpdatedAt, p.ID,
+	_, err = tx.ExecContext(ctx,
+		`UPDATE products SET name=?, description=?, category=?, category_id=?, price_cents=?, regular_price_cents=?, sku=?, stock=?, thumbnail=?, images=?, options=?, rating=?, review_count=?, updated_at=?, status=?, publish_at=?, unpublish_at=?, low_stock_threshold=? WHERE id=?`,
+		p.Name, p.Description, p.Category, nullID(p.CategoryID), p.PriceCents, nullPrice(p.RegularPriceCents), p.SKU, p.Stock, p.Thumbnail, images, options, p.Rating, p.ReviewCount, p.UpdatedAt, p.Status, p.PublishAt, p.UnpublishAt, nullInt(p.LowStockThreshold), p.ID,
 	)
 	if err != nil {
+		if isDuplicate(err) {
+			return nil, ErrSKUTaken
+		}
+		return nil, err
+	}
+	if err := insertPriceChange(ctx, tx, newPriceChange(p.ID, before, p.BasePrice(), models.PriceManual, actorFrom(ctx), "", p.UpdatedAt), func(int) string { return "?" }); err != nil {
+		return nil, err
+	}
+	if err := insertStockMovements(ctx, tx, stockChanges(p.ID, levels, stockLevels(p), models.StockAdjustment, actorFrom(ctx), "", p.UpdatedAt), func(int) string { return "?" }); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
-	return &p, nil
+	s.search.Put(p)
+	return p, nil
+}
+
+func (s *MySQLStore) ArchiveProduct(ctx context.Context, id string) error {
+	p, err := s.GetProduct(ctx, id)
+	if err != nil || p.ArchivedAt != nil {
+		return err
+	}
+	now := time.Now()
+	// MySQL assigns in order, so archived_status takes the status before it changes
+	_, err = s.db.ExecContext(ctx, `UPDATE products SET archived_at=?, archived_status=status, status=?, updated_at=? WHERE id=?`, now, models.ProductArchived, now, id)
+	return err
+}
+
+func (s *MySQLStore) RestoreProduct(ctx context.Context, id string) error {
+	p, err := s.GetProduct(ctx, id)
+	if err != nil || p.ArchivedAt == nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE products SET archived_at=NULL, status=COALESCE(archived_status, ?), archived_status=NULL, updated_at=? WHERE id=?`, models.ProductDraft, time.Now(), id)
+	return err
 }
 
-func (s *MySQLStore) DeleteProduct(id string) error {
-	res, err := s.db.Exec(`DELETE FROM products WHERE id=?`, id)
+func (s *MySQLStore) PurgeProduct(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var ordered int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM order_items WHERE product_id=?`, id).Scan(&ordered); err != nil {
+		return err
+	}
+	if ordered > 0 {
+		return ErrProductInUse
+	}
+
+	// cart_items rows go with it through ON DELETE CASCADE
+	res, err := tx.ExecContext(ctx, `DELETE FROM products WHERE id=?`, id)
 	if err != nil {
 		return err
 	}
@@ -512,250 +432,588 @@ func (s *MySQLStore) DeleteProduct(id string) error {
 	if n == 0 {
 		return errors.New("product not found")
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (s *MySQLStore) GetProduct(id string) (*models.Product, error) {
-	row := s.db.QueryRow(
-		`SELECT id, name, description, category, price_cents, sku, stock, thumbnail, created_at, updated_at 
-		FROM products WHERE id=?`,
-		id,
-	)
+func (s *MySQLStore) GetProductBySKU(ctx context.Context, sku string) (*models.Product, string, error) {
+	productID, variantID, err := productIDBySKU(ctx, s.db, sku, func(int) string { return "?" })
+	if err != nil {
+		return nil, "", err
+	}
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, "", err
+	}
+	return p, variantID, nil
+}
 
-	p := models.Product{}
-	if err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Category, &p.PriceCents, &p.SKU, &p.Stock, &p.Thumbnail, &p.CreatedAt, &p.UpdatedAt); err != nil {
+func (s *MySQLStore) GetProduct(ctx context.Context, id string) (*models.Product, error) {
+	if err := s.prices.settle(ctx, s.db, func(int) string { return "?" }); err != nil {
+		return nil, err
+	}
+	p, err := scanProduct(s.db.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE id=?`, id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.New("product not found")
 		}
 		return nil, err
 	}
-	return &p, nil
+	if err := loadVariants(ctx, s.db, []*models.Product{p}, func(int) string { return "?" }, false); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-func (s *MySQLStore) ListProducts(query string) ([]*models.Product, error) {
-	var rows *sql.Rows
-	var err error
+func (s *MySQLStore) ListProducts(ctx context.Context, filter ProductFilter, page Page) ([]*models.Product, string, error) {
+	if err := s.prices.settle(ctx, s.db, func(int) string { return "?" }); err != nil {
+		return nil, "", err
+	}
+	hits, err := searchHits(ctx, s.search, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	res, next, err := queryProducts(ctx, s.db, filter, hits, page, func(int) string { return "?" })
+	if err != nil {
+		return nil, "", err
+	}
+	if err := loadVariants(ctx, s.db, res, func(int) string { return "?" }, false); err != nil {
+		return nil, "", err
+	}
+	return res, next, nil
+}
 
-	if strings.TrimSpace(query) == "" {
-		rows, err = s.db.Query(
-			`SELECT id, name, description, category, price_cents, sku, stock, thumbnail, created_at, updated_at 
-			FROM products ORDER BY created_at DESC`,
-		)
-	} else {
-		like := "%" + strings.ToLower(query) + "%"
-		rows, err = s.db.Query(
-			`SELECT id, name, description, category, price_cents, sku, stock, thumbnail, created_at, updated_at 
-			FROM products 
-			WHERE LOWER(name) LIKE ? OR LOWER(description) LIKE ? OR LOWER(sku) LIKE ? OR LOWER(category) LIKE ? 
-			ORDER BY created_at DESC`,
-			like, like, like, like,
-		)
+func (s *MySQLStore) ProductFacets(ctx context.Context, filter ProductFilter, priceBounds []int64) (*ProductFacets, error) {
+	if err := s.prices.settle(ctx, s.db, func(int) string { return "?" }); err != nil {
+		return nil, err
+	}
+	hits, err := searchHits(ctx, s.search, filter)
+	if err != nil {
+		return nil, err
+	}
+	return queryProductFacets(ctx, s.db, filter, hits, priceBounds, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) ImportProducts(ctx context.Context, skus []string, dryRun bool, update func(i int, p *models.Product) error) ([]ImportedProduct, error) {
+	if err := s.prices.settle(ctx, s.db, func(int) string { return "?" }); err != nil {
+		return nil, err
 	}
 
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer func() { _ = tx.Rollback() }()
 
-	res := []*models.Product{}
-	for rows.Next() {
-		p := models.Product{}
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Category, &p.PriceCents, &p.SKU, &p.Stock, &p.Thumbnail, &p.CreatedAt, &p.UpdatedAt); err != nil {
-			return nil, err
-		}
-		cp := p
-		res = append(res, &cp)
+	rows, err := importProducts(ctx, tx, skus, dryRun, update, func(int) string { return "?" })
+	if err != nil || dryRun {
+		return rows, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		s.search.Put(row.Product)
 	}
-	return res, nil
+	return rows, nil
 }
 
-// Carts
-
-func (s *MySQLStore) GetOrCreateCart(userID string) *models.Cart {
-	_, _ = s.db.Exec(
-		`INSERT IGNORE INTO carts (user_id, updated_at) VALUES (?, ?)`,
-		userID, time.Now(),
-	)
+// Prices
 
-	c, _ := s.GetCart(userID)
-	if c == nil {
-		return &models.Cart{UserID: userID, Items: []models.CartItem{}}
+func (s *MySQLStore) ListPriceHistory(ctx context.Context, productID string) ([]*models.PriceChange, error) {
+	if _, err := s.GetProduct(ctx, productID); err != nil {
+		return nil, err
 	}
-	return c
+	return queryPriceHistory(ctx, s.db, productID, func(int) string { return "?" })
 }
 
-func (s *MySQLStore) AddToCart(userID, productID string, qty int) error {
-	if qty <= 0 {
-		return errors.New("quantity must be positive")
+func (s *MySQLStore) CreatePriceSchedule(ctx context.Context, sc *models.PriceSchedule) (*models.PriceSchedule, error) {
+	return createPriceSchedule(ctx, s.db, s.prices, sc, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) ListPriceSchedules(ctx context.Context, productID string) ([]*models.PriceSchedule, error) {
+	if _, err := s.GetProduct(ctx, productID); err != nil {
+		return nil, err
 	}
+	return queryPriceSchedules(ctx, s.db, `product_id = ?`, []any{productID}, false)
+}
 
-	p, err := s.GetProduct(productID)
-	if err != nil {
-		return err
+func (s *MySQLStore) CancelPriceSchedule(ctx context.Context, productID, scheduleID string) (*models.PriceSchedule, error) {
+	if err := s.prices.settle(ctx, s.db, func(int) string { return "?" }); err != nil {
+		return nil, err
 	}
+	return cancelPriceSchedule(ctx, s.db, s.prices, productID, scheduleID, func(int) string { return "?" })
+}
 
-	// Check current cart quantity
-	row := s.db.QueryRow(
-		`SELECT COALESCE(quantity, 0) FROM cart_items WHERE user_id=? AND product_id=?`,
-		userID, productID,
-	)
-	var currentQty int
-	row.Scan(&currentQty)
+// Categories
 
-	if p.Stock < (currentQty + qty) {
-		return errors.New("insufficient stock")
+func (s *MySQLStore) CreateCategory(ctx context.Context, c *models.Category) (*models.Category, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	_, err = s.db.Exec(
-		`INSERT INTO carts (user_id, updated_at) VALUES (?, ?) 
-		ON DUPLICATE KEY UPDATE updated_at=VALUES(updated_at)`,
-		userID, time.Now(),
-	)
+	all, err := queryCategories(ctx, tx, true)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	_, err = s.db.Exec(
-		`INSERT INTO cart_items (user_id, product_id, quantity) VALUES (?,?,?) 
-		ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity)`,
-		userID, productID, qty,
-	)
-	return err
-}
-
-func (s *MySQLStore) RemoveFromCart(userID, productID string, qty int) error {
-	if qty <= 0 {
-		return errors.New("quantity must be positive")
+	c.ID = uuid.NewString()
+	if err := checkCategoryParent(categoriesByID(all), c.ID, c.ParentID); err != nil {
+		return nil, err
 	}
+	c.Children = nil
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
 
-	row := s.db.QueryRow(
-		`SELECT quantity FROM cart_items WHERE user_id=? AND product_id=?`,
-		userID, productID,
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO categories (id, parent_id, name, slug, sku_prefix, sort_order, image, created_at, updated_at) VALUES (?,?,?,?,?,?,?,?,?)`,
+		c.ID, nullID(c.ParentID), c.Name, c.Slug, c.SKUPrefix, c.SortOrder, c.Image, c.CreatedAt, c.UpdatedAt,
 	)
-	var cur int
-	err := row.Scan(&cur)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("item not in cart")
+		if isDuplicate(err) {
+			return nil, ErrSlugTaken
 		}
-		return err
+		return nil, err
 	}
-
-	if cur <= qty {
-		_, err = s.db.Exec(
-			`DELETE FROM cart_items WHERE user_id=? AND product_id=?`,
-			userID, productID,
-		)
-		return err
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
-
-	_, err = s.db.Exec(
-		`UPDATE cart_items SET quantity = quantity - ? WHERE user_id=? AND product_id=?`,
-		qty, userID, productID,
-	)
-	return err
-}
-
-func (s *MySQLStore) ClearCart(userID string) {
-	_, _ = s.db.Exec(`DELETE FROM cart_items WHERE user_id=?`, userID)
-	_, _ = s.db.Exec(`DELETE FROM carts WHERE user_id=?`, userID)
+	return c, nil
 }
 
-func (s *MySQLStore) GetCart(userID string) (*models.Cart, error) {
-	rows, err := s.db.Query(
-		`SELECT product_id, quantity FROM cart_items WHERE user_id=?`,
-		userID,
-	)
+func (s *MySQLStore) UpdateCategory(ctx context.Context, id string, update func(c *models.Category) error) (*models.Category, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	items := []models.CartItem{}
-	for rows.Next() {
-		var pid string
-		var q int
-		if err := rows.Scan(&pid, &q); err != nil {
-			return nil, err
-		}
-		items = append(items, models.CartItem{ProductID: pid, Quantity: q})
-	}
-	return &models.Cart{UserID: userID, Items: items}, nil
-}
-
-// Orders
-
-func (s *MySQLStore) CreateOrder(userID string, items []models.CartItem, amount int64, status, paymentRef string) (*models.Order, error) {
-	id := uuid.NewString()
-	now := time.Now()
+	defer func() { _ = tx.Rollback() }()
 
-	tx, err := s.db.Begin()
+	all, err := queryCategories(ctx, tx, true)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
-		} else {
-			_ = tx.Commit()
-		}
-	}()
+	byID := categoriesByID(all)
+	stored, ok := byID[id]
+	if !ok {
+		return nil, errCategoryNotFound
+	}
+	c := *stored
+	if err := update(&c); err != nil {
+		return nil, err
+	}
+	c.ID = id
+	if err := checkCategoryParent(byID, id, c.ParentID); err != nil {
+		return nil, err
+	}
+	c.Children = nil
+	c.UpdatedAt = time.Now()
 
-	_, err = tx.Exec(
-		`INSERT INTO orders (id, user_id, amount_cents, status, payment_ref, created_at) 
-		VALUES (?,?,?,?,?,?)`,
-		id, userID, amount, status, paymentRef, now,
+	_, err = tx.ExecContext(ctx,
+		`UPDATE categories SET parent_id=?, name=?, slug=?, sku_prefix=?, sort_order=?, image=?, updated_at=? WHERE id=?`,
+		nullID(c.ParentID), c.Name, c.Slug, c.SKUPrefix, c.SortOrder, c.Image, c.UpdatedAt, id,
 	)
 	if err != nil {
+		if isDuplicate(err) {
+			return nil, ErrSlugTaken
+		}
 		return nil, err
 	}
-
-	for _, it := range items {
-		// Get current price
-		var priceCents int64
-		row := tx.QueryRow(`SELECT price_cents FROM products WHERE id=?`, it.ProductID)
-		if err := row.Scan(&priceCents); err != nil {
+	if c.Name != stored.Name {
+		if _, err := tx.ExecContext(ctx, `UPDATE products SET category=? WHERE category_id=?`, c.Name, id); err != nil {
 			return nil, err
 		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
 
-		_, err = tx.Exec(
-			`INSERT INTO order_items (order_id, product_id, quantity, price_cents) VALUES (?,?,?,?)`,
-			id, it.ProductID, it.Quantity, priceCents,
-		)
-		if err != nil {
-			return nil, err
+func (s *MySQLStore) DeleteCategory(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT 1 FROM categories WHERE id=? FOR UPDATE`, id).Scan(&exists); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errCategoryNotFound
 		}
+		return err
+	}
+	var refs int
+	err = tx.QueryRowContext(ctx,
+		`SELECT (SELECT COUNT(*) FROM categories WHERE parent_id=?) + (SELECT COUNT(*) FROM products WHERE category_id=?)`,
+		id, id,
+	).Scan(&refs)
+	if err != nil {
+		return err
+	}
+	if refs > 0 {
+		return ErrCategoryInUse
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-		// Decrement stock
-		res, err := tx.Exec(
-			`UPDATE products SET stock = stock - ? WHERE id=? AND stock >= ?`,
-			it.Quantity, it.ProductID, it.Quantity,
-		)
-		if err != nil {
-			return nil, err
+func (s *MySQLStore) ListCategories(ctx context.Context) ([]*models.Category, error) {
+	return queryCategories(ctx, s.db, false)
+}
+
+// Variants
+
+func (s *MySQLStore) CreateVariant(ctx context.Context, productID string, v *models.ProductVariant) (*models.ProductVariant, error) {
+	options, err := encodeVariantOptions(v.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockProduct(ctx, tx, productID); err != nil {
+		return nil, err
+	}
+	levels, err := queryStockLevels(ctx, tx, productID, func(int) string { return "?" })
+	if err != nil {
+		return nil, err
+	}
+
+	v.ID = uuid.NewString()
+	v.ProductID = productID
+	if v.Options == nil {
+		v.Options = map[string]string{}
+	}
+	v.CreatedAt = time.Now()
+	v.UpdatedAt = v.CreatedAt
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO product_variants (id, product_id, sku, options, price_cents, stock, image, created_at, updated_at) VALUES (?,?,?,?,?,?,?,?,?)`,
+		v.ID, v.ProductID, v.SKU, options, nullPrice(v.PriceCents), v.Stock, v.Image, v.CreatedAt, v.UpdatedAt,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, ErrSKUTaken
 		}
+		return nil, err
+	}
+	if err := s.syncStock(ctx, tx, productID, v.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := recordStockChanges(ctx, tx, productID, levels, models.StockAdjustment, v.UpdatedAt, func(int) string { return "?" }); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
 
-		affected, _ := res.RowsAffected()
-		if affected == 0 {
-			return nil, errors.New("insufficient stock for product: " + it.ProductID)
+func (s *MySQLStore) UpdateVariant(ctx context.Context, productID, variantID string, update func(v *models.ProductVariant) error) (*models.ProductVariant, *models.Product, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockProduct(ctx, tx, productID); err != nil {
+		return nil, nil, err
+	}
+	levels, err := queryStockLevels(ctx, tx, productID, func(int) string { return "?" })
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := scanVariant(tx.QueryRowContext(ctx,
+		`SELECT `+variantSelect+` FROM product_variants WHERE id=? AND product_id=? FOR UPDATE`,
+		variantID, productID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, errVariantNotFound
 		}
+		return nil, nil, err
 	}
 
-	return &models.Order{
-		ID:         id,
-		UserID:     userID,
-		Items:      items,
-		Amount:     amount,
-		Status:     status,
-		PaymentRef: paymentRef,
-		CreatedAt:  now,
-	}, nil
+	if err := update(v); err != nil {
+		return nil, nil, err
+	}
+	if v.Stock < 0 {
+		return nil, nil, ErrInsufficientStock
+	}
+	v.ID, v.ProductID = variantID, productID
+	v.UpdatedAt = time.Now()
+
+	options, err := encodeVariantOptions(v.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE product_variants SET sku=?, options=?, price_cents=?, stock=?, image=?, updated_at=? WHERE id=?`,
+		v.SKU, options, nullPrice(v.PriceCents), v.Stock, v.Image, v.UpdatedAt, v.ID,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, nil, ErrSKUTaken
+		}
+		return nil, nil, err
+	}
+	if err := s.syncStock(ctx, tx, productID, v.UpdatedAt); err != nil {
+		return nil, nil, err
+	}
+	if err := recordStockChanges(ctx, tx, productID, levels, models.StockAdjustment, v.UpdatedAt, func(int) string { return "?" }); err != nil {
+		return nil, nil, err
+	}
+	p, err := readProduct(ctx, tx, productID, func(int) string { return "?" })
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return v, p, nil
 }
 
-func (s *MySQLStore) ListOrdersByUser(userID string) ([]*models.Order, error) {
-	rows, err := s.db.Query(
-		`SELECT id, amount_cents, status, payment_ref, created_at 
-		FROM orders WHERE user_id=? ORDER BY created_at DESC`,
+func (s *MySQLStore) DeleteVariant(ctx context.Context, productID, variantID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockProduct(ctx, tx, productID); err != nil {
+		return err
+	}
+	levels, err := queryStockLevels(ctx, tx, productID, func(int) string { return "?" })
+	if err != nil {
+		return err
+	}
+
+	var ordered int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM order_items WHERE variant_id=?`, variantID).Scan(&ordered); err != nil {
+		return err
+	}
+	if ordered > 0 {
+		return ErrVariantInUse
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM product_variants WHERE id=? AND product_id=?`, variantID, productID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return errVariantNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM cart_items WHERE product_id=? AND variant_id=?`, productID, variantID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM guest_cart_items WHERE product_id=? AND variant_id=?`, productID, variantID); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := s.syncStock(ctx, tx, productID, now); err != nil {
+		return err
+	}
+	if err := recordStockChanges(ctx, tx, productID, levels, models.StockAdjustment, now, func(int) string { return "?" }); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockProduct takes the row lock that serialises every change to a
+// product's variants and stock, checkouts included
+func (s *MySQLStore) lockProduct(ctx context.Context, tx *sql.Tx, id string) error {
+	var locked string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM products WHERE id=? FOR UPDATE`, id).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("product not found")
+	}
+	return err
+}
+
+// syncStock sets a product's stock to the sum of its variants'. A zero time
+// leaves updated_at alone.
+func (s *MySQLStore) syncStock(ctx context.Context, tx *sql.Tx, id string, now time.Time) error {
+	query := `UPDATE products SET stock=(SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id=?) WHERE id=?`
+	args := []any{id, id}
+	if !now.IsZero() {
+		query = `UPDATE products SET stock=(SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id=?), updated_at=? WHERE id=?`
+		args = []any{id, now, id}
+	}
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Inventory
+
+func (s *MySQLStore) AdjustStock(ctx context.Context, productID, variantID, locationID string, delta int, reason, note string) (*models.StockMovement, *models.Product, error) {
+	return adjustStock(ctx, s.db, productID, variantID, locationID, delta, reason, note, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) ListStockMovements(ctx context.Context, productID string, page Page) ([]*models.StockMovement, string, error) {
+	return queryStockMovements(ctx, s.db, productID, page, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) ListLowStockProducts(ctx context.Context, defaultThreshold int) ([]*models.Product, error) {
+	return queryLowStockProducts(ctx, s.db, defaultThreshold, func(int) string { return "?" })
+}
+
+// Locations
+
+func (s *MySQLStore) CreateLocation(ctx context.Context, l *models.Location) (*models.Location, error) {
+	return createLocation(ctx, s.db, l, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) UpdateLocation(ctx context.Context, id string, update func(l *models.Location) error) (*models.Location, error) {
+	return updateLocation(ctx, s.db, id, update, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) DeleteLocation(ctx context.Context, id string) error {
+	return deleteLocation(ctx, s.db, id, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) ListLocations(ctx context.Context) ([]*models.Location, error) {
+	return queryLocations(ctx, s.db, false)
+}
+
+func (s *MySQLStore) ListStockLevels(ctx context.Context, productID string) ([]*models.StockLevel, error) {
+	return listStockLevels(ctx, s.db, productID, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) TransferStock(ctx context.Context, productID, variantID, fromID, toID string, qty int, note string) ([]*models.StockMovement, error) {
+	return transferStock(ctx, s.db, productID, variantID, fromID, toID, qty, note, func(int) string { return "?" })
+}
+
+// Back-in-stock subscriptions
+
+func (s *MySQLStore) SubscribeStock(ctx context.Context, userID, productID, variantID string) (*models.StockSubscription, error) {
+	return subscribeStock(ctx, s.db, userID, productID, variantID, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) UnsubscribeStock(ctx context.Context, userID, productID, variantID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM stock_subscriptions WHERE user_id=? AND product_id=? AND variant_id=?`,
+		userID, productID, variantID,
+	)
+	return err
+}
+
+func (s *MySQLStore) ListRestockedSubscriptions(ctx context.Context, productID string) ([]*models.StockSubscription, error) {
+	return queryRestockedSubscriptions(ctx, s.db, productID, func(int) string { return "?" })
+}
+
+// Carts
+
+func (s *MySQLStore) GetOrCreateCart(ctx context.Context, userID string) *models.Cart {
+	_, _ = s.db.ExecContext(ctx,
+		`INSERT IGNORE INTO carts (user_id, updated_at) VALUES (?, ?)`,
+		userID, time.Now(),
+	)
+
+	c, _ := s.GetCart(ctx, userID)
+	if c == nil {
+		return &models.Cart{UserID: userID, Items: []models.CartItem{}}
+	}
+	return c
+}
+
+func (s *MySQLStore) AddToCart(ctx context.Context, userID, productID, variantID string, qty int) error {
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if p.ArchivedAt != nil {
+		return ErrProductArchived
+	}
+	if !p.Live(time.Now()) {
+		return ErrProductNotPublished
+	}
+	v, err := resolveVariant(p, variantID)
+	if err != nil {
+		return err
+	}
+
+	// Check current cart quantity
+	row := s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(quantity, 0) FROM cart_items WHERE user_id=? AND product_id=? AND variant_id=?`,
+		userID, productID, variantID,
+	)
+	var currentQty int
+	row.Scan(&currentQty)
+
+	if stockFor(p, v) < (currentQty + qty) {
+		return errors.New("insufficient stock")
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO carts (user_id, updated_at) VALUES (?, ?) 
+		ON DUPLICATE KEY UPDATE updated_at=VALUES(updated_at)`,
+		userID, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO cart_items (user_id, product_id, variant_id, quantity) VALUES (?,?,?,?) 
+		ON DUPLICATE KEY UPDATE quantity = quantity + VALUES(quantity)`,
+		userID, productID, variantID, qty,
+	)
+	return err
+}
+
+func (s *MySQLStore) RemoveFromCart(ctx context.Context, userID, productID, variantID string, qty int) error {
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+
+	row := s.db.QueryRowContext(ctx,
+		`SELECT quantity FROM cart_items WHERE user_id=? AND product_id=? AND variant_id=?`,
+		userID, productID, variantID,
+	)
+	var cur int
+	err := row.Scan(&cur)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("item not in cart")
+		}
+		return err
+	}
+
+	if cur <= qty {
+		_, err = s.db.ExecContext(ctx,
+			`DELETE FROM cart_items WHERE user_id=? AND product_id=? AND variant_id=?`,
+			userID, productID, variantID,
+		)
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE cart_items SET quantity = quantity - ? WHERE user_id=? AND product_id=? AND variant_id=?`,
+		qty, userID, productID, variantID,
+	)
+	return err
+}
+
+func (s *MySQLStore) ClearCart(ctx context.Context, userID string) {
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM cart_items WHERE user_id=?`, userID)
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM carts WHERE user_id=?`, userID)
+}
+
+func (s *MySQLStore) GetCart(ctx context.Context, userID string) (*models.Cart, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT product_id, variant_id, quantity FROM cart_items WHERE user_id=?`,
 		userID,
 	)
 	if err != nil {
@@ -763,115 +1021,367 @@ func (s *MySQLStore) ListOrdersByUser(userID string) ([]*models.Order, error) {
 	}
 	defer rows.Close()
 
-	res := []*models.Order{}
+	items := []models.CartItem{}
 	for rows.Next() {
-		var id, status, pref string
-		var amount int64
-		var created time.Time
-		if err := rows.Scan(&id, &amount, &status, &pref, &created); err != nil {
+		var it models.CartItem
+		if err := rows.Scan(&it.ProductID, &it.VariantID, &it.Quantity); err != nil {
 			return nil, err
 		}
+		items = append(items, it)
+	}
+	return &models.Cart{UserID: userID, Items: items}, nil
+}
+
+func (s *MySQLStore) SetCartQuantity(ctx context.Context, userID, productID, variantID string, qty int) error {
+	if qty < 0 {
+		return errors.New("quantity must not be negative")
+	}
+	if qty == 0 {
+		return setCartLineQuantity(ctx, s.db, userCartTables, userID, nil, productID, variantID, 0, func(int) string { return "?" })
+	}
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	return setCartLineQuantity(ctx, s.db, userCartTables, userID, p, productID, variantID, qty, func(int) string { return "?" })
+}
+
+// Guest carts
+
+func (s *MySQLStore) AddToGuestCart(ctx context.Context, guestID, productID, variantID string, qty int) error {
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	return addToGuestCart(ctx, s.db, guestID, p, variantID, qty, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) RemoveFromGuestCart(ctx context.Context, guestID, productID, variantID string, qty int) error {
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+	return removeFromGuestCart(ctx, s.db, guestID, productID, variantID, qty, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) SetGuestCartQuantity(ctx context.Context, guestID, productID, variantID string, qty int) error {
+	if qty < 0 {
+		return errors.New("quantity must not be negative")
+	}
+	if qty == 0 {
+		return setCartLineQuantity(ctx, s.db, guestCartTables, guestID, nil, productID, variantID, 0, func(int) string { return "?" })
+	}
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	return setCartLineQuantity(ctx, s.db, guestCartTables, guestID, p, productID, variantID, qty, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) GetGuestCart(ctx context.Context, guestID string) (*models.GuestCart, error) {
+	return getGuestCart(ctx, s.db, guestID, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) MergeGuestCart(ctx context.Context, guestID, userID string) (*models.Cart, error) {
+	return mergeGuestCart(ctx, s.db, guestID, userID, s.GetProduct, func(int) string { return "?" })
+}
 
-		// Fetch items
-		ir, err := s.db.Query(
-			`SELECT product_id, quantity FROM order_items WHERE order_id=?`,
-			id,
+func (s *MySQLStore) ExpireGuestCarts(ctx context.Context, idleSince time.Time) (int, error) {
+	return expireGuestCarts(ctx, s.db, idleSince, func(int) string { return "?" })
+}
+
+// Orders
+
+func (s *MySQLStore) CreateOrder(ctx context.Context, userID string, items []models.CartItem, amount int64, status, paymentRef string) (*models.Order, error) {
+	id := uuid.NewString()
+	now := time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Every early return rolls back; Rollback after Commit is a no-op.
+	defer func() { _ = tx.Rollback() }()
+
+	reservation := orderReservation(status)
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO orders (id, user_id, amount_cents, status, payment_ref, reservation, reserved_at, created_at)
+		VALUES (?,?,?,?,?,?,?,?)`,
+		id, userID, amount, status, paymentRef, reservation, now, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make([]models.CartItem, 0, len(items))
+	for _, it := range items {
+		// Get current price, locking the product before its variants
+		p, err := scanProduct(tx.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE id=? FOR UPDATE`, it.ProductID))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, errors.New("product not found")
+			}
+			return nil, err
+		}
+		if err := loadVariants(ctx, tx, []*models.Product{p}, func(int) string { return "?" }, true); err != nil {
+			return nil, err
+		}
+		v, err := resolveVariant(p, it.VariantID)
+		if err != nil {
+			return nil, err
+		}
+		priceCents := p.PriceFor(v)
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO order_items (order_id, product_id, variant_id, quantity, price_cents) VALUES (?,?,?,?,?)`,
+			id, it.ProductID, it.VariantID, it.Quantity, priceCents,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		items := []models.CartItem{}
-		for ir.Next() {
-			var pid string
-			var q int
-			if err := ir.Scan(&pid, &q); err != nil {
-				_ = ir.Close()
+		it.PriceCents = priceCents
+		ordered = append(ordered, it)
+		if reservation == models.ReservationReleased {
+			continue
+		}
+
+		// Decrement stock
+		var res sql.Result
+		if v != nil {
+			res, err = tx.ExecContext(ctx,
+				`UPDATE product_variants SET stock = stock - ? WHERE id=? AND stock >= ?`,
+				it.Quantity, v.ID, it.Quantity,
+			)
+		} else {
+			res, err = tx.ExecContext(ctx,
+				`UPDATE products SET stock = stock - ? WHERE id=? AND stock >= ?`,
+				it.Quantity, it.ProductID, it.Quantity,
+			)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		affected, _ := res.RowsAffected()
+		if affected == 0 {
+			return nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, it.ProductID)
+		}
+		if v != nil {
+			if err := s.syncStock(ctx, tx, it.ProductID, time.Time{}); err != nil {
 				return nil, err
 			}
-			items = append(items, models.CartItem{ProductID: pid, Quantity: q})
 		}
-		_ = ir.Close()
+	}
 
-		res = append(res, &models.Order{
-			ID:         id,
-			UserID:     userID,
-			Items:      items,
-			Amount:     amount,
-			Status:     status,
-			PaymentRef: pref,
-			CreatedAt:  created,
-		})
+	o := &models.Order{
+		ID:          id,
+		UserID:      userID,
+		Items:       ordered,
+		Amount:      amount,
+		Status:      status,
+		PaymentRef:  paymentRef,
+		Reservation: reservation,
+		ReservedAt:  now,
+		CreatedAt:   now,
+	}
+	if reservation != models.ReservationReleased {
+		if err := allocateOrder(ctx, tx, o, allocationFrom(ctx), func(int) string { return "?" }); err != nil {
+			return nil, err
+		}
+		if err := insertStockMovements(ctx, tx, orderStockMovements(o, -1, reservation, stockActor(ctx, userID), now), func(int) string { return "?" }); err != nil {
+			return nil, err
+		}
 	}
-	return res, nil
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return o, nil
 }
 
-func (s *MySQLStore) ListOrders() ([]*models.Order, error) {
-	rows, err := s.db.Query(
-		`SELECT id, user_id, amount_cents, status, payment_ref, created_at 
-		FROM orders ORDER BY created_at DESC`,
-	)
+func (s *MySQLStore) CheckoutCart(ctx context.Context, userID string) (*models.Order, map[string]*models.Product, error) {
+	if err := s.prices.settle(ctx, s.db, func(int) string { return "?" }); err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer rows.Close()
+	defer func() { _ = tx.Rollback() }()
 
-	res := []*models.Order{}
+	// Lock the cart lines, then each product in ID order. Concurrent
+	// checkouts of the same product queue here instead of overselling, and
+	// cart edits wait until this order is committed or rolled back.
+	rows, err := tx.QueryContext(ctx,
+		`SELECT product_id, variant_id, quantity FROM cart_items WHERE user_id=? ORDER BY product_id, variant_id FOR UPDATE`,
+		userID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := []models.CartItem{}
 	for rows.Next() {
-		var id, userID, status, pref string
-		var amount int64
-		var created time.Time
-		if err := rows.Scan(&id, &userID, &amount, &status, &pref, &created); err != nil {
-			return nil, err
+		var it models.CartItem
+		if err := rows.Scan(&it.ProductID, &it.VariantID, &it.Quantity); err != nil {
+			_ = rows.Close()
+			return nil, nil, err
 		}
+		items = append(items, it)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil, ErrCartEmpty
+	}
 
-		ir, err := s.db.Query(
-			`SELECT product_id, quantity FROM order_items WHERE order_id=?`,
-			id,
-		)
+	now := time.Now()
+	o := &models.Order{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Items:       make([]models.CartItem, 0, len(items)),
+		Status:      "pending",
+		Reservation: models.ReservationHeld,
+		ReservedAt:  now,
+		CreatedAt:   now,
+	}
+	products := map[string]*models.Product{}
+	for _, it := range items {
+		p, err := scanProduct(tx.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE id=? FOR UPDATE`, it.ProductID))
 		if err != nil {
-			return nil, err
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil, errors.New("product not found")
+			}
+			return nil, nil, err
+		}
+		if p.ArchivedAt != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrProductArchived, it.ProductID)
 		}
+		if !p.Live(o.CreatedAt) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrProductNotPublished, it.ProductID)
+		}
+		if err := loadVariants(ctx, tx, []*models.Product{p}, func(int) string { return "?" }, true); err != nil {
+			return nil, nil, err
+		}
+		v, err := resolveVariant(p, it.VariantID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stockFor(p, v) < it.Quantity {
+			return nil, nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, it.ProductID)
+		}
+		it.PriceCents = p.PriceFor(v)
+		o.Items = append(o.Items, it)
+		o.Amount += int64(it.Quantity) * it.PriceCents
+		products[p.ID] = p
+	}
 
-		items := []models.CartItem{}
-		for ir.Next() {
-			var pid string
-			var q int
-			if err := ir.Scan(&pid, &q); err != nil {
-				_ = ir.Close()
-				return nil, err
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO orders (id, user_id, amount_cents, status, payment_ref, reservation, reserved_at, created_at) VALUES (?,?,?,?,?,?,?,?)`,
+		o.ID, o.UserID, o.Amount, o.Status, o.PaymentRef, o.Reservation, o.ReservedAt, o.CreatedAt,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, it := range o.Items {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO order_items (order_id, product_id, variant_id, quantity, price_cents) VALUES (?,?,?,?,?)`,
+			o.ID, it.ProductID, it.VariantID, it.Quantity, it.PriceCents,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		if it.VariantID == "" {
+			if _, err := tx.ExecContext(ctx, `UPDATE products SET stock = stock - ? WHERE id=?`, it.Quantity, it.ProductID); err != nil {
+				return nil, nil, err
 			}
-			items = append(items, models.CartItem{ProductID: pid, Quantity: q})
+			continue
 		}
-		_ = ir.Close()
+		if _, err := tx.ExecContext(ctx, `UPDATE product_variants SET stock = stock - ? WHERE id=?`, it.Quantity, it.VariantID); err != nil {
+			return nil, nil, err
+		}
+		if err := s.syncStock(ctx, tx, it.ProductID, time.Time{}); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := allocateOrder(ctx, tx, o, allocationFrom(ctx), func(int) string { return "?" }); err != nil {
+		return nil, nil, err
+	}
+	if err := insertStockMovements(ctx, tx, orderStockMovements(o, -1, o.Reservation, stockActor(ctx, userID), now), func(int) string { return "?" }); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM cart_items WHERE user_id=?`, userID); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM carts WHERE user_id=?`, userID); err != nil {
+		return nil, nil, err
+	}
 
-		res = append(res, &models.Order{
-			ID:         id,
-			UserID:     userID,
-			Items:      items,
-			Amount:     amount,
-			Status:     status,
-			PaymentRef: pref,
-			CreatedAt:  created,
-		})
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
 	}
-	return res, nil
+	deductStock(products, o.Items)
+	return o, products, nil
+}
+
+func (s *MySQLStore) ListOrdersByUser(ctx context.Context, userID string, page Page) ([]*models.Order, string, error) {
+	return s.listOrders(ctx, page, []string{`user_id=?`}, []any{userID})
 }
 
-func (s *MySQLStore) UpdateOrderStatus(orderID, status string) error {
-	res, err := s.db.Exec(`UPDATE orders SET status=? WHERE id=?`, status, orderID)
+func (s *MySQLStore) ListOrders(ctx context.Context, page Page) ([]*models.Order, string, error) {
+	return s.listOrders(ctx, page, nil, nil)
+}
+
+func (s *MySQLStore) listOrders(ctx context.Context, page Page, where []string, args []any) ([]*models.Order, string, error) {
+	where, args, tail, err := pageQuery(page, where, args, func(int) string { return "?" })
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
-		return errors.New("order not found")
+	query := `SELECT ` + orderSelect + ` FROM orders`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
 	}
-	return nil
+	rows, err := s.db.QueryContext(ctx, query+tail, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	res := []*models.Order{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, "", err
+		}
+		res = append(res, o)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	res, next := cutPage(res, page.Limit, orderKey)
+	if err := loadOrderItems(ctx, s.db, res, func(int) string { return "?" }); err != nil {
+		return nil, "", err
+	}
+	return res, next, nil
 }
 
-func (s *MySQLStore) UpdateOrderPaymentRef(orderID, paymentRef string) error {
-	res, err := s.db.Exec(`UPDATE orders SET payment_ref=? WHERE id=?`, paymentRef, orderID)
+func (s *MySQLStore) UpdateOrderStatus(ctx context.Context, orderID, status string) error {
+	_, err := setOrderStatus(ctx, s.db, orderID, status, nil, func(int) string { return "?" })
+	return err
+}
+
+func (s *MySQLStore) ExpireReservations(ctx context.Context, heldBefore time.Time) (int, error) {
+	return expireReservations(ctx, s.db, heldBefore, func(int) string { return "?" })
+}
+
+func (s *MySQLStore) UpdateOrderPaymentRef(ctx context.Context, orderID, paymentRef string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE orders SET payment_ref=? WHERE id=?`, paymentRef, orderID)
 	if err != nil {
 		return err
 	}
@@ -882,9 +1392,37 @@ func (s *MySQLStore) UpdateOrderPaymentRef(orderID, paymentRef string) error {
 	return nil
 }
 
+func (s *MySQLStore) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	o, err := scanOrder(s.db.QueryRowContext(ctx, `SELECT `+orderSelect+` FROM orders WHERE id=?`, orderID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("order not found")
+		}
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT product_id, variant_id, quantity, price_cents FROM order_items WHERE order_id=?`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	o.Items = []models.CartItem{}
+	for rows.Next() {
+		var it models.CartItem
+		if err := rows.Scan(&it.ProductID, &it.VariantID, &it.Quantity, &it.PriceCents); err != nil {
+			return nil, err
+		}
+		o.Items = append(o.Items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
 // Reviews
 
-func (s *MySQLStore) CreateReview(userID, userName, userPhoto string, rating int, comment string) (*models.Review, error) {
+func (s *MySQLStore) CreateReview(ctx context.Context, userID, userName, userPhoto string, rating int, comment string) (*models.Review, error) {
 	if rating < 1 || rating > 5 {
 		return nil, errors.New("rating must be between 1 and 5")
 	}
@@ -892,7 +1430,7 @@ func (s *MySQLStore) CreateReview(userID, userName, userPhoto string, rating int
 	id := uuid.NewString()
 	now := time.Now()
 
-	_, err := s.db.Exec(
+	_, err := s.db.ExecContext(ctx,
 		`INSERT INTO reviews (id, user_id, user_name, user_photo, rating, comment, created_at) VALUES (?,?,?,?,?,?,?)`,
 		id, userID, userName, userPhoto, rating, comment, now,
 	)
@@ -911,17 +1449,19 @@ func (s *MySQLStore) CreateReview(userID, userName, userPhoto string, rating int
 	}, nil
 }
 
-func (s *MySQLStore) ListReviews(limit int) ([]*models.Review, error) {
-	query := `SELECT id, user_id, user_name, user_photo, rating, comment, created_at FROM reviews ORDER BY created_at DESC`
-	args := []any{}
-	if limit > 0 {
-		query += ` LIMIT ?`
-		args = append(args, limit)
+func (s *MySQLStore) ListReviews(ctx context.Context, page Page) ([]*models.Review, string, error) {
+	where, args, tail, err := pageQuery(page, nil, nil, func(int) string { return "?" })
+	if err != nil {
+		return nil, "", err
+	}
+	query := `SELECT id, user_id, user_name, user_photo, rating, comment, created_at FROM reviews`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
 	}
 
-	rows, err := s.db.Query(query, args...)
+	rows, err := s.db.QueryContext(ctx, query+tail, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
@@ -929,17 +1469,21 @@ func (s *MySQLStore) ListReviews(limit int) ([]*models.Review, error) {
 	for rows.Next() {
 		r := models.Review{}
 		if err := rows.Scan(&r.ID, &r.UserID, &r.UserName, &r.UserPhoto, &r.Rating, &r.Comment, &r.CreatedAt); err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		review := r
 		reviews = append(reviews, &review)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
 
-	return reviews, nil
+	reviews, next := cutPage(reviews, page.Limit, reviewKey)
+	return reviews, next, nil
 }
 
-func (s *MySQLStore) GetUserReviewCount(userID string) (int, error) {
-	row := s.db.QueryRow(`SELECT COUNT(*) FROM reviews WHERE user_id=?`, userID)
+func (s *MySQLStore) GetUserReviewCount(ctx context.Context, userID string) (int, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM reviews WHERE user_id=?`, userID)
 	var count int
 	if err := row.Scan(&count); err != nil {
 		return 0, err
@@ -947,6 +1491,39 @@ func (s *MySQLStore) GetUserReviewCount(userID string) (int, error) {
 	return count, nil
 }
 
+// Audit log
+
+func (s *MySQLStore) CreateAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	e.ID = uuid.NewString()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO audit_log (`+auditSelect+`) VALUES (?,?,?,?,?,?,?,?,?)`,
+		e.ID, e.ActorID, e.Action, e.EntityType, e.EntityID, nullJSON(e.Before), nullJSON(e.After), e.IP, e.CreatedAt,
+	)
+	return err
+}
+
+func (s *MySQLStore) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*models.AuditEntry, error) {
+	query, args := auditQuery(filter, func(int) string { return "?" })
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.AuditEntry{}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
 // helpers
 
 func isDuplicate(err error) bool {
@@ -0,0 +1,625 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/example/ecommerce-api/internal/migrate"
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+// mysqlMigrations is the ordered schema history for MySQLStore. Append new
+// versions; never edit one that has shipped.
+var mysqlMigrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up:      mysqlBaselineUp,
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS reviews`,
+			`DROP TABLE IF EXISTS order_items`,
+			`DROP TABLE IF EXISTS orders`,
+			`DROP TABLE IF EXISTS cart_items`,
+			`DROP TABLE IF EXISTS carts`,
+			`DROP TABLE IF EXISTS products`,
+			`DROP TABLE IF EXISTS password_resets`,
+			`DROP TABLE IF EXISTS email_verifications`,
+			`DROP TABLE IF EXISTS users`,
+		),
+	},
+	{
+		Version: 2,
+		Name:    "product_gallery_and_rating",
+		Up:      mysqlProductGalleryUp,
+		Down: migrate.Exec(
+			`ALTER TABLE products DROP COLUMN images, DROP COLUMN rating, DROP COLUMN review_count`,
+		),
+	},
+	{
+		Version: 3,
+		Name:    "product_archived_at",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN archived_at DATETIME NULL AFTER updated_at, ADD INDEX idx_archived_at (archived_at)`,
+		),
+		Down: migrate.Exec(
+			`ALTER TABLE products DROP INDEX idx_archived_at, DROP COLUMN archived_at`,
+		),
+	},
+	{
+		Version: 4,
+		Name:    "audit_log",
+		Up: migrate.Exec(
+			`CREATE TABLE IF NOT EXISTS audit_log (
+				id CHAR(36) PRIMARY KEY,
+				actor_id VARCHAR(64) NOT NULL,
+				action VARCHAR(64) NOT NULL,
+				entity_type VARCHAR(32) NOT NULL,
+				entity_id VARCHAR(64) NOT NULL DEFAULT '',
+				before_data TEXT NULL,
+				after_data TEXT NULL,
+				ip VARCHAR(45) NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				INDEX idx_audit_actor (actor_id, created_at),
+				INDEX idx_audit_entity (entity_type, entity_id, created_at),
+				INDEX idx_audit_created_at (created_at)
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+		),
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS audit_log`,
+		),
+	},
+	{
+		Version: 5,
+		Name:    "keyset_pagination_indexes",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD INDEX idx_products_created_id (created_at, id)`,
+			`ALTER TABLE orders ADD INDEX idx_orders_created_id (created_at, id), ADD INDEX idx_orders_user_created_id (user_id, created_at, id)`,
+			`ALTER TABLE reviews ADD INDEX idx_reviews_created_id (created_at, id)`,
+		),
+		Down: migrate.Exec(
+			`ALTER TABLE reviews DROP INDEX idx_reviews_created_id`,
+			`ALTER TABLE orders DROP INDEX idx_orders_user_created_id, DROP INDEX idx_orders_created_id`,
+			`ALTER TABLE products DROP INDEX idx_products_created_id`,
+		),
+	},
+	{
+		Version: 6,
+		Name:    "product_variants",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN options TEXT NULL AFTER images`,
+			`CREATE TABLE IF NOT EXISTS product_variants (
+				id CHAR(36) PRIMARY KEY,
+				product_id CHAR(36) NOT NULL,
+				sku VARCHAR(100) NOT NULL,
+				options TEXT NOT NULL,
+				price_cents BIGINT NULL,
+				stock INT NOT NULL,
+				image TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL,
+				INDEX idx_variants_product (product_id, created_at),
+				INDEX idx_variants_sku (sku),
+				FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+			`ALTER TABLE cart_items ADD COLUMN variant_id VARCHAR(36) NOT NULL DEFAULT '' AFTER product_id,
+				DROP PRIMARY KEY, ADD PRIMARY KEY (user_id, product_id, variant_id)`,
+			`ALTER TABLE order_items ADD COLUMN variant_id VARCHAR(36) NOT NULL DEFAULT '' AFTER product_id,
+				DROP PRIMARY KEY, ADD PRIMARY KEY (order_id, product_id, variant_id),
+				ADD INDEX idx_order_items_variant (variant_id)`,
+		),
+		// Orders holding two variants of one product cannot be folded back
+		// into the old key; the down migration fails on them rather than
+		// dropping order lines.
+		Down: migrate.Exec(
+			`ALTER TABLE order_items DROP PRIMARY KEY, ADD PRIMARY KEY (order_id, product_id), DROP COLUMN variant_id`,
+			`DELETE FROM cart_items WHERE variant_id <> ''`,
+			`ALTER TABLE cart_items DROP PRIMARY KEY, ADD PRIMARY KEY (user_id, product_id), DROP COLUMN variant_id`,
+			`DROP TABLE IF EXISTS product_variants`,
+			`ALTER TABLE products DROP COLUMN options`,
+		),
+	},
+	{
+		Version: 7,
+		Name:    "categories",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			err := migrate.Exec(
+				`CREATE TABLE IF NOT EXISTS categories (
+					id CHAR(36) PRIMARY KEY,
+					parent_id CHAR(36) NULL,
+					name VARCHAR(255) NOT NULL,
+					slug VARCHAR(255) NOT NULL,
+					sort_order INT NOT NULL DEFAULT 0,
+					image TEXT NOT NULL,
+					created_at DATETIME NOT NULL,
+					updated_at DATETIME NOT NULL,
+					UNIQUE KEY uq_categories_slug (slug),
+					INDEX idx_categories_parent (parent_id),
+					CONSTRAINT fk_categories_parent FOREIGN KEY (parent_id) REFERENCES categories(id) ON DELETE SET NULL
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				`ALTER TABLE products ADD COLUMN category_id CHAR(36) NULL AFTER category,
+					ADD INDEX idx_products_category (category_id),
+					ADD CONSTRAINT fk_products_category FOREIGN KEY (category_id) REFERENCES categories(id)`,
+			)(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return backfillCategories(ctx, tx, func(int) string { return "?" })
+		},
+		// Products keep their category names, so going down loses only the
+		// tree itself
+		Down: migrate.Exec(
+			`ALTER TABLE products DROP FOREIGN KEY fk_products_category`,
+			`ALTER TABLE products DROP COLUMN category_id`,
+			`DROP TABLE IF EXISTS categories`,
+		),
+	},
+	{
+		Version: 8,
+		Name:    "product_search",
+		// InnoDB builds one FULLTEXT index per statement. Boolean searches
+		// need an index over exactly the columns they match, hence two.
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD FULLTEXT INDEX ft_products_name (name)`,
+			`ALTER TABLE products ADD FULLTEXT INDEX ft_products_text (name, category, sku, description)`,
+		),
+		Down: migrate.Exec(
+			`ALTER TABLE products DROP INDEX ft_products_text`,
+			`ALTER TABLE products DROP INDEX ft_products_name`,
+		),
+	},
+	{
+		Version: 9,
+		Name:    "unique_skus",
+		Up:      mysqlUniqueSKUsUp,
+		// Renamed duplicates keep their new SKUs
+		Down: migrate.Exec(
+			`ALTER TABLE categories DROP COLUMN sku_prefix`,
+			`ALTER TABLE product_variants DROP INDEX uq_variants_sku, ADD INDEX idx_variants_sku (sku)`,
+			`ALTER TABLE products DROP INDEX uq_products_sku, ADD INDEX idx_sku (sku)`,
+		),
+	},
+	{
+		Version: 10,
+		Name:    "product_lifecycle",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published' AFTER archived_at,
+				ADD COLUMN publish_at DATETIME NULL AFTER status,
+				ADD COLUMN unpublish_at DATETIME NULL AFTER publish_at,
+				ADD INDEX idx_products_status (status)`,
+			`UPDATE products SET status = 'archived' WHERE archived_at IS NOT NULL`,
+		),
+		Down: migrate.Exec(
+			`ALTER TABLE products DROP INDEX idx_products_status, DROP COLUMN unpublish_at, DROP COLUMN publish_at, DROP COLUMN status`,
+		),
+	},
+	{
+		Version: 11,
+		Name:    "price_schedules",
+		// History starts empty; existing prices have no recorded origin
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN regular_price_cents BIGINT NULL AFTER price_cents`,
+			`CREATE TABLE IF NOT EXISTS price_history (
+				id CHAR(36) PRIMARY KEY,
+				product_id CHAR(36) NOT NULL,
+				old_price_cents BIGINT NOT NULL,
+				new_price_cents BIGINT NOT NULL,
+				reason VARCHAR(20) NOT NULL,
+				actor_id VARCHAR(64) NOT NULL DEFAULT '',
+				schedule_id VARCHAR(36) NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				INDEX idx_price_history_product (product_id, created_at),
+				FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+			`CREATE TABLE IF NOT EXISTS price_schedules (
+				id CHAR(36) PRIMARY KEY,
+				product_id CHAR(36) NOT NULL,
+				price_cents BIGINT NOT NULL,
+				starts_at DATETIME NOT NULL,
+				ends_at DATETIME NULL,
+				status VARCHAR(20) NOT NULL,
+				next_at DATETIME NULL,
+				created_by VARCHAR(64) NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				INDEX idx_price_schedules_product (product_id, starts_at),
+				INDEX idx_price_schedules_next (next_at),
+				FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+		),
+		// Running sales are not undone; their products keep the sale price
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS price_schedules`,
+			`DROP TABLE IF EXISTS price_history`,
+			`ALTER TABLE products DROP COLUMN regular_price_cents`,
+		),
+	},
+	{
+		Version: 12,
+		Name:    "order_reservations",
+		// Failed orders never gave their stock back; they are marked released
+		// as they are, without restocking
+		Up: migrate.Exec(
+			`ALTER TABLE orders
+				ADD COLUMN reservation VARCHAR(16) NOT NULL DEFAULT 'held' AFTER payment_ref,
+				ADD COLUMN reserved_at DATETIME NULL AFTER reservation`,
+			`UPDATE orders SET reserved_at = created_at,
+				reservation = CASE
+					WHEN LOWER(status) IN ('failed', 'canceled', 'expired') THEN 'released'
+					WHEN LOWER(status) IN ('paid', 'done', 'completed') THEN 'committed'
+					ELSE 'held'
+				END`,
+			`ALTER TABLE orders MODIFY reserved_at DATETIME NOT NULL`,
+			`CREATE INDEX idx_orders_reservation ON orders (reservation, reserved_at)`,
+		),
+		Down: migrate.Exec(
+			`DROP INDEX idx_orders_reservation ON orders`,
+			`ALTER TABLE orders DROP COLUMN reserved_at, DROP COLUMN reservation`,
+		),
+	},
+	{
+		Version: 13,
+		Name:    "stock_movements",
+		// Current stock becomes each product's opening balance
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			err := migrate.Exec(
+				`CREATE TABLE IF NOT EXISTS stock_movements (
+					id CHAR(36) PRIMARY KEY,
+					product_id CHAR(36) NOT NULL,
+					variant_id VARCHAR(36) NOT NULL DEFAULT '',
+					delta INT NOT NULL,
+					reason VARCHAR(32) NOT NULL,
+					reference_id VARCHAR(64) NOT NULL DEFAULT '',
+					actor_id VARCHAR(64) NOT NULL DEFAULT '',
+					note VARCHAR(255) NOT NULL DEFAULT '',
+					created_at DATETIME NOT NULL,
+					INDEX idx_stock_movements_product (product_id, created_at),
+					FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+			)(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return backfillStockLedger(ctx, tx, func(int) string { return "?" })
+		},
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS stock_movements`,
+		),
+	},
+	{
+		Version: 14,
+		Name:    "stock_locations",
+		// Existing stock, and the ledger behind it, moves to a default
+		// location. Orders placed before have no allocations and put stock
+		// back at the default location.
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			err := migrate.Exec(
+				`CREATE TABLE IF NOT EXISTS locations (
+					id CHAR(36) PRIMARY KEY,
+					name VARCHAR(255) NOT NULL,
+					code VARCHAR(50) NOT NULL DEFAULT '',
+					priority INT NOT NULL DEFAULT 0,
+					latitude DOUBLE NULL,
+					longitude DOUBLE NULL,
+					created_at DATETIME NOT NULL,
+					updated_at DATETIME NOT NULL
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				`CREATE TABLE IF NOT EXISTS stock_levels (
+					location_id CHAR(36) NOT NULL,
+					product_id CHAR(36) NOT NULL,
+					variant_id VARCHAR(36) NOT NULL DEFAULT '',
+					stock INT NOT NULL,
+					PRIMARY KEY (location_id, product_id, variant_id),
+					INDEX idx_stock_levels_product (product_id, variant_id),
+					FOREIGN KEY (location_id) REFERENCES locations(id),
+					FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+				`ALTER TABLE stock_movements ADD COLUMN location_id VARCHAR(36) NOT NULL DEFAULT '' AFTER variant_id`,
+				`ALTER TABLE order_items ADD COLUMN allocations TEXT NULL`,
+			)(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return backfillLocations(ctx, tx, func(int) string { return "?" })
+		},
+		Down: migrate.Exec(
+			`ALTER TABLE order_items DROP COLUMN allocations`,
+			`ALTER TABLE stock_movements DROP COLUMN location_id`,
+			`DROP TABLE IF EXISTS stock_levels`,
+			`DROP TABLE IF EXISTS locations`,
+		),
+	},
+	{
+		Version: 15,
+		Name:    "stock_subscriptions",
+		Up: migrate.Exec(
+			`CREATE TABLE IF NOT EXISTS stock_subscriptions (
+				user_id CHAR(36) NOT NULL,
+				product_id CHAR(36) NOT NULL,
+				variant_id VARCHAR(36) NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				PRIMARY KEY (user_id, product_id, variant_id),
+				INDEX idx_stock_subscriptions_product (product_id),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+		),
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS stock_subscriptions`,
+		),
+	},
+	{
+		Version: 16,
+		Name:    "low_stock_threshold",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN low_stock_threshold INT NULL AFTER unpublish_at`,
+		),
+		Down: migrate.Exec(
+			`ALTER TABLE products DROP COLUMN low_stock_threshold`,
+		),
+	},
+	{
+		Version: 17,
+		Name:    "guest_carts",
+		Up: migrate.Exec(
+			`CREATE TABLE IF NOT EXISTS guest_carts (
+				id CHAR(36) PRIMARY KEY,
+				updated_at DATETIME NOT NULL,
+				INDEX idx_guest_carts_updated (updated_at)
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+			`CREATE TABLE IF NOT EXISTS guest_cart_items (
+				guest_id CHAR(36) NOT NULL,
+				product_id CHAR(36) NOT NULL,
+				variant_id VARCHAR(36) NOT NULL DEFAULT '',
+				quantity INT NOT NULL,
+				PRIMARY KEY (guest_id, product_id, variant_id),
+				FOREIGN KEY (guest_id) REFERENCES guest_carts(id) ON DELETE CASCADE,
+				FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+		),
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS guest_cart_items`,
+			`DROP TABLE IF EXISTS guest_carts`,
+		),
+	},
+	{
+		Version: 18,
+		Name:    "product_archived_status",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN archived_status VARCHAR(20) NULL AFTER status`,
+		),
+		Down: migrate.Exec(
+			`ALTER TABLE products DROP COLUMN archived_status`,
+		),
+	},
+}
+
+// mysqlUniqueSKUsUp renames duplicate SKUs, then swaps the plain SKU indexes
+// for unique ones. Databases adopted from setup.sql may lack idx_sku.
+func mysqlUniqueSKUsUp(ctx context.Context, tx *sql.Tx) error {
+	placeholder := func(int) string { return "?" }
+	if err := dedupeSKUs(ctx, tx, "products", placeholder); err != nil {
+		return err
+	}
+	if err := dedupeSKUs(ctx, tx, "product_variants", placeholder); err != nil {
+		return err
+	}
+
+	var n int
+	err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'products' AND INDEX_NAME = 'idx_sku'`,
+	).Scan(&n)
+	if err != nil {
+		return err
+	}
+	products := `ALTER TABLE products ADD UNIQUE KEY uq_products_sku (sku)`
+	if n > 0 {
+		products += `, DROP INDEX idx_sku`
+	}
+	return migrate.Exec(
+		products,
+		`ALTER TABLE product_variants DROP INDEX idx_variants_sku, ADD UNIQUE KEY uq_variants_sku (sku)`,
+		`ALTER TABLE categories ADD COLUMN sku_prefix VARCHAR(20) NOT NULL DEFAULT '' AFTER slug`,
+	)(ctx, tx)
+}
+
+// mysqlBaselineUp creates the original schema. Tables use IF NOT EXISTS and
+// legacy columns are added only when missing, so databases created by the
+// old auto-migration (or setup.sql) are adopted in place.
+func mysqlBaselineUp(ctx context.Context, tx *sql.Tx) error {
+	err := migrate.Exec(
+		`CREATE TABLE IF NOT EXISTS users (
+			id CHAR(36) PRIMARY KEY,
+			full_name VARCHAR(255) NOT NULL DEFAULT '',
+			phone VARCHAR(50) NOT NULL DEFAULT '',
+			email VARCHAR(255) UNIQUE NOT NULL,
+			password_hash VARCHAR(255) NOT NULL,
+			role VARCHAR(20) NOT NULL DEFAULT 'user',
+			auth_provider VARCHAR(30) NOT NULL DEFAULT 'email',
+			google_id VARCHAR(255) NOT NULL DEFAULT '',
+			email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at DATETIME NOT NULL,
+			INDEX idx_email (email)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+
+		`CREATE TABLE IF NOT EXISTS email_verifications (
+			token CHAR(36) PRIMARY KEY,
+			user_id CHAR(36) NOT NULL,
+			expires_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			INDEX idx_user_id (user_id),
+			INDEX idx_expires_at (expires_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+
+		`CREATE TABLE IF NOT EXISTS password_resets (
+			token CHAR(36) PRIMARY KEY,
+			user_id CHAR(36) NOT NULL,
+			expires_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			INDEX idx_user_id (user_id),
+			INDEX idx_expires_at (expires_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+
+		`CREATE TABLE IF NOT EXISTS products (
+			id CHAR(36) PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			description TEXT,
+			category VARCHAR(100),
+			price_cents BIGINT NOT NULL,
+			sku VARCHAR(100) NOT NULL,
+			stock INT NOT NULL,
+			thumbnail TEXT,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			INDEX idx_sku (sku),
+			INDEX idx_name (name),
+			INDEX idx_category (category)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+
+		`CREATE TABLE IF NOT EXISTS carts (
+			user_id CHAR(36) PRIMARY KEY,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+
+		`CREATE TABLE IF NOT EXISTS cart_items (
+			user_id CHAR(36) NOT NULL,
+			product_id CHAR(36) NOT NULL,
+			quantity INT NOT NULL,
+			PRIMARY KEY (user_id, product_id),
+			FOREIGN KEY (user_id) REFERENCES carts(user_id) ON DELETE CASCADE,
+			FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+
+		`CREATE TABLE IF NOT EXISTS orders (
+			id CHAR(36) PRIMARY KEY,
+			user_id CHAR(36) NOT NULL,
+			amount_cents BIGINT NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			payment_ref VARCHAR(255) NOT NULL,
+			created_at DATETIME NOT NULL,
+			INDEX idx_user_id (user_id),
+			INDEX idx_created_at (created_at),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+
+		`CREATE TABLE IF NOT EXISTS order_items (
+			order_id CHAR(36) NOT NULL,
+			product_id CHAR(36) NOT NULL,
+			quantity INT NOT NULL,
+			price_cents BIGINT NOT NULL,
+			PRIMARY KEY (order_id, product_id),
+			FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+			FOREIGN KEY (product_id) REFERENCES products(id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+
+		`CREATE TABLE IF NOT EXISTS reviews (
+			id CHAR(36) PRIMARY KEY,
+			user_id CHAR(36) NOT NULL,
+			user_name VARCHAR(255) NOT NULL,
+			user_photo TEXT,
+			rating INT NOT NULL,
+			comment TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			INDEX idx_reviews_created_at (created_at),
+			INDEX idx_reviews_user_id (user_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;`,
+	)(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	legacy := []struct{ table, column, ddl string }{
+		{"users", "full_name", `ALTER TABLE users ADD COLUMN full_name VARCHAR(255) NOT NULL DEFAULT '' AFTER id`},
+		{"users", "phone", `ALTER TABLE users ADD COLUMN phone VARCHAR(50) NOT NULL DEFAULT '' AFTER full_name`},
+		{"users", "role", `ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user' AFTER password_hash`},
+		{"users", "email_verified", `ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE AFTER role`},
+		{"users", "auth_provider", `ALTER TABLE users ADD COLUMN auth_provider VARCHAR(30) NOT NULL DEFAULT 'email' AFTER role`},
+		{"users", "google_id", `ALTER TABLE users ADD COLUMN google_id VARCHAR(255) NOT NULL DEFAULT '' AFTER auth_provider`},
+		{"products", "category", `ALTER TABLE products ADD COLUMN category VARCHAR(100) AFTER description`},
+		{"products", "thumbnail", `ALTER TABLE products ADD COLUMN thumbnail TEXT AFTER stock`},
+	}
+	for _, l := range legacy {
+		if err := mysqlAddColumnIfMissing(ctx, tx, l.table, l.column, l.ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mysqlColumnExists(ctx context.Context, tx *sql.Tx, table, column string) (bool, error) {
+	var n int
+	err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`,
+		table, column,
+	).Scan(&n)
+	return n > 0, err
+}
+
+func mysqlAddColumnIfMissing(ctx context.Context, tx *sql.Tx, table, column, ddl string) error {
+	ok, err := mysqlColumnExists(ctx, tx, table, column)
+	if err != nil || ok {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, ddl)
+	return err
+}
+
+// mysqlProductGalleryUp adds the gallery and rating columns that setup.sql
+// already had, then rewrites comma-separated image lists as JSON arrays.
+func mysqlProductGalleryUp(ctx context.Context, tx *sql.Tx) error {
+	cols := []struct{ column, ddl string }{
+		{"images", `ALTER TABLE products ADD COLUMN images TEXT AFTER thumbnail`},
+		{"rating", `ALTER TABLE products ADD COLUMN rating DECIMAL(3,2) NOT NULL DEFAULT 0 AFTER images`},
+		{"review_count", `ALTER TABLE products ADD COLUMN review_count INT NOT NULL DEFAULT 0 AFTER rating`},
+	}
+	for _, c := range cols {
+		if err := mysqlAddColumnIfMissing(ctx, tx, "products", c.column, c.ddl); err != nil {
+			return err
+		}
+	}
+
+	err := migrate.Exec(
+		`UPDATE products SET rating = 0 WHERE rating IS NULL`,
+		`UPDATE products SET review_count = 0 WHERE review_count IS NULL`,
+		`ALTER TABLE products MODIFY rating DECIMAL(3,2) NOT NULL DEFAULT 0, MODIFY review_count INT NOT NULL DEFAULT 0`,
+	)(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, COALESCE(images, '') FROM products WHERE images IS NULL OR images NOT LIKE '[%'`)
+	if err != nil {
+		return err
+	}
+	legacy := map[string]string{}
+	for rows.Next() {
+		var id, raw string
+		if err := rows.Scan(&id, &raw); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		legacy[id] = raw
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, raw := range legacy {
+		images := []models.ProductImage{}
+		for _, u := range strings.Split(raw, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				images = append(images, models.ProductImage{URL: u})
+			}
+		}
+		encoded, err := encodeImages(images)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE products SET images=? WHERE id=?`, encoded, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
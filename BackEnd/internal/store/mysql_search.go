@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/example/ecommerce-api/internal/search"
+)
+
+// mysqlMinWord is InnoDB's default innodb_ft_min_token_size; shorter words
+// never make it into a FULLTEXT index
+const mysqlMinWord = 3
+
+// mysqlIndex searches the FULLTEXT indexes on products. A name match counts
+// three times on top of the match over all searchable columns.
+type mysqlIndex struct {
+	*sqlVocabulary
+}
+
+func (ix mysqlIndex) Search(ctx context.Context, query string, limit int) ([]search.Hit, error) {
+	vocab, err := ix.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	against, short := mysqlBooleanQuery(search.Expand(query, vocab))
+	if against == "" && len(short) == 0 {
+		return []search.Hit{}, nil
+	}
+
+	score := `1`
+	where := []string{}
+	args := []any{}
+	if against != "" {
+		score = `MATCH(name) AGAINST(? IN BOOLEAN MODE) * 3 + MATCH(name, category, sku, description) AGAINST(? IN BOOLEAN MODE)`
+		where = append(where, `MATCH(name, category, sku, description) AGAINST(? IN BOOLEAN MODE)`)
+		args = append(args, against, against, against)
+	}
+	// Words too short for the index are looked for as plain substrings
+	for _, w := range short {
+		where = append(where, `LOWER(CONCAT_WS(' ', name, sku, category, description)) LIKE ?`)
+		args = append(args, "%"+w+"%")
+	}
+	args = append(args, limit)
+
+	rows, err := ix.db.QueryContext(ctx,
+		`SELECT id, `+score+` AS score FROM products WHERE `+strings.Join(where, ` AND `)+` ORDER BY score DESC, id LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return searchScores(rows)
+}
+
+// mysqlBooleanQuery requires one match per query word, the word as typed
+// ranking above its stem and synonyms as prefixes, and typo corrections
+// below those. Words shorter than mysqlMinWord are returned apart.
+func mysqlBooleanQuery(expansions []search.Expansion) (against string, short []string) {
+	groups := make([]string, 0, len(expansions))
+	for _, e := range expansions {
+		if utf8.RuneCountInString(e.Word) < mysqlMinWord {
+			short = append(short, e.Word)
+			continue
+		}
+		alts := []string{">" + e.Word, e.Stem + "*"}
+		for _, s := range e.Synonyms {
+			alts = append(alts, s+"*")
+		}
+		for _, w := range e.Typos {
+			alts = append(alts, "<"+w)
+		}
+		groups = append(groups, "+("+strings.Join(alts, " ")+")")
+	}
+	return strings.Join(groups, " "), short
+}
@@ -0,0 +1,46 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/example/ecommerce-api/internal/store"
+	"github.com/example/ecommerce-api/internal/store/storetest"
+)
+
+// TestMySQLStoreConformance runs against the database in MYSQL_TEST_DSN, e.g.
+//
+//	docker run --rm -d -p 3307:3306 -e MYSQL_ROOT_PASSWORD=test -e MYSQL_DATABASE=ecommerce_test mysql:8
+//	MYSQL_TEST_DSN='root:test@tcp(127.0.0.1:3307)/ecommerce_test?parseTime=true' go test ./internal/store/...
+//
+// The database is migrated and truncated, so never point it at real data.
+// CI runs it against a service container; see .github/workflows/backend.yml.
+func TestMySQLStoreConformance(t *testing.T) {
+	dsn := sqlTestDSN(t, "MYSQL_TEST_DSN")
+
+	m, db, err := store.NewMigrator("mysql", dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		for _, tbl := range sqlTables {
+			if _, err := db.ExecContext(ctx, `DELETE FROM `+tbl); err != nil {
+				t.Fatalf("clear %s: %v", tbl, err)
+			}
+		}
+
+		st, err := store.NewMySQLStore(dsn)
+		if err != nil {
+			t.Fatalf("NewMySQLStore: %v", err)
+		}
+		t.Cleanup(func() { _ = st.Close() })
+		return st
+	})
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+// Page selects one page of a list. Lists are ordered newest first by
+// (created_at, id), which stays stable while rows are being added.
+type Page struct {
+	Cursor string // next cursor of the previous page; empty for the first page
+	Limit  int    // 0 returns everything after the cursor
+}
+
+// cursor is the position of the last row on a page. It is handed to clients
+// base64-encoded and treated as opaque by them.
+type cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+func encodeCursor(createdAt time.Time, id string) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor returns nil for an empty cursor and ErrInvalidCursor for one
+// this package did not produce
+func decodeCursor(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return parseCursor(string(raw))
+}
+
+func parseCursor(raw string) (*cursor, error) {
+	ts, id, ok := strings.Cut(raw, "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// follows reports whether a row at (createdAt, id) is older than the cursor
+// and so belongs on a later page. Every row follows a nil cursor.
+func (c *cursor) follows(createdAt time.Time, id string) bool {
+	if c == nil {
+		return true
+	}
+	return createdAt.Before(c.CreatedAt) || createdAt.Equal(c.CreatedAt) && id < c.ID
+}
+
+// sortCursor is the position of the last row on a page of a list ordered by
+// a value first, such as a price; (created_at, id) break ties
+type sortCursor struct {
+	Value float64
+	cursor
+}
+
+func encodeSortCursor(value float64, createdAt time.Time, id string) string {
+	raw := strconv.FormatFloat(value, 'f', -1, 64) + "|" + createdAt.UTC().Format(time.RFC3339Nano) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeSortCursor is decodeCursor for sorted lists. Cursors of one kind
+// are rejected by the other.
+func decodeSortCursor(s string) (*sortCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	v, rest, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	value, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, ErrInvalidCursor
+	}
+	c, err := parseCursor(rest)
+	if err != nil {
+		return nil, err
+	}
+	return &sortCursor{Value: value, cursor: *c}, nil
+}
+
+// follows reports whether a row belongs after the cursor in a list sorted
+// by value, ascending or descending, then newest first
+func (c *sortCursor) follows(value float64, createdAt time.Time, id string, asc bool) bool {
+	if c == nil {
+		return true
+	}
+	if value != c.Value {
+		if asc {
+			return value > c.Value
+		}
+		return value < c.Value
+	}
+	return c.cursor.follows(createdAt, id)
+}
+
+// pageQuery adds the keyset condition for page to where and args and returns
+// the ORDER BY / LIMIT tail. One row beyond the limit is fetched so that
+// cutPage can tell whether there is another page.
+func pageQuery(page Page, where []string, args []any, placeholder func(n int) string) ([]string, []any, string, error) {
+	c, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if c != nil {
+		args = append(args, c.CreatedAt, c.CreatedAt, c.ID)
+		n := len(args)
+		where = append(where, fmt.Sprintf(`(created_at < %s OR (created_at = %s AND id < %s))`,
+			placeholder(n-2), placeholder(n-1), placeholder(n)))
+	}
+	tail := ` ORDER BY created_at DESC, id DESC`
+	if page.Limit > 0 {
+		args = append(args, page.Limit+1)
+		tail += ` LIMIT ` + placeholder(len(args))
+	}
+	return where, args, tail, nil
+}
+
+// cutPage trims rows fetched with one extra row back to the limit and
+// returns the cursor of the next page, or "" on the last page
+func cutPage[T any](rows []T, limit int, key func(T) (time.Time, string)) ([]T, string) {
+	if limit <= 0 || len(rows) <= limit {
+		return rows, ""
+	}
+	rows = rows[:limit]
+	createdAt, id := key(rows[limit-1])
+	return rows, encodeCursor(createdAt, id)
+}
+
+// pageSlice applies page to rows already held in memory
+func pageSlice[T any](rows []T, page Page, key func(T) (time.Time, string)) ([]T, string, error) {
+	c, err := decodeCursor(page.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		ti, idi := key(rows[i])
+		tj, idj := key(rows[j])
+		return (&cursor{CreatedAt: ti, ID: idi}).follows(tj, idj)
+	})
+
+	out := rows[:0]
+	for _, r := range rows {
+		if createdAt, id := key(r); c.follows(createdAt, id) {
+			out = append(out, r)
+		}
+	}
+	rows, next := cutPage(out, page.Limit, key)
+	return rows, next, nil
+}
+
+// sortedPageSlice is pageSlice for rows ordered by value first
+func sortedPageSlice[T any](rows []T, page Page, asc bool, value func(T) float64, key func(T) (time.Time, string)) ([]T, string, error) {
+	c, err := decodeSortCursor(page.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		ti, idi := key(rows[i])
+		tj, idj := key(rows[j])
+		return (&sortCursor{Value: value(rows[i]), cursor: cursor{CreatedAt: ti, ID: idi}}).follows(value(rows[j]), tj, idj, asc)
+	})
+
+	out := rows[:0]
+	for _, r := range rows {
+		if createdAt, id := key(r); c.follows(value(r), createdAt, id, asc) {
+			out = append(out, r)
+		}
+	}
+	rows, next := cutSortedPage(out, page.Limit, value, key)
+	return rows, next, nil
+}
+
+// cutSortedPage is cutPage for rows ordered by value first
+func cutSortedPage[T any](rows []T, limit int, value func(T) float64, key func(T) (time.Time, string)) ([]T, string) {
+	if limit <= 0 || len(rows) <= limit {
+		return rows, ""
+	}
+	rows = rows[:limit]
+	createdAt, id := key(rows[limit-1])
+	return rows, encodeSortCursor(value(rows[limit-1]), createdAt, id)
+}
+
+func productKey(p *models.Product) (time.Time, string) { return p.CreatedAt, p.ID }
+func orderKey(o *models.Order) (time.Time, string)     { return o.CreatedAt, o.ID }
+func reviewKey(r *models.Review) (time.Time, string)   { return r.CreatedAt, r.ID }
+func stockMovementKey(m *models.StockMovement) (time.Time, string) {
+	return m.CreatedAt, m.ID
+}
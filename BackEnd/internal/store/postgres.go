@@ -0,0 +1,1693 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/example/ecommerce-api/internal/migrate"
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/search"
+)
+
+type PostgresStore struct {
+	db     *sql.DB
+	search search.Index
+	prices *priceClock
+}
+
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := openPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := migrate.New(db, migrate.Postgres, postgresMigrations).Check(ctx); err != nil {
+		return nil, err
+	}
+	if err := ensureLocation(ctx, db, pgPlaceholder); err != nil {
+		return nil, err
+	}
+
+	return &PostgresStore{db: db, search: pgIndex{&sqlVocabulary{db: db}}, prices: &priceClock{}}, nil
+}
+
+// Close releases the underlying connection pool
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+func openPostgres(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PostgreSQL connection: %w", err)
+	}
+
+	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to ping PostgreSQL: %w", err)
+	}
+	return db, nil
+}
+
+// Users
+
+func (s *PostgresStore) CreateUser(ctx context.Context, fullName, phone, email, passwordHash, role, provider, googleID string, emailVerified bool) (*models.User, error) {
+	id := uuid.NewString()
+	now := time.Now()
+	if role == "" {
+		role = "user"
+	}
+	if provider == "" {
+		provider = "email"
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (id, full_name, phone, email, password_hash, role, auth_provider, google_id, email_verified, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		id, fullName, phone, email, passwordHash, role, provider, googleID, emailVerified, now,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, errors.New("email already registered")
+		}
+		return nil, err
+	}
+
+	return &models.User{
+		ID:            id,
+		FullName:      fullName,
+		Phone:         phone,
+		Email:         email,
+		Password:      passwordHash,
+		Role:          role,
+		AuthProvider:  provider,
+		GoogleID:      googleID,
+		EmailVerified: emailVerified,
+		CreatedAt:     now,
+	}, nil
+}
+
+func (s *PostgresStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, full_name, phone, email, password_hash, role, auth_provider, google_id, email_verified, created_at FROM users WHERE email=$1`,
+		email,
+	)
+
+	u := models.User{}
+	if err := row.Scan(&u.ID, &u.FullName, &u.Phone, &u.Email, &u.Password, &u.Role, &u.AuthProvider, &u.GoogleID, &u.EmailVerified, &u.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *PostgresStore) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	if !isUUID(id) {
+		return nil, errors.New("user not found")
+	}
+
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, full_name, phone, email, password_hash, role, auth_provider, google_id, email_verified, created_at FROM users WHERE id=$1`,
+		id,
+	)
+
+	u := models.User{}
+	if err := row.Scan(&u.ID, &u.FullName, &u.Phone, &u.Email, &u.Password, &u.Role, &u.AuthProvider, &u.GoogleID, &u.EmailVerified, &u.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *PostgresStore) ListUsersByRole(ctx context.Context, role string) ([]*models.User, error) {
+	return queryUsersByRole(ctx, s.db, role, pgPlaceholder)
+}
+
+func (s *PostgresStore) SeedAdminUser(ctx context.Context, email, passwordHash string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (id, full_name, phone, email, password_hash, role, auth_provider, google_id, email_verified, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+		ON CONFLICT (email) DO NOTHING`,
+		uuid.NewString(), "Administrator", "", email, passwordHash, "admin", "email", "", true, time.Now(),
+	)
+	return err
+}
+
+func (s *PostgresStore) UpdateUserPassword(ctx context.Context, userID, newPasswordHash string) error {
+	if !isUUID(userID) {
+		return errors.New("user not found")
+	}
+
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET password_hash=$1 WHERE id=$2`, newPasswordHash, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) MarkEmailVerified(ctx context.Context, userID string) error {
+	if !isUUID(userID) {
+		return errors.New("user not found")
+	}
+
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET email_verified=TRUE WHERE id=$1`, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// Email Verification
+
+func (s *PostgresStore) CreateEmailVerification(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO email_verifications (token, user_id, expires_at) VALUES ($1,$2,$3)`,
+		token, userID, expiresAt,
+	)
+	return err
+}
+
+func (s *PostgresStore) GetEmailVerification(ctx context.Context, token string) (*models.EmailVerification, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT token, user_id, expires_at FROM email_verifications WHERE token=$1`,
+		token,
+	)
+
+	v := models.EmailVerification{}
+	if err := row.Scan(&v.Token, &v.UserID, &v.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("verification token not found")
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *PostgresStore) GetLatestEmailVerificationByUser(ctx context.Context, userID string) (*models.EmailVerification, error) {
+	if !isUUID(userID) {
+		return nil, errors.New("verification token not found")
+	}
+
+	row := s.db.QueryRowContext(ctx,
+		`SELECT token, user_id, expires_at FROM email_verifications WHERE user_id=$1 ORDER BY expires_at DESC LIMIT 1`,
+		userID,
+	)
+
+	v := models.EmailVerification{}
+	if err := row.Scan(&v.Token, &v.UserID, &v.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("verification token not found")
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *PostgresStore) DeleteEmailVerification(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM email_verifications WHERE token=$1`, token)
+	return err
+}
+
+// Password Reset
+
+func (s *PostgresStore) CreatePasswordReset(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO password_resets (token, user_id, expires_at) VALUES ($1,$2,$3)`,
+		token, userID, expiresAt,
+	)
+	return err
+}
+
+func (s *PostgresStore) GetPasswordReset(ctx context.Context, token string) (*models.PasswordReset, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT token, user_id, expires_at FROM password_resets WHERE token=$1`,
+		token,
+	)
+
+	r := models.PasswordReset{}
+	if err := row.Scan(&r.Token, &r.UserID, &r.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("reset token not found")
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *PostgresStore) DeletePasswordReset(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM password_resets WHERE token=$1`, token)
+	return err
+}
+
+// Products
+
+func (s *PostgresStore) CreateProduct(ctx context.Context, p *models.Product) (*models.Product, error) {
+	images, err := encodeImages(p.Images)
+	if err != nil {
+		return nil, err
+	}
+	options, err := encodeOptions(p.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	p.ID = uuid.NewString()
+	now := time.Now()
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	if p.Options == nil {
+		p.Options = []models.ProductOption{}
+	}
+	if p.Status == "" {
+		p.Status = models.ProductPublished
+	}
+	// Variants are added one by one through CreateVariant
+	p.Variants = []models.ProductVariant{}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO products (id, name, description, category, category_id, price_cents, sku, stock, thumbnail, images, options, rating, review_count, created_at, updated_at, status, publish_at, unpublish_at, low_stock_threshold)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19)`,
+		p.ID, p.Name, p.Description, p.Category, nullID(p.CategoryID), p.PriceCents, p.SKU, p.Stock, p.Thumbnail, images, options, p.Rating, p.ReviewCount, p.CreatedAt, p.UpdatedAt, p.Status, p.PublishAt, p.UnpublishAt, nullInt(p.LowStockThreshold),
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, ErrSKUTaken
+		}
+		return nil, err
+	}
+	if err := insertPriceChange(ctx, tx, newPriceChange(p.ID, 0, p.BasePrice(), models.PriceCreated, actorFrom(ctx), "", now), pgPlaceholder); err != nil {
+		return nil, err
+	}
+	if err := insertStockMovements(ctx, tx, stockChanges(p.ID, nil, stockLevels(p), models.StockRestock, actorFrom(ctx), "", now), pgPlaceholder); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.search.Put(p)
+	return p, nil
+}
+
+func (s *PostgresStore) UpdateProduct(ctx context.Context, id string, updateFn func(p *models.Product) error) (*models.Product, error) {
+	if !isUUID(id) {
+		return nil, errors.New("product not found")
+	}
+
+	if err := s.prices.settle(ctx, s.db, pgPlaceholder); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	p, err := scanProduct(tx.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE id=$1 FOR UPDATE`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("product not found")
+		}
+		return nil, err
+	}
+
+	if err := loadVariants(ctx, tx, []*models.Product{p}, pgPlaceholder, false); err != nil {
+		return nil, err
+	}
+
+	before, levels := p.BasePrice(), stockLevels(p)
+	variants := p.Variants
+	if err := updateFn(p); err != nil {
+		return nil, err
+	}
+	if p.Stock < 0 {
+		return nil, ErrInsufficientStock
+	}
+	p.Variants = variants
+	rollupStock(p)
+
+	images, err := encodeImages(p.Images)
+	if err != nil {
+		return nil, err
+	}
+	options, err := encodeOptions(p.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	p.UpdatedAt = time.Now()
+	_, err = tx.ExecContext(ctx,
+		`UPDATE products SET name=$1, description=$2, category=$3, category_id=$4, price_cents=$5, regular_price_cents=$6, sku=$7, stock=$8, thumbnail=$9, images=$10, options=$11, rating=$12, review_count=$13, updated_at=$14, status=$15, publish_at=$16, unpublish_at=$17, low_stock_threshold=$18 WHERE id=$19`,
+		p.Name, p.Description, p.Category, nullID(p.CategoryID), p.PriceCents, nullPrice(p.RegularPriceCents), p.SKU, p.Stock, p.Thumbnail, images, options, p.Rating, p.ReviewCount, p.UpdatedAt, p.Status, p.PublishAt, p.UnpublishAt, nullInt(p.LowStockThreshold), p.ID,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, ErrSKUTaken
+		}
+		return nil, err
+	}
+	if err := insertPriceChange(ctx, tx, newPriceChange(p.ID, before, p.BasePrice(), models.PriceManual, actorFrom(ctx), "", p.UpdatedAt), pgPlaceholder); err != nil {
+		return nil, err
+	}
+	if err := insertStockMovements(ctx, tx, stockChanges(p.ID, levels, stockLevels(p), models.StockAdjustment, actorFrom(ctx), "", p.UpdatedAt), pgPlaceholder); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.search.Put(p)
+	return p, nil
+}
+
+func (s *PostgresStore) ArchiveProduct(ctx context.Context, id string) error {
+	p, err := s.GetProduct(ctx, id)
+	if err != nil || p.ArchivedAt != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = s.db.ExecContext(ctx, `UPDATE products SET archived_at=$1, archived_status=status, status=$2, updated_at=$1 WHERE id=$3`, now, models.ProductArchived, id)
+	return err
+}
+
+func (s *PostgresStore) RestoreProduct(ctx context.Context, id string) error {
+	p, err := s.GetProduct(ctx, id)
+	if err != nil || p.ArchivedAt == nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE products SET archived_at=NULL, status=COALESCE(archived_status, $1), archived_status=NULL, updated_at=$2 WHERE id=$3`, models.ProductDraft, time.Now(), id)
+	return err
+}
+
+func (s *PostgresStore) PurgeProduct(ctx context.Context, id string) error {
+	if !isUUID(id) {
+		return errors.New("product not found")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var ordered int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM order_items WHERE product_id=$1`, id).Scan(&ordered); err != nil {
+		return err
+	}
+	if ordered > 0 {
+		return ErrProductInUse
+	}
+
+	// cart_items rows go with it through ON DELETE CASCADE
+	res, err := tx.ExecContext(ctx, `DELETE FROM products WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return errors.New("product not found")
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) GetProductBySKU(ctx context.Context, sku string) (*models.Product, string, error) {
+	productID, variantID, err := productIDBySKU(ctx, s.db, sku, pgPlaceholder)
+	if err != nil {
+		return nil, "", err
+	}
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, "", err
+	}
+	return p, variantID, nil
+}
+
+func (s *PostgresStore) GetProduct(ctx context.Context, id string) (*models.Product, error) {
+	if !isUUID(id) {
+		return nil, errors.New("product not found")
+	}
+	if err := s.prices.settle(ctx, s.db, pgPlaceholder); err != nil {
+		return nil, err
+	}
+
+	p, err := scanProduct(s.db.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE id=$1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("product not found")
+		}
+		return nil, err
+	}
+	if err := loadVariants(ctx, s.db, []*models.Product{p}, pgPlaceholder, false); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *PostgresStore) ListProducts(ctx context.Context, filter ProductFilter, page Page) ([]*models.Product, string, error) {
+	if err := s.prices.settle(ctx, s.db, pgPlaceholder); err != nil {
+		return nil, "", err
+	}
+	filter.CategoryIDs = pgIDs(filter.CategoryIDs)
+	hits, err := searchHits(ctx, s.search, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	res, next, err := queryProducts(ctx, s.db, filter, hits, page, pgPlaceholder)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := loadVariants(ctx, s.db, res, pgPlaceholder, false); err != nil {
+		return nil, "", err
+	}
+	return res, next, nil
+}
+
+func (s *PostgresStore) ProductFacets(ctx context.Context, filter ProductFilter, priceBounds []int64) (*ProductFacets, error) {
+	if err := s.prices.settle(ctx, s.db, pgPlaceholder); err != nil {
+		return nil, err
+	}
+	filter.CategoryIDs = pgIDs(filter.CategoryIDs)
+	hits, err := searchHits(ctx, s.search, filter)
+	if err != nil {
+		return nil, err
+	}
+	return queryProductFacets(ctx, s.db, filter, hits, priceBounds, pgPlaceholder)
+}
+
+func (s *PostgresStore) ImportProducts(ctx context.Context, skus []string, dryRun bool, update func(i int, p *models.Product) error) ([]ImportedProduct, error) {
+	if err := s.prices.settle(ctx, s.db, pgPlaceholder); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := importProducts(ctx, tx, skus, dryRun, update, pgPlaceholder)
+	if err != nil || dryRun {
+		return rows, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		s.search.Put(row.Product)
+	}
+	return rows, nil
+}
+
+// Prices
+
+func (s *PostgresStore) ListPriceHistory(ctx context.Context, productID string) ([]*models.PriceChange, error) {
+	if _, err := s.GetProduct(ctx, productID); err != nil {
+		return nil, err
+	}
+	return queryPriceHistory(ctx, s.db, productID, pgPlaceholder)
+}
+
+func (s *PostgresStore) CreatePriceSchedule(ctx context.Context, sc *models.PriceSchedule) (*models.PriceSchedule, error) {
+	if !isUUID(sc.ProductID) {
+		return nil, errors.New("product not found")
+	}
+	return createPriceSchedule(ctx, s.db, s.prices, sc, pgPlaceholder)
+}
+
+func (s *PostgresStore) ListPriceSchedules(ctx context.Context, productID string) ([]*models.PriceSchedule, error) {
+	if _, err := s.GetProduct(ctx, productID); err != nil {
+		return nil, err
+	}
+	return queryPriceSchedules(ctx, s.db, `product_id = $1`, []any{productID}, false)
+}
+
+func (s *PostgresStore) CancelPriceSchedule(ctx context.Context, productID, scheduleID string) (*models.PriceSchedule, error) {
+	if !isUUID(productID) || !isUUID(scheduleID) {
+		return nil, errors.New("price schedule not found")
+	}
+	if err := s.prices.settle(ctx, s.db, pgPlaceholder); err != nil {
+		return nil, err
+	}
+	return cancelPriceSchedule(ctx, s.db, s.prices, productID, scheduleID, pgPlaceholder)
+}
+
+// Categories
+
+func (s *PostgresStore) CreateCategory(ctx context.Context, c *models.Category) (*models.Category, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	all, err := queryCategories(ctx, tx, true)
+	if err != nil {
+		return nil, err
+	}
+	c.ID = uuid.NewString()
+	if err := checkCategoryParent(categoriesByID(all), c.ID, c.ParentID); err != nil {
+		return nil, err
+	}
+	c.Children = nil
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO categories (id, parent_id, name, slug, sku_prefix, sort_order, image, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		c.ID, nullID(c.ParentID), c.Name, c.Slug, c.SKUPrefix, c.SortOrder, c.Image, c.CreatedAt, c.UpdatedAt,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, ErrSlugTaken
+		}
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *PostgresStore) UpdateCategory(ctx context.Context, id string, update func(c *models.Category) error) (*models.Category, error) {
+	if !isUUID(id) {
+		return nil, errCategoryNotFound
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	all, err := queryCategories(ctx, tx, true)
+	if err != nil {
+		return nil, err
+	}
+	byID := categoriesByID(all)
+	stored, ok := byID[id]
+	if !ok {
+		return nil, errCategoryNotFound
+	}
+	c := *stored
+	if err := update(&c); err != nil {
+		return nil, err
+	}
+	c.ID = id
+	if err := checkCategoryParent(byID, id, c.ParentID); err != nil {
+		return nil, err
+	}
+	c.Children = nil
+	c.UpdatedAt = time.Now()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE categories SET parent_id=$1, name=$2, slug=$3, sku_prefix=$4, sort_order=$5, image=$6, updated_at=$7 WHERE id=$8`,
+		nullID(c.ParentID), c.Name, c.Slug, c.SKUPrefix, c.SortOrder, c.Image, c.UpdatedAt, id,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, ErrSlugTaken
+		}
+		return nil, err
+	}
+	if c.Name != stored.Name {
+		if _, err := tx.ExecContext(ctx, `UPDATE products SET category=$1 WHERE category_id=$2`, c.Name, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *PostgresStore) DeleteCategory(ctx context.Context, id string) error {
+	if !isUUID(id) {
+		return errCategoryNotFound
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT 1 FROM categories WHERE id=$1 FOR UPDATE`, id).Scan(&exists); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errCategoryNotFound
+		}
+		return err
+	}
+	var refs int
+	err = tx.QueryRowContext(ctx,
+		`SELECT (SELECT COUNT(*) FROM categories WHERE parent_id=$1) + (SELECT COUNT(*) FROM products WHERE category_id=$1)`,
+		id,
+	).Scan(&refs)
+	if err != nil {
+		return err
+	}
+	if refs > 0 {
+		return ErrCategoryInUse
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE id=$1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) ListCategories(ctx context.Context) ([]*models.Category, error) {
+	return queryCategories(ctx, s.db, false)
+}
+
+// Variants
+
+func (s *PostgresStore) CreateVariant(ctx context.Context, productID string, v *models.ProductVariant) (*models.ProductVariant, error) {
+	options, err := encodeVariantOptions(v.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockProduct(ctx, tx, productID); err != nil {
+		return nil, err
+	}
+	levels, err := queryStockLevels(ctx, tx, productID, pgPlaceholder)
+	if err != nil {
+		return nil, err
+	}
+
+	v.ID = uuid.NewString()
+	v.ProductID = productID
+	if v.Options == nil {
+		v.Options = map[string]string{}
+	}
+	v.CreatedAt = time.Now()
+	v.UpdatedAt = v.CreatedAt
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO product_variants (id, product_id, sku, options, price_cents, stock, image, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		v.ID, v.ProductID, v.SKU, options, nullPrice(v.PriceCents), v.Stock, v.Image, v.CreatedAt, v.UpdatedAt,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, ErrSKUTaken
+		}
+		return nil, err
+	}
+	if err := s.syncStock(ctx, tx, productID, v.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := recordStockChanges(ctx, tx, productID, levels, models.StockAdjustment, v.UpdatedAt, pgPlaceholder); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *PostgresStore) UpdateVariant(ctx context.Context, productID, variantID string, update func(v *models.ProductVariant) error) (*models.ProductVariant, *models.Product, error) {
+	if !isUUID(variantID) {
+		return nil, nil, errVariantNotFound
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockProduct(ctx, tx, productID); err != nil {
+		return nil, nil, err
+	}
+	levels, err := queryStockLevels(ctx, tx, productID, pgPlaceholder)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := scanVariant(tx.QueryRowContext(ctx,
+		`SELECT `+variantSelect+` FROM product_variants WHERE id=$1 AND product_id=$2 FOR UPDATE`,
+		variantID, productID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, errVariantNotFound
+		}
+		return nil, nil, err
+	}
+
+	if err := update(v); err != nil {
+		return nil, nil, err
+	}
+	if v.Stock < 0 {
+		return nil, nil, ErrInsufficientStock
+	}
+	v.ID, v.ProductID = variantID, productID
+	v.UpdatedAt = time.Now()
+
+	options, err := encodeVariantOptions(v.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE product_variants SET sku=$1, options=$2, price_cents=$3, stock=$4, image=$5, updated_at=$6 WHERE id=$7`,
+		v.SKU, options, nullPrice(v.PriceCents), v.Stock, v.Image, v.UpdatedAt, v.ID,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, nil, ErrSKUTaken
+		}
+		return nil, nil, err
+	}
+	if err := s.syncStock(ctx, tx, productID, v.UpdatedAt); err != nil {
+		return nil, nil, err
+	}
+	if err := recordStockChanges(ctx, tx, productID, levels, models.StockAdjustment, v.UpdatedAt, pgPlaceholder); err != nil {
+		return nil, nil, err
+	}
+	p, err := readProduct(ctx, tx, productID, pgPlaceholder)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return v, p, nil
+}
+
+func (s *PostgresStore) DeleteVariant(ctx context.Context, productID, variantID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockProduct(ctx, tx, productID); err != nil {
+		return err
+	}
+	levels, err := queryStockLevels(ctx, tx, productID, pgPlaceholder)
+	if err != nil {
+		return err
+	}
+	if !isUUID(variantID) {
+		return errVariantNotFound
+	}
+
+	var ordered int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM order_items WHERE variant_id=$1`, variantID).Scan(&ordered); err != nil {
+		return err
+	}
+	if ordered > 0 {
+		return ErrVariantInUse
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM product_variants WHERE id=$1 AND product_id=$2`, variantID, productID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return errVariantNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM cart_items WHERE product_id=$1 AND variant_id=$2`, productID, variantID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM guest_cart_items WHERE product_id=$1 AND variant_id=$2`, productID, variantID); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := s.syncStock(ctx, tx, productID, now); err != nil {
+		return err
+	}
+	if err := recordStockChanges(ctx, tx, productID, levels, models.StockAdjustment, now, pgPlaceholder); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockProduct takes the row lock that serialises every change to a
+// product's variants and stock, checkouts included
+func (s *PostgresStore) lockProduct(ctx context.Context, tx *sql.Tx, id string) error {
+	if !isUUID(id) {
+		return errors.New("product not found")
+	}
+	var locked string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM products WHERE id=$1 FOR UPDATE`, id).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("product not found")
+	}
+	return err
+}
+
+// syncStock sets a product's stock to the sum of its variants'. A zero time
+// leaves updated_at alone.
+func (s *PostgresStore) syncStock(ctx context.Context, tx *sql.Tx, id string, now time.Time) error {
+	query := `UPDATE products SET stock=(SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id=$1) WHERE id=$1`
+	args := []any{id}
+	if !now.IsZero() {
+		query = `UPDATE products SET stock=(SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id=$1), updated_at=$2 WHERE id=$1`
+		args = []any{id, now}
+	}
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Inventory
+
+func (s *PostgresStore) AdjustStock(ctx context.Context, productID, variantID, locationID string, delta int, reason, note string) (*models.StockMovement, *models.Product, error) {
+	if !isUUID(productID) {
+		return nil, nil, errors.New("product not found")
+	}
+	if variantID != "" && !isUUID(variantID) {
+		return nil, nil, errVariantNotFound
+	}
+	if locationID != "" && !isUUID(locationID) {
+		return nil, nil, errLocationNotFound
+	}
+	return adjustStock(ctx, s.db, productID, variantID, locationID, delta, reason, note, pgPlaceholder)
+}
+
+func (s *PostgresStore) ListStockMovements(ctx context.Context, productID string, page Page) ([]*models.StockMovement, string, error) {
+	if !isUUID(productID) {
+		return []*models.StockMovement{}, "", nil
+	}
+	return queryStockMovements(ctx, s.db, productID, page, pgPlaceholder)
+}
+
+func (s *PostgresStore) ListLowStockProducts(ctx context.Context, defaultThreshold int) ([]*models.Product, error) {
+	return queryLowStockProducts(ctx, s.db, defaultThreshold, pgPlaceholder)
+}
+
+// Locations
+
+func (s *PostgresStore) CreateLocation(ctx context.Context, l *models.Location) (*models.Location, error) {
+	return createLocation(ctx, s.db, l, pgPlaceholder)
+}
+
+func (s *PostgresStore) UpdateLocation(ctx context.Context, id string, update func(l *models.Location) error) (*models.Location, error) {
+	if !isUUID(id) {
+		return nil, errLocationNotFound
+	}
+	return updateLocation(ctx, s.db, id, update, pgPlaceholder)
+}
+
+func (s *PostgresStore) DeleteLocation(ctx context.Context, id string) error {
+	if !isUUID(id) {
+		return errLocationNotFound
+	}
+	return deleteLocation(ctx, s.db, id, pgPlaceholder)
+}
+
+func (s *PostgresStore) ListLocations(ctx context.Context) ([]*models.Location, error) {
+	return queryLocations(ctx, s.db, false)
+}
+
+func (s *PostgresStore) ListStockLevels(ctx context.Context, productID string) ([]*models.StockLevel, error) {
+	if !isUUID(productID) {
+		return nil, errors.New("product not found")
+	}
+	return listStockLevels(ctx, s.db, productID, pgPlaceholder)
+}
+
+func (s *PostgresStore) TransferStock(ctx context.Context, productID, variantID, fromID, toID string, qty int, note string) ([]*models.StockMovement, error) {
+	if err := checkStockTransfer(fromID, toID, qty); err != nil {
+		return nil, err
+	}
+	if !isUUID(productID) {
+		return nil, errors.New("product not found")
+	}
+	if variantID != "" && !isUUID(variantID) {
+		return nil, errVariantNotFound
+	}
+	if !isUUID(fromID) || !isUUID(toID) {
+		return nil, errLocationNotFound
+	}
+	return transferStock(ctx, s.db, productID, variantID, fromID, toID, qty, note, pgPlaceholder)
+}
+
+// Back-in-stock subscriptions
+
+func (s *PostgresStore) SubscribeStock(ctx context.Context, userID, productID, variantID string) (*models.StockSubscription, error) {
+	if !isUUID(productID) {
+		return nil, errors.New("product not found")
+	}
+	if variantID != "" && !isUUID(variantID) {
+		return nil, errVariantNotFound
+	}
+	return subscribeStock(ctx, s.db, userID, productID, variantID, pgPlaceholder)
+}
+
+func (s *PostgresStore) UnsubscribeStock(ctx context.Context, userID, productID, variantID string) error {
+	if !isUUID(userID) || !isUUID(productID) {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM stock_subscriptions WHERE user_id=$1 AND product_id=$2 AND variant_id=$3`,
+		userID, productID, variantID,
+	)
+	return err
+}
+
+func (s *PostgresStore) ListRestockedSubscriptions(ctx context.Context, productID string) ([]*models.StockSubscription, error) {
+	if !isUUID(productID) {
+		return nil, errors.New("product not found")
+	}
+	return queryRestockedSubscriptions(ctx, s.db, productID, pgPlaceholder)
+}
+
+// Carts
+
+func (s *PostgresStore) GetOrCreateCart(ctx context.Context, userID string) *models.Cart {
+	_, _ = s.db.ExecContext(ctx,
+		`INSERT INTO carts (user_id, updated_at) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING`,
+		userID, time.Now(),
+	)
+
+	c, _ := s.GetCart(ctx, userID)
+	if c == nil {
+		return &models.Cart{UserID: userID, Items: []models.CartItem{}}
+	}
+	return c
+}
+
+func (s *PostgresStore) AddToCart(ctx context.Context, userID, productID, variantID string, qty int) error {
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	if p.ArchivedAt != nil {
+		return ErrProductArchived
+	}
+	if !p.Live(time.Now()) {
+		return ErrProductNotPublished
+	}
+	v, err := resolveVariant(p, variantID)
+	if err != nil {
+		return err
+	}
+
+	// Check current cart quantity
+	var currentQty int
+	err = s.db.QueryRowContext(ctx,
+		`SELECT quantity FROM cart_items WHERE user_id=$1 AND product_id=$2 AND variant_id=$3`,
+		userID, productID, variantID,
+	).Scan(&currentQty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if stockFor(p, v) < (currentQty + qty) {
+		return errors.New("insufficient stock")
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO carts (user_id, updated_at) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET updated_at = EXCLUDED.updated_at`,
+		userID, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO cart_items (user_id, product_id, variant_id, quantity) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (user_id, product_id, variant_id) DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity`,
+		userID, productID, variantID, qty,
+	)
+	return err
+}
+
+func (s *PostgresStore) RemoveFromCart(ctx context.Context, userID, productID, variantID string, qty int) error {
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+	if !isUUID(productID) {
+		return errors.New("item not in cart")
+	}
+
+	var cur int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT quantity FROM cart_items WHERE user_id=$1 AND product_id=$2 AND variant_id=$3`,
+		userID, productID, variantID,
+	).Scan(&cur)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("item not in cart")
+		}
+		return err
+	}
+
+	if cur <= qty {
+		_, err = s.db.ExecContext(ctx,
+			`DELETE FROM cart_items WHERE user_id=$1 AND product_id=$2 AND variant_id=$3`,
+			userID, productID, variantID,
+		)
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE cart_items SET quantity = quantity - $1 WHERE user_id=$2 AND product_id=$3 AND variant_id=$4`,
+		qty, userID, productID, variantID,
+	)
+	return err
+}
+
+func (s *PostgresStore) ClearCart(ctx context.Context, userID string) {
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM cart_items WHERE user_id=$1`, userID)
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM carts WHERE user_id=$1`, userID)
+}
+
+func (s *PostgresStore) GetCart(ctx context.Context, userID string) (*models.Cart, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT product_id, variant_id, quantity FROM cart_items WHERE user_id=$1`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.CartItem{}
+	for rows.Next() {
+		var it models.CartItem
+		if err := rows.Scan(&it.ProductID, &it.VariantID, &it.Quantity); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return &models.Cart{UserID: userID, Items: items}, rows.Err()
+}
+
+func (s *PostgresStore) SetCartQuantity(ctx context.Context, userID, productID, variantID string, qty int) error {
+	if qty < 0 {
+		return errors.New("quantity must not be negative")
+	}
+	if qty == 0 {
+		if !isUUID(productID) {
+			return nil
+		}
+		return setCartLineQuantity(ctx, s.db, userCartTables, userID, nil, productID, variantID, 0, pgPlaceholder)
+	}
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	return setCartLineQuantity(ctx, s.db, userCartTables, userID, p, productID, variantID, qty, pgPlaceholder)
+}
+
+// Guest carts
+
+func (s *PostgresStore) AddToGuestCart(ctx context.Context, guestID, productID, variantID string, qty int) error {
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+	if !isUUID(guestID) {
+		return errors.New("invalid guest id")
+	}
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	return addToGuestCart(ctx, s.db, guestID, p, variantID, qty, pgPlaceholder)
+}
+
+func (s *PostgresStore) RemoveFromGuestCart(ctx context.Context, guestID, productID, variantID string, qty int) error {
+	if qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+	if !isUUID(guestID) || !isUUID(productID) {
+		return errNotInCart
+	}
+	return removeFromGuestCart(ctx, s.db, guestID, productID, variantID, qty, pgPlaceholder)
+}
+
+func (s *PostgresStore) SetGuestCartQuantity(ctx context.Context, guestID, productID, variantID string, qty int) error {
+	if qty < 0 {
+		return errors.New("quantity must not be negative")
+	}
+	if !isUUID(guestID) {
+		return errors.New("invalid guest id")
+	}
+	if qty == 0 {
+		if !isUUID(productID) {
+			return nil
+		}
+		return setCartLineQuantity(ctx, s.db, guestCartTables, guestID, nil, productID, variantID, 0, pgPlaceholder)
+	}
+	p, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	return setCartLineQuantity(ctx, s.db, guestCartTables, guestID, p, productID, variantID, qty, pgPlaceholder)
+}
+
+func (s *PostgresStore) GetGuestCart(ctx context.Context, guestID string) (*models.GuestCart, error) {
+	if !isUUID(guestID) {
+		return &models.GuestCart{ID: guestID, Items: []models.CartItem{}}, nil
+	}
+	return getGuestCart(ctx, s.db, guestID, pgPlaceholder)
+}
+
+func (s *PostgresStore) MergeGuestCart(ctx context.Context, guestID, userID string) (*models.Cart, error) {
+	if !isUUID(guestID) {
+		return s.GetCart(ctx, userID)
+	}
+	return mergeGuestCart(ctx, s.db, guestID, userID, s.GetProduct, pgPlaceholder)
+}
+
+func (s *PostgresStore) ExpireGuestCarts(ctx context.Context, idleSince time.Time) (int, error) {
+	return expireGuestCarts(ctx, s.db, idleSince, pgPlaceholder)
+}
+
+// Orders
+
+func (s *PostgresStore) CreateOrder(ctx context.Context, userID string, items []models.CartItem, amount int64, status, paymentRef string) (*models.Order, error) {
+	id := uuid.NewString()
+	now := time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	reservation := orderReservation(status)
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO orders (id, user_id, amount_cents, status, payment_ref, reservation, reserved_at, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$7)`,
+		id, userID, amount, status, paymentRef, reservation, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make([]models.CartItem, 0, len(items))
+	for _, it := range items {
+		if !isUUID(it.ProductID) {
+			return nil, errors.New("product not found")
+		}
+
+		// Get current price, locking the product before its variants
+		p, err := scanProduct(tx.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE id=$1 FOR UPDATE`, it.ProductID))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, errors.New("product not found")
+			}
+			return nil, err
+		}
+		if err := loadVariants(ctx, tx, []*models.Product{p}, pgPlaceholder, true); err != nil {
+			return nil, err
+		}
+		v, err := resolveVariant(p, it.VariantID)
+		if err != nil {
+			return nil, err
+		}
+		priceCents := p.PriceFor(v)
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO order_items (order_id, product_id, variant_id, quantity, price_cents) VALUES ($1,$2,$3,$4,$5)`,
+			id, it.ProductID, it.VariantID, it.Quantity, priceCents,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		it.PriceCents = priceCents
+		ordered = append(ordered, it)
+		if reservation == models.ReservationReleased {
+			continue
+		}
+
+		// Decrement stock
+		var res sql.Result
+		if v != nil {
+			res, err = tx.ExecContext(ctx,
+				`UPDATE product_variants SET stock = stock - $1 WHERE id=$2 AND stock >= $1`,
+				it.Quantity, v.ID,
+			)
+		} else {
+			res, err = tx.ExecContext(ctx,
+				`UPDATE products SET stock = stock - $1 WHERE id=$2 AND stock >= $1`,
+				it.Quantity, it.ProductID,
+			)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		affected, _ := res.RowsAffected()
+		if affected == 0 {
+			return nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, it.ProductID)
+		}
+		if v != nil {
+			if err := s.syncStock(ctx, tx, it.ProductID, time.Time{}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	o := &models.Order{
+		ID:          id,
+		UserID:      userID,
+		Items:       ordered,
+		Amount:      amount,
+		Status:      status,
+		PaymentRef:  paymentRef,
+		Reservation: reservation,
+		ReservedAt:  now,
+		CreatedAt:   now,
+	}
+	if reservation != models.ReservationReleased {
+		if err := allocateOrder(ctx, tx, o, allocationFrom(ctx), pgPlaceholder); err != nil {
+			return nil, err
+		}
+		if err := insertStockMovements(ctx, tx, orderStockMovements(o, -1, reservation, stockActor(ctx, userID), now), pgPlaceholder); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (s *PostgresStore) CheckoutCart(ctx context.Context, userID string) (*models.Order, map[string]*models.Product, error) {
+	if !isUUID(userID) {
+		return nil, nil, ErrCartEmpty
+	}
+	if err := s.prices.settle(ctx, s.db, pgPlaceholder); err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Lock the cart lines, then each product in ID order. Concurrent
+	// checkouts of the same product queue here instead of overselling, and
+	// cart edits wait until this order is committed or rolled back.
+	rows, err := tx.QueryContext(ctx,
+		`SELECT product_id, variant_id, quantity FROM cart_items WHERE user_id=$1 ORDER BY product_id, variant_id FOR UPDATE`,
+		userID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	items := []models.CartItem{}
+	for rows.Next() {
+		var it models.CartItem
+		if err := rows.Scan(&it.ProductID, &it.VariantID, &it.Quantity); err != nil {
+			_ = rows.Close()
+			return nil, nil, err
+		}
+		items = append(items, it)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil, ErrCartEmpty
+	}
+
+	now := time.Now()
+	o := &models.Order{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Items:       make([]models.CartItem, 0, len(items)),
+		Status:      "pending",
+		Reservation: models.ReservationHeld,
+		ReservedAt:  now,
+		CreatedAt:   now,
+	}
+	products := map[string]*models.Product{}
+	for _, it := range items {
+		p, err := scanProduct(tx.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE id=$1 FOR UPDATE`, it.ProductID))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil, errors.New("product not found")
+			}
+			return nil, nil, err
+		}
+		if p.ArchivedAt != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrProductArchived, it.ProductID)
+		}
+		if !p.Live(o.CreatedAt) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrProductNotPublished, it.ProductID)
+		}
+		if err := loadVariants(ctx, tx, []*models.Product{p}, pgPlaceholder, true); err != nil {
+			return nil, nil, err
+		}
+		v, err := resolveVariant(p, it.VariantID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stockFor(p, v) < it.Quantity {
+			return nil, nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, it.ProductID)
+		}
+		it.PriceCents = p.PriceFor(v)
+		o.Items = append(o.Items, it)
+		o.Amount += int64(it.Quantity) * it.PriceCents
+		products[p.ID] = p
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO orders (id, user_id, amount_cents, status, payment_ref, reservation, reserved_at, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
+		o.ID, o.UserID, o.Amount, o.Status, o.PaymentRef, o.Reservation, o.ReservedAt, o.CreatedAt,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, it := range o.Items {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO order_items (order_id, product_id, variant_id, quantity, price_cents) VALUES ($1,$2,$3,$4,$5)`,
+			o.ID, it.ProductID, it.VariantID, it.Quantity, it.PriceCents,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		if it.VariantID == "" {
+			if _, err := tx.ExecContext(ctx, `UPDATE products SET stock = stock - $1 WHERE id=$2`, it.Quantity, it.ProductID); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE product_variants SET stock = stock - $1 WHERE id=$2`, it.Quantity, it.VariantID); err != nil {
+			return nil, nil, err
+		}
+		if err := s.syncStock(ctx, tx, it.ProductID, time.Time{}); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := allocateOrder(ctx, tx, o, allocationFrom(ctx), pgPlaceholder); err != nil {
+		return nil, nil, err
+	}
+	if err := insertStockMovements(ctx, tx, orderStockMovements(o, -1, o.Reservation, stockActor(ctx, userID), now), pgPlaceholder); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM cart_items WHERE user_id=$1`, userID); err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM carts WHERE user_id=$1`, userID); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	deductStock(products, o.Items)
+	return o, products, nil
+}
+
+func (s *PostgresStore) listOrders(ctx context.Context, page Page, where []string, args []any) ([]*models.Order, string, error) {
+	where, args, tail, err := pageQuery(page, where, args, pgPlaceholder)
+	if err != nil {
+		return nil, "", err
+	}
+	query := `SELECT ` + orderSelect + ` FROM orders`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	rows, err := s.db.QueryContext(ctx, query+tail, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	res := []*models.Order{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, "", err
+		}
+		res = append(res, o)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	// Fetch items once the order cursor is closed so we never hold two
+	// connections per call.
+	res, next := cutPage(res, page.Limit, orderKey)
+	if err := loadOrderItems(ctx, s.db, res, pgPlaceholder); err != nil {
+		return nil, "", err
+	}
+	return res, next, nil
+}
+
+func (s *PostgresStore) orderItems(ctx context.Context, orderID string) ([]models.CartItem, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT product_id, variant_id, quantity, price_cents FROM order_items WHERE order_id=$1`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.CartItem{}
+	for rows.Next() {
+		var it models.CartItem
+		if err := rows.Scan(&it.ProductID, &it.VariantID, &it.Quantity, &it.PriceCents); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+func (s *PostgresStore) ListOrdersByUser(ctx context.Context, userID string, page Page) ([]*models.Order, string, error) {
+	if !isUUID(userID) {
+		if _, err := decodeCursor(page.Cursor); err != nil {
+			return nil, "", err
+		}
+		return []*models.Order{}, "", nil
+	}
+	return s.listOrders(ctx, page, []string{`user_id=$1`}, []any{userID})
+}
+
+func (s *PostgresStore) ListOrders(ctx context.Context, page Page) ([]*models.Order, string, error) {
+	return s.listOrders(ctx, page, nil, nil)
+}
+
+func (s *PostgresStore) UpdateOrderStatus(ctx context.Context, orderID, status string) error {
+	if !isUUID(orderID) {
+		return errors.New("order not found")
+	}
+
+	_, err := setOrderStatus(ctx, s.db, orderID, status, nil, pgPlaceholder)
+	return err
+}
+
+func (s *PostgresStore) ExpireReservations(ctx context.Context, heldBefore time.Time) (int, error) {
+	return expireReservations(ctx, s.db, heldBefore, pgPlaceholder)
+}
+
+func (s *PostgresStore) UpdateOrderPaymentRef(ctx context.Context, orderID, paymentRef string) error {
+	if !isUUID(orderID) {
+		return errors.New("order not found")
+	}
+
+	res, err := s.db.ExecContext(ctx, `UPDATE orders SET payment_ref=$1 WHERE id=$2`, paymentRef, orderID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return errors.New("order not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	if !isUUID(orderID) {
+		return nil, errors.New("order not found")
+	}
+
+	o, err := scanOrder(s.db.QueryRowContext(ctx, `SELECT `+orderSelect+` FROM orders WHERE id=$1`, orderID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("order not found")
+		}
+		return nil, err
+	}
+
+	items, err := s.orderItems(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	o.Items = items
+	return o, nil
+}
+
+// Reviews
+
+func (s *PostgresStore) CreateReview(ctx context.Context, userID, userName, userPhoto string, rating int, comment string) (*models.Review, error) {
+	if rating < 1 || rating > 5 {
+		return nil, errors.New("rating must be between 1 and 5")
+	}
+
+	id := uuid.NewString()
+	now := time.Now()
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO reviews (id, user_id, user_name, user_photo, rating, comment, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+		id, userID, userName, userPhoto, rating, comment, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Review{
+		ID:        id,
+		UserID:    userID,
+		UserName:  userName,
+		UserPhoto: userPhoto,
+		Rating:    rating,
+		Comment:   comment,
+		CreatedAt: now,
+	}, nil
+}
+
+func (s *PostgresStore) ListReviews(ctx context.Context, page Page) ([]*models.Review, string, error) {
+	where, args, tail, err := pageQuery(page, nil, nil, pgPlaceholder)
+	if err != nil {
+		return nil, "", err
+	}
+	query := `SELECT id, user_id, user_name, user_photo, rating, comment, created_at FROM reviews`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query+tail, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	reviews := []*models.Review{}
+	for rows.Next() {
+		r := models.Review{}
+		if err := rows.Scan(&r.ID, &r.UserID, &r.UserName, &r.UserPhoto, &r.Rating, &r.Comment, &r.CreatedAt); err != nil {
+			return nil, "", err
+		}
+		reviews = append(reviews, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	reviews, next := cutPage(reviews, page.Limit, reviewKey)
+	return reviews, next, nil
+}
+
+func (s *PostgresStore) GetUserReviewCount(ctx context.Context, userID string) (int, error) {
+	if !isUUID(userID) {
+		return 0, nil
+	}
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM reviews WHERE user_id=$1`, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Audit log
+
+func (s *PostgresStore) CreateAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	e.ID = uuid.NewString()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO audit_log (`+auditSelect+`) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		e.ID, e.ActorID, e.Action, e.EntityType, e.EntityID, nullJSON(e.Before), nullJSON(e.After), e.IP, e.CreatedAt,
+	)
+	return err
+}
+
+func (s *PostgresStore) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*models.AuditEntry, error) {
+	query, args := auditQuery(filter, pgPlaceholder)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.AuditEntry{}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// pgPlaceholder renders the n-th bind parameter
+func pgPlaceholder(n int) string { return "$" + strconv.Itoa(n) }
+
+// isUUID guards UUID columns: PostgreSQL rejects malformed ids with a cast
+// error instead of simply matching no rows like MySQL does.
+func isUUID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil
+}
+
+// pgIDs replaces IDs that are not UUIDs, which Postgres would reject, with
+// the nil UUID, which matches no row
+func pgIDs(ids []string) []string {
+	if ids == nil {
+		return nil
+	}
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[i] = id
+		if !isUUID(id) {
+			out[i] = uuid.Nil.String()
+		}
+	}
+	return out
+}
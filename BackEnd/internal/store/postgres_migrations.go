@@ -0,0 +1,515 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/example/ecommerce-api/internal/migrate"
+)
+
+// postgresMigrations is the ordered schema history for PostgresStore. Append
+// new versions; never edit one that has shipped.
+var postgresMigrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: migrate.Exec(
+			`CREATE TABLE IF NOT EXISTS users (
+				id UUID PRIMARY KEY,
+				full_name VARCHAR(255) NOT NULL DEFAULT '',
+				phone VARCHAR(50) NOT NULL DEFAULT '',
+				email VARCHAR(255) UNIQUE NOT NULL,
+				password_hash VARCHAR(255) NOT NULL,
+				role VARCHAR(20) NOT NULL DEFAULT 'user',
+				auth_provider VARCHAR(30) NOT NULL DEFAULT 'email',
+				google_id VARCHAR(255) NOT NULL DEFAULT '',
+				email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS email_verifications (
+				token VARCHAR(255) PRIMARY KEY,
+				user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				expires_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_email_verifications_user_id ON email_verifications (user_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_email_verifications_expires_at ON email_verifications (expires_at)`,
+
+			`CREATE TABLE IF NOT EXISTS password_resets (
+				token VARCHAR(255) PRIMARY KEY,
+				user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				expires_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets (user_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_password_resets_expires_at ON password_resets (expires_at)`,
+
+			`CREATE TABLE IF NOT EXISTS products (
+				id UUID PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				category VARCHAR(100) NOT NULL DEFAULT '',
+				price_cents BIGINT NOT NULL,
+				sku VARCHAR(100) NOT NULL,
+				stock INT NOT NULL,
+				thumbnail TEXT NOT NULL DEFAULT '',
+				images TEXT NOT NULL DEFAULT '',
+				rating NUMERIC(3,2) NOT NULL DEFAULT 0,
+				review_count INT NOT NULL DEFAULT 0,
+				created_at TIMESTAMPTZ NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_products_sku ON products (sku)`,
+			`CREATE INDEX IF NOT EXISTS idx_products_name ON products (name)`,
+			`CREATE INDEX IF NOT EXISTS idx_products_category ON products (category)`,
+
+			`CREATE TABLE IF NOT EXISTS carts (
+				user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+				updated_at TIMESTAMPTZ NOT NULL
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS cart_items (
+				user_id UUID NOT NULL REFERENCES carts(user_id) ON DELETE CASCADE,
+				product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+				quantity INT NOT NULL,
+				PRIMARY KEY (user_id, product_id)
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS orders (
+				id UUID PRIMARY KEY,
+				user_id UUID NOT NULL REFERENCES users(id),
+				amount_cents BIGINT NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				payment_ref VARCHAR(255) NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders (user_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_orders_created_at ON orders (created_at)`,
+
+			`CREATE TABLE IF NOT EXISTS order_items (
+				order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+				product_id UUID NOT NULL REFERENCES products(id),
+				quantity INT NOT NULL,
+				price_cents BIGINT NOT NULL,
+				PRIMARY KEY (order_id, product_id)
+			)`,
+
+			`CREATE TABLE IF NOT EXISTS reviews (
+				id UUID PRIMARY KEY,
+				user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				user_name VARCHAR(255) NOT NULL,
+				user_photo TEXT NOT NULL DEFAULT '',
+				rating INT NOT NULL,
+				comment TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_reviews_created_at ON reviews (created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_reviews_user_id ON reviews (user_id)`,
+		),
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS reviews`,
+			`DROP TABLE IF EXISTS order_items`,
+			`DROP TABLE IF EXISTS orders`,
+			`DROP TABLE IF EXISTS cart_items`,
+			`DROP TABLE IF EXISTS carts`,
+			`DROP TABLE IF EXISTS products`,
+			`DROP TABLE IF EXISTS password_resets`,
+			`DROP TABLE IF EXISTS email_verifications`,
+			`DROP TABLE IF EXISTS users`,
+		),
+	},
+	{
+		Version: 2,
+		Name:    "product_gallery_json",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN images_json JSONB NOT NULL DEFAULT '[]'`,
+			`UPDATE products SET images_json = images::jsonb WHERE left(btrim(images), 1) = '['`,
+			`UPDATE products SET images_json = COALESCE((
+				SELECT jsonb_agg(jsonb_build_object('url', btrim(t.u)) ORDER BY t.ord)
+				FROM unnest(string_to_array(images, ',')) WITH ORDINALITY AS t(u, ord)
+				WHERE btrim(t.u) <> ''
+			), '[]'::jsonb)
+			WHERE left(btrim(images), 1) <> '['`,
+			`ALTER TABLE products DROP COLUMN images`,
+			`ALTER TABLE products RENAME COLUMN images_json TO images`,
+		),
+		Down: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN images_text TEXT NOT NULL DEFAULT ''`,
+			`UPDATE products SET images_text = COALESCE((
+				SELECT string_agg(t.e->>'url', ',' ORDER BY t.ord)
+				FROM jsonb_array_elements(images) WITH ORDINALITY AS t(e, ord)
+			), '')`,
+			`ALTER TABLE products DROP COLUMN images`,
+			`ALTER TABLE products RENAME COLUMN images_text TO images`,
+		),
+	},
+	{
+		Version: 3,
+		Name:    "product_archived_at",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN archived_at TIMESTAMPTZ`,
+			`CREATE INDEX idx_products_archived_at ON products (archived_at)`,
+		),
+		Down: migrate.Exec(
+			`DROP INDEX IF EXISTS idx_products_archived_at`,
+			`ALTER TABLE products DROP COLUMN archived_at`,
+		),
+	},
+	{
+		Version: 4,
+		Name:    "audit_log",
+		Up: migrate.Exec(
+			`CREATE TABLE IF NOT EXISTS audit_log (
+				id UUID PRIMARY KEY,
+				actor_id VARCHAR(64) NOT NULL,
+				action VARCHAR(64) NOT NULL,
+				entity_type VARCHAR(32) NOT NULL,
+				entity_id VARCHAR(64) NOT NULL DEFAULT '',
+				before_data JSONB,
+				after_data JSONB,
+				ip VARCHAR(45) NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_actor ON audit_log (actor_id, created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_entity ON audit_log (entity_type, entity_id, created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_audit_created_at ON audit_log (created_at)`,
+		),
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS audit_log`,
+		),
+	},
+	{
+		Version: 5,
+		Name:    "keyset_pagination_indexes",
+		Up: migrate.Exec(
+			`CREATE INDEX IF NOT EXISTS idx_products_created_id ON products (created_at, id)`,
+			`CREATE INDEX IF NOT EXISTS idx_orders_created_id ON orders (created_at, id)`,
+			`CREATE INDEX IF NOT EXISTS idx_orders_user_created_id ON orders (user_id, created_at, id)`,
+			`CREATE INDEX IF NOT EXISTS idx_reviews_created_id ON reviews (created_at, id)`,
+		),
+		Down: migrate.Exec(
+			`DROP INDEX IF EXISTS idx_reviews_created_id`,
+			`DROP INDEX IF EXISTS idx_orders_user_created_id`,
+			`DROP INDEX IF EXISTS idx_orders_created_id`,
+			`DROP INDEX IF EXISTS idx_products_created_id`,
+		),
+	},
+	{
+		Version: 6,
+		Name:    "product_variants",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN options JSONB NOT NULL DEFAULT '[]'`,
+			`CREATE TABLE IF NOT EXISTS product_variants (
+				id UUID PRIMARY KEY,
+				product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+				sku VARCHAR(100) NOT NULL,
+				options JSONB NOT NULL DEFAULT '{}',
+				price_cents BIGINT,
+				stock INT NOT NULL,
+				image TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_variants_product ON product_variants (product_id, created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_variants_sku ON product_variants (sku)`,
+			`ALTER TABLE cart_items ADD COLUMN variant_id VARCHAR(36) NOT NULL DEFAULT ''`,
+			`ALTER TABLE cart_items DROP CONSTRAINT cart_items_pkey, ADD PRIMARY KEY (user_id, product_id, variant_id)`,
+			`ALTER TABLE order_items ADD COLUMN variant_id VARCHAR(36) NOT NULL DEFAULT ''`,
+			`ALTER TABLE order_items DROP CONSTRAINT order_items_pkey, ADD PRIMARY KEY (order_id, product_id, variant_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_order_items_variant ON order_items (variant_id)`,
+		),
+		// Orders holding two variants of one product cannot be folded back
+		// into the old key; the down migration fails on them rather than
+		// dropping order lines.
+		Down: migrate.Exec(
+			`DROP INDEX IF EXISTS idx_order_items_variant`,
+			`ALTER TABLE order_items DROP CONSTRAINT order_items_pkey, ADD PRIMARY KEY (order_id, product_id)`,
+			`ALTER TABLE order_items DROP COLUMN variant_id`,
+			`DELETE FROM cart_items WHERE variant_id <> ''`,
+			`ALTER TABLE cart_items DROP CONSTRAINT cart_items_pkey, ADD PRIMARY KEY (user_id, product_id)`,
+			`ALTER TABLE cart_items DROP COLUMN variant_id`,
+			`DROP TABLE IF EXISTS product_variants`,
+			`ALTER TABLE products DROP COLUMN options`,
+		),
+	},
+	{
+		Version: 7,
+		Name:    "categories",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			err := migrate.Exec(
+				`CREATE TABLE IF NOT EXISTS categories (
+					id UUID PRIMARY KEY,
+					parent_id UUID REFERENCES categories(id) ON DELETE SET NULL,
+					name VARCHAR(255) NOT NULL,
+					slug VARCHAR(255) NOT NULL UNIQUE,
+					sort_order INT NOT NULL DEFAULT 0,
+					image TEXT NOT NULL DEFAULT '',
+					created_at TIMESTAMPTZ NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories (parent_id)`,
+				`ALTER TABLE products ADD COLUMN category_id UUID REFERENCES categories(id)`,
+				`CREATE INDEX IF NOT EXISTS idx_products_category ON products (category_id)`,
+			)(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return backfillCategories(ctx, tx, pgPlaceholder)
+		},
+		// Products keep their category names, so going down loses only the
+		// tree itself
+		Down: migrate.Exec(
+			`DROP INDEX IF EXISTS idx_products_category`,
+			`ALTER TABLE products DROP COLUMN category_id`,
+			`DROP TABLE IF EXISTS categories`,
+		),
+	},
+	{
+		Version: 8,
+		Name:    "product_search",
+		// The 'simple' configuration leaves stemming to the search package,
+		// which knows Indonesian as well as English
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple'::regconfig, name), 'A') ||
+				setweight(to_tsvector('simple'::regconfig, sku || ' ' || COALESCE(category, '')), 'B') ||
+				setweight(to_tsvector('simple'::regconfig, COALESCE(description, '')), 'C')
+			) STORED`,
+			`CREATE INDEX IF NOT EXISTS idx_products_search ON products USING GIN (search_vector)`,
+		),
+		Down: migrate.Exec(
+			`DROP INDEX IF EXISTS idx_products_search`,
+			`ALTER TABLE products DROP COLUMN search_vector`,
+		),
+	},
+	{
+		Version: 9,
+		Name:    "unique_skus",
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			if err := dedupeSKUs(ctx, tx, "products", pgPlaceholder); err != nil {
+				return err
+			}
+			if err := dedupeSKUs(ctx, tx, "product_variants", pgPlaceholder); err != nil {
+				return err
+			}
+			return migrate.Exec(
+				`CREATE UNIQUE INDEX IF NOT EXISTS uq_products_sku ON products (sku)`,
+				`DROP INDEX IF EXISTS idx_products_sku`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS uq_variants_sku ON product_variants (sku)`,
+				`DROP INDEX IF EXISTS idx_variants_sku`,
+				`ALTER TABLE categories ADD COLUMN sku_prefix VARCHAR(20) NOT NULL DEFAULT ''`,
+			)(ctx, tx)
+		},
+		// Renamed duplicates keep their new SKUs
+		Down: migrate.Exec(
+			`ALTER TABLE categories DROP COLUMN sku_prefix`,
+			`CREATE INDEX IF NOT EXISTS idx_variants_sku ON product_variants (sku)`,
+			`DROP INDEX IF EXISTS uq_variants_sku`,
+			`CREATE INDEX IF NOT EXISTS idx_products_sku ON products (sku)`,
+			`DROP INDEX IF EXISTS uq_products_sku`,
+		),
+	},
+	{
+		Version: 10,
+		Name:    "product_lifecycle",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published',
+				ADD COLUMN publish_at TIMESTAMPTZ,
+				ADD COLUMN unpublish_at TIMESTAMPTZ`,
+			`UPDATE products SET status = 'archived' WHERE archived_at IS NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS idx_products_status ON products (status)`,
+		),
+		Down: migrate.Exec(
+			`DROP INDEX IF EXISTS idx_products_status`,
+			`ALTER TABLE products DROP COLUMN unpublish_at, DROP COLUMN publish_at, DROP COLUMN status`,
+		),
+	},
+	{
+		Version: 11,
+		Name:    "price_schedules",
+		// History starts empty; existing prices have no recorded origin
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN regular_price_cents BIGINT`,
+			`CREATE TABLE IF NOT EXISTS price_history (
+				id UUID PRIMARY KEY,
+				product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+				old_price_cents BIGINT NOT NULL,
+				new_price_cents BIGINT NOT NULL,
+				reason VARCHAR(20) NOT NULL,
+				actor_id VARCHAR(64) NOT NULL DEFAULT '',
+				schedule_id VARCHAR(36) NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_price_history_product ON price_history (product_id, created_at)`,
+			`CREATE TABLE IF NOT EXISTS price_schedules (
+				id UUID PRIMARY KEY,
+				product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+				price_cents BIGINT NOT NULL,
+				starts_at TIMESTAMPTZ NOT NULL,
+				ends_at TIMESTAMPTZ,
+				status VARCHAR(20) NOT NULL,
+				next_at TIMESTAMPTZ,
+				created_by VARCHAR(64) NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_price_schedules_product ON price_schedules (product_id, starts_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_price_schedules_next ON price_schedules (next_at)`,
+		),
+		// Running sales are not undone; their products keep the sale price
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS price_schedules`,
+			`DROP TABLE IF EXISTS price_history`,
+			`ALTER TABLE products DROP COLUMN regular_price_cents`,
+		),
+	},
+	{
+		Version: 12,
+		Name:    "order_reservations",
+		// Failed orders never gave their stock back; they are marked released
+		// as they are, without restocking
+		Up: migrate.Exec(
+			`ALTER TABLE orders
+				ADD COLUMN reservation VARCHAR(16) NOT NULL DEFAULT 'held',
+				ADD COLUMN reserved_at TIMESTAMPTZ`,
+			`UPDATE orders SET reserved_at = created_at,
+				reservation = CASE
+					WHEN LOWER(status) IN ('failed', 'canceled', 'expired') THEN 'released'
+					WHEN LOWER(status) IN ('paid', 'done', 'completed') THEN 'committed'
+					ELSE 'held'
+				END`,
+			`ALTER TABLE orders ALTER COLUMN reserved_at SET NOT NULL`,
+			`CREATE INDEX IF NOT EXISTS idx_orders_reservation ON orders (reservation, reserved_at)`,
+		),
+		Down: migrate.Exec(
+			`DROP INDEX IF EXISTS idx_orders_reservation`,
+			`ALTER TABLE orders DROP COLUMN reserved_at, DROP COLUMN reservation`,
+		),
+	},
+	{
+		Version: 13,
+		Name:    "stock_movements",
+		// Current stock becomes each product's opening balance
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			err := migrate.Exec(
+				`CREATE TABLE IF NOT EXISTS stock_movements (
+					id UUID PRIMARY KEY,
+					product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+					variant_id VARCHAR(36) NOT NULL DEFAULT '',
+					delta INT NOT NULL,
+					reason VARCHAR(32) NOT NULL,
+					reference_id VARCHAR(64) NOT NULL DEFAULT '',
+					actor_id VARCHAR(64) NOT NULL DEFAULT '',
+					note VARCHAR(255) NOT NULL DEFAULT '',
+					created_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_stock_movements_product ON stock_movements (product_id, created_at)`,
+			)(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return backfillStockLedger(ctx, tx, pgPlaceholder)
+		},
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS stock_movements`,
+		),
+	},
+	{
+		Version: 14,
+		Name:    "stock_locations",
+		// Existing stock, and the ledger behind it, moves to a default
+		// location. Orders placed before have no allocations and put stock
+		// back at the default location.
+		Up: func(ctx context.Context, tx *sql.Tx) error {
+			err := migrate.Exec(
+				`CREATE TABLE IF NOT EXISTS locations (
+					id UUID PRIMARY KEY,
+					name VARCHAR(255) NOT NULL,
+					code VARCHAR(50) NOT NULL DEFAULT '',
+					priority INT NOT NULL DEFAULT 0,
+					latitude DOUBLE PRECISION,
+					longitude DOUBLE PRECISION,
+					created_at TIMESTAMPTZ NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE TABLE IF NOT EXISTS stock_levels (
+					location_id UUID NOT NULL REFERENCES locations(id),
+					product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+					variant_id VARCHAR(36) NOT NULL DEFAULT '',
+					stock INT NOT NULL,
+					PRIMARY KEY (location_id, product_id, variant_id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_stock_levels_product ON stock_levels (product_id, variant_id)`,
+				`ALTER TABLE stock_movements ADD COLUMN location_id VARCHAR(36) NOT NULL DEFAULT ''`,
+				`ALTER TABLE order_items ADD COLUMN allocations TEXT`,
+			)(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return backfillLocations(ctx, tx, pgPlaceholder)
+		},
+		Down: migrate.Exec(
+			`ALTER TABLE order_items DROP COLUMN allocations`,
+			`ALTER TABLE stock_movements DROP COLUMN location_id`,
+			`DROP TABLE IF EXISTS stock_levels`,
+			`DROP TABLE IF EXISTS locations`,
+		),
+	},
+	{
+		Version: 15,
+		Name:    "stock_subscriptions",
+		Up: migrate.Exec(
+			`CREATE TABLE IF NOT EXISTS stock_subscriptions (
+				user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+				variant_id VARCHAR(36) NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL,
+				PRIMARY KEY (user_id, product_id, variant_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_stock_subscriptions_product ON stock_subscriptions (product_id)`,
+		),
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS stock_subscriptions`,
+		),
+	},
+	{
+		Version: 16,
+		Name:    "low_stock_threshold",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN low_stock_threshold INTEGER`,
+		),
+		Down: migrate.Exec(
+			`ALTER TABLE products DROP COLUMN low_stock_threshold`,
+		),
+	},
+	{
+		Version: 17,
+		Name:    "guest_carts",
+		Up: migrate.Exec(
+			`CREATE TABLE IF NOT EXISTS guest_carts (
+				id UUID PRIMARY KEY,
+				updated_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_guest_carts_updated ON guest_carts (updated_at)`,
+			`CREATE TABLE IF NOT EXISTS guest_cart_items (
+				guest_id UUID NOT NULL REFERENCES guest_carts(id) ON DELETE CASCADE,
+				product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+				variant_id VARCHAR(36) NOT NULL DEFAULT '',
+				quantity INT NOT NULL,
+				PRIMARY KEY (guest_id, product_id, variant_id)
+			)`,
+		),
+		Down: migrate.Exec(
+			`DROP TABLE IF EXISTS guest_cart_items`,
+			`DROP TABLE IF EXISTS guest_carts`,
+		),
+	},
+	{
+		Version: 18,
+		Name:    "product_archived_status",
+		Up: migrate.Exec(
+			`ALTER TABLE products ADD COLUMN archived_status VARCHAR(20)`,
+		),
+		Down: migrate.Exec(
+			`ALTER TABLE products DROP COLUMN archived_status`,
+		),
+	},
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"strings"
+
+	"github.com/example/ecommerce-api/internal/search"
+)
+
+// pgIndex searches the products.search_vector column, where the name
+// weighs most, then SKU and category, then the description
+type pgIndex struct {
+	*sqlVocabulary
+}
+
+func (ix pgIndex) Search(ctx context.Context, query string, limit int) ([]search.Hit, error) {
+	vocab, err := ix.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tsquery := pgTSQuery(search.Expand(query, vocab))
+	if tsquery == "" {
+		return []search.Hit{}, nil
+	}
+
+	rows, err := ix.db.QueryContext(ctx,
+		`SELECT id, ts_rank(search_vector, to_tsquery('simple', $1)) AS score
+		FROM products WHERE search_vector @@ to_tsquery('simple', $1)
+		ORDER BY score DESC, id LIMIT $2`,
+		tsquery, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return searchScores(rows)
+}
+
+// pgTSQuery requires one match per query word: the word, its stem or a
+// synonym as prefixes, or a typo correction
+func pgTSQuery(expansions []search.Expansion) string {
+	groups := make([]string, 0, len(expansions))
+	for _, e := range expansions {
+		alts := []string{e.Word + ":*", e.Stem + ":*"}
+		for _, s := range e.Synonyms {
+			alts = append(alts, s+":*")
+		}
+		alts = append(alts, e.Typos...)
+		groups = append(groups, "("+strings.Join(alts, " | ")+")")
+	}
+	return strings.Join(groups, " & ")
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+type actorKey struct{}
+
+// WithActor returns ctx carrying the ID of the user making changes, which
+// price history records against the changes made with it
+func WithActor(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+func actorFrom(ctx context.Context) string {
+	id, _ := ctx.Value(actorKey{}).(string)
+	return id
+}
+
+// newPriceChange returns a history entry, or nil when the price did not
+// change
+func newPriceChange(productID string, oldCents, newCents int64, reason, actorID, scheduleID string, at time.Time) *models.PriceChange {
+	if oldCents == newCents {
+		return nil
+	}
+	return &models.PriceChange{
+		ID:            uuid.NewString(),
+		ProductID:     productID,
+		OldPriceCents: oldCents,
+		NewPriceCents: newCents,
+		Reason:        reason,
+		ActorID:       actorID,
+		ScheduleID:    scheduleID,
+		CreatedAt:     at,
+	}
+}
+
+// basePriceChange records an edit of the price p sells at outside sales
+func basePriceChange(before, after *models.Product, reason, actorID string, at time.Time) *models.PriceChange {
+	return newPriceChange(after.ID, before.BasePrice(), after.BasePrice(), reason, actorID, "", at)
+}
+
+// scheduleNextAt is when sc next changes a price: its start while pending,
+// its end while a sale runs, and nil once it is over
+func scheduleNextAt(sc *models.PriceSchedule) *time.Time {
+	switch sc.Status {
+	case models.SchedulePending:
+		return &sc.StartsAt
+	case models.ScheduleActive:
+		return sc.EndsAt
+	}
+	return nil
+}
+
+// scheduleDue reports whether sc has a step to take at now
+func scheduleDue(sc *models.PriceSchedule, now time.Time) bool {
+	next := scheduleNextAt(sc)
+	return next != nil && !next.After(now)
+}
+
+// scheduleBefore orders due schedules by when their step falls. On a tie a
+// running sale ends before a pending one starts, so back-to-back sales
+// hand over cleanly; the SQL backends get the same from ordering by status.
+func scheduleBefore(a, b *models.PriceSchedule) bool {
+	ta, tb := scheduleNextAt(a), scheduleNextAt(b)
+	if !ta.Equal(*tb) {
+		return ta.Before(*tb)
+	}
+	if a.Status != b.Status {
+		return a.Status < b.Status
+	}
+	return a.ID < b.ID
+}
+
+// stepPriceSchedule takes the next step of sc, which is due, on p: a
+// permanent change sets the regular price, a sale saves the regular price
+// and applies its own, and a sale ending restores the regular price. A sale
+// found pending after its end is skipped. Returns the history entry to
+// record, or nil.
+func stepPriceSchedule(p *models.Product, sc *models.PriceSchedule, now time.Time) *models.PriceChange {
+	switch {
+	case sc.Status == models.SchedulePending && sc.EndsAt == nil:
+		sc.Status = models.ScheduleDone
+		old := p.BasePrice()
+		p.SetBasePrice(sc.PriceCents)
+		return newPriceChange(p.ID, old, sc.PriceCents, models.PriceScheduled, "", sc.ID, sc.StartsAt)
+
+	case sc.Status == models.SchedulePending && !sc.EndsAt.After(now):
+		sc.Status = models.ScheduleDone
+		return nil
+
+	case sc.Status == models.SchedulePending:
+		sc.Status = models.ScheduleActive
+		old := p.PriceCents
+		regular := p.BasePrice()
+		p.RegularPriceCents = &regular
+		p.PriceCents = sc.PriceCents
+		return newPriceChange(p.ID, old, sc.PriceCents, models.PriceSaleStart, "", sc.ID, sc.StartsAt)
+
+	case sc.Status == models.ScheduleActive:
+		sc.Status = models.ScheduleDone
+		return endSale(p, sc, *sc.EndsAt, "")
+	}
+	return nil
+}
+
+// endSale puts p back on its regular price when sc, its running sale, ends
+func endSale(p *models.Product, sc *models.PriceSchedule, at time.Time, actorID string) *models.PriceChange {
+	old := p.PriceCents
+	p.PriceCents = p.BasePrice()
+	p.RegularPriceCents = nil
+	return newPriceChange(p.ID, old, p.PriceCents, models.PriceSaleEnd, actorID, sc.ID, at)
+}
+
+// salesOverlap reports whether sc, a sale, shares any time with another
+// pending or running sale of the same product in others
+func salesOverlap(sc *models.PriceSchedule, others []*models.PriceSchedule) bool {
+	if sc.EndsAt == nil {
+		return false
+	}
+	for _, o := range others {
+		if o.ID == sc.ID || o.ProductID != sc.ProductID || o.EndsAt == nil {
+			continue
+		}
+		if o.Status != models.SchedulePending && o.Status != models.ScheduleActive {
+			continue
+		}
+		if sc.StartsAt.Before(*o.EndsAt) && o.StartsAt.Before(*sc.EndsAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// comparePriceSchedules orders ListPriceSchedules: by start, then creation
+func comparePriceSchedules(a, b *models.PriceSchedule) int {
+	if c := a.StartsAt.Compare(b.StartsAt); c != 0 {
+		return c
+	}
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
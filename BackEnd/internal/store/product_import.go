@@ -0,0 +1,70 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+var errDuplicateImportSKU = errors.New("sku listed twice in import")
+
+// checkImportSKUs rejects imports naming a SKU twice, since the second row
+// would silently overwrite the first
+func checkImportSKUs(skus []string) error {
+	seen := make(map[string]bool, len(skus))
+	for _, sku := range skus {
+		if seen[sku] {
+			return fmt.Errorf("%w: %s", errDuplicateImportSKU, sku)
+		}
+		seen[sku] = true
+	}
+	return nil
+}
+
+// importRow runs update on the product stored under a SKU, or on a new one
+// when existing is nil, and returns what to write
+func importRow(i int, sku string, existing *models.Product, now time.Time, update func(i int, p *models.Product) error) (ImportedProduct, error) {
+	var row ImportedProduct
+	if existing != nil {
+		row.Product = cloneProduct(existing)
+	} else {
+		row.Created = true
+		row.Product = &models.Product{
+			SKU:      sku,
+			Status:   models.ProductPublished,
+			Images:   []models.ProductImage{},
+			Options:  []models.ProductOption{},
+			Variants: []models.ProductVariant{},
+		}
+	}
+
+	p := row.Product
+	variants := p.Variants
+	if err := update(i, p); err != nil {
+		return row, err
+	}
+	p.Variants = variants
+	rollupStock(p)
+	if p.Images == nil {
+		p.Images = []models.ProductImage{}
+	}
+	if p.Options == nil {
+		p.Options = []models.ProductOption{}
+	}
+	if row.Created {
+		p.CreatedAt = now
+	}
+	p.UpdatedAt = now
+	return row, nil
+}
+
+// importPriceChange is the history entry for an imported row whose product
+// sold at before outside sales, or nil when the price stayed
+func importPriceChange(row ImportedProduct, before int64, actorID string, now time.Time) *models.PriceChange {
+	if row.Created {
+		return newPriceChange(row.Product.ID, 0, row.Product.BasePrice(), models.PriceCreated, actorID, "", now)
+	}
+	return newPriceChange(row.Product.ID, before, row.Product.BasePrice(), models.PriceImport, actorID, "", now)
+}
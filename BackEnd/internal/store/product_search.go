@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/search"
+)
+
+// maxSearchHits caps how many products a text query can match; relevance
+// beyond that is too low to page through
+const maxSearchHits = 1000
+
+// searchHits runs filter.Query through idx and returns the score of every
+// matching product, or nil when there is no query
+func searchHits(ctx context.Context, idx search.Index, filter ProductFilter) (map[string]float64, error) {
+	if strings.TrimSpace(filter.Query) == "" {
+		return nil, nil
+	}
+	hits, err := idx.Search(ctx, filter.Query, maxSearchHits)
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[string]float64, len(hits))
+	for _, h := range hits {
+		scores[h.ID] = h.Score
+	}
+	return scores, nil
+}
+
+// relevance reports whether a listing ranks by search score
+func (f ProductFilter) relevance(hits map[string]float64) bool {
+	return f.Sort == SortRelevance && hits != nil
+}
+
+// soldStatuses are the order statuses whose items count as sold
+var soldStatuses = []string{"paid", "done", "completed"}
+
+func isSold(status string) bool {
+	return slices.Contains(soldStatuses, strings.ToLower(status))
+}
+
+// matches applies every part of f except the sort to p. hits are the
+// searchHits for f.Query.
+func (f ProductFilter) matches(p *models.Product, hits map[string]float64) bool {
+	if hits != nil {
+		if _, ok := hits[p.ID]; !ok {
+			return false
+		}
+	}
+	switch {
+	case p.ArchivedAt != nil && !f.IncludeArchived:
+		return false
+	case f.Status != "" && p.Status != f.Status:
+		return false
+	case !f.LiveAt.IsZero() && !p.Live(f.LiveAt):
+		return false
+	case len(f.CategoryIDs) > 0 && !slices.Contains(f.CategoryIDs, p.CategoryID):
+		return false
+	case f.MinPriceCents > 0 && p.PriceCents < f.MinPriceCents:
+		return false
+	case f.MaxPriceCents > 0 && p.PriceCents > f.MaxPriceCents:
+		return false
+	case f.InStock && p.Stock <= 0:
+		return false
+	case f.MinRating > 0 && p.Rating < f.MinRating:
+		return false
+	}
+	return true
+}
+
+// ascending reports whether s puts the lowest value first
+func (s ProductSort) ascending() bool { return s == SortPriceAsc }
+
+// value is what s ranks p by; sold holds units sold per product ID and
+// hits the search scores, each only read by the sort that needs it
+func (s ProductSort) value(p *models.Product, sold map[string]int, hits map[string]float64) float64 {
+	switch s {
+	case SortPriceAsc, SortPriceDesc:
+		return float64(p.PriceCents)
+	case SortRating:
+		return p.Rating
+	case SortBestSelling:
+		return float64(sold[p.ID])
+	case SortRelevance:
+		return hits[p.ID]
+	}
+	return 0
+}
+
+// priceBucket returns the index of the bucket price falls in, or -1 when it
+// is below the first bound
+func priceBucket(bounds []int64, price int64) int {
+	for i := len(bounds) - 1; i >= 0; i-- {
+		if price >= bounds[i] {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+// reservationSweep is how often StartReservationExpiry looks for orders left
+// unpaid too long
+const reservationSweep = time.Minute
+
+// orderReservation is the reservation state an order in status is in:
+// released once it failed, was canceled or expired, committed once it is
+// sold, and held otherwise
+func orderReservation(status string) string {
+	switch strings.ToLower(status) {
+	case "failed", "canceled", "expired":
+		return models.ReservationReleased
+	}
+	if isSold(status) {
+		return models.ReservationCommitted
+	}
+	return models.ReservationHeld
+}
+
+// reservationMove reports how moving an order from reservation state from to
+// to changes stock: -1 takes its units again, 1 puts them back, 0 leaves
+// stock alone
+func reservationMove(from, to string) int {
+	switch {
+	case from == models.ReservationReleased && to != models.ReservationReleased:
+		return -1
+	case from != models.ReservationReleased && to == models.ReservationReleased:
+		return 1
+	}
+	return 0
+}
+
+// StartReservationExpiry expires the orders of st left unpaid for longer than
+// timeout, checking every minute or every timeout if that is shorter, until
+// the returned stop function is called
+func StartReservationExpiry(st Store, timeout time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(min(reservationSweep, timeout))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := st.ExpireReservations(context.Background(), time.Now().Add(-timeout))
+				if err != nil {
+					log.Printf("⚠️  Failed to expire unpaid orders: %v", err)
+				} else if n > 0 {
+					log.Printf("⏰ Expired %d unpaid orders; their stock is released", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package store
+
+import "fmt"
+
+// freeSKU returns sku with the lowest numbered suffix from 2 up that taken
+// reports free, e.g. KAOS-1-2 for a second KAOS-1. It renames duplicates
+// left from before SKUs were unique.
+func freeSKU(sku string, taken func(sku string) (bool, error)) (string, error) {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s-%d", sku, n)
+		used, err := taken(candidate)
+		if err != nil || !used {
+			return candidate, err
+		}
+	}
+}
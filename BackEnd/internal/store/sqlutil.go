@@ -0,0 +1,2416 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/search"
+)
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// productSelect lists product columns in the order scanProduct expects.
+// Both SQL backends share it; nullable legacy columns are coalesced.
+// Variants are not part of the row; load them with loadVariants.
+const productSelect = `id, name, COALESCE(description, ''), COALESCE(category, ''), price_cents, sku, stock, COALESCE(thumbnail, ''), images, rating, review_count, created_at, updated_at, archived_at, options, category_id, status, publish_at, unpublish_at, regular_price_cents, low_stock_threshold`
+
+func scanProduct(row rowScanner) (*models.Product, error) {
+	p := models.Product{Variants: []models.ProductVariant{}}
+	var images, options, categoryID sql.NullString
+	var archived, publishAt, unpublishAt sql.NullTime
+	var regularPrice sql.NullInt64
+	var threshold sql.NullInt32
+	if err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Category, &p.PriceCents, &p.SKU, &p.Stock, &p.Thumbnail, &images, &p.Rating, &p.ReviewCount, &p.CreatedAt, &p.UpdatedAt, &archived, &options, &categoryID, &p.Status, &publishAt, &unpublishAt, &regularPrice, &threshold); err != nil {
+		return nil, err
+	}
+	p.CategoryID = categoryID.String
+	if regularPrice.Valid {
+		p.RegularPriceCents = &regularPrice.Int64
+	}
+	if threshold.Valid {
+		n := int(threshold.Int32)
+		p.LowStockThreshold = &n
+	}
+	if archived.Valid {
+		p.ArchivedAt = &archived.Time
+	}
+	if publishAt.Valid {
+		p.PublishAt = &publishAt.Time
+	}
+	if unpublishAt.Valid {
+		p.UnpublishAt = &unpublishAt.Time
+	}
+
+	imgs, err := decodeImages(images.String)
+	if err != nil {
+		return nil, err
+	}
+	p.Images = imgs
+
+	p.Options = []models.ProductOption{}
+	if options.String != "" {
+		if err := json.Unmarshal([]byte(options.String), &p.Options); err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// productWhere turns every part of filter except the sort into WHERE
+// conditions for the products table
+func productWhere(filter ProductFilter, hits map[string]float64, placeholder func(n int) string) ([]string, []any) {
+	where := []string{}
+	args := []any{}
+	arg := func(v any) string {
+		args = append(args, v)
+		return placeholder(len(args))
+	}
+
+	if !filter.IncludeArchived {
+		where = append(where, `archived_at IS NULL`)
+	}
+	if filter.Status != "" {
+		where = append(where, `status = `+arg(filter.Status))
+	}
+	if !filter.LiveAt.IsZero() {
+		where = append(where, `status = `+arg(models.ProductPublished),
+			`(publish_at IS NULL OR publish_at <= `+arg(filter.LiveAt)+`)`,
+			`(unpublish_at IS NULL OR unpublish_at > `+arg(filter.LiveAt)+`)`)
+	}
+	if hits != nil {
+		if len(hits) == 0 {
+			where = append(where, `1 = 0`)
+		} else {
+			in := make([]string, 0, len(hits))
+			for id := range hits {
+				in = append(in, arg(id))
+			}
+			where = append(where, `id IN (`+strings.Join(in, ", ")+`)`)
+		}
+	}
+	if len(filter.CategoryIDs) > 0 {
+		in := make([]string, len(filter.CategoryIDs))
+		for i, id := range filter.CategoryIDs {
+			in[i] = arg(id)
+		}
+		where = append(where, `category_id IN (`+strings.Join(in, ", ")+`)`)
+	}
+	if filter.MinPriceCents > 0 {
+		where = append(where, `price_cents >= `+arg(filter.MinPriceCents))
+	}
+	if filter.MaxPriceCents > 0 {
+		where = append(where, `price_cents <= `+arg(filter.MaxPriceCents))
+	}
+	if filter.InStock {
+		where = append(where, `stock > 0`)
+	}
+	if filter.MinRating > 0 {
+		where = append(where, `rating >= `+arg(filter.MinRating))
+	}
+	return where, args
+}
+
+// productSortExpr is the SQL value a sort ranks products by, "" for
+// SortNewest
+func productSortExpr(s ProductSort) string {
+	switch s {
+	case SortPriceAsc, SortPriceDesc:
+		return `price_cents`
+	case SortRating:
+		return `rating`
+	case SortBestSelling:
+		return `(SELECT COALESCE(SUM(oi.quantity), 0) FROM order_items oi JOIN orders o ON o.id = oi.order_id
+			WHERE oi.product_id = products.id AND LOWER(o.status) IN ('` + strings.Join(soldStatuses, `', '`) + `'))`
+	}
+	return ""
+}
+
+// sortArg converts a sort cursor value back to the column's type
+func sortArg(s ProductSort, v float64) any {
+	if s == SortRating {
+		return v
+	}
+	return int64(v)
+}
+
+// withSortValue scans one extra trailing column into value
+type withSortValue struct {
+	row   rowScanner
+	value *float64
+}
+
+func (w withSortValue) Scan(dest ...any) error {
+	return w.row.Scan(append(dest, w.value)...)
+}
+
+// queryProducts runs ListProducts for either SQL backend; hits are the
+// searchHits for filter.Query. Variants are not loaded.
+func queryProducts(ctx context.Context, q queryer, filter ProductFilter, hits map[string]float64, page Page, placeholder func(n int) string) ([]*models.Product, string, error) {
+	where, args := productWhere(filter, hits, placeholder)
+	if filter.relevance(hits) {
+		return queryProductsByScore(ctx, q, where, args, hits, page)
+	}
+	expr := productSortExpr(filter.Sort)
+
+	var tail string
+	if expr == "" {
+		var err error
+		where, args, tail, err = pageQuery(page, where, args, placeholder)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		c, err := decodeSortCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		dir, op := `DESC`, `<`
+		if filter.Sort.ascending() {
+			dir, op = `ASC`, `>`
+		}
+		if c != nil {
+			v := sortArg(filter.Sort, c.Value)
+			args = append(args, v, v, c.CreatedAt, c.CreatedAt, c.ID)
+			n := len(args)
+			where = append(where, fmt.Sprintf(`(%s %s %s OR (%s = %s AND (created_at < %s OR (created_at = %s AND id < %s))))`,
+				expr, op, placeholder(n-4), expr, placeholder(n-3), placeholder(n-2), placeholder(n-1), placeholder(n)))
+		}
+		tail = ` ORDER BY sort_value ` + dir + `, created_at DESC, id DESC`
+		if page.Limit > 0 {
+			args = append(args, page.Limit+1)
+			tail += ` LIMIT ` + placeholder(len(args))
+		}
+	}
+
+	query := `SELECT ` + productSelect
+	if expr != "" {
+		query += `, ` + expr + ` AS sort_value`
+	}
+	query += ` FROM products`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	rows, err := q.QueryContext(ctx, query+tail, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	res := []*models.Product{}
+	values := map[*models.Product]float64{}
+	for rows.Next() {
+		var row rowScanner = rows
+		var value float64
+		if expr != "" {
+			row = withSortValue{row: rows, value: &value}
+		}
+		p, err := scanProduct(row)
+		if err != nil {
+			return nil, "", err
+		}
+		values[p] = value
+		res = append(res, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	if expr == "" {
+		res, next := cutPage(res, page.Limit, productKey)
+		return res, next, nil
+	}
+	res, next := cutSortedPage(res, page.Limit, func(p *models.Product) float64 { return values[p] }, productKey)
+	return res, next, nil
+}
+
+// queryProductsByScore ranks the products matching where by search score.
+// The scores live outside the database, so the matches, at most
+// maxSearchHits of them, are loaded whole and paged here.
+func queryProductsByScore(ctx context.Context, q queryer, where []string, args []any, hits map[string]float64, page Page) ([]*models.Product, string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+productSelect+` FROM products WHERE `+strings.Join(where, ` AND `), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	res := []*models.Product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		res = append(res, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	return sortedPageSlice(res, page, false, func(p *models.Product) float64 { return hits[p.ID] }, productKey)
+}
+
+// queryProductFacets runs ProductFacets for either SQL backend
+func queryProductFacets(ctx context.Context, q queryer, filter ProductFilter, hits map[string]float64, priceBounds []int64, placeholder func(n int) string) (*ProductFacets, error) {
+	facets := &ProductFacets{Categories: map[string]int{}, PriceBuckets: make([]int, len(priceBounds))}
+
+	byCategory := filter
+	byCategory.CategoryIDs = nil
+	where, args := productWhere(byCategory, hits, placeholder)
+	query := `SELECT category_id, COUNT(*) FROM products`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	rows, err := q.QueryContext(ctx, query+` GROUP BY category_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id sql.NullString
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		facets.Categories[id.String] += n
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(priceBounds) == 0 {
+		return facets, nil
+	}
+
+	// Highest bound first, so each price lands in the bucket it starts.
+	// The CASE comes first in the query and so takes the first arguments.
+	args = []any{}
+	bucket := `CASE`
+	for i := len(priceBounds) - 1; i >= 0; i-- {
+		args = append(args, priceBounds[i])
+		bucket += fmt.Sprintf(` WHEN price_cents >= %s THEN %d`, placeholder(len(args)), i)
+	}
+	bucket += ` ELSE -1 END`
+	byPrice := filter
+	byPrice.MinPriceCents, byPrice.MaxPriceCents = 0, 0
+	offset := len(args)
+	where, whereArgs := productWhere(byPrice, hits, func(n int) string { return placeholder(offset + n) })
+	args = append(args, whereArgs...)
+	query = `SELECT ` + bucket + ` AS bucket, COUNT(*) FROM products`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	rows, err = q.QueryContext(ctx, query+` GROUP BY 1`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i, n int
+		if err := rows.Scan(&i, &n); err != nil {
+			return nil, err
+		}
+		if i >= 0 && i < len(facets.PriceBuckets) {
+			facets.PriceBuckets[i] = n
+		}
+	}
+	return facets, rows.Err()
+}
+
+// encodeOptions stores a product's option list as a JSON array
+func encodeOptions(options []models.ProductOption) (string, error) {
+	if options == nil {
+		options = []models.ProductOption{}
+	}
+	b, err := json.Marshal(options)
+	return string(b), err
+}
+
+// nullID stores an empty reference as NULL so foreign keys accept it
+func nullID(id string) any {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// categorySelect lists categories columns in the order scanCategory expects
+const categorySelect = `id, parent_id, name, slug, sku_prefix, sort_order, image, created_at, updated_at`
+
+func scanCategory(row rowScanner) (*models.Category, error) {
+	c := models.Category{}
+	var parentID sql.NullString
+	if err := row.Scan(&c.ID, &parentID, &c.Name, &c.Slug, &c.SKUPrefix, &c.SortOrder, &c.Image, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	c.ParentID = parentID.String
+	return &c, nil
+}
+
+// queryCategories loads every category by sort order then name. lock adds
+// FOR UPDATE so tree edits inside a transaction queue behind each other.
+func queryCategories(ctx context.Context, q queryer, lock bool) ([]*models.Category, error) {
+	query := `SELECT ` + categorySelect + ` FROM categories ORDER BY sort_order, name, id`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.Category{}
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+// categoriesByID indexes categories for checkCategoryParent
+func categoriesByID(cs []*models.Category) map[string]*models.Category {
+	byID := make(map[string]*models.Category, len(cs))
+	for _, c := range cs {
+		byID[c.ID] = c
+	}
+	return byID
+}
+
+// backfillCategories is the data half of the categories migration: every
+// distinct free-text product category becomes a top-level category and its
+// products point at it
+func backfillCategories(ctx context.Context, tx *sql.Tx, placeholder func(n int) string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT category FROM products WHERE category IS NOT NULL ORDER BY category`)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cats, byName := categoriesFromNames(names, time.Now())
+	ph := make([]string, 8)
+	for i := range ph {
+		ph[i] = placeholder(i + 1)
+	}
+	for _, c := range cats {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO categories (id, parent_id, name, slug, sort_order, image, created_at, updated_at) VALUES (`+strings.Join(ph, ", ")+`)`,
+			c.ID, nil, c.Name, c.Slug, c.SortOrder, c.Image, c.CreatedAt, c.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for name, c := range byName {
+		_, err := tx.ExecContext(ctx,
+			fmt.Sprintf(`UPDATE products SET category_id=%s, category=%s WHERE category=%s`, placeholder(1), placeholder(2), placeholder(3)),
+			c.ID, c.Name, name,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variantSelect lists product_variants columns in the order scanVariant expects
+const variantSelect = `id, product_id, sku, options, price_cents, stock, image, created_at, updated_at`
+
+func scanVariant(row rowScanner) (*models.ProductVariant, error) {
+	v := models.ProductVariant{}
+	var options string
+	var price sql.NullInt64
+	if err := row.Scan(&v.ID, &v.ProductID, &v.SKU, &options, &price, &v.Stock, &v.Image, &v.CreatedAt, &v.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if price.Valid {
+		v.PriceCents = &price.Int64
+	}
+	v.Options = map[string]string{}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &v.Options); err != nil {
+			return nil, err
+		}
+	}
+	return &v, nil
+}
+
+// encodeVariantOptions stores a variant's option values as a JSON object
+func encodeVariantOptions(options map[string]string) (string, error) {
+	if options == nil {
+		options = map[string]string{}
+	}
+	b, err := json.Marshal(options)
+	return string(b), err
+}
+
+// nullPrice stores a missing price override as NULL
+func nullPrice(price *int64) any {
+	if price == nil {
+		return nil
+	}
+	return *price
+}
+
+func nullInt(n *int) any {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+// loadVariants fills in Variants for every product with one query per
+// batch, oldest variant first. lock adds FOR UPDATE for use inside a
+// checkout transaction.
+func loadVariants(ctx context.Context, q queryer, products []*models.Product, placeholder func(n int) string, lock bool) error {
+	byID := make(map[string]*models.Product, len(products))
+	for _, p := range products {
+		p.Variants = []models.ProductVariant{}
+		byID[p.ID] = p
+	}
+
+	for start := 0; start < len(products); start += orderItemsBatch {
+		batch := products[start:min(start+orderItemsBatch, len(products))]
+		marks := make([]string, len(batch))
+		args := make([]any, len(batch))
+		for i, p := range batch {
+			marks[i] = placeholder(i + 1)
+			args[i] = p.ID
+		}
+
+		query := `SELECT ` + variantSelect + ` FROM product_variants WHERE product_id IN (` + strings.Join(marks, ", ") + `) ORDER BY created_at, id`
+		if lock {
+			query += ` FOR UPDATE`
+		}
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			v, err := scanVariant(rows)
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+			if p, ok := byID[v.ProductID]; ok {
+				p.Variants = append(p.Variants, *v)
+			}
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeImages stores a gallery as a JSON array, keeping its order
+func encodeImages(images []models.ProductImage) (string, error) {
+	if images == nil {
+		images = []models.ProductImage{}
+	}
+	b, err := json.Marshal(images)
+	return string(b), err
+}
+
+func decodeImages(raw string) ([]models.ProductImage, error) {
+	images := []models.ProductImage{}
+	if raw == "" {
+		return images, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// auditSelect lists audit_log columns in the order scanAuditEntry expects
+const auditSelect = `id, actor_id, action, entity_type, entity_id, before_data, after_data, ip, created_at`
+
+func scanAuditEntry(row rowScanner) (*models.AuditEntry, error) {
+	e := models.AuditEntry{}
+	var before, after sql.NullString
+	if err := row.Scan(&e.ID, &e.ActorID, &e.Action, &e.EntityType, &e.EntityID, &before, &after, &e.IP, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	if before.Valid {
+		e.Before = json.RawMessage(before.String)
+	}
+	if after.Valid {
+		e.After = json.RawMessage(after.String)
+	}
+	return &e, nil
+}
+
+// nullJSON stores an empty diff side as NULL
+func nullJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+// auditQuery builds the SELECT for ListAuditEntries. placeholder renders the
+// n-th bind parameter in the backend's syntax.
+func auditQuery(filter AuditFilter, placeholder func(n int) string) (string, []any) {
+	where := []string{}
+	args := []any{}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, cond+placeholder(len(args)))
+	}
+	if filter.ActorID != "" {
+		add(`actor_id = `, filter.ActorID)
+	}
+	if filter.EntityType != "" {
+		add(`entity_type = `, filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		add(`entity_id = `, filter.EntityID)
+	}
+	if !filter.From.IsZero() {
+		add(`created_at >= `, filter.From)
+	}
+	if !filter.To.IsZero() {
+		add(`created_at < `, filter.To)
+	}
+
+	query := `SELECT ` + auditSelect + ` FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY created_at DESC, id DESC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += ` LIMIT ` + placeholder(len(args))
+	}
+	return query, args
+}
+
+// orderItemsBatch caps the IN list of a single loadOrderItems or
+// loadVariants query
+const orderItemsBatch = 500
+
+// loadOrderItems fills in Items for every order with one query per batch
+// rather than one per order. placeholder renders the n-th bind parameter in
+// the backend's syntax.
+func loadOrderItems(ctx context.Context, q queryer, orders []*models.Order, placeholder func(n int) string) error {
+	byID := make(map[string]*models.Order, len(orders))
+	for _, o := range orders {
+		o.Items = []models.CartItem{}
+		byID[o.ID] = o
+	}
+
+	for start := 0; start < len(orders); start += orderItemsBatch {
+		batch := orders[start:min(start+orderItemsBatch, len(orders))]
+		marks := make([]string, len(batch))
+		args := make([]any, len(batch))
+		for i, o := range batch {
+			marks[i] = placeholder(i + 1)
+			args[i] = o.ID
+		}
+
+		rows, err := q.QueryContext(ctx,
+			`SELECT order_id, product_id, variant_id, quantity, price_cents, allocations FROM order_items WHERE order_id IN (`+strings.Join(marks, ", ")+`)`,
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var orderID string
+			var it models.CartItem
+			var allocations sql.NullString
+			if err := rows.Scan(&orderID, &it.ProductID, &it.VariantID, &it.Quantity, &it.PriceCents, &allocations); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			if allocations.String != "" {
+				if err := json.Unmarshal([]byte(allocations.String), &it.Allocations); err != nil {
+					_ = rows.Close()
+					return err
+				}
+			}
+			if o, ok := byID[orderID]; ok {
+				o.Items = append(o.Items, it)
+			}
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderSelect lists orders columns in the order scanOrder expects. Items
+// are not part of the row; load them with loadOrderItems.
+const orderSelect = `id, user_id, amount_cents, status, payment_ref, reservation, reserved_at, created_at`
+
+func scanOrder(row rowScanner) (*models.Order, error) {
+	o := models.Order{}
+	if err := row.Scan(&o.ID, &o.UserID, &o.Amount, &o.Status, &o.PaymentRef, &o.Reservation, &o.ReservedAt, &o.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// moveOrderStock takes the units of items out of stock with sign -1, failing
+// with ErrInsufficientStock when any are gone, and puts them back with 1.
+// Products are updated in ID order so concurrent moves cannot deadlock.
+func moveOrderStock(ctx context.Context, tx *sql.Tx, items []models.CartItem, sign int, ph func(n int) string) error {
+	items = slices.Clone(items)
+	slices.SortFunc(items, func(a, b models.CartItem) int {
+		return strings.Compare(a.ProductID+"/"+a.VariantID, b.ProductID+"/"+b.VariantID)
+	})
+	for _, it := range items {
+		table, id := "products", it.ProductID
+		if it.VariantID != "" {
+			table, id = "product_variants", it.VariantID
+		}
+		query := `UPDATE ` + table + ` SET stock = stock + ` + ph(1) + ` WHERE id=` + ph(2)
+		args := []any{sign * it.Quantity, id}
+		if sign < 0 {
+			query += ` AND stock >= ` + ph(3)
+			args = append(args, it.Quantity)
+		}
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 && sign < 0 {
+			return fmt.Errorf("%w for product: %s", ErrInsufficientStock, it.ProductID)
+		}
+		if it.VariantID == "" {
+			continue
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE products SET stock=(SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id=`+ph(1)+`) WHERE id=`+ph(2),
+			it.ProductID, it.ProductID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setOrderStatus runs UpdateOrderStatus for either SQL backend, moving the
+// order's stock in the same transaction. only, when set, is checked against
+// the locked order and skips the update by returning false.
+func setOrderStatus(ctx context.Context, db *sql.DB, orderID, status string, only func(o *models.Order) bool, ph func(n int) string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	o, err := scanOrder(tx.QueryRowContext(ctx, `SELECT `+orderSelect+` FROM orders WHERE id=`+ph(1)+` FOR UPDATE`, orderID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, errors.New("order not found")
+	}
+	if err != nil {
+		return false, err
+	}
+	if only != nil && !only(o) {
+		return false, nil
+	}
+	if err := loadOrderItems(ctx, tx, []*models.Order{o}, ph); err != nil {
+		return false, err
+	}
+
+	reservation := orderReservation(status)
+	if sign := reservationMove(o.Reservation, reservation); sign != 0 {
+		if err := moveOrderStock(ctx, tx, o.Items, sign, ph); err != nil {
+			return false, err
+		}
+		if sign < 0 {
+			if err := allocateOrder(ctx, tx, o, allocationFrom(ctx), ph); err != nil {
+				return false, err
+			}
+		}
+		now := time.Now()
+		if err := insertStockMovements(ctx, tx, orderStockMovements(o, sign, o.Reservation, actorFrom(ctx), now), ph); err != nil {
+			return false, err
+		}
+		if sign < 0 {
+			o.ReservedAt = now
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE orders SET status=`+ph(1)+`, reservation=`+ph(2)+`, reserved_at=`+ph(3)+` WHERE id=`+ph(4),
+		status, reservation, o.ReservedAt, orderID,
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// expireReservations runs ExpireReservations for either SQL backend, one
+// transaction per order so a payment arriving meanwhile only waits for its
+// own order
+func expireReservations(ctx context.Context, db *sql.DB, heldBefore time.Time, ph func(n int) string) (int, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id FROM orders WHERE reservation=`+ph(1)+` AND reserved_at < `+ph(2),
+		models.ReservationHeld, heldBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Recheck under the lock: the order may have been paid since
+	stillHeld := func(o *models.Order) bool {
+		return o.Reservation == models.ReservationHeld && o.ReservedAt.Before(heldBefore)
+	}
+	n := 0
+	for _, id := range ids {
+		ok, err := setOrderStatus(ctx, db, id, "expired", stillHeld, ph)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// importProducts runs ImportProducts for either SQL backend inside tx. Rows
+// for existing SKUs are locked until the transaction ends.
+func importProducts(ctx context.Context, tx *sql.Tx, skus []string, dryRun bool, update func(i int, p *models.Product) error, placeholder func(n int) string) ([]ImportedProduct, error) {
+	if err := checkImportSKUs(skus); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rows := make([]ImportedProduct, len(skus))
+	before := make([]int64, len(skus))
+	levels := make([]map[string]int, len(skus))
+	for i, sku := range skus {
+		existing, err := scanProduct(tx.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE sku = `+placeholder(1)+` FOR UPDATE`, sku))
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			existing = nil
+		case err != nil:
+			return nil, err
+		default:
+			if err := loadVariants(ctx, tx, []*models.Product{existing}, placeholder, true); err != nil {
+				return nil, err
+			}
+			before[i], levels[i] = existing.BasePrice(), stockLevels(existing)
+		}
+		if rows[i], err = importRow(i, sku, existing, now, update); err != nil {
+			return nil, err
+		}
+	}
+	if dryRun {
+		return rows, nil
+	}
+
+	actorID := actorFrom(ctx)
+	for i, row := range rows {
+		if err := writeImportedProduct(ctx, tx, row, placeholder); err != nil {
+			if isDuplicate(err) {
+				return nil, ErrSKUTaken
+			}
+			return nil, err
+		}
+		if err := insertPriceChange(ctx, tx, importPriceChange(row, before[i], actorID, now), placeholder); err != nil {
+			return nil, err
+		}
+		reason := models.StockAdjustment
+		if row.Created {
+			reason = models.StockRestock
+		}
+		if err := insertStockMovements(ctx, tx, stockChanges(row.Product.ID, levels[i], stockLevels(row.Product), reason, actorID, "", now), placeholder); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// writeImportedProduct inserts a new row, which gets its ID here, or writes
+// back the fields an import can change
+func writeImportedProduct(ctx context.Context, tx *sql.Tx, row ImportedProduct, placeholder func(n int) string) error {
+	p := row.Product
+	images, err := encodeImages(p.Images)
+	if err != nil {
+		return err
+	}
+
+	if row.Created {
+		options, err := encodeOptions(p.Options)
+		if err != nil {
+			return err
+		}
+		p.ID = uuid.NewString()
+		marks := make([]string, 17)
+		for i := range marks {
+			marks[i] = placeholder(i + 1)
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO products (id, name, description, category, category_id, price_cents, sku, stock, thumbnail, images, options, rating, review_count, created_at, updated_at, archived_at, status)
+			VALUES (`+strings.Join(marks, ",")+`)`,
+			p.ID, p.Name, p.Description, p.Category, nullID(p.CategoryID), p.PriceCents, p.SKU, p.Stock, p.Thumbnail, images, options, p.Rating, p.ReviewCount, p.CreatedAt, p.UpdatedAt, p.ArchivedAt, p.Status,
+		)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE products SET name=%s, description=%s, category=%s, category_id=%s, price_cents=%s, regular_price_cents=%s, stock=%s, thumbnail=%s, images=%s, updated_at=%s, archived_at=%s, status=%s WHERE id=%s`,
+			placeholder(1), placeholder(2), placeholder(3), placeholder(4), placeholder(5), placeholder(6), placeholder(7), placeholder(8), placeholder(9), placeholder(10), placeholder(11), placeholder(12), placeholder(13)),
+		p.Name, p.Description, p.Category, nullID(p.CategoryID), p.PriceCents, nullPrice(p.RegularPriceCents), p.Stock, p.Thumbnail, images, p.UpdatedAt, p.ArchivedAt, p.Status, p.ID,
+	)
+	return err
+}
+
+// productIDBySKU resolves GetProductBySKU to IDs for either SQL backend
+func productIDBySKU(ctx context.Context, db *sql.DB, sku string, placeholder func(n int) string) (productID, variantID string, err error) {
+	err = db.QueryRowContext(ctx, `SELECT id FROM products WHERE sku = `+placeholder(1), sku).Scan(&productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = db.QueryRowContext(ctx, `SELECT product_id, id FROM product_variants WHERE sku = `+placeholder(1), sku).Scan(&productID, &variantID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", errors.New("product not found")
+	}
+	return productID, variantID, err
+}
+
+// dedupeSKUs renames the rows of table, products or product_variants, that
+// share a SKU with an older row so that a unique index can be built
+func dedupeSKUs(ctx context.Context, tx *sql.Tx, table string, placeholder func(n int) string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT sku FROM `+table+` GROUP BY sku HAVING COUNT(*) > 1`)
+	if err != nil {
+		return err
+	}
+	dups := []string{}
+	for rows.Next() {
+		var sku string
+		if err := rows.Scan(&sku); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		dups = append(dups, sku)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	taken := func(sku string) (bool, error) {
+		var n int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE sku = `+placeholder(1), sku).Scan(&n)
+		return n > 0, err
+	}
+	for _, sku := range dups {
+		rows, err := tx.QueryContext(ctx, `SELECT id FROM `+table+` WHERE sku = `+placeholder(1)+` ORDER BY created_at, id`, sku)
+		if err != nil {
+			return err
+		}
+		ids := []string{}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// The oldest keeps the SKU
+		for _, id := range ids[1:] {
+			renamed, err := freeSKU(sku, taken)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET sku = `+placeholder(1)+` WHERE id = `+placeholder(2), renamed, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// priceChangeSelect lists price_history columns in the order
+// scanPriceChange expects
+const priceChangeSelect = `id, product_id, old_price_cents, new_price_cents, reason, actor_id, schedule_id, created_at`
+
+func scanPriceChange(row rowScanner) (*models.PriceChange, error) {
+	c := models.PriceChange{}
+	if err := row.Scan(&c.ID, &c.ProductID, &c.OldPriceCents, &c.NewPriceCents, &c.Reason, &c.ActorID, &c.ScheduleID, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// insertPriceChange records c in the price history; nil records nothing
+func insertPriceChange(ctx context.Context, tx *sql.Tx, c *models.PriceChange, placeholder func(n int) string) error {
+	if c == nil {
+		return nil
+	}
+	marks := make([]string, 8)
+	for i := range marks {
+		marks[i] = placeholder(i + 1)
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO price_history (`+priceChangeSelect+`) VALUES (`+strings.Join(marks, ",")+`)`,
+		c.ID, c.ProductID, c.OldPriceCents, c.NewPriceCents, c.Reason, c.ActorID, c.ScheduleID, c.CreatedAt,
+	)
+	return err
+}
+
+// queryPriceHistory runs ListPriceHistory for either SQL backend
+func queryPriceHistory(ctx context.Context, db *sql.DB, productID string, placeholder func(n int) string) ([]*models.PriceChange, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+priceChangeSelect+` FROM price_history WHERE product_id = `+placeholder(1)+` ORDER BY created_at DESC, id DESC`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []*models.PriceChange{}
+	for rows.Next() {
+		c, err := scanPriceChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+// priceScheduleSelect lists price_schedules columns in the order
+// scanPriceSchedule expects. next_at is left out; it is always
+// scheduleNextAt of the row.
+const priceScheduleSelect = `id, product_id, price_cents, starts_at, ends_at, status, created_by, created_at`
+
+func scanPriceSchedule(row rowScanner) (*models.PriceSchedule, error) {
+	sc := models.PriceSchedule{}
+	var endsAt sql.NullTime
+	if err := row.Scan(&sc.ID, &sc.ProductID, &sc.PriceCents, &sc.StartsAt, &endsAt, &sc.Status, &sc.CreatedBy, &sc.CreatedAt); err != nil {
+		return nil, err
+	}
+	if endsAt.Valid {
+		sc.EndsAt = &endsAt.Time
+	}
+	return &sc, nil
+}
+
+// queryPriceSchedules reads schedules matching where, a condition on
+// price_schedules, in the order ListPriceSchedules returns them. lock adds
+// FOR UPDATE.
+func queryPriceSchedules(ctx context.Context, q queryer, where string, args []any, lock bool) ([]*models.PriceSchedule, error) {
+	query := `SELECT ` + priceScheduleSelect + ` FROM price_schedules WHERE ` + where + ` ORDER BY starts_at, created_at, id`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []*models.PriceSchedule{}
+	for rows.Next() {
+		sc, err := scanPriceSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sc)
+	}
+	return res, rows.Err()
+}
+
+// createPriceSchedule runs CreatePriceSchedule for either SQL backend. The
+// product row is locked so that two overlapping sales cannot both pass the
+// check.
+func createPriceSchedule(ctx context.Context, db *sql.DB, clock *priceClock, sc *models.PriceSchedule, placeholder func(n int) string) (*models.PriceSchedule, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var locked string
+	err = tx.QueryRowContext(ctx, `SELECT id FROM products WHERE id = `+placeholder(1)+` FOR UPDATE`, sc.ProductID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("product not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	others, err := queryPriceSchedules(ctx, tx, `product_id = `+placeholder(1), []any{sc.ProductID}, false)
+	if err != nil {
+		return nil, err
+	}
+	if salesOverlap(sc, others) {
+		return nil, ErrPriceScheduleOverlap
+	}
+
+	stored := *sc
+	stored.ID = uuid.NewString()
+	stored.Status = models.SchedulePending
+	stored.CreatedBy = actorFrom(ctx)
+	stored.CreatedAt = time.Now()
+	marks := make([]string, 9)
+	for i := range marks {
+		marks[i] = placeholder(i + 1)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO price_schedules (`+priceScheduleSelect+`, next_at) VALUES (`+strings.Join(marks, ",")+`)`,
+		stored.ID, stored.ProductID, stored.PriceCents, stored.StartsAt, stored.EndsAt, stored.Status, stored.CreatedBy, stored.CreatedAt, scheduleNextAt(&stored),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// One that starts now takes effect straight away
+	clock.expire()
+	if err := clock.settle(ctx, db, placeholder); err != nil {
+		return nil, err
+	}
+	found, err := queryPriceSchedules(ctx, db, `id = `+placeholder(1), []any{stored.ID}, false)
+	if err != nil || len(found) == 0 {
+		return &stored, err
+	}
+	return found[0], nil
+}
+
+// cancelPriceSchedule runs CancelPriceSchedule for either SQL backend. The
+// schedule is locked before the product, in the order
+// applyPriceSchedules takes them.
+func cancelPriceSchedule(ctx context.Context, db *sql.DB, clock *priceClock, productID, scheduleID string, placeholder func(n int) string) (*models.PriceSchedule, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	found, err := queryPriceSchedules(ctx, tx, `id = `+placeholder(1)+` AND product_id = `+placeholder(2), []any{scheduleID, productID}, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errors.New("price schedule not found")
+	}
+	sc := found[0]
+	now := time.Now()
+	switch sc.Status {
+	case models.SchedulePending:
+	case models.ScheduleActive:
+		p, err := lockProductPrice(ctx, tx, productID, placeholder)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeScheduledPrice(ctx, tx, p, endSale(p, sc, now, actorFrom(ctx)), now, placeholder); err != nil {
+			return nil, err
+		}
+		sc.EndsAt = &now
+	default:
+		return nil, ErrPriceScheduleDone
+	}
+	sc.Status = models.ScheduleCanceled
+	if err := updatePriceSchedule(ctx, tx, sc, placeholder); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	clock.expire()
+	return sc, nil
+}
+
+// applyPriceSchedules takes every schedule step due at now, earliest first,
+// in one transaction, and returns when the next step falls, zero when none
+// is left. The schedules are locked, so instances settling at the same
+// time take each step once.
+func applyPriceSchedules(ctx context.Context, db *sql.DB, now time.Time, placeholder func(n int) string) (time.Time, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// 'active' sorts before 'pending', so on a tie a sale ends before the
+	// next one starts, as scheduleBefore has it
+	rows, err := tx.QueryContext(ctx, `SELECT `+priceScheduleSelect+` FROM price_schedules WHERE next_at <= `+placeholder(1)+` ORDER BY next_at, status, id FOR UPDATE`, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	due := []*models.PriceSchedule{}
+	for rows.Next() {
+		sc, err := scanPriceSchedule(rows)
+		if err != nil {
+			_ = rows.Close()
+			return time.Time{}, err
+		}
+		due = append(due, sc)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	for _, sc := range due {
+		p, err := lockProductPrice(ctx, tx, sc.ProductID, placeholder)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if err := writeScheduledPrice(ctx, tx, p, stepPriceSchedule(p, sc, now), now, placeholder); err != nil {
+			return time.Time{}, err
+		}
+		if err := updatePriceSchedule(ctx, tx, sc, placeholder); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	var next sql.NullTime
+	if err := tx.QueryRowContext(ctx, `SELECT MIN(next_at) FROM price_schedules`).Scan(&next); err != nil {
+		return time.Time{}, err
+	}
+	return next.Time, tx.Commit()
+}
+
+// lockProductPrice reads the price columns of a product for update
+func lockProductPrice(ctx context.Context, tx *sql.Tx, id string, placeholder func(n int) string) (*models.Product, error) {
+	p := models.Product{ID: id}
+	var regularPrice sql.NullInt64
+	err := tx.QueryRowContext(ctx, `SELECT price_cents, regular_price_cents FROM products WHERE id = `+placeholder(1)+` FOR UPDATE`, id).Scan(&p.PriceCents, &regularPrice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("product not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if regularPrice.Valid {
+		p.RegularPriceCents = &regularPrice.Int64
+	}
+	return &p, nil
+}
+
+// writeScheduledPrice stores the prices of p, read by lockProductPrice, and
+// records c
+func writeScheduledPrice(ctx context.Context, tx *sql.Tx, p *models.Product, c *models.PriceChange, now time.Time, placeholder func(n int) string) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE products SET price_cents=%s, regular_price_cents=%s, updated_at=%s WHERE id=%s`, placeholder(1), placeholder(2), placeholder(3), placeholder(4)),
+		p.PriceCents, nullPrice(p.RegularPriceCents), now, p.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return insertPriceChange(ctx, tx, c, placeholder)
+}
+
+// updatePriceSchedule writes back the fields a step or cancel changes
+func updatePriceSchedule(ctx context.Context, tx *sql.Tx, sc *models.PriceSchedule, placeholder func(n int) string) error {
+	_, err := tx.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE price_schedules SET status=%s, ends_at=%s, next_at=%s WHERE id=%s`, placeholder(1), placeholder(2), placeholder(3), placeholder(4)),
+		sc.Status, sc.EndsAt, scheduleNextAt(sc), sc.ID,
+	)
+	return err
+}
+
+// priceRecheck is how long a SQL backend trusts what it knows of the next
+// schedule step; other instances may add schedules in the meantime
+const priceRecheck = time.Minute
+
+// priceClock keeps the SQL backends from looking for due price schedules on
+// every read. It remembers when the next step falls and only settles once
+// that time has come or priceRecheck has passed.
+type priceClock struct {
+	mu      sync.Mutex
+	next    time.Time // zero when no step is left
+	checked time.Time
+}
+
+// settle applies the schedule steps that are due, if any may be
+func (c *priceClock) settle(ctx context.Context, db *sql.DB, placeholder func(n int) string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.checked) < priceRecheck && (c.next.IsZero() || now.Before(c.next)) {
+		return nil
+	}
+	next, err := applyPriceSchedules(ctx, db, now, placeholder)
+	if err != nil {
+		return err
+	}
+	c.next, c.checked = next, now
+	return nil
+}
+
+// expire makes the next settle look at the schedules again, after one was
+// added or canceled
+func (c *priceClock) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checked = time.Time{}
+}
+
+// vocabularyTTL is how long the SQL backends trust their word list before
+// reading it from the products table again
+const vocabularyTTL = 5 * time.Minute
+
+// sqlVocabulary is the word list the SQL search indexes correct typos
+// against. The database keeps the full-text index itself; this only has to
+// know which words exist. Put adds a product's words right away, while
+// words of changed or purged products linger until the next reload.
+type sqlVocabulary struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	vocab  *search.Vocabulary
+	loaded time.Time
+}
+
+func (v *sqlVocabulary) Put(p *models.Product) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.vocab != nil {
+		v.vocab.Add(search.Words(p)...)
+	}
+}
+
+func (v *sqlVocabulary) Remove(id string) {}
+
+// get returns the vocabulary, reading it from the products table when it
+// is missing or older than vocabularyTTL
+func (v *sqlVocabulary) get(ctx context.Context) (*search.Vocabulary, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.vocab != nil && time.Since(v.loaded) < vocabularyTTL {
+		return v.vocab, nil
+	}
+
+	rows, err := v.db.QueryContext(ctx, `SELECT name, sku, COALESCE(category, ''), COALESCE(description, '') FROM products`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vocab := search.NewVocabulary()
+	for rows.Next() {
+		var p models.Product
+		if err := rows.Scan(&p.Name, &p.SKU, &p.Category, &p.Description); err != nil {
+			return nil, err
+		}
+		vocab.Add(search.Words(&p)...)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	v.vocab, v.loaded = vocab, time.Now()
+	return vocab, nil
+}
+
+// searchScores reads (id, score) rows from a full-text query
+func searchScores(rows *sql.Rows) ([]search.Hit, error) {
+	defer rows.Close()
+	hits := []search.Hit{}
+	for rows.Next() {
+		var h search.Hit
+		if err := rows.Scan(&h.ID, &h.Score); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// stockMovementSelect lists stock_movements columns in the order
+// scanStockMovement expects
+const stockMovementSelect = `id, product_id, variant_id, location_id, delta, reason, reference_id, actor_id, note, created_at`
+
+func scanStockMovement(row rowScanner) (*models.StockMovement, error) {
+	m := models.StockMovement{}
+	if err := row.Scan(&m.ID, &m.ProductID, &m.VariantID, &m.LocationID, &m.Delta, &m.Reason, &m.ReferenceID, &m.ActorID, &m.Note, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// insertStockMovements places ms on locations, moves the stock_levels there
+// and appends them to the stock ledger, as recordStock does in memory.
+// Callers hold the lock on the products moved.
+func insertStockMovements(ctx context.Context, tx *sql.Tx, ms []*models.StockMovement, placeholder func(n int) string) error {
+	if len(ms) == 0 {
+		return nil
+	}
+	locs, err := queryLocations(ctx, tx, false)
+	if err != nil {
+		return err
+	}
+	if len(locs) == 0 {
+		return errors.New("no stock location")
+	}
+	order := allocationOrder(locs, Allocation{})
+	known := make(map[string]bool, len(locs))
+	for _, l := range locs {
+		known[l.ID] = true
+	}
+
+	marks := make([]string, 10)
+	for i := range marks {
+		marks[i] = placeholder(i + 1)
+	}
+	query := `INSERT INTO stock_movements (` + stockMovementSelect + `) VALUES (` + strings.Join(marks, ",") + `)`
+	for _, m := range ms {
+		var levels map[string]int
+		if m.LocationID == "" && m.Delta < 0 {
+			if levels, err = queryLineStock(ctx, tx, m.ProductID, m.VariantID, placeholder); err != nil {
+				return err
+			}
+		}
+		for _, placed := range spreadStock(m, levels, order) {
+			// An order may still be allocated to a location since deleted
+			if !known[placed.LocationID] {
+				placed.LocationID = order[0]
+			}
+			if err := moveLocationStock(ctx, tx, placed, placeholder); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, query,
+				placed.ID, placed.ProductID, placed.VariantID, placed.LocationID, placed.Delta, placed.Reason, placed.ReferenceID, placed.ActorID, placed.Note, placed.CreatedAt,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moveLocationStock applies a placed movement to stock_levels, which keeps
+// nonzero levels only
+func moveLocationStock(ctx context.Context, tx *sql.Tx, m *models.StockMovement, placeholder func(n int) string) error {
+	// The key follows the first bind parameter in both statements, so that
+	// MySQL's positional ones line up too
+	key := `location_id = ` + placeholder(2) + ` AND product_id = ` + placeholder(3) + ` AND variant_id = ` + placeholder(4)
+	res, err := tx.ExecContext(ctx,
+		`UPDATE stock_levels SET stock = stock + `+placeholder(1)+` WHERE `+key,
+		m.Delta, m.LocationID, m.ProductID, m.VariantID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO stock_levels (location_id, product_id, variant_id, stock) VALUES (`+placeholder(1)+`, `+placeholder(2)+`, `+placeholder(3)+`, `+placeholder(4)+`)`,
+			m.LocationID, m.ProductID, m.VariantID, m.Delta,
+		)
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`DELETE FROM stock_levels WHERE stock = `+placeholder(1)+` AND `+key,
+		0, m.LocationID, m.ProductID, m.VariantID,
+	)
+	return err
+}
+
+// queryStockLevels reads the stockLevels of a product; inside a transaction
+// the product should already be locked
+func queryStockLevels(ctx context.Context, q queryer, productID string, placeholder func(n int) string) (map[string]int, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id, stock FROM product_variants WHERE product_id = `+placeholder(1), productID)
+	if err != nil {
+		return nil, err
+	}
+	levels := map[string]int{}
+	for rows.Next() {
+		var id string
+		var stock int
+		if err := rows.Scan(&id, &stock); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		levels[id] = stock
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(levels) > 0 {
+		return levels, nil
+	}
+
+	rows, err = q.QueryContext(ctx, `SELECT stock FROM products WHERE id = `+placeholder(1), productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("product not found")
+	}
+	var stock int
+	if err := rows.Scan(&stock); err != nil {
+		return nil, err
+	}
+	return map[string]int{"": stock}, rows.Err()
+}
+
+// recordStockChanges appends to the ledger how a product's stock moved from
+// before, read with queryStockLevels earlier in tx, to now
+func recordStockChanges(ctx context.Context, tx *sql.Tx, productID string, before map[string]int, reason string, at time.Time, placeholder func(n int) string) error {
+	after, err := queryStockLevels(ctx, tx, productID, placeholder)
+	if err != nil {
+		return err
+	}
+	return insertStockMovements(ctx, tx, stockChanges(productID, before, after, reason, actorFrom(ctx), "", at), placeholder)
+}
+
+// lockStockLine locks a product for a change to the stock of one of its
+// lines, checking the line exists, and returns the line's total stock
+func lockStockLine(ctx context.Context, tx *sql.Tx, productID, variantID string, placeholder func(n int) string) (int, error) {
+	var locked string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM products WHERE id = `+placeholder(1)+` FOR UPDATE`, productID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("product not found")
+	}
+	if err != nil {
+		return 0, err
+	}
+	levels, err := queryStockLevels(ctx, tx, productID, placeholder)
+	if err != nil {
+		return 0, err
+	}
+	units, ok := levels[variantID]
+	switch {
+	case !ok && variantID == "":
+		return 0, ErrVariantRequired
+	case !ok:
+		return 0, errVariantNotFound
+	}
+	return units, nil
+}
+
+// readProduct loads a product and its variants inside a write, for the
+// methods that return it as they left it
+func readProduct(ctx context.Context, tx *sql.Tx, id string, placeholder func(n int) string) (*models.Product, error) {
+	p, err := scanProduct(tx.QueryRowContext(ctx, `SELECT `+productSelect+` FROM products WHERE id = `+placeholder(1), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("product not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := loadVariants(ctx, tx, []*models.Product{p}, placeholder, false); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// deductStock takes an order's units off the products CheckoutCart read, so
+// they carry the stock it wrote. A product's stock moves with its variants'.
+func deductStock(products map[string]*models.Product, items []models.CartItem) {
+	for _, it := range items {
+		p := products[it.ProductID]
+		p.Stock -= it.Quantity
+		if v := p.Variant(it.VariantID); v != nil {
+			v.Stock -= it.Quantity
+		}
+	}
+}
+
+// adjustStock runs AdjustStock for either SQL backend
+func adjustStock(ctx context.Context, db *sql.DB, productID, variantID, locationID string, delta int, reason, note string, placeholder func(n int) string) (*models.StockMovement, *models.Product, error) {
+	if err := checkStockAdjustment(delta, reason); err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := lockStockLine(ctx, tx, productID, variantID, placeholder); err != nil {
+		return nil, nil, err
+	}
+	locs, err := queryLocations(ctx, tx, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if locationID == "" && len(locs) > 0 {
+		locationID = allocationOrder(locs, Allocation{})[0]
+	}
+	if !slices.ContainsFunc(locs, func(l *models.Location) bool { return l.ID == locationID }) {
+		return nil, nil, errLocationNotFound
+	}
+	levels, err := queryLineStock(ctx, tx, productID, variantID, placeholder)
+	if err != nil {
+		return nil, nil, err
+	}
+	if levels[locationID]+delta < 0 {
+		return nil, nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, productID)
+	}
+
+	// A product's stock is the sum of its variants', so it moves by delta
+	// either way
+	now := time.Now()
+	if variantID != "" {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE product_variants SET stock = stock + `+placeholder(1)+`, updated_at = `+placeholder(2)+` WHERE id = `+placeholder(3),
+			delta, now, variantID,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE products SET stock = stock + `+placeholder(1)+`, updated_at = `+placeholder(2)+` WHERE id = `+placeholder(3),
+		delta, now, productID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := newStockMovement(productID, variantID, locationID, delta, reason, "", actorFrom(ctx), note, now)
+	if err := insertStockMovements(ctx, tx, []*models.StockMovement{m}, placeholder); err != nil {
+		return nil, nil, err
+	}
+	p, err := readProduct(ctx, tx, productID, placeholder)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return m, p, nil
+}
+
+// queryAllStock loads the stock of every product and its variants, for the
+// inventory migrations
+func queryAllStock(ctx context.Context, tx *sql.Tx) ([]*models.Product, error) {
+	products := []*models.Product{}
+	byID := map[string]*models.Product{}
+	rows, err := tx.QueryContext(ctx, `SELECT id, stock FROM products ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		p := &models.Product{}
+		if err := rows.Scan(&p.ID, &p.Stock); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		products = append(products, p)
+		byID[p.ID] = p
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT product_id, id, stock FROM product_variants`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var v models.ProductVariant
+		if err := rows.Scan(&v.ProductID, &v.ID, &v.Stock); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		if p, ok := byID[v.ProductID]; ok {
+			p.Variants = append(p.Variants, v)
+		}
+	}
+	_ = rows.Close()
+	return products, rows.Err()
+}
+
+// backfillStockLedger is the data half of the stock ledger migration: every
+// product's current stock is recorded as its opening balance
+func backfillStockLedger(ctx context.Context, tx *sql.Tx, placeholder func(n int) string) error {
+	products, err := queryAllStock(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	// The ledger as this migration created it, before locations
+	marks := make([]string, 9)
+	for i := range marks {
+		marks[i] = placeholder(i + 1)
+	}
+	query := `INSERT INTO stock_movements (id, product_id, variant_id, delta, reason, reference_id, actor_id, note, created_at) VALUES (` + strings.Join(marks, ",") + `)`
+	now := time.Now()
+	for _, p := range products {
+		for _, m := range openingBalance(p, now) {
+			_, err := tx.ExecContext(ctx, query, m.ID, m.ProductID, m.VariantID, m.Delta, m.Reason, m.ReferenceID, m.ActorID, m.Note, m.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// queryStockMovements runs ListStockMovements for either SQL backend
+func queryStockMovements(ctx context.Context, db *sql.DB, productID string, page Page, placeholder func(n int) string) ([]*models.StockMovement, string, error) {
+	where, args, tail, err := pageQuery(page, []string{`product_id = ` + placeholder(1)}, []any{productID}, placeholder)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT `+stockMovementSelect+` FROM stock_movements WHERE `+strings.Join(where, " AND ")+tail, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	res := []*models.StockMovement{}
+	for rows.Next() {
+		m, err := scanStockMovement(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		res = append(res, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	res, next := cutPage(res, page.Limit, stockMovementKey)
+	return res, next, nil
+}
+
+// locationSelect lists locations columns in the order scanLocation expects
+const locationSelect = `id, name, code, priority, latitude, longitude, created_at, updated_at`
+
+func scanLocation(row rowScanner) (*models.Location, error) {
+	l := models.Location{}
+	var lat, lng sql.NullFloat64
+	if err := row.Scan(&l.ID, &l.Name, &l.Code, &l.Priority, &lat, &lng, &l.CreatedAt, &l.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if lat.Valid && lng.Valid {
+		l.Latitude, l.Longitude = &lat.Float64, &lng.Float64
+	}
+	return &l, nil
+}
+
+func nullFloat(f *float64) any {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
+
+// queryLocations loads every location by priority then name. lock adds FOR
+// UPDATE so location edits inside a transaction queue behind each other.
+func queryLocations(ctx context.Context, q queryer, lock bool) ([]*models.Location, error) {
+	query := `SELECT ` + locationSelect + ` FROM locations ORDER BY priority, name, id`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.Location{}
+	for rows.Next() {
+		l, err := scanLocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, l)
+	}
+	return res, rows.Err()
+}
+
+// queryLineStock reads the stock of a product line per location
+func queryLineStock(ctx context.Context, q queryer, productID, variantID string, placeholder func(n int) string) (map[string]int, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT location_id, stock FROM stock_levels WHERE product_id = `+placeholder(1)+` AND variant_id = `+placeholder(2),
+		productID, variantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	levels := map[string]int{}
+	for rows.Next() {
+		var id string
+		var stock int
+		if err := rows.Scan(&id, &stock); err != nil {
+			return nil, err
+		}
+		levels[id] = stock
+	}
+	return levels, rows.Err()
+}
+
+// allocateOrder spreads the lines of o, whose units tx has taken off the
+// products, over locations following a and saves where each ships from
+func allocateOrder(ctx context.Context, tx *sql.Tx, o *models.Order, a Allocation, placeholder func(n int) string) error {
+	locs, err := queryLocations(ctx, tx, false)
+	if err != nil {
+		return err
+	}
+	allocs, err := allocateItems(o.Items, allocationOrder(locs, a), func(productID, variantID string) (map[string]int, error) {
+		return queryLineStock(ctx, tx, productID, variantID, placeholder)
+	})
+	if err != nil {
+		return err
+	}
+	for i, it := range o.Items {
+		raw, err := json.Marshal(allocs[i])
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE order_items SET allocations = `+placeholder(1)+` WHERE order_id = `+placeholder(2)+` AND product_id = `+placeholder(3)+` AND variant_id = `+placeholder(4),
+			string(raw), o.ID, it.ProductID, it.VariantID,
+		)
+		if err != nil {
+			return err
+		}
+		o.Items[i].Allocations = allocs[i]
+	}
+	return nil
+}
+
+func insertLocation(ctx context.Context, q execer, l *models.Location, placeholder func(n int) string) error {
+	marks := make([]string, 8)
+	for i := range marks {
+		marks[i] = placeholder(i + 1)
+	}
+	_, err := q.ExecContext(ctx,
+		`INSERT INTO locations (`+locationSelect+`) VALUES (`+strings.Join(marks, ",")+`)`,
+		l.ID, l.Name, l.Code, l.Priority, nullFloat(l.Latitude), nullFloat(l.Longitude), l.CreatedAt, l.UpdatedAt,
+	)
+	return err
+}
+
+// ensureLocation gives a database left without locations, such as one
+// emptied by hand, a default location to keep stock at
+func ensureLocation(ctx context.Context, db *sql.DB, placeholder func(n int) string) error {
+	var n int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM locations`).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	return insertLocation(ctx, db, newDefaultLocation(time.Now()), placeholder)
+}
+
+// createLocation runs CreateLocation for either SQL backend
+func createLocation(ctx context.Context, db *sql.DB, l *models.Location, placeholder func(n int) string) (*models.Location, error) {
+	l.ID = uuid.NewString()
+	l.CreatedAt = time.Now()
+	l.UpdatedAt = l.CreatedAt
+	if err := insertLocation(ctx, db, l, placeholder); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// updateLocation runs UpdateLocation for either SQL backend
+func updateLocation(ctx context.Context, db *sql.DB, id string, update func(l *models.Location) error, placeholder func(n int) string) (*models.Location, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stored, err := scanLocation(tx.QueryRowContext(ctx, `SELECT `+locationSelect+` FROM locations WHERE id = `+placeholder(1)+` FOR UPDATE`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errLocationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := *stored
+	if err := update(&l); err != nil {
+		return nil, err
+	}
+	l.ID, l.CreatedAt = stored.ID, stored.CreatedAt
+	l.UpdatedAt = time.Now()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE locations SET name = `+placeholder(1)+`, code = `+placeholder(2)+`, priority = `+placeholder(3)+`, latitude = `+placeholder(4)+`, longitude = `+placeholder(5)+`, updated_at = `+placeholder(6)+` WHERE id = `+placeholder(7),
+		l.Name, l.Code, l.Priority, nullFloat(l.Latitude), nullFloat(l.Longitude), l.UpdatedAt, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// deleteLocation runs DeleteLocation for either SQL backend
+func deleteLocation(ctx context.Context, db *sql.DB, id string, placeholder func(n int) string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	locs, err := queryLocations(ctx, tx, true)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(locs, func(l *models.Location) bool { return l.ID == id }) {
+		return errLocationNotFound
+	}
+	if len(locs) == 1 {
+		return ErrLocationInUse
+	}
+	var held int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM stock_levels WHERE location_id = `+placeholder(1), id).Scan(&held); err != nil {
+		return err
+	}
+	if held > 0 {
+		return ErrLocationInUse
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM locations WHERE id = `+placeholder(1), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// listStockLevels runs ListStockLevels for either SQL backend
+func listStockLevels(ctx context.Context, db *sql.DB, productID string, placeholder func(n int) string) ([]*models.StockLevel, error) {
+	var exists string
+	err := db.QueryRowContext(ctx, `SELECT id FROM products WHERE id = `+placeholder(1), productID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("product not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx,
+		`SELECT sl.location_id, sl.product_id, sl.variant_id, sl.stock FROM stock_levels sl
+		JOIN locations l ON l.id = sl.location_id
+		WHERE sl.product_id = `+placeholder(1)+`
+		ORDER BY l.priority, l.name, l.id, sl.variant_id`,
+		productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []*models.StockLevel{}
+	for rows.Next() {
+		var sl models.StockLevel
+		if err := rows.Scan(&sl.LocationID, &sl.ProductID, &sl.VariantID, &sl.Stock); err != nil {
+			return nil, err
+		}
+		res = append(res, &sl)
+	}
+	return res, rows.Err()
+}
+
+// transferStock runs TransferStock for either SQL backend
+func transferStock(ctx context.Context, db *sql.DB, productID, variantID, fromID, toID string, qty int, note string, placeholder func(n int) string) ([]*models.StockMovement, error) {
+	if err := checkStockTransfer(fromID, toID, qty); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := lockStockLine(ctx, tx, productID, variantID, placeholder); err != nil {
+		return nil, err
+	}
+	locs, err := queryLocations(ctx, tx, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range []string{fromID, toID} {
+		if !slices.ContainsFunc(locs, func(l *models.Location) bool { return l.ID == id }) {
+			return nil, errLocationNotFound
+		}
+	}
+	levels, err := queryLineStock(ctx, tx, productID, variantID, placeholder)
+	if err != nil {
+		return nil, err
+	}
+	if levels[fromID] < qty {
+		return nil, fmt.Errorf("%w for product: %s", ErrInsufficientStock, productID)
+	}
+
+	ms := transferMovements(productID, variantID, fromID, toID, qty, actorFrom(ctx), note, time.Now())
+	if err := insertStockMovements(ctx, tx, ms, placeholder); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// backfillLocations is the data half of the locations migration: all stock
+// moves to a default location, where the ledger says it has always been
+func backfillLocations(ctx context.Context, tx *sql.Tx, placeholder func(n int) string) error {
+	l := newDefaultLocation(time.Now())
+	if err := insertLocation(ctx, tx, l, placeholder); err != nil {
+		return err
+	}
+	products, err := queryAllStock(ctx, tx)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO stock_levels (location_id, product_id, variant_id, stock) VALUES (` + placeholder(1) + `, ` + placeholder(2) + `, ` + placeholder(3) + `, ` + placeholder(4) + `)`
+	for _, p := range products {
+		for variantID, n := range stockLevels(p) {
+			if n == 0 {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, query, l.ID, p.ID, variantID, n); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE stock_movements SET location_id = `+placeholder(1)+` WHERE location_id = ''`, l.ID)
+	return err
+}
+
+// lockStockProduct locks a product and loads its stock for the
+// back-in-stock subscription calls
+func lockStockProduct(ctx context.Context, tx *sql.Tx, productID string, placeholder func(n int) string) (*models.Product, error) {
+	p := &models.Product{ID: productID}
+	err := tx.QueryRowContext(ctx, `SELECT stock FROM products WHERE id = `+placeholder(1)+` FOR UPDATE`, productID).Scan(&p.Stock)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("product not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	levels, err := queryStockLevels(ctx, tx, productID, placeholder)
+	if err != nil {
+		return nil, err
+	}
+	for id, n := range levels {
+		if id != "" {
+			p.Variants = append(p.Variants, models.ProductVariant{ID: id, ProductID: productID, Stock: n})
+		}
+	}
+	return p, nil
+}
+
+// subscribeStock runs SubscribeStock for either SQL backend
+func subscribeStock(ctx context.Context, db *sql.DB, userID, productID, variantID string, placeholder func(n int) string) (*models.StockSubscription, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	p, err := lockStockProduct(ctx, tx, productID, placeholder)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStockSubscription(p, variantID); err != nil {
+		return nil, err
+	}
+	sub := &models.StockSubscription{UserID: userID, ProductID: productID, VariantID: variantID}
+	err = tx.QueryRowContext(ctx,
+		`SELECT created_at FROM stock_subscriptions WHERE user_id = `+placeholder(1)+` AND product_id = `+placeholder(2)+` AND variant_id = `+placeholder(3),
+		userID, productID, variantID,
+	).Scan(&sub.CreatedAt)
+	if err == nil {
+		return sub, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	sub.CreatedAt = time.Now()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO stock_subscriptions (user_id, product_id, variant_id, created_at) VALUES (`+placeholder(1)+`, `+placeholder(2)+`, `+placeholder(3)+`, `+placeholder(4)+`)`,
+		userID, productID, variantID, sub.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// queryRestockedSubscriptions runs ListRestockedSubscriptions for either SQL
+// backend
+func queryRestockedSubscriptions(ctx context.Context, db *sql.DB, productID string, placeholder func(n int) string) ([]*models.StockSubscription, error) {
+	p := &models.Product{ID: productID}
+	err := db.QueryRowContext(ctx, `SELECT stock FROM products WHERE id = `+placeholder(1), productID).Scan(&p.Stock)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("product not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := loadVariants(ctx, db, []*models.Product{p}, placeholder, false); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx,
+		`SELECT user_id, product_id, variant_id, created_at FROM stock_subscriptions WHERE product_id = `+placeholder(1)+` ORDER BY created_at, user_id`,
+		productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.StockSubscription{}
+	for rows.Next() {
+		sub := &models.StockSubscription{}
+		if err := rows.Scan(&sub.UserID, &sub.ProductID, &sub.VariantID, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		if lineInStock(p, sub.VariantID) {
+			res = append(res, sub)
+		}
+	}
+	return res, rows.Err()
+}
+
+// queryLowStockProducts runs ListLowStockProducts for either SQL backend
+func queryLowStockProducts(ctx context.Context, db *sql.DB, defaultThreshold int, placeholder func(n int) string) ([]*models.Product, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT `+productSelect+` FROM products WHERE archived_at IS NULL AND stock < COALESCE(low_stock_threshold, `+placeholder(1)+`) ORDER BY stock, name, id`,
+		defaultThreshold,
+	)
+	if err != nil {
+		return nil, err
+	}
+	res := []*models.Product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := loadVariants(ctx, db, res, placeholder, false); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// queryUsersByRole runs ListUsersByRole for either SQL backend
+func queryUsersByRole(ctx context.Context, db *sql.DB, role string, placeholder func(n int) string) ([]*models.User, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, full_name, phone, email, password_hash, role, auth_provider, google_id, email_verified, created_at FROM users WHERE role = `+placeholder(1)+` ORDER BY created_at, id`,
+		role,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*models.User{}
+	for rows.Next() {
+		u := &models.User{}
+		if err := rows.Scan(&u.ID, &u.FullName, &u.Phone, &u.Email, &u.Password, &u.Role, &u.AuthProvider, &u.GoogleID, &u.EmailVerified, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		res = append(res, u)
+	}
+	return res, rows.Err()
+}
+
+// queryCartLines loads the lines of the cart owned by id from table, whose
+// owner column is key: cart_items by user_id or guest_cart_items by
+// guest_id
+func queryCartLines(ctx context.Context, q queryer, table, key, id string, lock bool, placeholder func(n int) string) ([]models.CartItem, error) {
+	query := `SELECT product_id, variant_id, quantity FROM ` + table + ` WHERE ` + key + ` = ` + placeholder(1) + ` ORDER BY product_id, variant_id`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	rows, err := q.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.CartItem{}
+	for rows.Next() {
+		var it models.CartItem
+		if err := rows.Scan(&it.ProductID, &it.VariantID, &it.Quantity); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// touchCart makes sure the cart row of id exists in table, carts by user_id
+// or guest_carts by id, and marks it changed at now
+func touchCart(ctx context.Context, tx *sql.Tx, table, key, id string, now time.Time, placeholder func(n int) string) error {
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+table+` WHERE `+key+` = `+placeholder(1), id).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := tx.ExecContext(ctx, `INSERT INTO `+table+` (`+key+`, updated_at) VALUES (`+placeholder(1)+`, `+placeholder(2)+`)`, id, now)
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE `+table+` SET updated_at = `+placeholder(1)+` WHERE `+key+` = `+placeholder(2), now, id)
+	return err
+}
+
+// setCartLine writes the quantity of a cart line from old to qty: an
+// insert from 0, a delete to 0 and an update otherwise
+func setCartLine(ctx context.Context, tx *sql.Tx, table, key, id, productID, variantID string, old, qty int, placeholder func(n int) string) error {
+	var err error
+	switch {
+	case old == qty:
+	case old == 0:
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO `+table+` (`+key+`, product_id, variant_id, quantity) VALUES (`+placeholder(1)+`, `+placeholder(2)+`, `+placeholder(3)+`, `+placeholder(4)+`)`,
+			id, productID, variantID, qty,
+		)
+	case qty <= 0:
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM `+table+` WHERE `+key+` = `+placeholder(1)+` AND product_id = `+placeholder(2)+` AND variant_id = `+placeholder(3),
+			id, productID, variantID,
+		)
+	default:
+		_, err = tx.ExecContext(ctx,
+			`UPDATE `+table+` SET quantity = `+placeholder(1)+` WHERE `+key+` = `+placeholder(2)+` AND product_id = `+placeholder(3)+` AND variant_id = `+placeholder(4),
+			qty, id, productID, variantID,
+		)
+	}
+	return err
+}
+
+// cartTables names the tables a kind of cart is kept in
+type cartTables struct {
+	carts, key      string // the cart rows and the column of their owner
+	items, itemsKey string // the cart lines and the column of their owner
+}
+
+var (
+	userCartTables  = cartTables{carts: "carts", key: "user_id", items: "cart_items", itemsKey: "user_id"}
+	guestCartTables = cartTables{carts: "guest_carts", key: "id", items: "guest_cart_items", itemsKey: "guest_id"}
+)
+
+// setCartLineQuantity runs SetCartQuantity and SetGuestCartQuantity for
+// either SQL backend on the cart of id in t. p is the product of the line
+// and only needed when qty is above 0.
+func setCartLineQuantity(ctx context.Context, db *sql.DB, t cartTables, id string, p *models.Product, productID, variantID string, qty int, placeholder func(n int) string) error {
+	now := time.Now()
+	if qty > 0 {
+		if err := checkCartLine(p, variantID, qty, now); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	items, err := queryCartLines(ctx, tx, t.items, t.itemsKey, id, true, placeholder)
+	if err != nil {
+		return err
+	}
+	cur := cartQuantity(items, productID, variantID)
+	if cur == qty {
+		return nil
+	}
+	if err := touchCart(ctx, tx, t.carts, t.key, id, now, placeholder); err != nil {
+		return err
+	}
+	if err := setCartLine(ctx, tx, t.items, t.itemsKey, id, productID, variantID, cur, qty, placeholder); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addToGuestCart runs AddToGuestCart for either SQL backend, p being the
+// product added
+func addToGuestCart(ctx context.Context, db *sql.DB, guestID string, p *models.Product, variantID string, qty int, placeholder func(n int) string) error {
+	now := time.Now()
+	stock, err := cartLineStock(p, variantID, now)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := touchCart(ctx, tx, "guest_carts", "id", guestID, now, placeholder); err != nil {
+		return err
+	}
+	items, err := queryCartLines(ctx, tx, "guest_cart_items", "guest_id", guestID, true, placeholder)
+	if err != nil {
+		return err
+	}
+	cur := cartQuantity(items, p.ID, variantID)
+	if stock < cur+qty {
+		return errors.New("insufficient stock")
+	}
+	if err := setCartLine(ctx, tx, "guest_cart_items", "guest_id", guestID, p.ID, variantID, cur, cur+qty, placeholder); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeFromGuestCart runs RemoveFromGuestCart for either SQL backend
+func removeFromGuestCart(ctx context.Context, db *sql.DB, guestID, productID, variantID string, qty int, placeholder func(n int) string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	items, err := queryCartLines(ctx, tx, "guest_cart_items", "guest_id", guestID, true, placeholder)
+	if err != nil {
+		return err
+	}
+	cur := cartQuantity(items, productID, variantID)
+	if cur == 0 {
+		return errNotInCart
+	}
+	if err := setCartLine(ctx, tx, "guest_cart_items", "guest_id", guestID, productID, variantID, cur, max(cur-qty, 0), placeholder); err != nil {
+		return err
+	}
+	if err := touchCart(ctx, tx, "guest_carts", "id", guestID, time.Now(), placeholder); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getGuestCart runs GetGuestCart for either SQL backend
+func getGuestCart(ctx context.Context, db *sql.DB, guestID string, placeholder func(n int) string) (*models.GuestCart, error) {
+	c := &models.GuestCart{ID: guestID, Items: []models.CartItem{}}
+	err := db.QueryRowContext(ctx, `SELECT updated_at FROM guest_carts WHERE id = `+placeholder(1), guestID).Scan(&c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Items, err = queryCartLines(ctx, db, "guest_cart_items", "guest_id", guestID, false, placeholder); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// mergeGuestCart runs MergeGuestCart for either SQL backend, looking
+// products up with product
+func mergeGuestCart(ctx context.Context, db *sql.DB, guestID, userID string, product func(ctx context.Context, id string) (*models.Product, error), placeholder func(n int) string) (*models.Cart, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	guest, err := queryCartLines(ctx, tx, "guest_cart_items", "guest_id", guestID, true, placeholder)
+	if err != nil {
+		return nil, err
+	}
+	items, err := queryCartLines(ctx, tx, "cart_items", "user_id", userID, true, placeholder)
+	if err != nil {
+		return nil, err
+	}
+	products := map[string]*models.Product{}
+	for _, it := range guest {
+		if p, err := product(ctx, it.ProductID); err == nil {
+			products[p.ID] = p
+		}
+	}
+
+	now := time.Now()
+	merged := mergeCartItems(items, guest, func(id string) *models.Product { return products[id] }, now)
+	touched := false
+	for _, it := range merged {
+		old := cartQuantity(items, it.ProductID, it.VariantID)
+		if old == it.Quantity {
+			continue
+		}
+		if !touched {
+			if err := touchCart(ctx, tx, "carts", "user_id", userID, now, placeholder); err != nil {
+				return nil, err
+			}
+			touched = true
+		}
+		if err := setCartLine(ctx, tx, "cart_items", "user_id", userID, it.ProductID, it.VariantID, old, it.Quantity, placeholder); err != nil {
+			return nil, err
+		}
+	}
+	// guest_cart_items go with it through ON DELETE CASCADE
+	if _, err := tx.ExecContext(ctx, `DELETE FROM guest_carts WHERE id = `+placeholder(1), guestID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &models.Cart{UserID: userID, Items: merged}, nil
+}
+
+// expireGuestCarts runs ExpireGuestCarts for either SQL backend
+func expireGuestCarts(ctx context.Context, db *sql.DB, idleSince time.Time, placeholder func(n int) string) (int, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM guest_carts WHERE updated_at < `+placeholder(1), idleSince)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
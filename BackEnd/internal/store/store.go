@@ -1,55 +1,325 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/example/ecommerce-api/internal/models"
 )
 
-// Store abstracts data storage backends
+var (
+	// ErrCartEmpty is returned by CheckoutCart when the cart has no items
+	ErrCartEmpty = errors.New("cart is empty")
+	// ErrInsufficientStock is wrapped with the product ID when an order
+	// asks for more units than are in stock, and returned by writes that
+	// would leave stock below zero
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrProductArchived is returned when an archived product is added to a
+	// cart or checked out
+	ErrProductArchived = errors.New("product is archived")
+	// ErrProductNotPublished is returned when a draft, or a product outside
+	// its publish window, is added to a cart or checked out
+	ErrProductNotPublished = errors.New("product is not published")
+	// ErrProductInUse is returned by PurgeProduct when orders still
+	// reference the product
+	ErrProductInUse = errors.New("product is referenced by orders")
+	// ErrVariantRequired is returned when a product with variants is added
+	// to a cart or ordered without naming one
+	ErrVariantRequired = errors.New("product has variants; choose one")
+	// ErrVariantInUse is returned by DeleteVariant when orders still
+	// reference the variant
+	ErrVariantInUse = errors.New("variant is referenced by orders")
+	// ErrSlugTaken is returned when a category slug is already in use
+	ErrSlugTaken = errors.New("slug already in use")
+	// ErrCategoryInUse is returned by DeleteCategory while the category
+	// still has subcategories or products
+	ErrCategoryInUse = errors.New("category has subcategories or products")
+	// ErrCategoryCycle is returned when a category would become its own
+	// ancestor
+	ErrCategoryCycle = errors.New("category cannot be moved under itself")
+	// ErrInvalidCursor is returned by list methods for a Page.Cursor they
+	// did not issue
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrSKUTaken is returned when a product's SKU is already used by
+	// another product, or a variant's by another variant
+	ErrSKUTaken = errors.New("sku already in use")
+	// ErrPriceScheduleOverlap is returned when a sale would run at the same
+	// time as another sale of the product
+	ErrPriceScheduleOverlap = errors.New("sale overlaps another sale")
+	// ErrPriceScheduleDone is returned when canceling a schedule that has
+	// already run or been canceled
+	ErrPriceScheduleDone = errors.New("price schedule already finished")
+	// ErrInvalidStockAdjustment is returned by AdjustStock for a zero delta
+	// or a reason reserved for orders
+	ErrInvalidStockAdjustment = errors.New("invalid stock adjustment")
+	// ErrLocationInUse is returned by DeleteLocation while the location
+	// holds stock or is the last one left
+	ErrLocationInUse = errors.New("location holds stock or is the last one")
+	// ErrInStock is returned by SubscribeStock for a product, or variant,
+	// that can be bought right now
+	ErrInStock = errors.New("product is in stock")
+)
+
+// ProductFilter narrows and orders ListProducts; zero fields match everything
+type ProductFilter struct {
+	Query           string    // full-text search over name, SKU, category and description
+	IncludeArchived bool      // admin listings; public listings leave it false
+	Status          string    // draft, published or archived; "" for any
+	LiveAt          time.Time // when set, only products models.Product.Live at that time
+	CategoryIDs     []string  // when set, only products in one of these categories
+	MinPriceCents   int64     // inclusive
+	MaxPriceCents   int64     // inclusive
+	InStock         bool      // only products with stock left
+	MinRating       float64
+	Sort            ProductSort
+}
+
+// ProductSort orders ListProducts. Ties, and SortNewest itself, fall back to
+// newest first, so every order is stable while paging.
+type ProductSort string
+
+const (
+	SortNewest      ProductSort = ""
+	SortPriceAsc    ProductSort = "price_asc"
+	SortPriceDesc   ProductSort = "price_desc"
+	SortRating      ProductSort = "rating"     // highest rated first
+	SortBestSelling ProductSort = "bestseller" // most units in paid or completed orders first
+	SortRelevance   ProductSort = "relevance"  // best Query match first; newest without a Query
+)
+
+// ProductFacets counts the products matching a filter. Each dimension
+// ignores its own part of the filter, so the counts show what picking
+// another category or price range would give.
+type ProductFacets struct {
+	// Categories maps a category ID to the products filed directly under
+	// it; uncategorized products are counted under ""
+	Categories map[string]int
+	// PriceBuckets[i] counts prices from bounds[i] up to, not including,
+	// bounds[i+1]; the last bucket has no upper end
+	PriceBuckets []int
+}
+
+// ImportedProduct is one row written, or in a dry run checked, by
+// ImportProducts
+type ImportedProduct struct {
+	Product *models.Product
+	Created bool
+}
+
+// AuditFilter narrows ListAuditEntries; zero fields match everything
+type AuditFilter struct {
+	ActorID    string
+	EntityType string
+	EntityID   string
+	From       time.Time // inclusive
+	To         time.Time // exclusive
+	Limit      int       // newest first; 0 means no limit
+}
+
+// Store abstracts data storage backends. Every method takes the caller's
+// context so request cancellation and deadlines reach the backend.
 type Store interface {
 	// Users
-	CreateUser(fullName, phone, email, passwordHash, role, provider, googleID string, emailVerified bool) (*models.User, error)
-	GetUserByEmail(email string) (*models.User, error)
-	GetUserByID(id string) (*models.User, error)
-	SeedAdminUser(email, passwordHash string) error
-	UpdateUserPassword(userID, newPasswordHash string) error
-	MarkEmailVerified(userID string) error
+	CreateUser(ctx context.Context, fullName, phone, email, passwordHash, role, provider, googleID string, emailVerified bool) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	SeedAdminUser(ctx context.Context, email, passwordHash string) error
+	UpdateUserPassword(ctx context.Context, userID, newPasswordHash string) error
+	MarkEmailVerified(ctx context.Context, userID string) error
+	// ListUsersByRole returns the users with role, oldest first
+	ListUsersByRole(ctx context.Context, role string) ([]*models.User, error)
 
 	// Email Verification
-	CreateEmailVerification(userID, token string, expiresAt time.Time) error
-	GetEmailVerification(token string) (*models.EmailVerification, error)
-	GetLatestEmailVerificationByUser(userID string) (*models.EmailVerification, error)
-	DeleteEmailVerification(token string) error
+	CreateEmailVerification(ctx context.Context, userID, token string, expiresAt time.Time) error
+	GetEmailVerification(ctx context.Context, token string) (*models.EmailVerification, error)
+	GetLatestEmailVerificationByUser(ctx context.Context, userID string) (*models.EmailVerification, error)
+	DeleteEmailVerification(ctx context.Context, token string) error
 
 	// Password Reset
-	CreatePasswordReset(userID, token string, expiresAt time.Time) error
-	GetPasswordReset(token string) (*models.PasswordReset, error)
-	DeletePasswordReset(token string) error
+	CreatePasswordReset(ctx context.Context, userID, token string, expiresAt time.Time) error
+	GetPasswordReset(ctx context.Context, token string) (*models.PasswordReset, error)
+	DeletePasswordReset(ctx context.Context, token string) error
+
+	// Products. SKUs are unique among products and among variants; writes
+	// that would share one fail with ErrSKUTaken.
+	CreateProduct(ctx context.Context, p *models.Product) (*models.Product, error)
+	// UpdateProduct fails with ErrInsufficientStock when update leaves the
+	// product's stock below zero
+	UpdateProduct(ctx context.Context, id string, update func(p *models.Product) error) (*models.Product, error)
+	// ArchiveProduct hides a product from listings and carts while keeping it
+	// for order history; RestoreProduct undoes it, putting back the status
+	// the product had, or draft when that is unknown. PurgeProduct deletes
+	// the product for good and fails with ErrProductInUse once it has been
+	// ordered.
+	ArchiveProduct(ctx context.Context, id string) error
+	RestoreProduct(ctx context.Context, id string) error
+	PurgeProduct(ctx context.Context, id string) error
+	// GetProduct resolves archived products too, so order history keeps working
+	GetProduct(ctx context.Context, id string) (*models.Product, error)
+	// GetProductBySKU finds the product with the given SKU or, failing that,
+	// the one with a variant of that SKU, whose ID is returned as well.
+	// Archived products are found too.
+	GetProductBySKU(ctx context.Context, sku string) (*models.Product, string, error)
+	// List methods return one page and the cursor of the next, "" on the last
+	ListProducts(ctx context.Context, filter ProductFilter, page Page) ([]*models.Product, string, error)
+	// ProductFacets counts the products matching filter per category and
+	// per price bucket; priceBounds are the ascending lower bounds of the
+	// buckets. filter.Sort is ignored.
+	ProductFacets(ctx context.Context, filter ProductFilter, priceBounds []int64) (*ProductFacets, error)
+	// ImportProducts upserts products by SKU in one transaction. update is
+	// called for each of the distinct skus in order with the product to
+	// write: the stored one, archived or not, or a new one with only the SKU
+	// set. Variants and, for products with variants, stock are kept. An
+	// error from update rolls back the whole import. A dry run calls update
+	// for every SKU but writes nothing; its new products have no ID.
+	ImportProducts(ctx context.Context, skus []string, dryRun bool, update func(i int, p *models.Product) error) ([]ImportedProduct, error)
+
+	// Prices. Every change to a product's price is kept in its history,
+	// against the actor from WithActor for changes made through the methods
+	// above. Schedules take effect on the first product read once they are
+	// due, so listings, filters and checkout see the current price.
+	// ListPriceHistory returns a product's price changes, newest first
+	ListPriceHistory(ctx context.Context, productID string) ([]*models.PriceChange, error)
+	// CreatePriceSchedule fails with ErrPriceScheduleOverlap for a sale
+	// that overlaps another pending or running sale of the product
+	CreatePriceSchedule(ctx context.Context, sc *models.PriceSchedule) (*models.PriceSchedule, error)
+	// ListPriceSchedules returns every schedule of a product by start time
+	ListPriceSchedules(ctx context.Context, productID string) ([]*models.PriceSchedule, error)
+	// CancelPriceSchedule cancels a pending schedule, or a running sale,
+	// which then ends now. Schedules that are over fail with
+	// ErrPriceScheduleDone.
+	CancelPriceSchedule(ctx context.Context, productID, scheduleID string) (*models.PriceSchedule, error)
+
+	// Variants. Products come back with their variants loaded; options are
+	// edited through UpdateProduct. A product's Stock is kept as the sum of
+	// its variants' stock once it has any.
+	CreateVariant(ctx context.Context, productID string, v *models.ProductVariant) (*models.ProductVariant, error)
+	// UpdateVariant returns the variant and its product as the update left
+	// them. It fails with ErrInsufficientStock when update leaves the
+	// variant's stock below zero.
+	UpdateVariant(ctx context.Context, productID, variantID string, update func(v *models.ProductVariant) error) (*models.ProductVariant, *models.Product, error)
+	// DeleteVariant fails with ErrVariantInUse once the variant has been ordered
+	DeleteVariant(ctx context.Context, productID, variantID string) error
 
-	// Products
-	CreateProduct(p *models.Product) (*models.Product, error)
-	UpdateProduct(id string, update func(p *models.Product) error) (*models.Product, error)
-	DeleteProduct(id string) error
-	GetProduct(id string) (*models.Product, error)
-	ListProducts(query string) ([]*models.Product, error)
+	// Inventory. Every stock change made through any method is appended to
+	// the product's movement ledger, against the actor from WithActor or,
+	// for a customer's own order, the customer. The deltas of a product's
+	// movements add up to its stock.
+	// AdjustStock moves the stock of a product, or with variantID of one of
+	// its variants, by delta at a location, "" for the default one. reason
+	// is adjustment, restock or return. It returns the movement and the
+	// product as it left it. Fails with ErrInsufficientStock when stock
+	// there would drop below zero.
+	AdjustStock(ctx context.Context, productID, variantID, locationID string, delta int, reason, note string) (*models.StockMovement, *models.Product, error)
+	// ListStockMovements returns a product's ledger, newest first
+	ListStockMovements(ctx context.Context, productID string, page Page) ([]*models.StockMovement, string, error)
+	// ListLowStockProducts returns the products not archived whose stock is
+	// below their LowStockThreshold, or defaultThreshold when they have
+	// none, lowest stock first
+	ListLowStockProducts(ctx context.Context, defaultThreshold int) ([]*models.Product, error)
+
+	// Locations. Stock is kept per location; the Stock of a product or
+	// variant is its total over all of them. Changes to that total, such as
+	// editing a product, land on the default location, the first by
+	// priority, and removals take from it first. Orders spread each line
+	// over locations following the Allocation from WithAllocation. There is
+	// always at least one location.
+	CreateLocation(ctx context.Context, l *models.Location) (*models.Location, error)
+	UpdateLocation(ctx context.Context, id string, update func(l *models.Location) error) (*models.Location, error)
+	// DeleteLocation fails with ErrLocationInUse while the location holds
+	// stock or is the last one
+	DeleteLocation(ctx context.Context, id string) error
+	// ListLocations returns every location by priority, default first
+	ListLocations(ctx context.Context) ([]*models.Location, error)
+	// ListStockLevels returns the stock of a product per location and
+	// variant, leaving out empty ones
+	ListStockLevels(ctx context.Context, productID string) ([]*models.StockLevel, error)
+	// TransferStock moves qty units of a product, or of one of its variants,
+	// from one location to another, leaving the total unchanged. Fails with
+	// ErrInsufficientStock when from holds fewer.
+	TransferStock(ctx context.Context, productID, variantID, fromID, toID string, qty int, note string) ([]*models.StockMovement, error)
+
+	// Back-in-stock subscriptions. A variantID of "" stands for the product
+	// as a whole, back once any of its stock is.
+	// SubscribeStock fails with ErrInStock while the product, or variant,
+	// has stock; subscribing again keeps the first subscription
+	SubscribeStock(ctx context.Context, userID, productID, variantID string) (*models.StockSubscription, error)
+	// UnsubscribeStock is a no-op for a subscription that does not exist
+	UnsubscribeStock(ctx context.Context, userID, productID, variantID string) error
+	// ListRestockedSubscriptions returns the subscriptions to a product that
+	// are back in stock, oldest first. They stay until UnsubscribeStock
+	// removes them once their subscriber has been notified.
+	ListRestockedSubscriptions(ctx context.Context, productID string) ([]*models.StockSubscription, error)
+
+	// Categories form a tree through ParentID. Slugs are unique; renaming a
+	// category renames it on its products too.
+	CreateCategory(ctx context.Context, c *models.Category) (*models.Category, error)
+	UpdateCategory(ctx context.Context, id string, update func(c *models.Category) error) (*models.Category, error)
+	// DeleteCategory fails with ErrCategoryInUse while anything refers to it
+	DeleteCategory(ctx context.Context, id string) error
+	// ListCategories returns every category, flat, by sort order then name
+	ListCategories(ctx context.Context) ([]*models.Category, error)
 
 	// Carts
-	GetOrCreateCart(userID string) *models.Cart
-	AddToCart(userID, productID string, qty int) error
-	RemoveFromCart(userID, productID string, qty int) error
-	ClearCart(userID string)
-	GetCart(userID string) (*models.Cart, error)
+	GetOrCreateCart(ctx context.Context, userID string) *models.Cart
+	// Cart lines are keyed by product and variant; variantID is "" for
+	// products without variants and required for products with them.
+	AddToCart(ctx context.Context, userID, productID, variantID string, qty int) error
+	RemoveFromCart(ctx context.Context, userID, productID, variantID string, qty int) error
+	// SetCartQuantity sets a line to exactly qty, at most its stock; 0
+	// removes the line, and is fine when there is none
+	SetCartQuantity(ctx context.Context, userID, productID, variantID string, qty int) error
+	ClearCart(ctx context.Context, userID string)
+	GetCart(ctx context.Context, userID string) (*models.Cart, error)
+
+	// Guest carts belong to visitors who have not logged in, by guest ID.
+	// They take lines under the same rules as user carts; every change
+	// keeps them from expiring for a while.
+	AddToGuestCart(ctx context.Context, guestID, productID, variantID string, qty int) error
+	RemoveFromGuestCart(ctx context.Context, guestID, productID, variantID string, qty int) error
+	SetGuestCartQuantity(ctx context.Context, guestID, productID, variantID string, qty int) error
+	// GetGuestCart returns an empty cart for a guest who has none
+	GetGuestCart(ctx context.Context, guestID string) (*models.GuestCart, error)
+	// MergeGuestCart moves a guest's lines into the user's cart and deletes
+	// the guest cart. Quantities add up to at most the stock a line sells
+	// from; lines that can no longer be bought are dropped.
+	MergeGuestCart(ctx context.Context, guestID, userID string) (*models.Cart, error)
+	// ExpireGuestCarts deletes the guest carts last changed before
+	// idleSince and returns how many there were
+	ExpireGuestCarts(ctx context.Context, idleSince time.Time) (int, error)
 
 	// Orders
-	CreateOrder(userID string, items []models.CartItem, amount int64, status, paymentRef string) (*models.Order, error)
-	ListOrdersByUser(userID string) ([]*models.Order, error)
-	ListOrders() ([]*models.Order, error)
-	UpdateOrderStatus(orderID, status string) error
-	UpdateOrderPaymentRef(orderID, paymentRef string) error
+	CreateOrder(ctx context.Context, userID string, items []models.CartItem, amount int64, status, paymentRef string) (*models.Order, error)
+	// CheckoutCart turns the user's cart into a pending order in one step:
+	// lines are priced at the current product price, their units are held
+	// out of stock and the cart is cleared. Nothing is changed if any step
+	// fails. It returns the order, without a payment reference yet, and the
+	// products it contains keyed by ID, with the stock left after the order
+	// took its units. The caller charges the order after
+	// this returns and stores the reference with UpdateOrderPaymentRef, or
+	// marks the order failed to release its units.
+	CheckoutCart(ctx context.Context, userID string) (*models.Order, map[string]*models.Product, error)
+	ListOrdersByUser(ctx context.Context, userID string, page Page) ([]*models.Order, string, error)
+	ListOrders(ctx context.Context, page Page) ([]*models.Order, string, error)
+	// UpdateOrderStatus also moves the order's reservation: a failed,
+	// canceled or expired order puts its units back in stock, and reopening
+	// it or marking it paid takes them again, failing with
+	// ErrInsufficientStock when they are gone
+	UpdateOrderStatus(ctx context.Context, orderID, status string) error
+	// ExpireReservations marks the pending orders whose units have been held
+	// since before heldBefore as expired and puts the units back in stock.
+	// Returns how many orders expired.
+	ExpireReservations(ctx context.Context, heldBefore time.Time) (int, error)
+	UpdateOrderPaymentRef(ctx context.Context, orderID, paymentRef string) error
+	GetOrder(ctx context.Context, orderID string) (*models.Order, error)
 
 	// Reviews
-	CreateReview(userID, userName, userPhoto string, rating int, comment string) (*models.Review, error)
-	ListReviews(limit int) ([]*models.Review, error)
-	GetUserReviewCount(userID string) (int, error)
+	CreateReview(ctx context.Context, userID, userName, userPhoto string, rating int, comment string) (*models.Review, error)
+	ListReviews(ctx context.Context, page Page) ([]*models.Review, string, error)
+	GetUserReviewCount(ctx context.Context, userID string) (int, error)
+
+	// Audit log
+	CreateAuditEntry(ctx context.Context, e *models.AuditEntry) error
+	ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*models.AuditEntry, error)
 }
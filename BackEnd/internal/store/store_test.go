@@ -0,0 +1,39 @@
+package store_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/example/ecommerce-api/internal/store"
+	"github.com/example/ecommerce-api/internal/store/storetest"
+)
+
+// sqlTables lists every table the conformance suite writes to, children
+// first, so the SQL backends can be reset between subtests.
+var sqlTables = []string{
+	"audit_log", "stock_subscriptions", "reviews", "order_items", "orders", "guest_cart_items", "guest_carts", "cart_items", "carts",
+	"price_history", "price_schedules", "stock_movements", "stock_levels", "locations", "product_variants", "products", "categories", "password_resets", "email_verifications", "users",
+}
+
+// sqlTestDSN returns the database a SQL conformance run uses, from the env
+// variable named. The run is skipped when it is unset, unless
+// STORE_TEST_REQUIRE_SQL is set as it is in CI, where that fails it.
+func sqlTestDSN(t *testing.T, env string) string {
+	t.Helper()
+	dsn := os.Getenv(env)
+	switch {
+	case dsn != "":
+		return dsn
+	case os.Getenv("STORE_TEST_REQUIRE_SQL") != "":
+		t.Fatalf("%s not set, and STORE_TEST_REQUIRE_SQL requires the SQL suites", env)
+	default:
+		t.Skip(env + " not set")
+	}
+	return ""
+}
+
+func TestInMemoryStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewInMemoryStore()
+	})
+}
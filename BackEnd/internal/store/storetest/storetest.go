@@ -0,0 +1,2596 @@
+// Package storetest is the conformance suite every store.Store
+// implementation must pass. Backends call Run from their own tests with a
+// factory that hands out an empty store.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/ecommerce-api/internal/models"
+	"github.com/example/ecommerce-api/internal/store"
+)
+
+// Factory returns a store with no data in it
+type Factory func(t *testing.T) store.Store
+
+// Run executes the whole suite against stores produced by newStore
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st store.Store)
+	}{
+		{"UserUniqueness", testUserUniqueness},
+		{"SeedAdminUser", testSeedAdminUser},
+		{"EmailVerificationLookup", testEmailVerificationLookup},
+		{"PasswordResetLookup", testPasswordResetLookup},
+		{"ProductCRUD", testProductCRUD},
+		{"ArchiveRestorePurge", testArchiveRestorePurge},
+		{"CartQuantityRules", testCartQuantityRules},
+		{"GuestCarts", testGuestCarts},
+		{"CartSetQuantity", testCartSetQuantity},
+		{"CreateOrderDecrementsStock", testCreateOrderDecrementsStock},
+		{"CreateOrderRollsBack", testCreateOrderRollsBack},
+		{"OrderStatusAndPaymentRef", testOrderStatusAndPaymentRef},
+		{"StockReservations", testStockReservations},
+		{"StockLedger", testStockLedger},
+		{"StockLocations", testStockLocations},
+		{"StockSubscriptions", testStockSubscriptions},
+		{"LowStock", testLowStock},
+		{"CheckoutCart", testCheckoutCart},
+		{"CheckoutCartFailedPayment", testCheckoutCartFailedPayment},
+		{"CheckoutCartNoOversell", testCheckoutCartNoOversell},
+		{"CheckoutRejectsArchived", testCheckoutRejectsArchived},
+		{"ProductLifecycle", testProductLifecycle},
+		{"Variants", testVariants},
+		{"NegativeStockRejected", testNegativeStockRejected},
+		{"Categories", testCategories},
+		{"ReviewCounts", testReviewCounts},
+		{"AuditLog", testAuditLog},
+		{"Pagination", testPagination},
+		{"ProductSearch", testProductSearch},
+		{"TextSearch", testTextSearch},
+		{"ImportProducts", testImportProducts},
+		{"SKUs", testSKUs},
+		{"PriceSchedules", testPriceSchedules},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newStore(t))
+		})
+	}
+}
+
+func mustUser(t *testing.T, st store.Store, email string) *models.User {
+	t.Helper()
+	u, err := st.CreateUser(context.Background(), "Test User", "08123456789", email, "hash", "user", "email", "", false)
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	return u
+}
+
+func mustProduct(t *testing.T, st store.Store, sku string, priceCents int64, stock int) *models.Product {
+	t.Helper()
+	p, err := st.CreateProduct(context.Background(), &models.Product{
+		Name:        "Product " + sku,
+		Description: "Conformance product",
+		Category:    "Testing",
+		PriceCents:  priceCents,
+		SKU:         sku,
+		Stock:       stock,
+		Thumbnail:   "https://example.com/" + sku + ".png",
+	})
+	if err != nil {
+		t.Fatalf("CreateProduct(%s): %v", sku, err)
+	}
+	return p
+}
+
+func stockOf(t *testing.T, st store.Store, productID string) int {
+	t.Helper()
+	p, err := st.GetProduct(context.Background(), productID)
+	if err != nil {
+		t.Fatalf("GetProduct(%s): %v", productID, err)
+	}
+	return p.Stock
+}
+
+func cartQty(t *testing.T, st store.Store, userID, productID string) int {
+	t.Helper()
+	c, err := st.GetCart(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("GetCart: %v", err)
+	}
+	for _, it := range c.Items {
+		if it.ProductID == productID {
+			return it.Quantity
+		}
+	}
+	return 0
+}
+
+// sameSecond compares timestamps at the precision of a DATETIME column
+func sameSecond(a, b time.Time) bool {
+	return a.Truncate(time.Second).Equal(b.Truncate(time.Second))
+}
+
+func testUserUniqueness(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "unique@example.com")
+
+	if _, err := st.CreateUser(ctx, "Other", "", "unique@example.com", "hash2", "", "", "", false); err == nil {
+		t.Fatal("second CreateUser with the same email succeeded")
+	}
+
+	byEmail, err := st.GetUserByEmail(ctx, "unique@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if byEmail.ID != u.ID || byEmail.Role != "user" || byEmail.AuthProvider != "email" {
+		t.Fatalf("GetUserByEmail = %+v, want id %s role user provider email", byEmail, u.ID)
+	}
+
+	byID, err := st.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if byID.Email != u.Email || byID.FullName != u.FullName {
+		t.Fatalf("GetUserByID = %+v, want %+v", byID, u)
+	}
+
+	if _, err := st.GetUserByEmail(ctx, "missing@example.com"); err == nil {
+		t.Fatal("GetUserByEmail for unknown email succeeded")
+	}
+
+	if err := st.UpdateUserPassword(ctx, u.ID, "newhash"); err != nil {
+		t.Fatalf("UpdateUserPassword: %v", err)
+	}
+	if err := st.MarkEmailVerified(ctx, u.ID); err != nil {
+		t.Fatalf("MarkEmailVerified: %v", err)
+	}
+	got, _ := st.GetUserByID(ctx, u.ID)
+	if got.Password != "newhash" || !got.EmailVerified {
+		t.Fatalf("user after updates = %+v, want new password and verified", got)
+	}
+}
+
+func testSeedAdminUser(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := st.SeedAdminUser(ctx, "admin@example.com", "hash"); err != nil {
+			t.Fatalf("SeedAdminUser #%d: %v", i+1, err)
+		}
+	}
+
+	u, err := st.GetUserByEmail(ctx, "admin@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if u.Role != "admin" || !u.EmailVerified {
+		t.Fatalf("seeded admin = %+v, want verified admin", u)
+	}
+}
+
+func testEmailVerificationLookup(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "verify@example.com")
+	now := time.Now()
+
+	if err := st.CreateEmailVerification(ctx, u.ID, "111111", now.Add(10*time.Minute)); err != nil {
+		t.Fatalf("CreateEmailVerification: %v", err)
+	}
+	if err := st.CreateEmailVerification(ctx, u.ID, "222222", now.Add(30*time.Minute)); err != nil {
+		t.Fatalf("CreateEmailVerification: %v", err)
+	}
+	if err := st.CreateEmailVerification(ctx, u.ID, "333333", now.Add(-time.Minute)); err != nil {
+		t.Fatalf("CreateEmailVerification: %v", err)
+	}
+
+	v, err := st.GetEmailVerification(ctx, "111111")
+	if err != nil {
+		t.Fatalf("GetEmailVerification: %v", err)
+	}
+	if v.UserID != u.ID || !sameSecond(v.ExpiresAt, now.Add(10*time.Minute)) {
+		t.Fatalf("GetEmailVerification = %+v", v)
+	}
+
+	latest, err := st.GetLatestEmailVerificationByUser(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetLatestEmailVerificationByUser: %v", err)
+	}
+	if latest.Token != "222222" {
+		t.Fatalf("latest token = %s, want the one expiring last", latest.Token)
+	}
+
+	if err := st.DeleteEmailVerification(ctx, "111111"); err != nil {
+		t.Fatalf("DeleteEmailVerification: %v", err)
+	}
+	if _, err := st.GetEmailVerification(ctx, "111111"); err == nil {
+		t.Fatal("deleted verification token still found")
+	}
+	if _, err := st.GetLatestEmailVerificationByUser(ctx, mustUser(t, st, "none@example.com").ID); err == nil {
+		t.Fatal("latest verification for a user without tokens succeeded")
+	}
+}
+
+func testPasswordResetLookup(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "reset@example.com")
+	expires := time.Now().Add(time.Hour)
+
+	if err := st.CreatePasswordReset(ctx, u.ID, "reset-token", expires); err != nil {
+		t.Fatalf("CreatePasswordReset: %v", err)
+	}
+
+	r, err := st.GetPasswordReset(ctx, "reset-token")
+	if err != nil {
+		t.Fatalf("GetPasswordReset: %v", err)
+	}
+	if r.UserID != u.ID || !sameSecond(r.ExpiresAt, expires) {
+		t.Fatalf("GetPasswordReset = %+v", r)
+	}
+
+	if err := st.DeletePasswordReset(ctx, "reset-token"); err != nil {
+		t.Fatalf("DeletePasswordReset: %v", err)
+	}
+	if _, err := st.GetPasswordReset(ctx, "reset-token"); err == nil {
+		t.Fatal("deleted reset token still found")
+	}
+}
+
+func testProductCRUD(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	gallery := []models.ProductImage{
+		{URL: "https://example.com/front.png", Alt: "Front"},
+		{URL: "https://example.com/back.png"},
+	}
+	p, err := st.CreateProduct(ctx, &models.Product{
+		Name:        "Product CRUD-1",
+		Category:    "Testing",
+		PriceCents:  15000,
+		SKU:         "CRUD-1",
+		Stock:       7,
+		Thumbnail:   "https://example.com/thumb.png",
+		Images:      gallery,
+		Rating:      4.5,
+		ReviewCount: 12,
+	})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+
+	got, err := st.GetProduct(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if got.Name != p.Name || got.Category != "Testing" || got.PriceCents != 15000 || got.Stock != 7 || got.Thumbnail != p.Thumbnail {
+		t.Fatalf("GetProduct = %+v, want %+v", got, p)
+	}
+	if got.Rating != 4.5 || got.ReviewCount != 12 {
+		t.Fatalf("GetProduct rating = %v (%d reviews), want 4.5 (12)", got.Rating, got.ReviewCount)
+	}
+	if !reflect.DeepEqual(got.Images, gallery) {
+		t.Fatalf("GetProduct images = %+v, want %+v in order", got.Images, gallery)
+	}
+
+	plain := mustProduct(t, st, "CRUD-2", 1000, 1)
+	if got, _ := st.GetProduct(ctx, plain.ID); got.Images == nil || len(got.Images) != 0 {
+		t.Fatalf("product without gallery has images %#v, want empty list", got.Images)
+	}
+
+	updated, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+		p.PriceCents = 20000
+		p.Stock = 3
+		p.Images = gallery[1:]
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	if updated.PriceCents != 20000 || updated.Stock != 3 {
+		t.Fatalf("UpdateProduct = %+v", updated)
+	}
+	if got, _ := st.GetProduct(ctx, p.ID); len(got.Images) != 1 || got.Images[0].URL != gallery[1].URL || got.Rating != 4.5 {
+		t.Fatalf("product after update = %+v", got)
+	}
+
+	list, _, err := st.ListProducts(ctx, store.ProductFilter{Query: "crud-1"}, store.Page{})
+	if err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != p.ID || list[0].ReviewCount != 12 || len(list[0].Images) != 1 {
+		t.Fatalf("ListProducts(crud-1) = %+v, want only %s with its gallery", list, p.ID)
+	}
+
+	if err := st.PurgeProduct(ctx, p.ID); err != nil {
+		t.Fatalf("PurgeProduct: %v", err)
+	}
+	if _, err := st.GetProduct(ctx, p.ID); err == nil {
+		t.Fatal("purged product still found")
+	}
+	if err := st.PurgeProduct(ctx, p.ID); err == nil {
+		t.Fatal("purging a missing product succeeded")
+	}
+}
+
+func testArchiveRestorePurge(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "archive@example.com")
+	sold := mustProduct(t, st, "ARC-SOLD", 10000, 5)
+	other := mustProduct(t, st, "ARC-OTHER", 5000, 5)
+
+	if _, err := st.CreateOrder(ctx, u.ID, []models.CartItem{{ProductID: sold.ID, Quantity: 1}}, 10000, "paid", ""); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if err := st.AddToCart(ctx, u.ID, other.ID, "", 1); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := st.ArchiveProduct(ctx, sold.ID); err != nil {
+			t.Fatalf("ArchiveProduct #%d: %v", i+1, err)
+		}
+	}
+	if err := st.ArchiveProduct(ctx, "00000000-0000-0000-0000-000000000000"); err == nil {
+		t.Fatal("archiving a missing product succeeded")
+	}
+
+	// Archived products stay resolvable for order history
+	got, err := st.GetProduct(ctx, sold.ID)
+	if err != nil {
+		t.Fatalf("GetProduct of archived product: %v", err)
+	}
+	if got.ArchivedAt == nil {
+		t.Fatal("archived product has no archived_at")
+	}
+
+	public, _, err := st.ListProducts(ctx, store.ProductFilter{}, store.Page{})
+	if err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	if len(public) != 1 || public[0].ID != other.ID {
+		t.Fatalf("public listing = %d products, want only the active one", len(public))
+	}
+	all, _, err := st.ListProducts(ctx, store.ProductFilter{IncludeArchived: true}, store.Page{})
+	if err != nil {
+		t.Fatalf("ListProducts(IncludeArchived): %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("admin listing = %d products, want 2", len(all))
+	}
+
+	if err := st.AddToCart(ctx, u.ID, sold.ID, "", 1); !errors.Is(err, store.ErrProductArchived) {
+		t.Fatalf("AddToCart of archived product = %v, want ErrProductArchived", err)
+	}
+
+	if err := st.PurgeProduct(ctx, sold.ID); !errors.Is(err, store.ErrProductInUse) {
+		t.Fatalf("PurgeProduct of ordered product = %v, want ErrProductInUse", err)
+	}
+
+	if err := st.RestoreProduct(ctx, sold.ID); err != nil {
+		t.Fatalf("RestoreProduct: %v", err)
+	}
+	if got, _ := st.GetProduct(ctx, sold.ID); got.ArchivedAt != nil {
+		t.Fatalf("restored product still archived at %v", got.ArchivedAt)
+	}
+	if public, _, _ := st.ListProducts(ctx, store.ProductFilter{}, store.Page{}); len(public) != 2 {
+		t.Fatalf("public listing after restore = %d products, want 2", len(public))
+	}
+
+	// A product that was never ordered can be purged, carts included
+	if err := st.PurgeProduct(ctx, other.ID); err != nil {
+		t.Fatalf("PurgeProduct: %v", err)
+	}
+	if got := cartQty(t, st, u.ID, other.ID); got != 0 {
+		t.Fatalf("purged product still in cart with quantity %d", got)
+	}
+}
+
+func testProductLifecycle(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "lifecycle@example.com")
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	live := mustProduct(t, st, "LIFE-LIVE", 1000, 5)
+	if live.Status != models.ProductPublished {
+		t.Fatalf("new product status = %q, want published", live.Status)
+	}
+	create := func(sku, status string, publishAt, unpublishAt *time.Time) *models.Product {
+		t.Helper()
+		p, err := st.CreateProduct(ctx, &models.Product{Name: sku, SKU: sku, PriceCents: 1000, Stock: 5, Status: status, PublishAt: publishAt, UnpublishAt: unpublishAt})
+		if err != nil {
+			t.Fatalf("CreateProduct(%s): %v", sku, err)
+		}
+		return p
+	}
+	draft := create("LIFE-DRAFT", models.ProductDraft, nil, nil)
+	scheduled := create("LIFE-SOON", models.ProductPublished, &future, nil)
+	ended := create("LIFE-ENDED", models.ProductPublished, &past, &past)
+	window := create("LIFE-WINDOW", models.ProductPublished, &past, &future)
+
+	got, err := st.GetProduct(ctx, scheduled.ID)
+	if err != nil || got.PublishAt == nil || !sameSecond(*got.PublishAt, future) || got.UnpublishAt != nil {
+		t.Fatalf("GetProduct(scheduled) = %+v, %v", got, err)
+	}
+
+	ids := func(filter store.ProductFilter) map[string]bool {
+		t.Helper()
+		list, _, err := st.ListProducts(ctx, filter, store.Page{})
+		if err != nil {
+			t.Fatalf("ListProducts(%+v): %v", filter, err)
+		}
+		res := map[string]bool{}
+		for _, p := range list {
+			res[p.ID] = true
+		}
+		return res
+	}
+	if got := ids(store.ProductFilter{LiveAt: now}); len(got) != 2 || !got[live.ID] || !got[window.ID] {
+		t.Fatalf("live listing = %v, want the plain and the windowed product", got)
+	}
+	if got := ids(store.ProductFilter{LiveAt: future.Add(time.Minute)}); len(got) != 2 || !got[live.ID] || !got[scheduled.ID] {
+		t.Fatalf("listing in two hours = %v, want the plain and the scheduled product", got)
+	}
+	if got := ids(store.ProductFilter{Status: models.ProductDraft}); len(got) != 1 || !got[draft.ID] {
+		t.Fatalf("draft listing = %v, want only the draft", got)
+	}
+	if got := ids(store.ProductFilter{}); len(got) != 5 {
+		t.Fatalf("unfiltered listing = %d products, want 5", len(got))
+	}
+
+	for _, p := range []*models.Product{draft, scheduled, ended} {
+		if err := st.AddToCart(ctx, u.ID, p.ID, "", 1); !errors.Is(err, store.ErrProductNotPublished) {
+			t.Fatalf("AddToCart(%s) = %v, want ErrProductNotPublished", p.SKU, err)
+		}
+	}
+
+	// Unpublishing a product in a cart stops its checkout
+	if err := st.AddToCart(ctx, u.ID, live.ID, "", 1); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+	if _, err := st.UpdateProduct(ctx, live.ID, func(p *models.Product) error {
+		p.Status = models.ProductDraft
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	_, _, err = st.CheckoutCart(ctx, u.ID)
+	if !errors.Is(err, store.ErrProductNotPublished) {
+		t.Fatalf("CheckoutCart = %v, want ErrProductNotPublished", err)
+	}
+
+	// Archiving sets the status; restoring puts back the one it had
+	if err := st.ArchiveProduct(ctx, window.ID); err != nil {
+		t.Fatalf("ArchiveProduct: %v", err)
+	}
+	if got := ids(store.ProductFilter{IncludeArchived: true, Status: models.ProductArchived}); len(got) != 1 || !got[window.ID] {
+		t.Fatalf("archived listing = %v, want the archived product", got)
+	}
+	if err := st.RestoreProduct(ctx, window.ID); err != nil {
+		t.Fatalf("RestoreProduct: %v", err)
+	}
+	if got, _ := st.GetProduct(ctx, window.ID); got.Status != models.ProductPublished || got.ArchivedAt != nil {
+		t.Fatalf("restored product status = %q", got.Status)
+	}
+	if err := st.ArchiveProduct(ctx, draft.ID); err != nil {
+		t.Fatalf("ArchiveProduct(draft): %v", err)
+	}
+	if err := st.RestoreProduct(ctx, draft.ID); err != nil {
+		t.Fatalf("RestoreProduct(draft): %v", err)
+	}
+	if got, _ := st.GetProduct(ctx, draft.ID); got.Status != models.ProductDraft || got.ArchivedAt != nil {
+		t.Fatalf("restored draft status = %q, want draft", got.Status)
+	}
+	if got := ids(store.ProductFilter{LiveAt: now}); got[draft.ID] {
+		t.Fatal("restored draft is live")
+	}
+}
+
+func testCheckoutRejectsArchived(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "archived-cart@example.com")
+	p := mustProduct(t, st, "ARC-CART", 10000, 5)
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 1); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+	if err := st.ArchiveProduct(ctx, p.ID); err != nil {
+		t.Fatalf("ArchiveProduct: %v", err)
+	}
+
+	_, _, err := st.CheckoutCart(ctx, u.ID)
+	if !errors.Is(err, store.ErrProductArchived) {
+		t.Fatalf("CheckoutCart = %v, want ErrProductArchived", err)
+	}
+	if got := stockOf(t, st, p.ID); got != 5 {
+		t.Fatalf("stock = %d after rejected checkout, want 5", got)
+	}
+}
+
+func testCartQuantityRules(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "cart@example.com")
+	p := mustProduct(t, st, "CART-1", 10000, 5)
+
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 0); err == nil {
+		t.Fatal("AddToCart with zero quantity succeeded")
+	}
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 6); err == nil {
+		t.Fatal("AddToCart beyond stock succeeded")
+	}
+	if err := st.AddToCart(ctx, u.ID, "00000000-0000-0000-0000-000000000000", "", 1); err == nil {
+		t.Fatal("AddToCart for unknown product succeeded")
+	}
+
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 3); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 2); err != nil {
+		t.Fatalf("AddToCart up to stock: %v", err)
+	}
+	if got := cartQty(t, st, u.ID, p.ID); got != 5 {
+		t.Fatalf("cart quantity = %d, want 5", got)
+	}
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 1); err == nil {
+		t.Fatal("AddToCart pushing the line past stock succeeded")
+	}
+
+	if err := st.RemoveFromCart(ctx, u.ID, p.ID, "", 0); err == nil {
+		t.Fatal("RemoveFromCart with zero quantity succeeded")
+	}
+	if err := st.RemoveFromCart(ctx, u.ID, p.ID, "", 2); err != nil {
+		t.Fatalf("RemoveFromCart: %v", err)
+	}
+	if got := cartQty(t, st, u.ID, p.ID); got != 3 {
+		t.Fatalf("cart quantity after remove = %d, want 3", got)
+	}
+	if err := st.RemoveFromCart(ctx, u.ID, p.ID, "", 10); err != nil {
+		t.Fatalf("RemoveFromCart past quantity: %v", err)
+	}
+	c, _ := st.GetCart(ctx, u.ID)
+	if len(c.Items) != 0 {
+		t.Fatalf("cart still has %d lines after removing everything", len(c.Items))
+	}
+	if err := st.RemoveFromCart(ctx, u.ID, p.ID, "", 1); err == nil {
+		t.Fatal("RemoveFromCart for an item not in the cart succeeded")
+	}
+
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 1); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+	st.ClearCart(ctx, u.ID)
+	c, _ = st.GetCart(ctx, u.ID)
+	if len(c.Items) != 0 {
+		t.Fatalf("cart has %d lines after ClearCart", len(c.Items))
+	}
+}
+
+func testGuestCarts(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	const guest = "6f1c2a4e-8b3d-4c5e-9f70-1a2b3c4d5e6f"
+	u := mustUser(t, st, "guest-cart@example.com")
+	p := mustProduct(t, st, "GUEST-1", 10000, 5)
+	q := mustProduct(t, st, "GUEST-2", 20000, 3)
+	gone := mustProduct(t, st, "GUEST-3", 30000, 3)
+
+	c, err := st.GetGuestCart(ctx, guest)
+	if err != nil || len(c.Items) != 0 {
+		t.Fatalf("GetGuestCart of a new guest = %+v, %v; want an empty cart", c, err)
+	}
+	if err := st.AddToGuestCart(ctx, guest, p.ID, "", 6); err == nil {
+		t.Fatal("AddToGuestCart beyond stock succeeded")
+	}
+	for _, it := range []struct {
+		id  string
+		qty int
+	}{{p.ID, 4}, {q.ID, 3}, {gone.ID, 1}} {
+		if err := st.AddToGuestCart(ctx, guest, it.id, "", it.qty); err != nil {
+			t.Fatalf("AddToGuestCart: %v", err)
+		}
+	}
+	if err := st.RemoveFromGuestCart(ctx, guest, q.ID, "", 1); err != nil {
+		t.Fatalf("RemoveFromGuestCart: %v", err)
+	}
+	if err := st.RemoveFromGuestCart(ctx, guest, "00000000-0000-0000-0000-000000000000", "", 1); err == nil {
+		t.Fatal("RemoveFromGuestCart for an item not in the cart succeeded")
+	}
+	c, _ = st.GetGuestCart(ctx, guest)
+	if len(c.Items) != 3 {
+		t.Fatalf("guest cart has %d lines, want 3", len(c.Items))
+	}
+
+	// The merge caps p at its stock, adds q and drops the archived product
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 3); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+	if err := st.ArchiveProduct(ctx, gone.ID); err != nil {
+		t.Fatalf("ArchiveProduct: %v", err)
+	}
+	merged, err := st.MergeGuestCart(ctx, guest, u.ID)
+	if err != nil {
+		t.Fatalf("MergeGuestCart: %v", err)
+	}
+	if len(merged.Items) != 2 {
+		t.Fatalf("merged cart has %d lines, want 2", len(merged.Items))
+	}
+	if got := cartQty(t, st, u.ID, p.ID); got != 5 {
+		t.Fatalf("merged quantity of %s = %d, want 5", p.SKU, got)
+	}
+	if got := cartQty(t, st, u.ID, q.ID); got != 2 {
+		t.Fatalf("merged quantity of %s = %d, want 2", q.SKU, got)
+	}
+	if got := cartQty(t, st, u.ID, gone.ID); got != 0 {
+		t.Fatalf("archived product merged with quantity %d", got)
+	}
+	c, _ = st.GetGuestCart(ctx, guest)
+	if len(c.Items) != 0 {
+		t.Fatalf("guest cart still has %d lines after the merge", len(c.Items))
+	}
+	if _, err := st.MergeGuestCart(ctx, guest, u.ID); err != nil {
+		t.Fatalf("MergeGuestCart of an empty guest cart: %v", err)
+	}
+	if got := cartQty(t, st, u.ID, p.ID); got != 5 {
+		t.Fatalf("quantity after merging an empty guest cart = %d, want 5", got)
+	}
+
+	if err := st.AddToGuestCart(ctx, guest, p.ID, "", 1); err != nil {
+		t.Fatalf("AddToGuestCart: %v", err)
+	}
+	if n, err := st.ExpireGuestCarts(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("ExpireGuestCarts of a fresh cart = %d, %v; want 0", n, err)
+	}
+	if n, err := st.ExpireGuestCarts(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("ExpireGuestCarts = %d, %v; want 1", n, err)
+	}
+	c, _ = st.GetGuestCart(ctx, guest)
+	if len(c.Items) != 0 {
+		t.Fatalf("expired guest cart still has %d lines", len(c.Items))
+	}
+}
+
+func testCartSetQuantity(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	const guest = "0d9e8f7a-6b5c-4d3e-8f2a-1b0c9d8e7f6a"
+	u := mustUser(t, st, "set-qty@example.com")
+	p := mustProduct(t, st, "SETQ-1", 10000, 5)
+
+	if err := st.SetCartQuantity(ctx, u.ID, p.ID, "", -1); err == nil {
+		t.Fatal("SetCartQuantity with a negative quantity succeeded")
+	}
+	if err := st.SetCartQuantity(ctx, u.ID, p.ID, "", 6); err == nil {
+		t.Fatal("SetCartQuantity beyond stock succeeded")
+	}
+	if err := st.SetCartQuantity(ctx, u.ID, p.ID, "", 0); err != nil {
+		t.Fatalf("SetCartQuantity to 0 of a line not in the cart: %v", err)
+	}
+	for _, qty := range []int{4, 2, 5} {
+		if err := st.SetCartQuantity(ctx, u.ID, p.ID, "", qty); err != nil {
+			t.Fatalf("SetCartQuantity(%d): %v", qty, err)
+		}
+		if got := cartQty(t, st, u.ID, p.ID); got != qty {
+			t.Fatalf("cart quantity = %d, want %d", got, qty)
+		}
+	}
+	if err := st.SetCartQuantity(ctx, u.ID, p.ID, "", 0); err != nil {
+		t.Fatalf("SetCartQuantity to 0: %v", err)
+	}
+	c, _ := st.GetCart(ctx, u.ID)
+	if len(c.Items) != 0 {
+		t.Fatalf("cart has %d lines after setting the only one to 0", len(c.Items))
+	}
+
+	if err := st.SetGuestCartQuantity(ctx, guest, p.ID, "", 6); err == nil {
+		t.Fatal("SetGuestCartQuantity beyond stock succeeded")
+	}
+	if err := st.SetGuestCartQuantity(ctx, guest, p.ID, "", 3); err != nil {
+		t.Fatalf("SetGuestCartQuantity: %v", err)
+	}
+	g, _ := st.GetGuestCart(ctx, guest)
+	if len(g.Items) != 1 || g.Items[0].Quantity != 3 {
+		t.Fatalf("guest cart = %+v, want one line of 3", g.Items)
+	}
+	if err := st.SetGuestCartQuantity(ctx, guest, p.ID, "", 0); err != nil {
+		t.Fatalf("SetGuestCartQuantity to 0: %v", err)
+	}
+	g, _ = st.GetGuestCart(ctx, guest)
+	if len(g.Items) != 0 {
+		t.Fatalf("guest cart has %d lines after setting the only one to 0", len(g.Items))
+	}
+}
+
+func testCreateOrderDecrementsStock(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "order@example.com")
+	a := mustProduct(t, st, "ORD-A", 10000, 5)
+	b := mustProduct(t, st, "ORD-B", 2500, 2)
+
+	items := []models.CartItem{{ProductID: a.ID, Quantity: 2}, {ProductID: b.ID, Quantity: 2}}
+	o, err := st.CreateOrder(ctx, u.ID, items, 25000, "pending", "")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if o.ID == "" || o.Status != "pending" || o.Amount != 25000 {
+		t.Fatalf("CreateOrder = %+v", o)
+	}
+
+	if got := stockOf(t, st, a.ID); got != 3 {
+		t.Fatalf("stock of A = %d, want 3", got)
+	}
+	if got := stockOf(t, st, b.ID); got != 0 {
+		t.Fatalf("stock of B = %d, want 0", got)
+	}
+
+	orders, _, err := st.ListOrdersByUser(ctx, u.ID, store.Page{})
+	if err != nil {
+		t.Fatalf("ListOrdersByUser: %v", err)
+	}
+	if len(orders) != 1 || len(orders[0].Items) != 2 {
+		t.Fatalf("ListOrdersByUser = %+v, want one order with two lines", orders)
+	}
+}
+
+func testCreateOrderRollsBack(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "rollback@example.com")
+	a := mustProduct(t, st, "RB-A", 10000, 5)
+	b := mustProduct(t, st, "RB-B", 10000, 1)
+
+	items := []models.CartItem{{ProductID: a.ID, Quantity: 2}, {ProductID: b.ID, Quantity: 2}}
+	if _, err := st.CreateOrder(ctx, u.ID, items, 40000, "pending", ""); err == nil {
+		t.Fatal("CreateOrder with insufficient stock succeeded")
+	}
+
+	if got := stockOf(t, st, a.ID); got != 5 {
+		t.Fatalf("stock of A = %d after failed order, want 5", got)
+	}
+	if got := stockOf(t, st, b.ID); got != 1 {
+		t.Fatalf("stock of B = %d after failed order, want 1", got)
+	}
+
+	orders, _, err := st.ListOrdersByUser(ctx, u.ID, store.Page{})
+	if err != nil {
+		t.Fatalf("ListOrdersByUser: %v", err)
+	}
+	if len(orders) != 0 {
+		t.Fatalf("failed CreateOrder left %d orders behind", len(orders))
+	}
+}
+
+func testOrderStatusAndPaymentRef(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "status@example.com")
+	p := mustProduct(t, st, "STAT-1", 10000, 5)
+
+	o, err := st.CreateOrder(ctx, u.ID, []models.CartItem{{ProductID: p.ID, Quantity: 1}}, 10000, "pending", "")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if err := st.UpdateOrderPaymentRef(ctx, o.ID, "PAY-123"); err != nil {
+		t.Fatalf("UpdateOrderPaymentRef: %v", err)
+	}
+	if err := st.UpdateOrderStatus(ctx, o.ID, "paid"); err != nil {
+		t.Fatalf("UpdateOrderStatus: %v", err)
+	}
+	if err := st.UpdateOrderStatus(ctx, "00000000-0000-0000-0000-000000000000", "paid"); err == nil {
+		t.Fatal("UpdateOrderStatus for unknown order succeeded")
+	}
+
+	got, err := st.GetOrder(ctx, o.ID)
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if got.Status != "paid" || got.PaymentRef != "PAY-123" || len(got.Items) != 1 || got.Items[0].PriceCents != 10000 {
+		t.Fatalf("GetOrder = %+v", got)
+	}
+	if _, err := st.GetOrder(ctx, "00000000-0000-0000-0000-000000000000"); err == nil {
+		t.Fatal("GetOrder for unknown order succeeded")
+	}
+
+	orders, _, err := st.ListOrders(ctx, store.Page{})
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if len(orders) != 1 || orders[0].Status != "paid" || orders[0].PaymentRef != "PAY-123" || orders[0].UserID != u.ID {
+		t.Fatalf("ListOrders = %+v", orders)
+	}
+}
+
+func testStockReservations(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "reserve@example.com")
+	a := mustProduct(t, st, "RES-A", 10000, 5)
+	b := mustProduct(t, st, "RES-B", 10000, 0)
+	if _, err := st.UpdateProduct(ctx, b.ID, func(p *models.Product) error {
+		p.Options = []models.ProductOption{{Name: "size", Values: []string{"S"}}}
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	small, err := st.CreateVariant(ctx, b.ID, &models.ProductVariant{SKU: "RES-B-S", Options: map[string]string{"size": "S"}, Stock: 3})
+	if err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+	wantStock := func(wantA, wantB int) {
+		t.Helper()
+		if got := stockOf(t, st, a.ID); got != wantA {
+			t.Fatalf("stock of A = %d, want %d", got, wantA)
+		}
+		if got := stockOf(t, st, b.ID); got != wantB {
+			t.Fatalf("stock of B = %d, want %d", got, wantB)
+		}
+	}
+	wantOrder := func(id, status, reservation string) {
+		t.Helper()
+		o, err := st.GetOrder(ctx, id)
+		if err != nil {
+			t.Fatalf("GetOrder: %v", err)
+		}
+		if o.Status != status || o.Reservation != reservation {
+			t.Fatalf("order is %s/%s, want %s/%s", o.Status, o.Reservation, status, reservation)
+		}
+	}
+
+	items := []models.CartItem{{ProductID: a.ID, Quantity: 2}, {ProductID: b.ID, VariantID: small.ID, Quantity: 1}}
+	o, err := st.CreateOrder(ctx, u.ID, items, 30000, "pending", "")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	wantOrder(o.ID, "pending", models.ReservationHeld)
+	wantStock(3, 2)
+
+	// Failing releases the units once; reopening takes them again
+	for range 2 {
+		if err := st.UpdateOrderStatus(ctx, o.ID, "failed"); err != nil {
+			t.Fatalf("UpdateOrderStatus(failed): %v", err)
+		}
+	}
+	wantOrder(o.ID, "failed", models.ReservationReleased)
+	wantStock(5, 3)
+	if err := st.UpdateOrderStatus(ctx, o.ID, "pending"); err != nil {
+		t.Fatalf("UpdateOrderStatus(pending): %v", err)
+	}
+	wantStock(3, 2)
+
+	// Paid orders keep their units and never expire
+	if err := st.UpdateOrderStatus(ctx, o.ID, "paid"); err != nil {
+		t.Fatalf("UpdateOrderStatus(paid): %v", err)
+	}
+	wantOrder(o.ID, "paid", models.ReservationCommitted)
+	wantStock(3, 2)
+
+	unpaid, err := st.CreateOrder(ctx, u.ID, []models.CartItem{{ProductID: a.ID, Quantity: 1}}, 10000, "pending", "")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	wantStock(2, 2)
+	if n, err := st.ExpireReservations(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("ExpireReservations before the timeout = %d, %v; want 0", n, err)
+	}
+	if n, err := st.ExpireReservations(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("ExpireReservations = %d, %v; want 1", n, err)
+	}
+	wantOrder(unpaid.ID, "expired", models.ReservationReleased)
+	wantOrder(o.ID, "paid", models.ReservationCommitted)
+	wantStock(3, 2)
+
+	// A payment arriving after the units went to someone else fails
+	// without changing anything
+	if _, err := st.CreateOrder(ctx, u.ID, []models.CartItem{{ProductID: a.ID, Quantity: 3}}, 30000, "paid", ""); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if err := st.UpdateOrderStatus(ctx, unpaid.ID, "paid"); !errors.Is(err, store.ErrInsufficientStock) {
+		t.Fatalf("UpdateOrderStatus(paid) of an expired order = %v, want ErrInsufficientStock", err)
+	}
+	wantOrder(unpaid.ID, "expired", models.ReservationReleased)
+	wantStock(0, 2)
+}
+
+// ledgerOf pages through a product's stock movements two at a time
+func ledgerOf(t *testing.T, st store.Store, productID string) []*models.StockMovement {
+	t.Helper()
+	all := []*models.StockMovement{}
+	page := store.Page{Limit: 2}
+	for {
+		ms, next, err := st.ListStockMovements(context.Background(), productID, page)
+		if err != nil {
+			t.Fatalf("ListStockMovements: %v", err)
+		}
+		all = append(all, ms...)
+		if next == "" {
+			return all
+		}
+		page.Cursor = next
+	}
+}
+
+func testStockLedger(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	admin := store.WithActor(ctx, "admin-1")
+	u := mustUser(t, st, "ledger@example.com")
+	a := mustProduct(t, st, "LED-A", 10000, 5)
+	b := mustProduct(t, st, "LED-B", 10000, 2)
+	if _, err := st.UpdateProduct(admin, b.ID, func(p *models.Product) error {
+		p.Options = []models.ProductOption{{Name: "size", Values: []string{"S"}}}
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	small, err := st.CreateVariant(admin, b.ID, &models.ProductVariant{SKU: "LED-B-S", Options: map[string]string{"size": "S"}, Stock: 3})
+	if err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+
+	// Every change adds up: the deltas of each product's ledger are its stock
+	balanced := func() {
+		t.Helper()
+		for _, id := range []string{a.ID, b.ID} {
+			sum := 0
+			for _, m := range ledgerOf(t, st, id) {
+				sum += m.Delta
+			}
+			if got := stockOf(t, st, id); got != sum {
+				t.Fatalf("stock of %s = %d, ledger adds up to %d", id, got, sum)
+			}
+		}
+	}
+	balanced()
+
+	m, p, err := st.AdjustStock(admin, a.ID, "", "", 4, models.StockRestock, "PO-1")
+	if err != nil {
+		t.Fatalf("AdjustStock: %v", err)
+	}
+	if m.ID == "" || m.ProductID != a.ID || m.Delta != 4 || m.Reason != models.StockRestock || m.ActorID != "admin-1" || m.Note != "PO-1" {
+		t.Fatalf("AdjustStock = %+v", m)
+	}
+	if p.ID != a.ID || p.Stock != 9 {
+		t.Fatalf("AdjustStock product = %+v, want A with stock 9", p)
+	}
+	if got := stockOf(t, st, a.ID); got != 9 {
+		t.Fatalf("stock of A = %d, want 9", got)
+	}
+	if _, _, err := st.AdjustStock(admin, a.ID, "", "", -10, models.StockAdjustment, ""); !errors.Is(err, store.ErrInsufficientStock) {
+		t.Fatalf("AdjustStock below zero = %v, want ErrInsufficientStock", err)
+	}
+	if _, _, err := st.AdjustStock(admin, a.ID, "", "", -1, models.StockSale, ""); !errors.Is(err, store.ErrInvalidStockAdjustment) {
+		t.Fatalf("AdjustStock(sale) = %v, want ErrInvalidStockAdjustment", err)
+	}
+	if _, _, err := st.AdjustStock(admin, a.ID, "", "", 0, models.StockAdjustment, ""); !errors.Is(err, store.ErrInvalidStockAdjustment) {
+		t.Fatalf("AdjustStock(0) = %v, want ErrInvalidStockAdjustment", err)
+	}
+	if _, _, err := st.AdjustStock(admin, b.ID, "", "", 1, models.StockAdjustment, ""); !errors.Is(err, store.ErrVariantRequired) {
+		t.Fatalf("AdjustStock without a variant = %v, want ErrVariantRequired", err)
+	}
+	_, p, err = st.AdjustStock(admin, b.ID, small.ID, "", -1, models.StockAdjustment, "rusak")
+	if err != nil {
+		t.Fatalf("AdjustStock(variant): %v", err)
+	}
+	if p.Stock != 2 || p.Variant(small.ID) == nil || p.Variant(small.ID).Stock != 2 {
+		t.Fatalf("AdjustStock(variant) product = %+v, want B and its variant with stock 2", p)
+	}
+	if got := stockOf(t, st, b.ID); got != 2 {
+		t.Fatalf("stock of B = %d, want 2", got)
+	}
+	balanced()
+
+	// An order is a sale by the customer; failing it releases the units and
+	// canceling after payment returns them
+	items := []models.CartItem{{ProductID: a.ID, Quantity: 2}, {ProductID: b.ID, VariantID: small.ID, Quantity: 1}}
+	o, err := st.CreateOrder(ctx, u.ID, items, 30000, "pending", "")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	for _, status := range []string{"failed", "paid", "canceled"} {
+		if err := st.UpdateOrderStatus(admin, o.ID, status); err != nil {
+			t.Fatalf("UpdateOrderStatus(%s): %v", status, err)
+		}
+	}
+	if _, err := st.UpdateProduct(admin, a.ID, func(p *models.Product) error {
+		p.Stock = 20
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	balanced()
+
+	reasons := map[string]int{}
+	for _, m := range ledgerOf(t, st, a.ID) {
+		if m.ReferenceID == o.ID {
+			reasons[m.Reason] += m.Delta
+		}
+		if m.Reason == models.StockSale && m.ActorID != u.ID && m.ActorID != "admin-1" {
+			t.Fatalf("sale recorded against %q", m.ActorID)
+		}
+	}
+	want := map[string]int{models.StockSale: -4, models.StockRelease: 2, models.StockReturn: 2}
+	if len(reasons) != len(want) {
+		t.Fatalf("order movements of A = %v, want %v", reasons, want)
+	}
+	for reason, delta := range want {
+		if reasons[reason] != delta {
+			t.Fatalf("order movements of A = %v, want %v", reasons, want)
+		}
+	}
+	if !slices.ContainsFunc(ledgerOf(t, st, a.ID), func(m *models.StockMovement) bool {
+		return m.Reason == models.StockAdjustment && m.Delta == 11 && m.ActorID == "admin-1"
+	}) {
+		t.Fatal("editing the stock of A to 20 was not recorded as a +11 adjustment")
+	}
+	for _, m := range ledgerOf(t, st, b.ID) {
+		if m.ReferenceID == o.ID && m.VariantID != small.ID {
+			t.Fatalf("order movement of B on %q, want the variant", m.VariantID)
+		}
+	}
+}
+
+func testStockLocations(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	admin := store.WithActor(ctx, "admin-1")
+	u := mustUser(t, st, "locations@example.com")
+
+	locs, err := st.ListLocations(ctx)
+	if err != nil || len(locs) != 1 {
+		t.Fatalf("ListLocations on an empty store = %v, %v; want the default location", locs, err)
+	}
+	main := locs[0]
+	coords := func(lat, lng float64) (*float64, *float64) { return &lat, &lng }
+	cafe := &models.Location{Name: "Cafe Counter", Priority: 5}
+	cafe.Latitude, cafe.Longitude = coords(-6.2, 106.8)
+	if cafe, err = st.CreateLocation(ctx, cafe); err != nil {
+		t.Fatalf("CreateLocation(cafe): %v", err)
+	}
+	east := &models.Location{Name: "East Warehouse", Code: "EAST", Priority: 10}
+	east.Latitude, east.Longitude = coords(-7.25, 112.75)
+	if east, err = st.CreateLocation(ctx, east); err != nil {
+		t.Fatalf("CreateLocation(east): %v", err)
+	}
+	if locs, err = st.ListLocations(ctx); err != nil || len(locs) != 3 || locs[0].ID != main.ID || locs[1].ID != cafe.ID || locs[2].ID != east.ID {
+		t.Fatalf("ListLocations = %v, %v; want default, cafe, east", locs, err)
+	}
+
+	// Levels add up to the product's stock; the ledger to each level
+	p := mustProduct(t, st, "LOC-A", 10000, 5)
+	levelsAre := func(want map[string]int) {
+		t.Helper()
+		levels, err := st.ListStockLevels(ctx, p.ID)
+		if err != nil {
+			t.Fatalf("ListStockLevels: %v", err)
+		}
+		got, total := map[string]int{}, 0
+		for _, l := range levels {
+			got[l.LocationID] = l.Stock
+			total += l.Stock
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("stock levels = %v, want %v", got, want)
+		}
+		if stock := stockOf(t, st, p.ID); stock != total {
+			t.Fatalf("stock = %d, levels add up to %d", stock, total)
+		}
+		ledger := map[string]int{}
+		for _, m := range ledgerOf(t, st, p.ID) {
+			if m.LocationID == "" {
+				t.Fatalf("movement %+v has no location", m)
+			}
+			if ledger[m.LocationID] += m.Delta; ledger[m.LocationID] == 0 {
+				delete(ledger, m.LocationID)
+			}
+		}
+		if !reflect.DeepEqual(ledger, want) {
+			t.Fatalf("ledger per location = %v, want %v", ledger, want)
+		}
+	}
+	levelsAre(map[string]int{main.ID: 5})
+
+	ms, err := st.TransferStock(admin, p.ID, "", main.ID, east.ID, 3, "restock east")
+	if err != nil {
+		t.Fatalf("TransferStock: %v", err)
+	}
+	if len(ms) != 2 || ms[0].LocationID != main.ID || ms[0].Delta != -3 || ms[1].LocationID != east.ID || ms[1].Delta != 3 || ms[1].Reason != models.StockTransfer {
+		t.Fatalf("TransferStock = %+v", ms)
+	}
+	levelsAre(map[string]int{main.ID: 2, east.ID: 3})
+	if _, err := st.TransferStock(admin, p.ID, "", main.ID, east.ID, 3, ""); !errors.Is(err, store.ErrInsufficientStock) {
+		t.Fatalf("TransferStock beyond the source = %v, want ErrInsufficientStock", err)
+	}
+	if _, err := st.TransferStock(admin, p.ID, "", main.ID, main.ID, 1, ""); !errors.Is(err, store.ErrInvalidStockAdjustment) {
+		t.Fatalf("TransferStock to itself = %v, want ErrInvalidStockAdjustment", err)
+	}
+	if _, err := st.TransferStock(admin, p.ID, "", main.ID, east.ID, 0, ""); !errors.Is(err, store.ErrInvalidStockAdjustment) {
+		t.Fatalf("TransferStock(0) = %v, want ErrInvalidStockAdjustment", err)
+	}
+	if _, err := st.TransferStock(admin, p.ID, "", main.ID, p.ID, 1, ""); err == nil {
+		t.Fatal("TransferStock to an unknown location succeeded")
+	}
+
+	// By priority, the first location holding the whole line ships it
+	line := func(qty int) []models.CartItem { return []models.CartItem{{ProductID: p.ID, Quantity: qty}} }
+	allocated := func(o *models.Order, want ...models.StockAllocation) {
+		t.Helper()
+		got, err := st.GetOrder(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("GetOrder: %v", err)
+		}
+		if !reflect.DeepEqual(got.Items[0].Allocations, want) {
+			t.Fatalf("allocations = %+v, want %+v", got.Items[0].Allocations, want)
+		}
+	}
+	o1, err := st.CreateOrder(ctx, u.ID, line(3), 30000, "pending", "")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	allocated(o1, models.StockAllocation{LocationID: east.ID, Quantity: 3})
+	levelsAre(map[string]int{main.ID: 2})
+	if err := st.UpdateOrderStatus(admin, o1.ID, "failed"); err != nil {
+		t.Fatalf("UpdateOrderStatus: %v", err)
+	}
+	levelsAre(map[string]int{main.ID: 2, east.ID: 3})
+
+	// Nearest ships from the closest location that has it
+	lat, lng := coords(-7.3, 112.7)
+	near := store.WithAllocation(ctx, store.Allocation{Rule: store.AllocateNearest, Latitude: lat, Longitude: lng})
+	o2, err := st.CreateOrder(near, u.ID, line(2), 20000, "pending", "")
+	if err != nil {
+		t.Fatalf("CreateOrder(nearest): %v", err)
+	}
+	allocated(o2, models.StockAllocation{LocationID: east.ID, Quantity: 2})
+	levelsAre(map[string]int{main.ID: 2, east.ID: 1})
+
+	// A line no single location holds is split in priority order
+	o3, err := st.CreateOrder(ctx, u.ID, line(3), 30000, "paid", "")
+	if err != nil {
+		t.Fatalf("CreateOrder(split): %v", err)
+	}
+	allocated(o3, models.StockAllocation{LocationID: main.ID, Quantity: 2}, models.StockAllocation{LocationID: east.ID, Quantity: 1})
+	levelsAre(map[string]int{})
+	if _, err := st.CreateOrder(ctx, u.ID, line(1), 10000, "pending", ""); !errors.Is(err, store.ErrInsufficientStock) {
+		t.Fatalf("CreateOrder with no stock left = %v, want ErrInsufficientStock", err)
+	}
+	if err := st.UpdateOrderStatus(admin, o3.ID, "canceled"); err != nil {
+		t.Fatalf("UpdateOrderStatus: %v", err)
+	}
+	levelsAre(map[string]int{main.ID: 2, east.ID: 1})
+
+	// Manual adjustments land on the location given, or the default
+	m, _, err := st.AdjustStock(admin, p.ID, "", east.ID, 4, models.StockRestock, "")
+	if err != nil || m.LocationID != east.ID {
+		t.Fatalf("AdjustStock(east) = %+v, %v", m, err)
+	}
+	if _, _, err := st.AdjustStock(admin, p.ID, "", cafe.ID, -1, models.StockAdjustment, ""); !errors.Is(err, store.ErrInsufficientStock) {
+		t.Fatalf("AdjustStock below zero at the cafe = %v, want ErrInsufficientStock", err)
+	}
+	if _, err := st.UpdateProduct(admin, p.ID, func(p *models.Product) error {
+		p.Stock = 10
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	levelsAre(map[string]int{main.ID: 5, east.ID: 5})
+
+	if err := st.DeleteLocation(admin, east.ID); !errors.Is(err, store.ErrLocationInUse) {
+		t.Fatalf("DeleteLocation holding stock = %v, want ErrLocationInUse", err)
+	}
+	if err := st.DeleteLocation(admin, cafe.ID); err != nil {
+		t.Fatalf("DeleteLocation(cafe): %v", err)
+	}
+	upd, err := st.UpdateLocation(admin, east.ID, func(l *models.Location) error {
+		l.Priority = -1
+		return nil
+	})
+	if err != nil || upd.Priority != -1 || upd.Name != east.Name {
+		t.Fatalf("UpdateLocation = %+v, %v", upd, err)
+	}
+	if locs, err = st.ListLocations(ctx); err != nil || len(locs) != 2 || locs[0].ID != east.ID {
+		t.Fatalf("ListLocations after reprioritising = %v, %v; want east first", locs, err)
+	}
+}
+
+func testStockSubscriptions(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "subscriber@example.com")
+	other := mustUser(t, st, "subscriber2@example.com")
+	p := mustProduct(t, st, "SUB-A", 10000, 0)
+	inStock := mustProduct(t, st, "SUB-B", 10000, 1)
+
+	if _, err := st.SubscribeStock(ctx, u.ID, inStock.ID, ""); !errors.Is(err, store.ErrInStock) {
+		t.Fatalf("SubscribeStock to an in-stock product err = %v, want ErrInStock", err)
+	}
+	sub, err := st.SubscribeStock(ctx, u.ID, p.ID, "")
+	if err != nil || sub.UserID != u.ID || sub.ProductID != p.ID || sub.CreatedAt.IsZero() {
+		t.Fatalf("SubscribeStock = %+v, %v", sub, err)
+	}
+	if again, err := st.SubscribeStock(ctx, u.ID, p.ID, ""); err != nil || !again.CreatedAt.Equal(sub.CreatedAt) {
+		t.Fatalf("SubscribeStock again = %+v, %v; want the same subscription", again, err)
+	}
+	if _, err := st.SubscribeStock(ctx, other.ID, p.ID, ""); err != nil {
+		t.Fatalf("SubscribeStock(other): %v", err)
+	}
+
+	// Nothing is listed while the product is still out of stock
+	if subs, err := st.ListRestockedSubscriptions(ctx, p.ID); err != nil || len(subs) != 0 {
+		t.Fatalf("ListRestockedSubscriptions out of stock = %v, %v; want none", subs, err)
+	}
+	if err := st.UnsubscribeStock(ctx, other.ID, p.ID, ""); err != nil {
+		t.Fatalf("UnsubscribeStock: %v", err)
+	}
+	if _, _, err := st.AdjustStock(ctx, p.ID, "", "", 4, models.StockRestock, ""); err != nil {
+		t.Fatalf("AdjustStock: %v", err)
+	}
+	subs, err := st.ListRestockedSubscriptions(ctx, p.ID)
+	if err != nil || len(subs) != 1 || subs[0].UserID != u.ID {
+		t.Fatalf("ListRestockedSubscriptions = %v, %v; want only the remaining subscriber", subs, err)
+	}
+	// Subscriptions stay until the subscriber has been notified
+	if again, err := st.ListRestockedSubscriptions(ctx, p.ID); err != nil || len(again) != 1 {
+		t.Fatalf("ListRestockedSubscriptions again = %v, %v; want the subscriber still", again, err)
+	}
+	if err := st.UnsubscribeStock(ctx, u.ID, p.ID, ""); err != nil {
+		t.Fatalf("UnsubscribeStock: %v", err)
+	}
+	if subs, err := st.ListRestockedSubscriptions(ctx, p.ID); err != nil || len(subs) != 0 {
+		t.Fatalf("ListRestockedSubscriptions after notifying = %v, %v; want none", subs, err)
+	}
+
+	// A variant is watched on its own
+	v, err := st.CreateVariant(ctx, inStock.ID, &models.ProductVariant{SKU: "SUB-B-S", Options: map[string]string{"size": "S"}})
+	if err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+	if _, err := st.SubscribeStock(ctx, u.ID, inStock.ID, "missing-variant"); err == nil {
+		t.Fatal("SubscribeStock to a missing variant succeeded")
+	}
+	if _, err := st.SubscribeStock(ctx, u.ID, inStock.ID, v.ID); err != nil {
+		t.Fatalf("SubscribeStock(variant): %v", err)
+	}
+	if _, _, err := st.AdjustStock(ctx, inStock.ID, v.ID, "", 2, models.StockRestock, ""); err != nil {
+		t.Fatalf("AdjustStock(variant): %v", err)
+	}
+	if subs, err := st.ListRestockedSubscriptions(ctx, inStock.ID); err != nil || len(subs) != 1 || subs[0].VariantID != v.ID {
+		t.Fatalf("ListRestockedSubscriptions(variant) = %v, %v", subs, err)
+	}
+	if _, err := st.ListRestockedSubscriptions(ctx, "missing-product"); err == nil {
+		t.Fatal("ListRestockedSubscriptions of a missing product succeeded")
+	}
+}
+
+func testLowStock(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	if err := st.SeedAdminUser(ctx, "admin@example.com", "hash"); err != nil {
+		t.Fatalf("SeedAdminUser: %v", err)
+	}
+	mustUser(t, st, "shopper@example.com")
+	admins, err := st.ListUsersByRole(ctx, "admin")
+	if err != nil || len(admins) != 1 || admins[0].Email != "admin@example.com" {
+		t.Fatalf("ListUsersByRole(admin) = %v, %v; want the seeded admin", admins, err)
+	}
+
+	ten := 10
+	own := mustProduct(t, st, "LOW-OWN", 10000, 8)
+	if own, err = st.UpdateProduct(ctx, own.ID, func(p *models.Product) error {
+		p.LowStockThreshold = &ten
+		return nil
+	}); err != nil || own.LowStockThreshold == nil || *own.LowStockThreshold != 10 {
+		t.Fatalf("UpdateProduct(threshold) = %+v, %v", own, err)
+	}
+	low := mustProduct(t, st, "LOW-DEFAULT", 10000, 2)
+	mustProduct(t, st, "LOW-PLENTY", 10000, 50)
+	archived := mustProduct(t, st, "LOW-ARCHIVED", 10000, 0)
+	if err := st.ArchiveProduct(ctx, archived.ID); err != nil {
+		t.Fatalf("ArchiveProduct: %v", err)
+	}
+
+	ids := func(ps []*models.Product) []string {
+		res := []string{}
+		for _, p := range ps {
+			res = append(res, p.ID)
+		}
+		return res
+	}
+	ps, err := st.ListLowStockProducts(ctx, 5)
+	if err != nil || !reflect.DeepEqual(ids(ps), []string{low.ID, own.ID}) {
+		t.Fatalf("ListLowStockProducts(5) = %v, %v; want the default-threshold product, then its own", ids(ps), err)
+	}
+	if got, err := st.GetProduct(ctx, own.ID); err != nil || got.LowStockThreshold == nil || *got.LowStockThreshold != 10 {
+		t.Fatalf("GetProduct threshold = %+v, %v; want 10", got, err)
+	}
+
+	// A default of 0 leaves only products with their own threshold
+	if ps, err = st.ListLowStockProducts(ctx, 0); err != nil || !reflect.DeepEqual(ids(ps), []string{own.ID}) {
+		t.Fatalf("ListLowStockProducts(0) = %v, %v; want only the own-threshold product", ids(ps), err)
+	}
+	if _, err := st.UpdateProduct(ctx, own.ID, func(p *models.Product) error {
+		p.LowStockThreshold = nil
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct(clear threshold): %v", err)
+	}
+	if ps, err = st.ListLowStockProducts(ctx, 5); err != nil || !reflect.DeepEqual(ids(ps), []string{low.ID}) {
+		t.Fatalf("ListLowStockProducts after clearing = %v, %v; want only the default-threshold product", ids(ps), err)
+	}
+}
+
+func testCheckoutCart(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "checkout@example.com")
+	a := mustProduct(t, st, "CO-A", 10000, 5)
+	b := mustProduct(t, st, "CO-B", 2500, 3)
+
+	if _, _, err := st.CheckoutCart(ctx, u.ID); !errors.Is(err, store.ErrCartEmpty) {
+		t.Fatalf("CheckoutCart on empty cart = %v, want ErrCartEmpty", err)
+	}
+
+	for _, it := range []models.CartItem{{ProductID: a.ID, Quantity: 2}, {ProductID: b.ID, Quantity: 3}} {
+		if err := st.AddToCart(ctx, u.ID, it.ProductID, "", it.Quantity); err != nil {
+			t.Fatalf("AddToCart: %v", err)
+		}
+	}
+
+	o, products, err := st.CheckoutCart(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("CheckoutCart: %v", err)
+	}
+	if o.ID == "" || o.Status != "pending" || o.Reservation != models.ReservationHeld || o.PaymentRef != "" || o.Amount != 27500 || len(o.Items) != 2 {
+		t.Fatalf("CheckoutCart = %+v, want a pending order of two lines totalling 27500", o)
+	}
+	if products[a.ID] == nil || products[b.ID] == nil || products[a.ID].Name != a.Name {
+		t.Fatalf("CheckoutCart products = %+v, want both cart products", products)
+	}
+	if products[a.ID].Stock != 3 || products[b.ID].Stock != 0 {
+		t.Fatalf("CheckoutCart products have stock %d and %d, want what the order left, 3 and 0", products[a.ID].Stock, products[b.ID].Stock)
+	}
+	// The reference is stored once the order has been charged
+	if err := st.UpdateOrderPaymentRef(ctx, o.ID, "PAY-"+o.ID); err != nil {
+		t.Fatalf("UpdateOrderPaymentRef: %v", err)
+	}
+	o.PaymentRef = "PAY-" + o.ID
+
+	if got := stockOf(t, st, a.ID); got != 3 {
+		t.Fatalf("stock of A = %d, want 3", got)
+	}
+	if got := stockOf(t, st, b.ID); got != 0 {
+		t.Fatalf("stock of B = %d, want 0", got)
+	}
+	if c, _ := st.GetCart(ctx, u.ID); len(c.Items) != 0 {
+		t.Fatalf("cart has %d lines after checkout", len(c.Items))
+	}
+
+	// Later price changes must not touch the recorded order
+	if _, err := st.UpdateProduct(ctx, a.ID, func(p *models.Product) error {
+		p.PriceCents = 99999
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+
+	orders, _, err := st.ListOrdersByUser(ctx, u.ID, store.Page{})
+	if err != nil {
+		t.Fatalf("ListOrdersByUser: %v", err)
+	}
+	if len(orders) != 1 || orders[0].PaymentRef != o.PaymentRef || orders[0].Amount != 27500 {
+		t.Fatalf("ListOrdersByUser = %+v, want the checked out order", orders)
+	}
+	prices := map[string]int64{}
+	for _, it := range orders[0].Items {
+		prices[it.ProductID] = it.PriceCents
+	}
+	if prices[a.ID] != 10000 || prices[b.ID] != 2500 {
+		t.Fatalf("order line prices = %v, want the prices at checkout", prices)
+	}
+}
+
+// testCheckoutCartFailedPayment checks what the checkout handler does when
+// the gateway declines: marking the order failed puts its units back
+func testCheckoutCartFailedPayment(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "declined@example.com")
+	p := mustProduct(t, st, "CO-DECL", 5000, 4)
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 2); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+
+	o, _, err := st.CheckoutCart(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("CheckoutCart: %v", err)
+	}
+	if got := stockOf(t, st, p.ID); got != 2 {
+		t.Fatalf("stock = %d while the order is unpaid, want 2", got)
+	}
+	if err := st.UpdateOrderStatus(ctx, o.ID, "failed"); err != nil {
+		t.Fatalf("UpdateOrderStatus(failed): %v", err)
+	}
+	if got := stockOf(t, st, p.ID); got != 4 {
+		t.Fatalf("stock = %d after the declined payment, want 4", got)
+	}
+	if got, err := st.GetOrder(ctx, o.ID); err != nil || got.Reservation != models.ReservationReleased {
+		t.Fatalf("declined order = %+v, %v; want its reservation released", got, err)
+	}
+
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 2); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+
+	// Stock sold elsewhere after the item went into the cart
+	if _, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+		p.Stock = 1
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	_, _, err = st.CheckoutCart(ctx, u.ID)
+	if !errors.Is(err, store.ErrInsufficientStock) {
+		t.Fatalf("CheckoutCart over stock = %v, want ErrInsufficientStock", err)
+	}
+	if got := cartQty(t, st, u.ID, p.ID); got != 2 {
+		t.Fatalf("cart quantity = %d after a rejected checkout, want 2", got)
+	}
+	if orders, _, _ := st.ListOrdersByUser(ctx, u.ID, store.Page{}); len(orders) != 1 {
+		t.Fatalf("rejected checkout left %d orders, want only the declined one", len(orders))
+	}
+}
+
+// testCheckoutCartNoOversell races more buyers than there is stock and
+// checks that exactly the available units are sold
+func testCheckoutCartNoOversell(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	const stock, buyers = 5, 12
+	p := mustProduct(t, st, "CO-RACE", 1000, stock)
+
+	users := make([]*models.User, buyers)
+	for i := range users {
+		users[i] = mustUser(t, st, fmt.Sprintf("buyer-%d@example.com", i))
+		if err := st.AddToCart(ctx, users[i].ID, p.ID, "", 1); err != nil {
+			t.Fatalf("AddToCart: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, buyers)
+	for _, u := range users {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			_, _, err := st.CheckoutCart(ctx, userID)
+			errs <- err
+		}(u.ID)
+	}
+	wg.Wait()
+	close(errs)
+
+	sold := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			sold++
+		case !errors.Is(err, store.ErrInsufficientStock):
+			t.Errorf("CheckoutCart: %v", err)
+		}
+	}
+	if sold != stock {
+		t.Fatalf("%d checkouts succeeded, want exactly %d", sold, stock)
+	}
+	if got := stockOf(t, st, p.ID); got != 0 {
+		t.Fatalf("stock after race = %d, want 0", got)
+	}
+	orders, _, err := st.ListOrders(ctx, store.Page{})
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if len(orders) != stock {
+		t.Fatalf("%d orders recorded, want %d", len(orders), stock)
+	}
+}
+
+// testNegativeStockRejected checks that edits cannot push stock below zero,
+// which would also post a negative movement and location level
+func testNegativeStockRejected(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	plain := mustProduct(t, st, "NEG-PLAIN", 10000, 3)
+	_, err := st.UpdateProduct(ctx, plain.ID, func(p *models.Product) error {
+		p.Stock = -2
+		return nil
+	})
+	if !errors.Is(err, store.ErrInsufficientStock) {
+		t.Fatalf("UpdateProduct to negative stock = %v, want ErrInsufficientStock", err)
+	}
+	if got := stockOf(t, st, plain.ID); got != 3 {
+		t.Fatalf("stock = %d after the rejected product update, want 3", got)
+	}
+	if ms, _, _ := st.ListStockMovements(ctx, plain.ID, store.Page{}); len(ms) != 1 || ms[0].Delta != 3 {
+		t.Fatalf("movements after the rejected product update = %+v, want only the initial stock", ms)
+	}
+	levels, err := st.ListStockLevels(ctx, plain.ID)
+	if err != nil {
+		t.Fatalf("ListStockLevels: %v", err)
+	}
+	for _, l := range levels {
+		if l.Stock < 0 {
+			t.Fatalf("rejected update left level %+v", l)
+		}
+	}
+
+	p := mustProduct(t, st, "NEG-TEE", 10000, 0)
+	if _, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+		p.Options = []models.ProductOption{{Name: "size", Values: []string{"S"}}}
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	v, err := st.CreateVariant(ctx, p.ID, &models.ProductVariant{SKU: "NEG-TEE-S", Options: map[string]string{"size": "S"}, Stock: 2})
+	if err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+
+	_, _, err = st.UpdateVariant(ctx, p.ID, v.ID, func(v *models.ProductVariant) error {
+		v.Stock = -1
+		return nil
+	})
+	if !errors.Is(err, store.ErrInsufficientStock) {
+		t.Fatalf("UpdateVariant to negative stock = %v, want ErrInsufficientStock", err)
+	}
+	if got := stockOf(t, st, p.ID); got != 2 {
+		t.Fatalf("stock = %d after the rejected variant update, want 2", got)
+	}
+	ms, _, err := st.ListStockMovements(ctx, p.ID, store.Page{})
+	if err != nil {
+		t.Fatalf("ListStockMovements: %v", err)
+	}
+	for _, m := range ms {
+		if m.Delta < 0 {
+			t.Fatalf("rejected update left movement %+v", m)
+		}
+	}
+}
+
+func testVariants(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "variants@example.com")
+	p := mustProduct(t, st, "VAR-TEE", 10000, 0)
+	if _, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+		p.Options = []models.ProductOption{{Name: "size", Values: []string{"S", "M"}}}
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+
+	override := int64(12500)
+	small, err := st.CreateVariant(ctx, p.ID, &models.ProductVariant{SKU: "VAR-TEE-S", Options: map[string]string{"size": "S"}, Stock: 2})
+	if err != nil {
+		t.Fatalf("CreateVariant(S): %v", err)
+	}
+	medium, err := st.CreateVariant(ctx, p.ID, &models.ProductVariant{SKU: "VAR-TEE-M", Options: map[string]string{"size": "M"}, PriceCents: &override, Stock: 3})
+	if err != nil {
+		t.Fatalf("CreateVariant(M): %v", err)
+	}
+
+	got, err := st.GetProduct(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if got.Stock != 5 || len(got.Variants) != 2 || len(got.Options) != 1 || got.Options[0].Name != "size" {
+		t.Fatalf("product = %+v, want stock 5 over two variants", got)
+	}
+	if m := got.Variant(medium.ID); m == nil || m.PriceCents == nil || *m.PriceCents != override || m.Options["size"] != "M" {
+		t.Fatalf("variant M = %+v, want the price override", m)
+	}
+	if s := got.Variant(small.ID); s == nil || s.PriceCents != nil {
+		t.Fatalf("variant S = %+v, want no price override", s)
+	}
+
+	// Stock is checked per variant, not against the product total
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 1); !errors.Is(err, store.ErrVariantRequired) {
+		t.Fatalf("AddToCart without variant = %v, want ErrVariantRequired", err)
+	}
+	if err := st.AddToCart(ctx, u.ID, p.ID, "00000000-0000-0000-0000-000000000000", 1); err == nil {
+		t.Fatal("AddToCart with unknown variant succeeded")
+	}
+	if err := st.AddToCart(ctx, u.ID, p.ID, small.ID, 3); err == nil {
+		t.Fatal("AddToCart beyond the variant's stock succeeded")
+	}
+	if err := st.AddToCart(ctx, u.ID, p.ID, small.ID, 2); err != nil {
+		t.Fatalf("AddToCart(S): %v", err)
+	}
+	if err := st.AddToCart(ctx, u.ID, p.ID, medium.ID, 1); err != nil {
+		t.Fatalf("AddToCart(M): %v", err)
+	}
+	c, err := st.GetCart(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetCart: %v", err)
+	}
+	if len(c.Items) != 2 {
+		t.Fatalf("cart = %+v, want one line per variant", c.Items)
+	}
+	if err := st.RemoveFromCart(ctx, u.ID, p.ID, medium.ID, 1); err != nil {
+		t.Fatalf("RemoveFromCart(M): %v", err)
+	}
+	if err := st.AddToCart(ctx, u.ID, p.ID, medium.ID, 1); err != nil {
+		t.Fatalf("AddToCart(M): %v", err)
+	}
+
+	o, _, err := st.CheckoutCart(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("CheckoutCart: %v", err)
+	}
+	if o.Amount != 2*10000+override {
+		t.Fatalf("order amount = %d, want %d", o.Amount, 2*10000+override)
+	}
+	stored, err := st.GetOrder(ctx, o.ID)
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	lines := map[string]models.CartItem{}
+	for _, it := range stored.Items {
+		lines[it.VariantID] = it
+	}
+	if lines[small.ID].PriceCents != 10000 || lines[medium.ID].PriceCents != override || lines[medium.ID].Quantity != 1 {
+		t.Fatalf("order lines = %+v, want variant prices", stored.Items)
+	}
+
+	got, err = st.GetProduct(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if got.Stock != 2 || got.Variant(small.ID).Stock != 0 || got.Variant(medium.ID).Stock != 2 {
+		t.Fatalf("product after checkout = %+v, want S 0, M 2, total 2", got)
+	}
+
+	// CreateOrder works at variant level too and rolls back as a whole
+	items := []models.CartItem{{ProductID: p.ID, VariantID: medium.ID, Quantity: 1}, {ProductID: p.ID, VariantID: small.ID, Quantity: 1}}
+	if _, err := st.CreateOrder(ctx, u.ID, items, 22500, "pending", ""); !errors.Is(err, store.ErrInsufficientStock) {
+		t.Fatalf("CreateOrder of a sold out variant = %v, want ErrInsufficientStock", err)
+	}
+	if got := stockOf(t, st, p.ID); got != 2 {
+		t.Fatalf("stock = %d after failed order, want 2", got)
+	}
+	o2, err := st.CreateOrder(ctx, u.ID, items[:1], override, "pending", "")
+	if err != nil {
+		t.Fatalf("CreateOrder(M): %v", err)
+	}
+	if o2.Items[0].PriceCents != override || o2.Items[0].VariantID != medium.ID {
+		t.Fatalf("CreateOrder line = %+v, want the variant price", o2.Items[0])
+	}
+
+	// Editing a variant's stock moves the product total with it; setting
+	// the product stock directly is overridden by the variants
+	_, got, err = st.UpdateVariant(ctx, p.ID, small.ID, func(v *models.ProductVariant) error {
+		v.Stock = 4
+		v.PriceCents = &override
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateVariant: %v", err)
+	}
+	if got.Stock != 5 || got.Variant(small.ID).Stock != 4 {
+		t.Fatalf("UpdateVariant product = %+v, want stock 5", got)
+	}
+	if _, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+		p.Stock = 100
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	got, err = st.GetProduct(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if got.Stock != 5 || *got.Variant(small.ID).PriceCents != override {
+		t.Fatalf("product after variant update = %+v, want stock 5", got)
+	}
+
+	if err := st.DeleteVariant(ctx, p.ID, medium.ID); !errors.Is(err, store.ErrVariantInUse) {
+		t.Fatalf("DeleteVariant of an ordered variant = %v, want ErrVariantInUse", err)
+	}
+	fresh, err := st.CreateVariant(ctx, p.ID, &models.ProductVariant{SKU: "VAR-TEE-X", Options: map[string]string{"size": "S"}, Stock: 1})
+	if err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+	if err := st.AddToCart(ctx, u.ID, p.ID, fresh.ID, 1); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+	if err := st.DeleteVariant(ctx, p.ID, fresh.ID); err != nil {
+		t.Fatalf("DeleteVariant: %v", err)
+	}
+	if c, _ := st.GetCart(ctx, u.ID); len(c.Items) != 0 {
+		t.Fatalf("cart = %+v after its variant was deleted", c.Items)
+	}
+	if got := stockOf(t, st, p.ID); got != 5 {
+		t.Fatalf("stock = %d after deleting a variant, want 5", got)
+	}
+	if err := st.DeleteVariant(ctx, p.ID, fresh.ID); err == nil {
+		t.Fatal("DeleteVariant of a deleted variant succeeded")
+	}
+}
+
+func testCategories(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	apparel, err := st.CreateCategory(ctx, &models.Category{Name: "Apparel", Slug: "apparel", SortOrder: 2})
+	if err != nil {
+		t.Fatalf("CreateCategory(apparel): %v", err)
+	}
+	shirts, err := st.CreateCategory(ctx, &models.Category{Name: "Shirts", Slug: "shirts", ParentID: apparel.ID})
+	if err != nil {
+		t.Fatalf("CreateCategory(shirts): %v", err)
+	}
+	books, err := st.CreateCategory(ctx, &models.Category{Name: "Books", Slug: "books", SortOrder: 1, Image: "https://example.com/books.png"})
+	if err != nil {
+		t.Fatalf("CreateCategory(books): %v", err)
+	}
+	if _, err := st.CreateCategory(ctx, &models.Category{Name: "Apparel again", Slug: "apparel"}); !errors.Is(err, store.ErrSlugTaken) {
+		t.Fatalf("CreateCategory with a taken slug = %v, want ErrSlugTaken", err)
+	}
+	if _, err := st.CreateCategory(ctx, &models.Category{Name: "Orphan", Slug: "orphan", ParentID: "00000000-0000-0000-0000-000000000000"}); err == nil {
+		t.Fatal("CreateCategory under a missing parent succeeded")
+	}
+
+	cats, err := st.ListCategories(ctx)
+	if err != nil {
+		t.Fatalf("ListCategories: %v", err)
+	}
+	if len(cats) != 3 || cats[0].ID != shirts.ID || cats[1].ID != books.ID || cats[2].ID != apparel.ID {
+		t.Fatalf("ListCategories = %+v, want shirts, books, apparel by sort order", cats)
+	}
+	if cats[0].ParentID != apparel.ID || cats[1].Image != books.Image {
+		t.Fatalf("ListCategories = %+v, want parent and image kept", cats)
+	}
+
+	// A category cannot move under itself or its own subcategory
+	for _, parent := range []string{apparel.ID, shirts.ID} {
+		_, err := st.UpdateCategory(ctx, apparel.ID, func(c *models.Category) error {
+			c.ParentID = parent
+			return nil
+		})
+		if !errors.Is(err, store.ErrCategoryCycle) {
+			t.Fatalf("moving apparel under %s = %v, want ErrCategoryCycle", parent, err)
+		}
+	}
+	if _, err := st.UpdateCategory(ctx, books.ID, func(c *models.Category) error {
+		c.Slug = "shirts"
+		return nil
+	}); !errors.Is(err, store.ErrSlugTaken) {
+		t.Fatalf("UpdateCategory to a taken slug = %v, want ErrSlugTaken", err)
+	}
+
+	tee := mustProduct(t, st, "CAT-TEE", 5000, 1)
+	novel := mustProduct(t, st, "CAT-NOVEL", 7000, 1)
+	mustProduct(t, st, "CAT-NONE", 1000, 1)
+	for id, cat := range map[string]*models.Category{tee.ID: shirts, novel.ID: books} {
+		if _, err := st.UpdateProduct(ctx, id, func(p *models.Product) error {
+			p.CategoryID, p.Category = cat.ID, cat.Name
+			return nil
+		}); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+	}
+
+	ps, _, err := st.ListProducts(ctx, store.ProductFilter{CategoryIDs: []string{apparel.ID, shirts.ID}}, store.Page{})
+	if err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	if len(ps) != 1 || ps[0].ID != tee.ID || ps[0].CategoryID != shirts.ID {
+		t.Fatalf("ListProducts(apparel tree) = %+v, want only the shirt", ps)
+	}
+
+	// Renaming a category renames it on its products
+	if _, err := st.UpdateCategory(ctx, shirts.ID, func(c *models.Category) error {
+		c.Name = "T-Shirts"
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateCategory(rename): %v", err)
+	}
+	if got, err := st.GetProduct(ctx, tee.ID); err != nil || got.Category != "T-Shirts" {
+		t.Fatalf("GetProduct after rename = %+v, %v; want category T-Shirts", got, err)
+	}
+	if got, err := st.GetProduct(ctx, novel.ID); err != nil || got.Category != "Books" {
+		t.Fatalf("GetProduct(novel) = %+v, %v; want category untouched", got, err)
+	}
+
+	if err := st.DeleteCategory(ctx, apparel.ID); !errors.Is(err, store.ErrCategoryInUse) {
+		t.Fatalf("DeleteCategory with a subcategory = %v, want ErrCategoryInUse", err)
+	}
+	if err := st.DeleteCategory(ctx, shirts.ID); !errors.Is(err, store.ErrCategoryInUse) {
+		t.Fatalf("DeleteCategory with a product = %v, want ErrCategoryInUse", err)
+	}
+	if _, err := st.UpdateProduct(ctx, tee.ID, func(p *models.Product) error {
+		p.CategoryID = ""
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct(uncategorize): %v", err)
+	}
+	if err := st.DeleteCategory(ctx, shirts.ID); err != nil {
+		t.Fatalf("DeleteCategory(shirts): %v", err)
+	}
+	if err := st.DeleteCategory(ctx, apparel.ID); err != nil {
+		t.Fatalf("DeleteCategory(apparel): %v", err)
+	}
+	if err := st.DeleteCategory(ctx, apparel.ID); err == nil {
+		t.Fatal("DeleteCategory of a deleted category succeeded")
+	}
+	if cats, err := st.ListCategories(ctx); err != nil || len(cats) != 1 || cats[0].ID != books.ID {
+		t.Fatalf("ListCategories after delete = %+v, %v; want only books", cats, err)
+	}
+}
+
+func testReviewCounts(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	a := mustUser(t, st, "reviewer-a@example.com")
+	b := mustUser(t, st, "reviewer-b@example.com")
+
+	if _, err := st.CreateReview(ctx, a.ID, a.Email, "", 6, "too good"); err == nil {
+		t.Fatal("CreateReview with rating 6 succeeded")
+	}
+	if _, err := st.CreateReview(ctx, a.ID, a.Email, "", 0, "too bad"); err == nil {
+		t.Fatal("CreateReview with rating 0 succeeded")
+	}
+
+	for i, u := range []*models.User{a, a, b} {
+		if _, err := st.CreateReview(ctx, u.ID, u.Email, "", 4+i%2, "nice"); err != nil {
+			t.Fatalf("CreateReview: %v", err)
+		}
+	}
+
+	if n, err := st.GetUserReviewCount(ctx, a.ID); err != nil || n != 2 {
+		t.Fatalf("GetUserReviewCount(a) = %d, %v; want 2", n, err)
+	}
+	if n, err := st.GetUserReviewCount(ctx, b.ID); err != nil || n != 1 {
+		t.Fatalf("GetUserReviewCount(b) = %d, %v; want 1", n, err)
+	}
+
+	all, _, err := st.ListReviews(ctx, store.Page{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("ListReviews(0) = %d reviews, %v; want 3", len(all), err)
+	}
+	limited, _, err := st.ListReviews(ctx, store.Page{Limit: 2})
+	if err != nil || len(limited) != 2 {
+		t.Fatalf("ListReviews(2) = %d reviews, %v; want 2", len(limited), err)
+	}
+}
+
+func testAuditLog(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	entries := []*models.AuditEntry{
+		{ActorID: "admin-a", Action: "product.create", EntityType: "product", EntityID: "p1", After: []byte(`{"name":"Kopi"}`), IP: "10.0.0.1", CreatedAt: base},
+		{ActorID: "admin-a", Action: "product.update", EntityType: "product", EntityID: "p1", Before: []byte(`{"stock":1}`), After: []byte(`{"stock":5}`), IP: "10.0.0.1", CreatedAt: base.Add(time.Minute)},
+		{ActorID: "admin-b", Action: "order.status", EntityType: "order", EntityID: "o1", Before: []byte(`{"status":"pending"}`), After: []byte(`{"status":"paid"}`), IP: "10.0.0.2", CreatedAt: base.Add(2 * time.Minute)},
+	}
+	for _, e := range entries {
+		if err := st.CreateAuditEntry(ctx, e); err != nil {
+			t.Fatalf("CreateAuditEntry(%s): %v", e.Action, err)
+		}
+		if e.ID == "" {
+			t.Fatalf("CreateAuditEntry(%s) left ID empty", e.Action)
+		}
+	}
+
+	actions := func(es []*models.AuditEntry) []string {
+		out := []string{}
+		for _, e := range es {
+			out = append(out, e.Action)
+		}
+		return out
+	}
+	cases := []struct {
+		name   string
+		filter store.AuditFilter
+		want   []string
+	}{
+		{"all newest first", store.AuditFilter{}, []string{"order.status", "product.update", "product.create"}},
+		{"by actor", store.AuditFilter{ActorID: "admin-a"}, []string{"product.update", "product.create"}},
+		{"by entity", store.AuditFilter{EntityType: "product", EntityID: "p1"}, []string{"product.update", "product.create"}},
+		{"by entity type", store.AuditFilter{EntityType: "order"}, []string{"order.status"}},
+		{"from inclusive", store.AuditFilter{From: base.Add(time.Minute)}, []string{"order.status", "product.update"}},
+		{"to exclusive", store.AuditFilter{To: base.Add(2 * time.Minute)}, []string{"product.update", "product.create"}},
+		{"limit", store.AuditFilter{Limit: 1}, []string{"order.status"}},
+	}
+	for _, tc := range cases {
+		got, err := st.ListAuditEntries(ctx, tc.filter)
+		if err != nil {
+			t.Fatalf("ListAuditEntries(%s): %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(actions(got), tc.want) {
+			t.Fatalf("ListAuditEntries(%s) = %v, want %v", tc.name, actions(got), tc.want)
+		}
+	}
+
+	got, err := st.ListAuditEntries(ctx, store.AuditFilter{EntityType: "order"})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("ListAuditEntries(order) = %v, %v", got, err)
+	}
+	e := got[0]
+	if e.ActorID != "admin-b" || e.IP != "10.0.0.2" || !sameSecond(e.CreatedAt, base.Add(2*time.Minute)) {
+		t.Fatalf("audit entry = %+v", e)
+	}
+	var before, after map[string]string
+	if err := json.Unmarshal(e.Before, &before); err != nil || before["status"] != "pending" {
+		t.Fatalf("audit before = %s, %v", e.Before, err)
+	}
+	if err := json.Unmarshal(e.After, &after); err != nil || after["status"] != "paid" {
+		t.Fatalf("audit after = %s, %v", e.After, err)
+	}
+
+	created, err := st.ListAuditEntries(ctx, store.AuditFilter{EntityID: "p1", To: base.Add(time.Second)})
+	if err != nil || len(created) != 1 || created[0].Before != nil {
+		t.Fatalf("create entry = %+v, %v; want no before data", created, err)
+	}
+}
+
+func testPagination(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "pages@example.com")
+	other := mustUser(t, st, "pages-other@example.com")
+
+	products := []string{}
+	for i := 0; i < 5; i++ {
+		products = append(products, mustProduct(t, st, fmt.Sprintf("PAGE-%d", i), 1000, 100).ID)
+	}
+	for i := 0; i < 5; i++ {
+		owner := u
+		if i%2 == 1 {
+			owner = other
+		}
+		if _, err := st.CreateOrder(ctx, owner.ID, []models.CartItem{{ProductID: products[i], Quantity: 1}}, 1000, "paid", ""); err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		if _, err := st.CreateReview(ctx, owner.ID, owner.Email, "", 5, "ok"); err != nil {
+			t.Fatalf("CreateReview: %v", err)
+		}
+	}
+
+	type lister func(page store.Page) (ids []string, times []time.Time, next string, err error)
+	lists := map[string]lister{
+		"products": func(page store.Page) ([]string, []time.Time, string, error) {
+			ps, next, err := st.ListProducts(ctx, store.ProductFilter{}, page)
+			ids, times := []string{}, []time.Time{}
+			for _, p := range ps {
+				ids, times = append(ids, p.ID), append(times, p.CreatedAt)
+			}
+			return ids, times, next, err
+		},
+		"orders": func(page store.Page) ([]string, []time.Time, string, error) {
+			orders, next, err := st.ListOrders(ctx, page)
+			ids, times := []string{}, []time.Time{}
+			for _, o := range orders {
+				if len(o.Items) != 1 {
+					return nil, nil, "", fmt.Errorf("order %s has %d items, want 1", o.ID, len(o.Items))
+				}
+				ids, times = append(ids, o.ID), append(times, o.CreatedAt)
+			}
+			return ids, times, next, err
+		},
+		"orders by user": func(page store.Page) ([]string, []time.Time, string, error) {
+			orders, next, err := st.ListOrdersByUser(ctx, u.ID, page)
+			ids, times := []string{}, []time.Time{}
+			for _, o := range orders {
+				ids, times = append(ids, o.ID), append(times, o.CreatedAt)
+			}
+			return ids, times, next, err
+		},
+		"reviews": func(page store.Page) ([]string, []time.Time, string, error) {
+			rs, next, err := st.ListReviews(ctx, page)
+			ids, times := []string{}, []time.Time{}
+			for _, r := range rs {
+				ids, times = append(ids, r.ID), append(times, r.CreatedAt)
+			}
+			return ids, times, next, err
+		},
+	}
+
+	for name, list := range lists {
+		all, allTimes, next, err := list(store.Page{})
+		if err != nil || next != "" {
+			t.Fatalf("%s: unpaged list = next %q, %v", name, next, err)
+		}
+		for i := 1; i < len(all); i++ {
+			if allTimes[i].After(allTimes[i-1]) || allTimes[i].Equal(allTimes[i-1]) && all[i] > all[i-1] {
+				t.Fatalf("%s: not ordered newest first by (created_at, id): %v %v", name, all, allTimes)
+			}
+		}
+
+		walked := []string{}
+		page := store.Page{Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > len(all) {
+				t.Fatalf("%s: pagination does not terminate", name)
+			}
+			ids, _, next, err := list(page)
+			if err != nil {
+				t.Fatalf("%s: page %d: %v", name, pages, err)
+			}
+			if len(ids) > 2 {
+				t.Fatalf("%s: page %d has %d rows, limit 2", name, pages, len(ids))
+			}
+			walked = append(walked, ids...)
+			if next == "" {
+				break
+			}
+			page.Cursor = next
+		}
+		if !reflect.DeepEqual(walked, all) {
+			t.Fatalf("%s: paged walk = %v, want %v", name, walked, all)
+		}
+
+		if _, _, _, err := list(store.Page{Cursor: "not-a-cursor", Limit: 2}); !errors.Is(err, store.ErrInvalidCursor) {
+			t.Fatalf("%s: bogus cursor = %v, want ErrInvalidCursor", name, err)
+		}
+	}
+
+	// Rows added while a client is paging land before its cursor, so later
+	// pages neither repeat nor skip anything.
+	first, next, err := st.ListProducts(ctx, store.ProductFilter{}, store.Page{Limit: 2})
+	if err != nil || next == "" {
+		t.Fatalf("ListProducts first page: next %q, %v", next, err)
+	}
+	mustProduct(t, st, "PAGE-LATE", 1000, 1)
+	rest, _, err := st.ListProducts(ctx, store.ProductFilter{}, store.Page{Cursor: next})
+	if err != nil {
+		t.Fatalf("ListProducts rest: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, p := range append(first, rest...) {
+		if seen[p.ID] || p.SKU == "PAGE-LATE" {
+			t.Fatalf("product %s (%s) listed twice or after the cursor", p.ID, p.SKU)
+		}
+		seen[p.ID] = true
+	}
+	if len(seen) != len(products) {
+		t.Fatalf("paged listing saw %d products, want %d", len(seen), len(products))
+	}
+}
+
+func testProductSearch(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	u := mustUser(t, st, "search@example.com")
+	x, err := st.CreateCategory(ctx, &models.Category{Name: "X", Slug: "x"})
+	if err != nil {
+		t.Fatalf("CreateCategory(x): %v", err)
+	}
+	y, err := st.CreateCategory(ctx, &models.Category{Name: "Y", Slug: "y"})
+	if err != nil {
+		t.Fatalf("CreateCategory(y): %v", err)
+	}
+
+	// sku: price, stock, rating, category, units sold
+	specs := []struct {
+		sku      string
+		price    int64
+		stock    int
+		rating   float64
+		category *models.Category
+		sold     int
+	}{
+		{"SRCH-A", 1000, 5, 4.5, x, 3},
+		{"SRCH-B", 3000, 0, 3, x, 1},
+		{"SRCH-C", 2000, 2, 4.8, y, 5},
+		{"SRCH-D", 9000, 1, 4, nil, 0},
+	}
+	ids := map[string]string{}
+	for _, spec := range specs {
+		p := mustProduct(t, st, spec.sku, spec.price, 100)
+		ids[p.ID] = spec.sku
+		status := "paid"
+		if spec.sold == 0 {
+			// Pending orders are not sales
+			status, spec.sold = "pending", 10
+		}
+		if _, err := st.CreateOrder(ctx, u.ID, []models.CartItem{{ProductID: p.ID, Quantity: spec.sold}}, spec.price, status, ""); err != nil {
+			t.Fatalf("CreateOrder(%s): %v", spec.sku, err)
+		}
+		if _, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+			p.Stock, p.Rating = spec.stock, spec.rating
+			if spec.category != nil {
+				p.CategoryID, p.Category = spec.category.ID, spec.category.Name
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("UpdateProduct(%s): %v", spec.sku, err)
+		}
+	}
+	// Archived products are not listed
+	archived := mustProduct(t, st, "SRCH-E", 1500, 1)
+	if err := st.ArchiveProduct(ctx, archived.ID); err != nil {
+		t.Fatalf("ArchiveProduct: %v", err)
+	}
+
+	list := func(filter store.ProductFilter, page store.Page) ([]string, string) {
+		t.Helper()
+		ps, next, err := st.ListProducts(ctx, filter, page)
+		if err != nil {
+			t.Fatalf("ListProducts(%+v): %v", filter, err)
+		}
+		skus := []string{}
+		for _, p := range ps {
+			if sku, ok := ids[p.ID]; ok {
+				skus = append(skus, sku)
+			}
+		}
+		return skus, next
+	}
+
+	sorts := map[store.ProductSort][]string{
+		store.SortPriceAsc:    {"SRCH-A", "SRCH-C", "SRCH-B", "SRCH-D"},
+		store.SortPriceDesc:   {"SRCH-D", "SRCH-B", "SRCH-C", "SRCH-A"},
+		store.SortRating:      {"SRCH-C", "SRCH-A", "SRCH-D", "SRCH-B"},
+		store.SortBestSelling: {"SRCH-C", "SRCH-A", "SRCH-B", "SRCH-D"},
+	}
+	for sort, want := range sorts {
+		if got, next := list(store.ProductFilter{Sort: sort}, store.Page{}); !slices.Equal(got, want) || next != "" {
+			t.Fatalf("sort %q = %v (next %q), want %v", sort, got, next, want)
+		}
+
+		// The same order one product per page
+		walked := []string{}
+		page := store.Page{Limit: 1}
+		for pages := 0; ; pages++ {
+			if pages > len(want) {
+				t.Fatalf("sort %q: pagination does not terminate", sort)
+			}
+			got, next := list(store.ProductFilter{Sort: sort}, page)
+			walked = append(walked, got...)
+			if next == "" {
+				break
+			}
+			page.Cursor = next
+		}
+		if !slices.Equal(walked, want) {
+			t.Fatalf("sort %q paged = %v, want %v", sort, walked, want)
+		}
+	}
+	if _, _, err := st.ListProducts(ctx, store.ProductFilter{Sort: store.SortPriceAsc}, store.Page{Cursor: "bm90LWEtY3Vyc29y"}); !errors.Is(err, store.ErrInvalidCursor) {
+		t.Fatalf("sorted list with a bad cursor = %v, want ErrInvalidCursor", err)
+	}
+	_, newest, err := st.ListProducts(ctx, store.ProductFilter{}, store.Page{Limit: 1})
+	if err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	if _, _, err := st.ListProducts(ctx, store.ProductFilter{Sort: store.SortPriceAsc}, store.Page{Cursor: newest}); !errors.Is(err, store.ErrInvalidCursor) {
+		t.Fatalf("sorted list with a newest-first cursor = %v, want ErrInvalidCursor", err)
+	}
+
+	filters := []struct {
+		filter store.ProductFilter
+		want   []string
+	}{
+		{store.ProductFilter{MinPriceCents: 1500, MaxPriceCents: 3000}, []string{"SRCH-C", "SRCH-B"}},
+		{store.ProductFilter{InStock: true}, []string{"SRCH-A", "SRCH-C", "SRCH-D"}},
+		{store.ProductFilter{MinRating: 4.5}, []string{"SRCH-A", "SRCH-C"}},
+		{store.ProductFilter{CategoryIDs: []string{x.ID}, InStock: true}, []string{"SRCH-A"}},
+		{store.ProductFilter{CategoryIDs: []string{"no-such-category"}}, []string{}},
+	}
+	for _, tc := range filters {
+		tc.filter.Sort = store.SortPriceAsc
+		if got, _ := list(tc.filter, store.Page{}); !slices.Equal(got, tc.want) {
+			t.Fatalf("ListProducts(%+v) = %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+
+	// Each facet ignores its own part of the filter
+	bounds := []int64{0, 1500, 5000}
+	facets, err := st.ProductFacets(ctx, store.ProductFilter{CategoryIDs: []string{x.ID}, InStock: true}, bounds)
+	if err != nil {
+		t.Fatalf("ProductFacets: %v", err)
+	}
+	if facets.Categories[x.ID] != 1 || facets.Categories[y.ID] != 1 || facets.Categories[""] != 1 {
+		t.Fatalf("category facets = %v, want one each in x, y and none", facets.Categories)
+	}
+	if !slices.Equal(facets.PriceBuckets, []int{1, 0, 0}) {
+		t.Fatalf("price facets = %v, want [1 0 0]", facets.PriceBuckets)
+	}
+	facets, err = st.ProductFacets(ctx, store.ProductFilter{MinPriceCents: 1500}, bounds)
+	if err != nil {
+		t.Fatalf("ProductFacets: %v", err)
+	}
+	if facets.Categories[x.ID] != 1 || facets.Categories[y.ID] != 1 || facets.Categories[""] != 1 {
+		t.Fatalf("category facets = %v, want one each in x, y and none", facets.Categories)
+	}
+	if !slices.Equal(facets.PriceBuckets, []int{1, 2, 1}) {
+		t.Fatalf("price facets = %v, want [1 2 1]", facets.PriceBuckets)
+	}
+}
+
+func testTextSearch(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	ids := map[string]string{}
+	create := func(sku, name, description string) *models.Product {
+		t.Helper()
+		p, err := st.CreateProduct(ctx, &models.Product{Name: name, Description: description, Category: "Toko", SKU: sku, PriceCents: 1000, Stock: 1})
+		if err != nil {
+			t.Fatalf("CreateProduct(%s): %v", sku, err)
+		}
+		ids[p.ID] = sku
+		return p
+	}
+	keyboard := create("TXT-KB", "Mechanical Keyboard", "Tactile switches")
+	desk := create("TXT-D01", "Standing Desk", "Room for a full-size keyboard and mouse")
+	create("TXT-TEE", "Kaos Polos Hitam", "Katun combed")
+	create("TXT-SHOE", "Sepatu Lari", "Ringan untuk jogging")
+	create("TXT-KOPI", "Kopi Arabika Gayo", "Biji sangrai medium")
+
+	search := func(query string, page store.Page) ([]string, string) {
+		t.Helper()
+		ps, next, err := st.ListProducts(ctx, store.ProductFilter{Query: query, Sort: store.SortRelevance}, page)
+		if err != nil {
+			t.Fatalf("ListProducts(%q): %v", query, err)
+		}
+		skus := []string{}
+		for _, p := range ps {
+			skus = append(skus, ids[p.ID])
+		}
+		return skus, next
+	}
+
+	queries := []struct {
+		query string
+		want  []string
+	}{
+		{"keyboard", []string{"TXT-KB", "TXT-D01"}}, // name before description
+		{"KEYBOARDS", []string{"TXT-KB", "TXT-D01"}},
+		{"keybord", []string{"TXT-KB", "TXT-D01"}},
+		{"tshirt", []string{"TXT-TEE"}},
+		{"shoes", []string{"TXT-SHOE"}},
+		{"kopi gayo", []string{"TXT-KOPI"}},
+		{"kopi keyboard", []string{}},
+		{"xylophone", []string{}},
+	}
+	for _, tc := range queries {
+		if got, _ := search(tc.query, store.Page{}); !slices.Equal(got, tc.want) {
+			t.Fatalf("search %q = %v, want %v", tc.query, got, tc.want)
+		}
+	}
+
+	// Relevance order holds across pages
+	walked := []string{}
+	page := store.Page{Limit: 1}
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatal("relevance pagination does not terminate")
+		}
+		got, next := search("keyboard", page)
+		walked = append(walked, got...)
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+	if !slices.Equal(walked, []string{"TXT-KB", "TXT-D01"}) {
+		t.Fatalf("search keyboard paged = %v", walked)
+	}
+
+	// The index follows updates and purges
+	if _, err := st.UpdateProduct(ctx, desk.ID, func(p *models.Product) error {
+		p.Name = "Meja Kerja"
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	if got, _ := search("desk", store.Page{}); len(got) != 0 {
+		t.Fatalf("search desk after rename = %v, want none", got)
+	}
+	if got, _ := search("meja", store.Page{}); !slices.Equal(got, []string{"TXT-D01"}) {
+		t.Fatalf("search meja after rename = %v, want [TXT-DESK]", got)
+	}
+	if err := st.PurgeProduct(ctx, keyboard.ID); err != nil {
+		t.Fatalf("PurgeProduct: %v", err)
+	}
+	if got, _ := search("keyboard", store.Page{}); !slices.Equal(got, []string{"TXT-D01"}) {
+		t.Fatalf("search keyboard after purge = %v, want [TXT-DESK]", got)
+	}
+}
+
+func testImportProducts(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	existing := mustProduct(t, st, "IMP-A", 1000, 5)
+	variant := mustProduct(t, st, "IMP-V", 2000, 0)
+	if _, err := st.UpdateProduct(ctx, variant.ID, func(p *models.Product) error {
+		p.Options = []models.ProductOption{{Name: "size", Values: []string{"S"}}}
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	if _, err := st.CreateVariant(ctx, variant.ID, &models.ProductVariant{SKU: "IMP-V-S", Options: map[string]string{"size": "S"}, Stock: 4}); err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+
+	skus := []string{"IMP-A", "IMP-NEW", "IMP-V"}
+	seen := map[string]*models.Product{}
+	update := func(i int, p *models.Product) error {
+		cp := *p
+		seen[skus[i]] = &cp
+		p.Name = "Imported " + skus[i]
+		p.PriceCents = 7000
+		p.Stock = 9
+		return nil
+	}
+
+	// A dry run sees the stored products but writes nothing
+	rows, err := st.ImportProducts(ctx, skus, true, update)
+	if err != nil {
+		t.Fatalf("ImportProducts(dry run): %v", err)
+	}
+	if len(rows) != 3 || rows[0].Created || !rows[1].Created || rows[2].Created || rows[1].Product.ID != "" {
+		t.Fatalf("dry run rows = %+v", rows)
+	}
+	if seen["IMP-A"].ID != existing.ID || seen["IMP-A"].Stock != 5 || seen["IMP-NEW"].SKU != "IMP-NEW" || len(seen["IMP-V"].Variants) != 1 {
+		t.Fatalf("update saw %+v", seen)
+	}
+	if got, _ := st.GetProduct(ctx, existing.ID); got.Name != existing.Name || got.PriceCents != 1000 {
+		t.Fatalf("dry run changed %+v", got)
+	}
+	if list, _, _ := st.ListProducts(ctx, store.ProductFilter{IncludeArchived: true}, store.Page{}); len(list) != 2 {
+		t.Fatalf("dry run left %d products, want 2", len(list))
+	}
+
+	rows, err = st.ImportProducts(ctx, skus, false, update)
+	if err != nil {
+		t.Fatalf("ImportProducts: %v", err)
+	}
+	if got, _ := st.GetProduct(ctx, existing.ID); got.Name != "Imported IMP-A" || got.PriceCents != 7000 || got.Stock != 9 {
+		t.Fatalf("updated product = %+v", got)
+	}
+	created, err := st.GetProduct(ctx, rows[1].Product.ID)
+	if err != nil || created.SKU != "IMP-NEW" || created.Name != "Imported IMP-NEW" || created.CreatedAt.IsZero() {
+		t.Fatalf("created product = %+v, %v", created, err)
+	}
+	// Stock of a product with variants stays the sum of theirs
+	if got, _ := st.GetProduct(ctx, variant.ID); got.Stock != 4 || len(got.Variants) != 1 || got.PriceCents != 7000 {
+		t.Fatalf("product with variants after import = %+v", got)
+	}
+	if list, _, _ := st.ListProducts(ctx, store.ProductFilter{Query: "imported"}, store.Page{}); len(list) != 3 {
+		t.Fatalf("search finds %d imported products, want 3", len(list))
+	}
+
+	// A failing row rolls back the rows before it
+	boom := errors.New("boom")
+	_, err = st.ImportProducts(ctx, []string{"IMP-A", "IMP-LATE"}, false, func(i int, p *models.Product) error {
+		if i == 1 {
+			return boom
+		}
+		p.Name = "Rolled back"
+		return nil
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("failing import = %v, want boom", err)
+	}
+	if got, _ := st.GetProduct(ctx, existing.ID); got.Name != "Imported IMP-A" {
+		t.Fatalf("failed import left name %q", got.Name)
+	}
+
+	if _, err := st.ImportProducts(ctx, []string{"IMP-A", "IMP-A"}, true, update); err == nil {
+		t.Fatal("import naming a SKU twice succeeded")
+	}
+}
+
+func testSKUs(t *testing.T, st store.Store) {
+	ctx := context.Background()
+	p := mustProduct(t, st, "SKU-A", 1000, 1)
+	other := mustProduct(t, st, "SKU-B", 1000, 1)
+
+	if _, err := st.CreateProduct(ctx, &models.Product{Name: "Copy", SKU: "SKU-A", PriceCents: 1000}); !errors.Is(err, store.ErrSKUTaken) {
+		t.Fatalf("create with a taken SKU = %v, want ErrSKUTaken", err)
+	}
+	if _, err := st.UpdateProduct(ctx, other.ID, func(p *models.Product) error {
+		p.SKU = "SKU-A"
+		return nil
+	}); !errors.Is(err, store.ErrSKUTaken) {
+		t.Fatalf("update to a taken SKU = %v, want ErrSKUTaken", err)
+	}
+	if got, _ := st.GetProduct(ctx, other.ID); got.SKU != "SKU-B" {
+		t.Fatalf("failed update left SKU %q", got.SKU)
+	}
+	// Saving a product under its own SKU is not a clash
+	if _, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+		p.Name = "Renamed"
+		return nil
+	}); err != nil {
+		t.Fatalf("update keeping the SKU: %v", err)
+	}
+
+	v, err := st.CreateVariant(ctx, p.ID, &models.ProductVariant{SKU: "SKU-A-RED", Options: map[string]string{"color": "red"}, Stock: 2})
+	if err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+	if _, err := st.CreateVariant(ctx, other.ID, &models.ProductVariant{SKU: "SKU-A-RED", Options: map[string]string{"color": "red"}}); !errors.Is(err, store.ErrSKUTaken) {
+		t.Fatalf("variant with a taken SKU = %v, want ErrSKUTaken", err)
+	}
+	blue, err := st.CreateVariant(ctx, p.ID, &models.ProductVariant{SKU: "SKU-A-BLUE", Options: map[string]string{"color": "blue"}})
+	if err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+	if _, _, err := st.UpdateVariant(ctx, p.ID, blue.ID, func(v *models.ProductVariant) error {
+		v.SKU = "SKU-A-RED"
+		return nil
+	}); !errors.Is(err, store.ErrSKUTaken) {
+		t.Fatalf("variant update to a taken SKU = %v, want ErrSKUTaken", err)
+	}
+
+	got, variantID, err := st.GetProductBySKU(ctx, "SKU-A")
+	if err != nil || got.ID != p.ID || variantID != "" {
+		t.Fatalf("GetProductBySKU(SKU-A) = %v, %q, %v", got, variantID, err)
+	}
+	got, variantID, err = st.GetProductBySKU(ctx, "SKU-A-RED")
+	if err != nil || got.ID != p.ID || variantID != v.ID {
+		t.Fatalf("GetProductBySKU(SKU-A-RED) = %v, %q, %v; want variant %s", got, variantID, err, v.ID)
+	}
+	if len(got.Variants) != 2 {
+		t.Fatalf("product by variant SKU has %d variants, want 2", len(got.Variants))
+	}
+	if _, _, err := st.GetProductBySKU(ctx, "SKU-NONE"); err == nil {
+		t.Fatal("GetProductBySKU of an unknown SKU succeeded")
+	}
+}
+
+func testPriceSchedules(t *testing.T, st store.Store) {
+	ctx := store.WithActor(context.Background(), "admin-1")
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	p := mustProduct(t, st, "PRICE-A", 10000, 5)
+	if _, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+		p.SetBasePrice(12000)
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	// Edits that leave the price alone are not history
+	if _, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+		p.Name = "Renamed"
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+
+	schedule := func(price int64, startsAt, endsAt *time.Time) *models.PriceSchedule {
+		t.Helper()
+		sc, err := st.CreatePriceSchedule(ctx, &models.PriceSchedule{ProductID: p.ID, PriceCents: price, StartsAt: *startsAt, EndsAt: endsAt})
+		if err != nil {
+			t.Fatalf("CreatePriceSchedule(%d): %v", price, err)
+		}
+		if sc.CreatedBy != "admin-1" {
+			t.Fatalf("schedule created by %q, want admin-1", sc.CreatedBy)
+		}
+		return sc
+	}
+	price := func(wantPrice int64, wantRegular *int64) {
+		t.Helper()
+		got, err := st.GetProduct(ctx, p.ID)
+		if err != nil {
+			t.Fatalf("GetProduct: %v", err)
+		}
+		if got.PriceCents != wantPrice || !reflect.DeepEqual(got.RegularPriceCents, wantRegular) {
+			t.Fatalf("price = %d (regular %v), want %d (regular %v)", got.PriceCents, got.RegularPriceCents, wantPrice, wantRegular)
+		}
+	}
+	regular := func(cents int64) *int64 { return &cents }
+
+	// A change that is already due applies on the next read
+	change := schedule(11000, at(-time.Hour), nil)
+	price(11000, nil)
+	future := schedule(13000, at(24*time.Hour), nil)
+	if future.Status != models.SchedulePending {
+		t.Fatalf("future change status = %q, want pending", future.Status)
+	}
+
+	sale := schedule(8000, at(-time.Minute), at(time.Hour))
+	if sale.Status != models.ScheduleActive {
+		t.Fatalf("running sale status = %q, want active", sale.Status)
+	}
+	price(8000, regular(11000))
+	list, _, err := st.ListProducts(ctx, store.ProductFilter{MaxPriceCents: 9000}, store.Page{})
+	if err != nil || len(list) != 1 || list[0].ID != p.ID {
+		t.Fatalf("ListProducts(max 9000) = %v, %v; want the product on sale", list, err)
+	}
+	if _, err := st.CreatePriceSchedule(ctx, &models.PriceSchedule{ProductID: p.ID, PriceCents: 7000, StartsAt: *at(30 * time.Minute), EndsAt: at(2 * time.Hour)}); !errors.Is(err, store.ErrPriceScheduleOverlap) {
+		t.Fatalf("overlapping sale = %v, want ErrPriceScheduleOverlap", err)
+	}
+	next := schedule(7000, at(time.Hour), at(2*time.Hour))
+	past := schedule(5000, at(-2*time.Hour), at(-time.Hour))
+	if past.Status != models.ScheduleDone {
+		t.Fatalf("sale that is over status = %q, want done", past.Status)
+	}
+	price(8000, regular(11000))
+
+	// Editing the price during a sale moves the price it ends on
+	if _, err := st.UpdateProduct(ctx, p.ID, func(p *models.Product) error {
+		p.SetBasePrice(11500)
+		return nil
+	}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	price(8000, regular(11500))
+
+	u := mustUser(t, st, "sale@example.com")
+	if err := st.AddToCart(ctx, u.ID, p.ID, "", 1); err != nil {
+		t.Fatalf("AddToCart: %v", err)
+	}
+	o, _, err := st.CheckoutCart(ctx, u.ID)
+	if err != nil || o.Amount != 8000 {
+		t.Fatalf("CheckoutCart = %+v, %v; want the sale price", o, err)
+	}
+
+	canceled, err := st.CancelPriceSchedule(ctx, p.ID, sale.ID)
+	if err != nil || canceled.Status != models.ScheduleCanceled || canceled.EndsAt == nil || canceled.EndsAt.After(time.Now()) {
+		t.Fatalf("CancelPriceSchedule(running) = %+v, %v", canceled, err)
+	}
+	price(11500, nil)
+	if _, err := st.CancelPriceSchedule(ctx, p.ID, sale.ID); !errors.Is(err, store.ErrPriceScheduleDone) {
+		t.Fatalf("cancel twice = %v, want ErrPriceScheduleDone", err)
+	}
+	if _, err := st.CancelPriceSchedule(ctx, p.ID, change.ID); !errors.Is(err, store.ErrPriceScheduleDone) {
+		t.Fatalf("cancel applied change = %v, want ErrPriceScheduleDone", err)
+	}
+	if sc, err := st.CancelPriceSchedule(ctx, p.ID, next.ID); err != nil || sc.Status != models.ScheduleCanceled {
+		t.Fatalf("CancelPriceSchedule(pending) = %+v, %v", sc, err)
+	}
+	other := mustProduct(t, st, "PRICE-B", 1000, 1)
+	if _, err := st.CancelPriceSchedule(ctx, other.ID, future.ID); err == nil {
+		t.Fatal("canceled a schedule through another product")
+	}
+
+	schedules, err := st.ListPriceSchedules(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("ListPriceSchedules: %v", err)
+	}
+	statuses := []string{}
+	for _, sc := range schedules {
+		statuses = append(statuses, sc.Status)
+	}
+	// By start: past sale, change, running sale, next sale, future change
+	want := []string{models.ScheduleDone, models.ScheduleDone, models.ScheduleCanceled, models.ScheduleCanceled, models.SchedulePending}
+	if !slices.Equal(statuses, want) {
+		t.Fatalf("schedule statuses = %v, want %v", statuses, want)
+	}
+
+	history, err := st.ListPriceHistory(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("ListPriceHistory: %v", err)
+	}
+	byReason := map[string][]*models.PriceChange{}
+	for i, c := range history {
+		if i > 0 && c.CreatedAt.After(history[i-1].CreatedAt) {
+			t.Fatalf("history not newest first: %v after %v", c.CreatedAt, history[i-1].CreatedAt)
+		}
+		byReason[c.Reason] = append(byReason[c.Reason], c)
+	}
+	if len(history) != 6 {
+		t.Fatalf("history has %d entries, want 6: %+v", len(history), byReason)
+	}
+	for _, w := range []struct {
+		reason   string
+		old, new int64
+		actor    string
+		schedule string
+	}{
+		{models.PriceCreated, 0, 10000, "", ""},
+		{models.PriceScheduled, 12000, 11000, "", change.ID},
+		{models.PriceSaleStart, 11000, 8000, "", sale.ID},
+		{models.PriceSaleEnd, 8000, 11500, "admin-1", sale.ID},
+	} {
+		got := byReason[w.reason]
+		if len(got) != 1 || got[0].OldPriceCents != w.old || got[0].NewPriceCents != w.new || got[0].ActorID != w.actor || got[0].ScheduleID != w.schedule {
+			t.Fatalf("%s history = %+v, want %d -> %d by %q", w.reason, got, w.old, w.new, w.actor)
+		}
+	}
+	manual := byReason[models.PriceManual]
+	if len(manual) != 2 || manual[0].NewPriceCents != 11500 || manual[1].NewPriceCents != 12000 || manual[0].ActorID != "admin-1" {
+		t.Fatalf("manual history = %+v", manual)
+	}
+
+	if h, err := st.ListPriceHistory(ctx, other.ID); err != nil || len(h) != 1 {
+		t.Fatalf("other product's history = %v, %v; want its creation only", h, err)
+	}
+	if err := st.PurgeProduct(ctx, other.ID); err != nil {
+		t.Fatalf("PurgeProduct: %v", err)
+	}
+	if _, err := st.ListPriceHistory(ctx, other.ID); err == nil {
+		t.Fatal("ListPriceHistory of a purged product succeeded")
+	}
+}
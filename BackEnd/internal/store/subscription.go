@@ -0,0 +1,27 @@
+package store
+
+import (
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+// lineInStock reports whether the stock of a product line can be bought:
+// the variant's, or with variantID "" the product's total
+func lineInStock(p *models.Product, variantID string) bool {
+	if variantID == "" {
+		return p.Stock > 0
+	}
+	v := p.Variant(variantID)
+	return v != nil && v.Stock > 0
+}
+
+// checkStockSubscription is the check SubscribeStock makes of the product
+// subscribed to
+func checkStockSubscription(p *models.Product, variantID string) error {
+	if variantID != "" && p.Variant(variantID) == nil {
+		return errVariantNotFound
+	}
+	if lineInStock(p, variantID) {
+		return ErrInStock
+	}
+	return nil
+}
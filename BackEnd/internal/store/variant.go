@@ -0,0 +1,77 @@
+package store
+
+import (
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/example/ecommerce-api/internal/models"
+)
+
+var errVariantNotFound = errors.New("variant not found")
+
+// resolveVariant finds the variant a cart or order line refers to. Products
+// with variants must name one; products without must not.
+func resolveVariant(p *models.Product, variantID string) (*models.ProductVariant, error) {
+	if variantID == "" {
+		if len(p.Variants) > 0 {
+			return nil, ErrVariantRequired
+		}
+		return nil, nil
+	}
+	v := p.Variant(variantID)
+	if v == nil {
+		return nil, errVariantNotFound
+	}
+	return v, nil
+}
+
+// stockFor returns the units a line can draw on: the variant's when it has
+// one, the product's otherwise
+func stockFor(p *models.Product, v *models.ProductVariant) int {
+	if v != nil {
+		return v.Stock
+	}
+	return p.Stock
+}
+
+// lineKey identifies a cart or order line
+func lineKey(productID, variantID string) string {
+	return productID + "/" + variantID
+}
+
+// rollupStock sets the product's stock to the sum of its variants'
+func rollupStock(p *models.Product) {
+	if len(p.Variants) == 0 {
+		return
+	}
+	p.Stock = 0
+	for _, v := range p.Variants {
+		p.Stock += v.Stock
+	}
+}
+
+// cloneProduct copies p deeply enough that the copy can be handed out or
+// edited without touching the stored product
+func cloneProduct(p *models.Product) *models.Product {
+	cp := *p
+	cp.Images = slices.Clone(p.Images)
+	cp.Options = make([]models.ProductOption, len(p.Options))
+	for i, o := range p.Options {
+		cp.Options[i] = models.ProductOption{Name: o.Name, Values: slices.Clone(o.Values)}
+	}
+	cp.Variants = make([]models.ProductVariant, len(p.Variants))
+	for i, v := range p.Variants {
+		cp.Variants[i] = cloneVariant(v)
+	}
+	return &cp
+}
+
+func cloneVariant(v models.ProductVariant) models.ProductVariant {
+	v.Options = maps.Clone(v.Options)
+	if v.PriceCents != nil {
+		price := *v.PriceCents
+		v.PriceCents = &price
+	}
+	return v
+}
@@ -1,4 +1,5 @@
 import { API_BASE_URL } from "../env";
+import type { Page } from "../types";
 
 type RequestOptions = {
   method?: string;
@@ -45,3 +46,24 @@ export async function backendRequest<T>(path: string, options: RequestOptions =
     status: response.status,
   };
 }
+
+// Follows next_cursor until the last page and returns every item of a
+// paginated list endpoint.
+export async function backendRequestAll<T>(path: string, options: RequestOptions = {}): Promise<{ data: T[] | null; error: string | null; status: number }> {
+  const items: T[] = [];
+  const separator = path.includes("?") ? "&" : "?";
+  let cursor = "";
+
+  for (;;) {
+    const pagePath = `${path}${separator}limit=100${cursor ? `&cursor=${encodeURIComponent(cursor)}` : ""}`;
+    const result = await backendRequest<Page<T>>(pagePath, options);
+    if (!result.data) {
+      return { data: null, error: result.error, status: result.status };
+    }
+    items.push(...(result.data.items || []));
+    if (!result.data.next_cursor) {
+      return { data: items, error: null, status: result.status };
+    }
+    cursor = result.data.next_cursor;
+  }
+}
@@ -3,25 +3,158 @@ export interface Product {
   name: string;
   description: string;
   category?: string;
+  category_id?: string;
   price_cents: number;
+  regular_price_cents?: number;
   sku: string;
   stock: number;
   thumbnail?: string;
-  images?: string;
+  images?: { url: string; alt?: string }[];
   rating?: number;
   review_count?: number;
   created_at: string;
   updated_at: string;
+  archived_at?: string;
+  status?: "draft" | "published" | "archived";
+  publish_at?: string;
+  unpublish_at?: string;
+  low_stock_threshold?: number;
+  options?: ProductOption[];
+  variants?: ProductVariant[];
+}
+
+export interface PriceChange {
+  id: string;
+  product_id: string;
+  old_price_cents: number;
+  new_price_cents: number;
+  reason: "create" | "manual" | "import" | "schedule" | "sale_start" | "sale_end";
+  actor_id?: string;
+  schedule_id?: string;
+  created_at: string;
+}
+
+export interface PriceSchedule {
+  id: string;
+  product_id: string;
+  price_cents: number;
+  starts_at: string;
+  ends_at?: string;
+  status: "pending" | "active" | "done" | "canceled";
+  created_by?: string;
+  created_at: string;
+}
+
+export interface StockMovement {
+  id: string;
+  product_id: string;
+  variant_id?: string;
+  location_id?: string;
+  delta: number;
+  reason: "sale" | "restock" | "adjustment" | "return" | "reservation_release" | "transfer";
+  reference_id?: string;
+  actor_id?: string;
+  note?: string;
+  created_at: string;
+}
+
+export interface Location {
+  id: string;
+  name: string;
+  code?: string;
+  priority: number;
+  latitude?: number;
+  longitude?: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface StockLevel {
+  location_id: string;
+  product_id: string;
+  variant_id?: string;
+  stock: number;
+}
+
+export interface StockSubscription {
+  user_id: string;
+  product_id: string;
+  variant_id?: string;
+  created_at: string;
+}
+
+export interface StockAllocation {
+  location_id: string;
+  quantity: number;
+}
+
+export interface Category {
+  id: string;
+  parent_id?: string;
+  name: string;
+  slug: string;
+  sku_prefix?: string;
+  sort_order: number;
+  image?: string;
+  created_at: string;
+  updated_at: string;
+  children?: Category[];
+}
+
+export interface ProductOption {
+  name: string;
+  values: string[];
+}
+
+export interface ProductVariant {
+  id: string;
+  product_id: string;
+  sku: string;
+  options: Record<string, string>;
+  price_cents?: number;
+  stock: number;
+  image?: string;
+  created_at: string;
+  updated_at: string;
 }
 
 export interface CartItem {
   product_id: string;
+  variant_id?: string;
   quantity: number;
+  price_cents?: number;
+  allocations?: StockAllocation[];
+}
+
+export interface OrderItem extends CartItem {
+  name?: string;
+  variant?: string;
+  thumbnail?: string;
+}
+
+export interface CartLine extends CartItem {
+  name?: string;
+  variant?: string;
+  sku?: string;
+  thumbnail?: string;
+  unit_price_cents: number;
+  line_total_cents: number;
+  stock: number;
+  available: boolean;
 }
 
 export interface Cart {
   user_id: string;
-  items: CartItem[];
+  items: CartLine[];
+  subtotal_cents: number;
+  item_count: number;
+}
+
+export interface GuestCart {
+  id: string;
+  items: CartLine[];
+  subtotal_cents: number;
+  item_count: number;
 }
 
 export interface Order {
@@ -31,6 +164,22 @@ export interface Order {
   amount_cents: number;
   status: string;
   payment_ref: string;
+  reservation?: "held" | "committed" | "released";
+  pay_before?: string;
   created_at: string;
-  items: CartItem[];
+  items: OrderItem[];
+}
+
+export interface Page<T> {
+  items: T[];
+  next_cursor: string;
+}
+
+export interface ProductFacets {
+  categories: { id: string; parent_id?: string; name: string; slug: string; count: number }[];
+  prices: { min_price_cents: number; max_price_cents?: number; count: number }[];
+}
+
+export interface ProductPage extends Page<Product> {
+  facets?: ProductFacets;
 }
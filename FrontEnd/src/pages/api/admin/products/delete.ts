@@ -22,5 +22,5 @@ export const POST: APIRoute = async ({ request, cookies, redirect }) => {
     return redirect(`/admin/products?error=${encodeURIComponent(result.error)}`);
   }
 
-  return redirect(`/admin/products?message=${encodeURIComponent("Produk berhasil dihapus")}`);
+  return redirect(`/admin/products?message=${encodeURIComponent("Produk berhasil diarsipkan")}`);
 };
@@ -16,6 +16,7 @@ export const POST: APIRoute = async ({ request, cookies, redirect }) => {
 
   const form = await request.formData();
   const productID = String(form.get("product_id") || "").trim();
+  const variantID = String(form.get("variant_id") || "").trim();
   const quantity = Number(form.get("quantity") || "1");
 
   const result = await backendRequest<null>("/api/v1/me/cart/remove", {
@@ -23,6 +24,7 @@ export const POST: APIRoute = async ({ request, cookies, redirect }) => {
     token: session.token,
     body: {
       product_id: productID,
+      variant_id: variantID,
       quantity,
     },
   });